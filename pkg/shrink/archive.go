@@ -0,0 +1,91 @@
+package shrink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	filepath "path/filepath"
+	"sync"
+	"time"
+)
+
+// ArchiveEntry is a line in the archive manifest, enough to find and verify an original later
+type ArchiveEntry struct {
+	Original    string    `json:"original"`
+	Location    string    `json:"location"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CaptureTime time.Time `json:"captureTime"`
+	Archived    time.Time `json:"archived"`
+}
+
+// Archiver uploads originals to cold storage before they are replaced,
+// recording each one in a json lines manifest
+type Archiver struct {
+	Dest         Uploader
+	Location     string
+	ManifestFile string
+	// Runner runs ffprobe to read capture times, a Processor sets it to the one of its encoder
+	Runner Runner
+	mu     sync.Mutex
+}
+
+// Returns the sha256 of a file as hex
+func fileSHA256(fsys FS, fileName string) (string, error) {
+	file, err := fsys.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Archive uploads the original under its relative name and appends it to the manifest
+func (a *Archiver) Archive(ctx context.Context, fileName, relName string) error {
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	sum, err := fileSHA256(OSFS{}, fileName)
+	if err != nil {
+		return err
+	}
+	captureTime := captureTime(ctx, runnerOrExec(a.Runner), OSFS{}, time.Local, nil, fileName)
+	if err := a.Dest.Upload(ctx, fileName, relName, captureTime); err != nil {
+		return err
+	}
+
+	absName, _ := filepath.Abs(fileName)
+	line, err := json.Marshal(ArchiveEntry{
+		Original:    absName,
+		Location:    a.Location,
+		Name:        relName,
+		Size:        stat.Size(),
+		SHA256:      sum,
+		CaptureTime: captureTime,
+		Archived:    time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	manifest, err := os.OpenFile(a.ManifestFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := manifest.Write(append(line, '\n')); err != nil {
+		manifest.Close()
+		return err
+	}
+	return manifest.Close()
+}
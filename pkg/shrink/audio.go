@@ -0,0 +1,69 @@
+package shrink
+
+import (
+	"context"
+	filepath "path/filepath"
+	"strings"
+)
+
+// DefaultAudioFileCodec is the codec audio files are compressed with when it isn't set
+const DefaultAudioFileCodec = "aac"
+
+// IsAudio returns true if the file is uncompressed or losslessly compressed audio, like voice memos and camcorder audio
+func IsAudio(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".wav", ".aif", ".aiff", ".flac":
+		return true
+	}
+	return false
+}
+
+// AudioEncoder compresses audio files with ffmpeg, keeping their metadata
+type AudioEncoder struct {
+	// Codec is aac, saved as .m4a, or opus, saved as .opus, DefaultAudioFileCodec if empty
+	Codec string
+	// Bitrate is the audio bitrate, eg. 64k, DefaultAudioBitrate if empty
+	Bitrate string
+	// Runner runs ffmpeg and ffprobe, os/exec if nil
+	Runner Runner
+}
+
+// Settings returns the ffmpeg settings of the encoder, using the defaults for those that aren't set
+func (e AudioEncoder) Settings() EncodeSettings {
+	settings := EncodeSettings{Codec: "aac", AudioBitrate: e.Bitrate}
+	if e.Codec == "opus" {
+		settings.Codec = "libopus"
+	}
+	if len(settings.AudioBitrate) == 0 {
+		settings.AudioBitrate = DefaultAudioBitrate
+	}
+	return settings
+}
+
+// Ext returns the extension of the compressed audio file
+func (e AudioEncoder) Ext() string {
+	if e.Codec == "opus" {
+		return ".opus"
+	}
+	return ".m4a"
+}
+
+// Args returns the ffmpeg arguments to compress the source into dest, leaving out cover art
+func (e AudioEncoder) Args(sourceFile, destFile string) []string {
+	settings := e.Settings()
+	args := []string{"-i", sourceFile, "-vn", "-map_metadata", "0", "-c:a", settings.Codec, "-b:a", settings.AudioBitrate}
+	if e.Ext() == ".m4a" {
+		args = append(args, "-movflags", "+faststart+use_metadata_tags")
+	}
+	return append(args, destFile)
+}
+
+// Encode runs ffmpeg on the source, if progress isn't nil it is called with the fraction encoded so far
+func (e AudioEncoder) Encode(ctx context.Context, sourceFile, destFile string, progress func(float64)) error {
+	runner := runnerOrExec(e.Runner)
+	if progress == nil {
+		return runner.Run(ctx, "ffmpeg", e.Args(sourceFile, destFile), nil, nil)
+	}
+	args := append([]string{"-progress", "pipe:1", "-nostats"}, e.Args(sourceFile, destFile)...)
+	return runWithProgress(ctx, runner, args, probeDuration(ctx, runner, sourceFile), progress)
+}
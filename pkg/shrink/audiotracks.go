@@ -0,0 +1,174 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AudioDefault selects the default audio track in Encoder.AudioLanguages
+const AudioDefault = "default"
+
+// silenceVolume is the loudest audio that is pure silence, digital silence is -inf and the dither of 16 bit audio about -90 dB
+const silenceVolume = -90
+
+// AudioCopyMaxBitrate is the highest bit rate of aac audio Encoder.AudioCopy copies, in bits per second
+const AudioCopyMaxBitrate = 192000
+
+// audioTrack is an audio stream of a movie
+type audioTrack struct {
+	Index       int    `json:"index"`
+	CodecName   string `json:"codec_name"`
+	BitRate     string `json:"bit_rate"`
+	Disposition struct {
+		Default int `json:"default"`
+	} `json:"disposition"`
+	Tags struct {
+		Language string `json:"language"`
+	} `json:"tags"`
+}
+
+// Reads the audio streams of a movie with ffprobe
+func probeAudioTracks(ctx context.Context, runner Runner, fileName string) ([]audioTrack, error) {
+	var out bytes.Buffer
+	args := []string{"-v", "error", "-select_streams", "a", "-show_entries", "stream=index,codec_name,bit_rate:stream_disposition=default:stream_tags=language",
+		"-of", "json", fileName}
+	if err := runnerOrExec(runner).Run(ctx, "ffprobe", args, &out, nil); err != nil {
+		return nil, err
+	}
+	var probe struct {
+		Streams []audioTrack `json:"streams"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return nil, err
+	}
+	return probe.Streams, nil
+}
+
+// Returns true if a track is efficient enough to be copied, aac of a known bit rate up to AudioCopyMaxBitrate
+func (t audioTrack) copyable() bool {
+	bitRate, err := strconv.Atoi(t.BitRate)
+	return t.CodecName == "aac" && err == nil && bitRate > 0 && bitRate <= AudioCopyMaxBitrate
+}
+
+// Returns the -map arguments of the audio tracks of a movie in the languages of the encoder, nil to keep them all
+func (e Encoder) audioMaps(ctx context.Context, fileName string) []string {
+	if e.dropSilentAudio(ctx, fileName) {
+		return []string{}
+	}
+	if len(e.AudioLanguages) == 0 {
+		return nil
+	}
+	tracks, err := probeAudioTracks(ctx, e.Runner, fileName)
+	if err != nil {
+		log.Error("Could not read the audio tracks of: ", fileName, " ", err)
+		return nil
+	}
+	maps, _ := e.pickAudio(tracks, fileName)
+	return maps
+}
+
+// Returns the -map arguments of the audio tracks of a movie, nil to keep them all, and for each audio stream
+// of the encode whether it is copied rather than transcoded, nil to transcode them all
+func (e Encoder) audioStreams(ctx context.Context, fileName string) ([]string, []bool) {
+	if e.dropSilentAudio(ctx, fileName) {
+		return []string{}, nil
+	}
+	if len(e.AudioLanguages) == 0 && !e.AudioCopy {
+		return nil, nil
+	}
+	tracks, err := probeAudioTracks(ctx, e.Runner, fileName)
+	if err != nil {
+		log.Error("Could not read the audio tracks of: ", fileName, " ", err)
+		return nil, nil
+	}
+	var audioMaps []string
+	kept := tracks
+	if len(e.AudioLanguages) > 0 {
+		audioMaps, kept = e.pickAudio(tracks, fileName)
+	}
+	if !e.AudioCopy || len(kept) == 0 {
+		return audioMaps, nil
+	}
+	copies := make([]bool, len(kept))
+	all := true
+	for i, track := range kept {
+		copies[i] = track.copyable()
+		all = all && copies[i]
+	}
+	// without maps ffmpeg picks one track, which is only known to be copyable if they all are
+	if len(e.Strip) == 0 && len(e.AudioLanguages) == 0 {
+		if all {
+			return nil, []bool{true}
+		}
+		return nil, nil
+	}
+	return audioMaps, copies
+}
+
+// SilentAudio returns true if every audio track of a movie is pure silence, like those of screen recordings and
+// muted cameras, false if it has none or it can't tell
+func (e Encoder) SilentAudio(ctx context.Context, fileName string) bool {
+	var stderr bytes.Buffer
+	args := []string{"-v", "info", "-nostats", "-i", fileName, "-map", "0:a", "-af", "volumedetect", "-f", "null", "-"}
+	if err := runnerOrExec(e.Runner).Run(ctx, "ffmpeg", args, nil, &stderr); err != nil {
+		log.Debug("Could not check for silent audio: ", fileName, " ", err)
+		return false
+	}
+	// volumedetect logs the volume of each track
+	matches := maxVolumePattern.FindAllSubmatch(stderr.Bytes(), -1)
+	for _, match := range matches {
+		if volume, err := strconv.ParseFloat(string(match[1]), 64); err != nil || volume > silenceVolume {
+			return false
+		}
+	}
+	return len(matches) > 0
+}
+
+// Returns true if the encoder drops the audio of a movie because it is silent
+func (e Encoder) dropSilentAudio(ctx context.Context, fileName string) bool {
+	if !e.DropSilentAudio || !e.SilentAudio(ctx, fileName) {
+		return false
+	}
+	log.Info("Dropping silent audio of: ", fileName)
+	return true
+}
+
+// Returns the -map arguments and the audio tracks in the languages of the encoder.
+// Tracks without a language are und. If none match the default track is kept, or the first, so movies don't lose their sound.
+func (e Encoder) pickAudio(tracks []audioTrack, fileName string) ([]string, []audioTrack) {
+	if len(tracks) == 0 {
+		return []string{}, nil
+	}
+	var maps []string
+	var kept []audioTrack
+	for _, track := range tracks {
+		language := strings.ToLower(track.Tags.Language)
+		if len(language) == 0 {
+			language = "und"
+		}
+		for _, wanted := range e.AudioLanguages {
+			if wanted == language || (wanted == AudioDefault && track.Disposition.Default == 1) {
+				maps = append(maps, "-map", "0:"+strconv.Itoa(track.Index))
+				kept = append(kept, track)
+				break
+			}
+		}
+	}
+	if len(maps) > 0 {
+		return maps, kept
+	}
+	fallback := tracks[0]
+	for _, track := range tracks {
+		if track.Disposition.Default == 1 {
+			fallback = track
+			break
+		}
+	}
+	log.Info("No audio track in ", strings.Join(e.AudioLanguages, ","), ", keeping track ", fallback.Index, " of: ", fileName)
+	return []string{"-map", "0:" + strconv.Itoa(fallback.Index)}, []audioTrack{fallback}
+}
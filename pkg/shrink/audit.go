@@ -0,0 +1,78 @@
+package shrink
+
+import (
+	"context"
+	"encoding/json"
+	filepath "path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// auditSuffix is added to the name of a shrunk file for its audit sidecar, eg. 20160513_181656.mp4.shrink.json
+const auditSuffix = ".shrink.json"
+
+// AuditRecord is written next to a shrunk file when Options.Audit is set,
+// so where it came from is known without the state file
+type AuditRecord struct {
+	// Original is the name of the original relative to the input root
+	Original    string         `json:"original"`
+	Size        int64          `json:"size"`
+	Codec       string         `json:"codec,omitempty"`
+	SHA256      string         `json:"sha256,omitempty"`
+	CaptureTime time.Time      `json:"captureTime"`
+	Settings    EncodeSettings `json:"settings"`
+	OutputSize  int64          `json:"outputSize"`
+	Ratio       float64        `json:"ratio"`
+	Converted   time.Time      `json:"converted"`
+	// Provenance is the commands, ffmpeg version and probe of the original the file was encoded with
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// Returns the audit record of an original that is about to be replaced, leaving out what can't be read
+func (p *Processor) auditRecord(ctx context.Context, settings EncodeSettings, sourceFile, name string, captured time.Time, result FileResult) AuditRecord {
+	record := AuditRecord{
+		Original:    name,
+		Size:        result.InSize,
+		CaptureTime: captured,
+		Settings:    settings,
+		OutputSize:  result.OutSize,
+		Ratio:       result.Ratio,
+		Converted:   time.Now(),
+	}
+	if info, err := (Encoder{Runner: p.Encoder.Runner}).Probe(ctx, sourceFile); err != nil {
+		log.Error("Could not probe original for audit: ", sourceFile, err)
+	} else {
+		record.Codec = info.Codec
+	}
+	if sum, err := fileSHA256(p.FS, sourceFile); err != nil {
+		log.Error("Could not hash original for audit: ", sourceFile, err)
+	} else {
+		record.SHA256 = sum
+	}
+	return record
+}
+
+// Writes the audit sidecar of a shrunk file
+func (p *Processor) writeAudit(fileName string, record AuditRecord) {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	auditFile := fileName + auditSuffix
+	out, err := p.FS.Create(auditFile)
+	if err != nil {
+		log.Error("Could not write audit: ", auditFile, err)
+		return
+	}
+	_, err = out.Write(append(data, '\n'))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Error("Could not write audit: ", auditFile, err)
+		return
+	}
+	log.Debug("Wrote audit: ", filepath.Base(auditFile))
+}
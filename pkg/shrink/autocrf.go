@@ -0,0 +1,113 @@
+package shrink
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AutoCRF as the CRF of an encoder picks the CRF of each movie from its source with the encoder's CRFMap
+const AutoCRF = -1
+
+// CRFStep gives movies up to a number of bits per pixel a CRF, no limit if MaxBPP is zero
+type CRFStep struct {
+	MaxBPP float64
+	CRF    int
+}
+
+// CRFMap picks the CRF of a movie from the bits its source spends per pixel of each frame, converted to h264 bits
+// for other codecs. The first step the source is under wins, so sources of low bit rates are encoded gentler.
+type CRFMap []CRFStep
+
+// DefaultCRFMap leaves the CRF of phone and camera movies at DefaultCRF, and lowers it for sources that were already
+// compressed hard, like messenger videos and streaming rips
+var DefaultCRFMap = CRFMap{{MaxBPP: 0.05, CRF: 24}, {MaxBPP: 0.1, CRF: 26}, {CRF: DefaultCRF}}
+
+// codecEfficiency is about how many bits of h264 a bit of a codec is worth at the same quality, 1 for the others
+var codecEfficiency = map[string]float64{
+	"hevc":       1.6,
+	"vp9":        1.6,
+	"av1":        2,
+	"mpeg4":      0.7,
+	"mpeg2video": 0.5,
+	"mjpeg":      0.15,
+	"prores":     0.1,
+}
+
+// ParseCRFMap parses steps of bits per pixel and CRF separated by commas, the last step a CRF alone, eg. 0.05:24,0.1:26,28
+func ParseCRFMap(value string) (CRFMap, error) {
+	var steps CRFMap
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		var step CRFStep
+		crf := part
+		if i < len(parts)-1 {
+			colon := strings.Index(part, ":")
+			if colon < 0 {
+				return nil, fmt.Errorf("step %s is not bits per pixel:crf", part)
+			}
+			maxBPP, err := strconv.ParseFloat(part[:colon], 64)
+			if err != nil || maxBPP <= 0 {
+				return nil, fmt.Errorf("invalid bits per pixel: %s", part[:colon])
+			}
+			if len(steps) > 0 && maxBPP <= steps[len(steps)-1].MaxBPP {
+				return nil, fmt.Errorf("bits per pixel must go up: %s", part)
+			}
+			step.MaxBPP, crf = maxBPP, part[colon+1:]
+		}
+		var err error
+		if step.CRF, err = strconv.Atoi(crf); err != nil || step.CRF <= 0 {
+			return nil, fmt.Errorf("invalid crf: %s", crf)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// String returns the steps as ParseCRFMap reads them
+func (m CRFMap) String() string {
+	parts := make([]string, len(m))
+	for i, step := range m {
+		parts[i] = strconv.Itoa(step.CRF)
+		if step.MaxBPP > 0 {
+			parts[i] = strconv.FormatFloat(step.MaxBPP, 'f', -1, 64) + ":" + parts[i]
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// CRF returns the CRF for a source, DefaultCRF if its bit rate or resolution isn't known
+func (m CRFMap) CRF(info Info) int {
+	if info.BitRate <= 0 || info.Width == 0 || info.Height == 0 || len(m) == 0 {
+		return DefaultCRF
+	}
+	fps := info.FrameRate
+	if fps == 0 {
+		fps = 30
+	}
+	efficiency, ok := codecEfficiency[info.Codec]
+	if !ok {
+		efficiency = 1
+	}
+	bpp := float64(info.BitRate) / (float64(info.Width*info.Height) * fps) * efficiency
+	for _, step := range m {
+		if step.MaxBPP == 0 || bpp < step.MaxBPP {
+			return step.CRF
+		}
+	}
+	return m[len(m)-1].CRF
+}
+
+// ResolveCRF returns the encoder with the CRF its CRFMap, or DefaultCRFMap, picks for a source if its CRF is AutoCRF
+func (e Encoder) ResolveCRF(info Info) Encoder {
+	if e.CRF != AutoCRF {
+		return e
+	}
+	crfMap := e.CRFMap
+	if len(crfMap) == 0 {
+		crfMap = DefaultCRFMap
+	}
+	e.CRF = crfMap.CRF(info)
+	return e
+}
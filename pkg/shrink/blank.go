@@ -0,0 +1,88 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	filepath "path/filepath"
+	"regexp"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BlankClips finds movies that are black all along, like recordings with the lens cap on or in a pocket
+type BlankClips struct {
+	// ReviewDir, when set, is where blank movies are moved to instead of being encoded, keeping their dir below the input root
+	ReviewDir string
+}
+
+// Blank thresholds: the fraction of a movie that must be black, only keyframes are looked at so the last
+// group of pictures may be missed, and the loudest audio that is silence
+const (
+	blackFraction = 0.9
+	silentVolume  = -50
+)
+
+var (
+	blackDurationPattern = regexp.MustCompile(`black_duration:\s*([0-9.]+)`)
+	maxVolumePattern     = regexp.MustCompile(`max_volume:\s*(-?[0-9.]+|-inf) dB`)
+)
+
+// Blank returns why a movie looks blank, black video with silent audio or not, empty if it isn't.
+// It decodes only the keyframes of the movie to sample its picture and measures the volume of its audio.
+func (e Encoder) Blank(ctx context.Context, fileName string) string {
+	info, err := e.Probe(ctx, fileName)
+	if err != nil || info.Duration <= 0 {
+		return ""
+	}
+	var stderr bytes.Buffer
+	args := []string{"-v", "info", "-nostats", "-skip_frame", "nokey", "-i", fileName,
+		"-vf", "blackdetect=d=0:pix_th=0.10", "-af", "volumedetect", "-f", "null", "-"}
+	if err := runnerOrExec(e.Runner).Run(ctx, "ffmpeg", args, nil, &stderr); err != nil {
+		log.Debug("Could not check for blank content: ", fileName, " ", err)
+		return ""
+	}
+	var black float64
+	for _, match := range blackDurationPattern.FindAllSubmatch(stderr.Bytes(), -1) {
+		if seconds, err := strconv.ParseFloat(string(match[1]), 64); err == nil {
+			black += seconds
+		}
+	}
+	if black < blackFraction*info.Duration.Seconds() {
+		return ""
+	}
+	// movies without audio have no volume to measure, digital silence is -inf
+	if match := maxVolumePattern.FindSubmatch(stderr.Bytes()); match != nil {
+		if volume, err := strconv.ParseFloat(string(match[1]), 64); err == nil && volume <= silentVolume {
+			return "black video and silent audio"
+		}
+		return "black video"
+	}
+	return "black video without audio"
+}
+
+// Flags a blank movie in its result, moving it into the review dir if there is one.
+// Returns true if it was moved and shouldn't be encoded.
+func (p *Processor) reviewBlank(ctx context.Context, sourceFile, name string, result *FileResult) bool {
+	reason := p.Encoder.Blank(ctx, sourceFile)
+	if len(reason) == 0 {
+		return false
+	}
+	log.Info("Blank clip: ", sourceFile, " ", reason)
+	result.Blank = reason
+	if len(p.Blank.ReviewDir) == 0 {
+		return false
+	}
+	dest := filepath.Join(p.Blank.ReviewDir, filepath.FromSlash(name))
+	if err := p.FS.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		log.Error("Could not move blank clip to review: ", sourceFile, err)
+		return false
+	}
+	if err := moveFile(p.FS, sourceFile, dest); err != nil {
+		log.Error("Could not move blank clip to review: ", sourceFile, err)
+		return false
+	}
+	log.Info("Moved blank clip to review: ", dest)
+	result.Result, result.Skipped = dest, "blank clip, "+reason
+	return true
+}
@@ -0,0 +1,217 @@
+package shrink
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	filepath "path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// goProChapterPattern matches the chapters GoPro cameras split long recordings into, GX010123.MP4 and GX020123.MP4
+// are chapters 1 and 2 of recording 0123 (GH for AVC), older cameras name them GOPR0123.MP4, GP010123.MP4 and so on
+var goProChapterPattern = regexp.MustCompile(`(?i)^(?:G([HX])(\d{2})(\d{4})|GOPR(\d{4})|GP(\d{2})(\d{4}))\.mp4$`)
+
+// numberedPattern splits a name like MVI_0042.MP4 into its prefix, number and extension
+var numberedPattern = regexp.MustCompile(`^(.*?)(\d+)(\.[^.]+)$`)
+
+// splitSize is the size from which a file was split at the 4 GiB limit of FAT32 cards and continues in the next number,
+// as Canon and other cameras do
+const splitSize = 4<<30 - 64<<20
+
+// splitGap is how far the timecode or creation time of a part split at the size limit may be off from where the part
+// before it ends, the creation times of many cameras only have seconds
+const splitGap = 2 * time.Second
+
+// timecodePattern matches SMPTE timecodes, eg. 01:02:03:04, the last separator is ; or . for drop frame timecodes
+var timecodePattern = regexp.MustCompile(`^(\d{2}):(\d{2}):(\d{2})([:;.])(\d{2})$`)
+
+// Returns the recording a file is a GoPro chapter of and the number of the chapter, ok is false if it isn't one
+func goProChapter(fileName string) (recording string, chapter int, ok bool) {
+	m := goProChapterPattern.FindStringSubmatch(filepath.Base(fileName))
+	if m == nil {
+		return "", 0, false
+	}
+	dir := filepath.Dir(fileName)
+	switch {
+	case len(m[1]) > 0:
+		chapter, _ = strconv.Atoi(m[2])
+		return filepath.Join(dir, "G"+strings.ToUpper(m[1])+m[3]), chapter, true
+	case len(m[4]) > 0:
+		return filepath.Join(dir, "GOPR"+m[4]), 0, true
+	}
+	chapter, _ = strconv.Atoi(m[5])
+	return filepath.Join(dir, "GOPR"+m[6]), chapter, true
+}
+
+// Finds the recordings split into chapters among files, returning the later chapters of each recording by its first one.
+// These are GoPro chapters, and files at the FAT32 size limit followed by the next number with the same prefix,
+// unless their timecodes or creation times show the next file was recorded separately.
+func (p *Processor) findChapters(ctx context.Context, fileNames []string) map[string][]string {
+	type part struct {
+		chapter  int
+		fileName string
+	}
+	recordings := make(map[string][]part)
+	found := make(map[string]bool)
+	for _, fileName := range fileNames {
+		found[fileName] = true
+		if recording, chapter, ok := goProChapter(fileName); ok {
+			recordings[recording] = append(recordings[recording], part{chapter, fileName})
+		}
+	}
+
+	chapters := make(map[string][]string)
+	for _, parts := range recordings {
+		if len(parts) < 2 {
+			continue
+		}
+		sort.Slice(parts, func(i, j int) bool { return parts[i].chapter < parts[j].chapter })
+		for _, part := range parts[1:] {
+			chapters[parts[0].fileName] = append(chapters[parts[0].fileName], part.fileName)
+		}
+	}
+
+	sorted := append([]string(nil), fileNames...)
+	sort.Strings(sorted)
+	joined := make(map[string]bool)
+	for _, fileName := range sorted {
+		if _, _, ok := goProChapter(fileName); ok || joined[fileName] || !IsMovie(fileName) {
+			continue
+		}
+		for current := fileName; fileSize(p.FS, current) >= splitSize; {
+			next, ok := nextNumber(current)
+			if !ok || !found[next] || !p.continues(ctx, current, next) {
+				break
+			}
+			chapters[fileName] = append(chapters[fileName], next)
+			joined[next] = true
+			current = next
+		}
+	}
+	return chapters
+}
+
+// splitPart is where a file split from a recording starts and how long it is
+type splitPart struct {
+	info    Info
+	created time.Time
+	// timecode is the timecode of the first frame in seconds, negative if the file has none
+	timecode  float64
+	dropFrame bool
+}
+
+// Reads the start and length of a file split from a recording, ok is false if ffprobe can't read it
+func (p *Processor) splitPart(ctx context.Context, fileName string) (part splitPart, ok bool) {
+	info, err := p.Encoder.Probe(ctx, fileName)
+	if err != nil {
+		return part, false
+	}
+	part.info, part.timecode = info, -1
+	tags, err := probeTags(ctx, p.Encoder.Runner, fileName)
+	if err != nil {
+		return part, true
+	}
+	for _, tag := range creationTimeTags {
+		if created, ok := parseCreationTime(tags.Get(tag)); ok {
+			part.created = created
+			break
+		}
+	}
+	// timecodes count whole frames, 30 a second at 29.97 fps
+	fps := math.Round(info.FrameRate)
+	if m := timecodePattern.FindStringSubmatch(tags.Get("timecode")); m != nil && fps > 0 {
+		hours, _ := strconv.Atoi(m[1])
+		minutes, _ := strconv.Atoi(m[2])
+		seconds, _ := strconv.Atoi(m[3])
+		frames, _ := strconv.Atoi(m[5])
+		part.timecode = float64(hours*3600+minutes*60+seconds) + float64(frames)/fps
+		part.dropFrame = m[4] != ":"
+	}
+	return part, true
+}
+
+// Returns false if the timecode or creation time of the next file shows it doesn't continue the recording of the file,
+// true if it does or they can't tell
+func (p *Processor) continues(ctx context.Context, fileName, next string) bool {
+	prev, ok := p.splitPart(ctx, fileName)
+	if !ok {
+		return true
+	}
+	part, ok := p.splitPart(ctx, next)
+	if !ok {
+		return true
+	}
+	if prev.timecode >= 0 && part.timecode >= 0 {
+		elapsed := part.timecode - prev.timecode
+		if elapsed < 0 {
+			// timecodes start over at midnight
+			elapsed += 24 * 3600
+		}
+		// timecodes that don't drop frames run slow at fractional frame rates
+		want := prev.info.Duration.Seconds()
+		if fps := prev.info.FrameRate; !prev.dropFrame && fps > 0 {
+			want *= fps / math.Round(fps)
+		}
+		if math.Abs(elapsed-want) <= splitGap.Seconds() {
+			return true
+		}
+		log.Info("Not joining: ", next, " its timecode doesn't continue: ", fileName)
+		return false
+	}
+	if prev.created.IsZero() || part.created.IsZero() {
+		return true
+	}
+	// cameras date the parts by when the recording started, when each part started or when it was written
+	gap := part.created.Sub(prev.created)
+	for _, want := range []time.Duration{0, prev.info.Duration, part.info.Duration} {
+		if gap-want <= splitGap && want-gap <= splitGap {
+			return true
+		}
+	}
+	log.Info("Not joining: ", next, " its creation time doesn't continue: ", fileName)
+	return false
+}
+
+// Returns the name with the next number, eg. MVI_0043.MP4 for MVI_0042.MP4, keeping the number of digits
+func nextNumber(fileName string) (string, bool) {
+	m := numberedPattern.FindStringSubmatch(filepath.Base(fileName))
+	if m == nil {
+		return "", false
+	}
+	number, err := strconv.Atoi(m[2])
+	if err != nil {
+		return "", false
+	}
+	next := fmt.Sprintf("%0*d", len(m[2]), number+1)
+	return filepath.Join(filepath.Dir(fileName), m[1]+next+m[3]), true
+}
+
+// Joins the chapters of a recording into one file without encoding them, leaving out GoPro telemetry tracks
+func (p *Processor) joinChapters(ctx context.Context, chapters []string, destFile string) error {
+	var list strings.Builder
+	for _, chapter := range chapters {
+		abs, err := filepath.Abs(chapter)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`))
+	}
+	listFile := destFile + ".txt"
+	if err := ioutil.WriteFile(listFile, []byte(list.String()), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(listFile)
+	args := []string{"-y", "-v", "error", "-f", "concat", "-safe", "0", "-i", listFile,
+		"-map", "0:v", "-map", "0:a?", "-c", "copy", "-map_metadata", "0", destFile}
+	log.Info("Joining chapters: ", strings.Join(chapters, ", "))
+	return runnerOrExec(p.Encoder.Runner).Run(ctx, "ffmpeg", args, nil, nil)
+}
@@ -0,0 +1,156 @@
+package shrink
+
+import (
+	"context"
+	"errors"
+	"io"
+	filepath "path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNextNumber(t *testing.T) {
+	tests := []struct {
+		fileName, want string
+		ok             bool
+	}{
+		{"MVI_0042.MP4", "MVI_0043.MP4", true},
+		{"MVI_0999.MP4", "MVI_1000.MP4", true},
+		{"clip9.mov", "clip10.mov", true},
+		{filepath.Join("DCIM", "100CANON", "MVI_0042.MOV"), filepath.Join("DCIM", "100CANON", "MVI_0043.MOV"), true},
+		{"holiday.mp4", "", false},
+		{"0042", "", false},
+	}
+	for _, test := range tests {
+		got, ok := nextNumber(test.fileName)
+		if got != test.want || ok != test.ok {
+			t.Errorf("nextNumber(%q) = %q, %v, want %q, %v", test.fileName, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestGoProChapter(t *testing.T) {
+	tests := []struct {
+		fileName, recording string
+		chapter             int
+		ok                  bool
+	}{
+		{"GX010123.MP4", "GX0123", 1, true},
+		{"GX020123.MP4", "GX0123", 2, true},
+		{"gh030042.mp4", "GH0042", 3, true},
+		{"GOPR0123.MP4", "GOPR0123", 0, true},
+		{"GP010123.MP4", "GOPR0123", 1, true},
+		{"GX010123.MOV", "", 0, false},
+		{"MVI_0123.MP4", "", 0, false},
+	}
+	for _, test := range tests {
+		recording, chapter, ok := goProChapter(test.fileName)
+		if recording != test.recording || chapter != test.chapter || ok != test.ok {
+			t.Errorf("goProChapter(%q) = %q, %d, %v, want %q, %d, %v", test.fileName, recording, chapter, ok, test.recording, test.chapter, test.ok)
+		}
+	}
+}
+
+func TestFindChapters(t *testing.T) {
+	// parts are the probe and tags json of each file, files without any can't be probed
+	type part struct {
+		size        int64
+		probe, tags string
+	}
+	tests := []struct {
+		name  string
+		parts map[string]part
+		want  map[string][]string
+	}{
+		{
+			name: "gopro chapters",
+			parts: map[string]part{
+				"GX030123.MP4": {}, "GX010123.MP4": {}, "GX020123.MP4": {}, "GX010124.MP4": {},
+				"GOPR0200.MP4": {}, "GP010200.MP4": {},
+			},
+			want: map[string][]string{
+				"GX010123.MP4": {"GX020123.MP4", "GX030123.MP4"},
+				"GOPR0200.MP4": {"GP010200.MP4"},
+			},
+		},
+		{
+			name: "split at the size limit",
+			parts: map[string]part{
+				"MVI_0042.MP4": {size: splitSize}, "MVI_0043.MP4": {size: splitSize}, "MVI_0044.MP4": {size: 1 << 30}, "MVI_0045.MP4": {size: 1 << 30},
+				"MVI_0050.MP4": {size: 1 << 30}, "MVI_0051.MP4": {size: splitSize},
+			},
+			want: map[string][]string{"MVI_0042.MP4": {"MVI_0043.MP4", "MVI_0044.MP4"}},
+		},
+		{
+			name: "next file missing",
+			parts: map[string]part{
+				"MVI_0042.MP4": {size: splitSize}, "MVI_0044.MP4": {size: 1 << 30},
+			},
+			want: map[string][]string{},
+		},
+		{
+			name: "creation times continue",
+			parts: map[string]part{
+				"MVI_0001.MP4": {size: splitSize, probe: `{"format": {"duration": "1500"}}`,
+					tags: `{"format": {"tags": {"creation_time": "2024-05-13T10:00:00Z"}}}`},
+				"MVI_0002.MP4": {size: 1 << 30, probe: `{"format": {"duration": "300"}}`,
+					tags: `{"format": {"tags": {"creation_time": "2024-05-13T10:25:01Z"}}}`},
+			},
+			want: map[string][]string{"MVI_0001.MP4": {"MVI_0002.MP4"}},
+		},
+		{
+			name: "creation times don't continue",
+			parts: map[string]part{
+				"MVI_0001.MP4": {size: splitSize, probe: `{"format": {"duration": "1500"}}`,
+					tags: `{"format": {"tags": {"creation_time": "2024-05-13T10:00:00Z"}}}`},
+				"MVI_0002.MP4": {size: 1 << 30, probe: `{"format": {"duration": "300"}}`,
+					tags: `{"format": {"tags": {"creation_time": "2024-05-13T12:00:00Z"}}}`},
+			},
+			want: map[string][]string{},
+		},
+		{
+			name: "timecodes win over creation times",
+			parts: map[string]part{
+				"C0001.MP4": {size: splitSize, probe: `{"streams": [{"avg_frame_rate": "25/1"}], "format": {"duration": "600"}}`,
+					tags: `{"format": {"tags": {"creation_time": "2024-05-13T10:00:00Z"}}, "streams": [{"tags": {"timecode": "23:55:00:00"}}]}`},
+				"C0002.MP4": {size: 1 << 30, probe: `{"streams": [{"avg_frame_rate": "25/1"}], "format": {"duration": "60"}}`,
+					tags: `{"format": {"tags": {"creation_time": "2024-05-13T18:00:00Z"}}, "streams": [{"tags": {"timecode": "00:05:00:00"}}]}`},
+			},
+			want: map[string][]string{"C0001.MP4": {"C0002.MP4"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fsys := &fakeFS{sizes: map[string]int64{}}
+			var fileNames []string
+			for name, part := range test.parts {
+				fileName := filepath.Join(dir, name)
+				fsys.sizes[fileName] = part.size
+				fileNames = append(fileNames, fileName)
+			}
+			runner := fakeRunner(func(name string, args []string, stdout, stderr io.Writer) error {
+				part := test.parts[filepath.Base(args[len(args)-1])]
+				if len(part.probe) == 0 {
+					return errors.New("exit status 1")
+				}
+				if probeRunner(part.probe, part.tags, "").Run(context.Background(), name, args, stdout, stderr) != nil {
+					t.Fatal("fake ffprobe failed")
+				}
+				return nil
+			})
+			p := New(Options{FS: fsys, Encoder: Encoder{Runner: runner}})
+
+			chapters := p.findChapters(context.Background(), fileNames)
+			got := map[string][]string{}
+			for first, rest := range chapters {
+				for _, fileName := range rest {
+					got[filepath.Base(first)] = append(got[filepath.Base(first)], filepath.Base(fileName))
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("findChapters() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkSampleLength is how much is decoded at the start, middle and end of a movie by VerifySamples
+const checkSampleLength = 2 * time.Second
+
+// Check looks for damage with a quick ffprobe pass: movies ffprobe can't read, that are empty, have no video,
+// a zero duration or are cut short. It returns the problems it found, none for a healthy movie.
+func (e Encoder) Check(ctx context.Context, fileName string) []string {
+	var out, stderr bytes.Buffer
+	args := []string{"-v", "error", "-show_entries", "stream=codec_type:format=duration,size", "-of", "json", fileName}
+	err := runnerOrExec(e.Runner).Run(ctx, "ffprobe", args, &out, &stderr)
+	// only the first message, damaged files can report thousands
+	message := strings.SplitN(strings.TrimSpace(stderr.String()), "\n", 2)[0]
+	if err != nil {
+		if len(message) > 0 {
+			return []string{"unreadable: " + message}
+		}
+		return []string{"unreadable: " + err.Error()}
+	}
+	var probe struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+			Size     string `json:"size"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return []string{"unreadable: " + err.Error()}
+	}
+
+	var problems []string
+	if size, _ := strconv.ParseInt(probe.Format.Size, 10, 64); size == 0 {
+		problems = append(problems, "empty file")
+	}
+	video := false
+	for _, stream := range probe.Streams {
+		video = video || stream.CodecType == "video"
+	}
+	if !video {
+		problems = append(problems, "no video stream")
+	}
+	if seconds, _ := strconv.ParseFloat(probe.Format.Duration, 64); seconds <= 0 {
+		problems = append(problems, "zero duration")
+	}
+	// eg. "partial file" for movies cut short while they were copied
+	if len(message) > 0 {
+		problems = append(problems, message)
+	}
+	return problems
+}
+
+// VerifySamples decodes a few seconds at the start, middle and end of a movie and returns an error if they are damaged,
+// a much quicker check than Verify that still finds most unplayable movies
+func (e Encoder) VerifySamples(ctx context.Context, fileName string, duration time.Duration) error {
+	runner := runnerOrExec(e.Runner)
+	for _, position := range []float64{0, 0.5, 1} {
+		start := time.Duration(position * float64(duration-checkSampleLength))
+		if start < 0 {
+			start = 0
+		}
+		var stderr bytes.Buffer
+		args := []string{"-v", "error", "-ss", fmt.Sprintf("%.3f", start.Seconds()), "-t", fmt.Sprintf("%.3f", checkSampleLength.Seconds()),
+			"-i", fileName, "-f", "null", "-"}
+		err := runner.Run(ctx, "ffmpeg", args, nil, &stderr)
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return fmt.Errorf("at %s: %w", start.Round(time.Second), errors.New(strings.SplitN(msg, "\n", 2)[0]))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
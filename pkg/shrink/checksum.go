@@ -0,0 +1,84 @@
+package shrink
+
+import (
+	"bytes"
+	"io/ioutil"
+	filepath "path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Ways of writing the checksums of shrunk files
+const (
+	// ChecksumSidecar writes a sha256sum file next to each shrunk file, eg. 20160513_181656.mp4.sha256
+	ChecksumSidecar = "sidecar"
+	// ChecksumManifest keeps the sums of the shrunk files of each dir in a SHA256SUMS file in that dir
+	ChecksumManifest = "manifest"
+)
+
+const (
+	checksumSuffix   = ".sha256"
+	checksumManifest = "SHA256SUMS"
+)
+
+// Writes the sha256 of a shrunk file in the format of sha256sum, so it can be checked with sha256sum -c in its dir
+func (p *Processor) writeChecksum(fileName string) {
+	sum, err := fileSHA256(p.FS, fileName)
+	if err != nil {
+		log.Error("Could not checksum: ", fileName, err)
+		return
+	}
+	line := sum + "  " + filepath.Base(fileName) + "\n"
+	checksumFile := fileName + checksumSuffix
+	var data []byte
+	if p.Checksums == ChecksumManifest {
+		checksumFile = filepath.Join(filepath.Dir(fileName), checksumManifest)
+		data = p.manifestWithout(checksumFile, filepath.Base(fileName))
+	}
+	if err := writeFile(p.FS, checksumFile, append(data, line...)); err != nil {
+		log.Error("Could not write checksum: ", checksumFile, err)
+		return
+	}
+	log.Debug("Wrote checksum: ", checksumFile)
+}
+
+// Returns the lines of a checksum manifest leaving out the one of a file, which is being replaced
+func (p *Processor) manifestWithout(manifestFile, name string) []byte {
+	in, err := p.FS.Open(manifestFile)
+	if err != nil {
+		return nil
+	}
+	defer in.Close()
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil
+	}
+	var kept bytes.Buffer
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		// the name follows the sum and a space, then another space for text or * for binary mode
+		if fields := strings.SplitN(strings.TrimRight(line, "\n"), " ", 2); len(fields) == 2 && strings.TrimLeft(fields[1], " *") == name {
+			continue
+		}
+		kept.WriteString(line)
+	}
+	return kept.Bytes()
+}
+
+// Writes a file through a temp file next to it, so it is never half written
+func writeFile(fsys FS, fileName string, data []byte) error {
+	tmpName := fileName + ".tmp"
+	out, err := fsys.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fsys.Remove(tmpName)
+		return err
+	}
+	return fsys.Rename(tmpName, fileName)
+}
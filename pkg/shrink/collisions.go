@@ -0,0 +1,109 @@
+package shrink
+
+import (
+	"context"
+	"os"
+	"path"
+	filepath "path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// What to do when the name of a shrunk file is taken at a destination, see Options.Collisions
+const (
+	// CollisionRename names it after its camera or adds a counter, same as for files in the dir of the original
+	CollisionRename = "rename"
+	// CollisionSkip leaves the original alone and reports it as skipped
+	CollisionSkip = "skip"
+)
+
+// Lister is implemented by destinations that can list the names of the files they hold,
+// relative to their root with forward slashes
+type Lister interface {
+	List(ctx context.Context) ([]string, error)
+}
+
+// Returns the names of the files at the destinations that can be listed, listing them once per processor.
+// Files uploaded since are added to them.
+func (p *Processor) destinationNames(ctx context.Context) map[string]bool {
+	p.destinationsOnce.Do(func() {
+		p.destinations = make(map[string]bool)
+		var listers []Lister
+		for _, uploader := range p.Uploaders {
+			if lister, ok := uploader.(Lister); ok {
+				listers = append(listers, lister)
+			}
+		}
+		for _, mirror := range p.Mirrors {
+			if lister, ok := mirror.Dest.(Lister); ok {
+				listers = append(listers, lister)
+			}
+		}
+		if lister, ok := p.output.(Lister); ok {
+			listers = append(listers, lister)
+		}
+		for _, lister := range listers {
+			names, err := lister.List(ctx)
+			if err != nil {
+				log.Error("Could not list destination, names taken there aren't checked: ", err)
+				continue
+			}
+			for _, name := range names {
+				p.destinations[name] = true
+			}
+		}
+		if len(listers) > 0 {
+			log.Info("Files at destinations: ", len(p.destinations))
+		}
+	})
+	p.destinationsMu.Lock()
+	defer p.destinationsMu.Unlock()
+	return p.destinations
+}
+
+// Returns true if a file at one of the destinations has the name, relative to the input root, other than the original itself
+func (p *Processor) takenAtDestination(ctx context.Context, name, relName string) bool {
+	if relName == name {
+		return false
+	}
+	names := p.destinationNames(ctx)
+	p.destinationsMu.Lock()
+	defer p.destinationsMu.Unlock()
+	return names[relName]
+}
+
+// Remembers a file uploaded to the destinations, so later files don't take its name
+func (p *Processor) claimDestination(relName string) {
+	p.destinationsMu.Lock()
+	defer p.destinationsMu.Unlock()
+	if p.destinations != nil {
+		p.destinations[relName] = true
+	}
+}
+
+// Returns the name relative to the input root of a file named in the dir of the original, name is that of the original
+func siblingName(name, fileName string) string {
+	return path.Join(path.Dir(name), fileName)
+}
+
+// List returns the names of all files below the dir
+func (l LocalUploader) List(ctx context.Context) ([]string, error) {
+	var names []string
+	err := filepath.Walk(l.Dir, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, err := filepath.Rel(l.Dir, fileName)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return ctx.Err()
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return names, err
+}
@@ -0,0 +1,43 @@
+package shrink
+
+import (
+	"context"
+	"fmt"
+	filepath "path/filepath"
+	"time"
+)
+
+// Compare saves frames of an original and its shrunk version side by side in outDir, original on the left, as
+// compare_01.jpg and so on, at frames positions spread across the movie and scaled to height lines.
+// If clip isn't zero it saves clips of that length instead, as compare_01.mp4 and so on. It returns the files it saved.
+func (e Encoder) Compare(ctx context.Context, original, shrunk, outDir string, frames, height int, clip time.Duration) ([]string, error) {
+	info, err := e.Probe(ctx, original)
+	if err != nil {
+		return nil, err
+	}
+	if info.Duration <= 0 {
+		return nil, fmt.Errorf("unknown duration: %s", original)
+	}
+	runner := runnerOrExec(e.Runner)
+	filter := fmt.Sprintf("[0:v]scale=-2:%d,setsar=1[a];[1:v]scale=-2:%d,setsar=1[b];[a][b]hstack", height, height)
+	var saved []string
+	for i := 1; i <= frames; i++ {
+		at := time.Duration(float64(info.Duration) * float64(i) / float64(frames+1))
+		start := fmt.Sprintf("%.3f", at.Seconds())
+		args := []string{"-y", "-v", "error", "-ss", start, "-i", original, "-ss", start, "-i", shrunk, "-filter_complex", filter}
+		var outFile string
+		if clip > 0 {
+			outFile = filepath.Join(outDir, fmt.Sprintf("compare_%02d.mp4", i))
+			// nearly lossless, so the comparison shows the shrunk version and not this encode
+			args = append(args, "-t", fmt.Sprintf("%.3f", clip.Seconds()), "-an", "-c:v", "libx264", "-crf", "10", "-preset", "veryfast", outFile)
+		} else {
+			outFile = filepath.Join(outDir, fmt.Sprintf("compare_%02d.jpg", i))
+			args = append(args, "-frames:v", "1", "-q:v", "1", outFile)
+		}
+		if err := runner.Run(ctx, "ffmpeg", args, nil, nil); err != nil {
+			return saved, err
+		}
+		saved = append(saved, outFile)
+	}
+	return saved, nil
+}
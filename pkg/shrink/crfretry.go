@@ -0,0 +1,53 @@
+package shrink
+
+import (
+	"context"
+	"fmt"
+	filepath "path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CRFRetry encodes movies that didn't shrink enough again at higher CRFs
+type CRFRetry struct {
+	// Step is how much the CRF goes up each time
+	Step int
+	// MaxCRF is the quality floor, the highest CRF tried
+	MaxCRF int
+}
+
+// Encodes a movie that didn't shrink enough again at higher CRFs until it does or the quality floor is reached.
+// Each encode that succeeds replaces destFile, and the result and settings are updated to match it.
+// Returns true if the movie shrank enough.
+func (p *Processor) retryCRF(ctx context.Context, sourceFile, inputFile, destFile, name string, settings *EncodeSettings, result *FileResult) bool {
+	encoder := p.encoderFor(ctx, sourceFile, name)
+	ext := filepath.Ext(destFile)
+	for crf := settings.CRF + p.CRFRetry.Step; crf <= p.CRFRetry.MaxCRF; crf += p.CRFRetry.Step {
+		log.Info("Encoding again at crf ", crf, ": ", sourceFile, " ratio: ", result.Ratio)
+		encoder.CRF = crf
+		retryFile := strings.TrimSuffix(destFile, ext) + fmt.Sprintf("_crf%d", crf) + ext
+		done := p.encoding(name)
+		err := encoder.Encode(ctx, inputFile, retryFile, p.progressFor(name))
+		done()
+		if err != nil {
+			p.FS.Remove(retryFile)
+			if ctx.Err() == nil {
+				log.Error("Could not encode again: ", sourceFile, err)
+			}
+			return false
+		}
+		if err := p.FS.Rename(retryFile, destFile); err != nil {
+			p.FS.Remove(retryFile)
+			log.Error("Could not encode again: ", sourceFile, err)
+			return false
+		}
+		*settings = encoder.Settings()
+		result.OutSize = fileSize(p.FS, destFile)
+		result.Ratio = float64(result.OutSize) / float64(result.InSize)
+		if p.Swapper.ShouldSwap(result.Ratio) {
+			return true
+		}
+	}
+	return false
+}
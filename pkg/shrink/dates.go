@@ -0,0 +1,61 @@
+package shrink
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// DefaultDatePatterns read capture dates from the names phones and cameras give movies.
+// GoPro names like GX010123.MP4 hold no date, their date comes from the metadata.
+var DefaultDatePatterns = []*regexp.Regexp{
+	// 20160513_181656.mp4, Samsung and most Android phones
+	regexp.MustCompile(`^(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})_(?:(?P<hour>\d{2})(?P<minute>\d{2})(?P<second>\d{2}))?`),
+	// PXL_20160513_181656123.mp4 or VID_20160513_181656.mp4
+	regexp.MustCompile(`^(?:PXL|VID|IMG)_(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})_(?P<hour>\d{2})(?P<minute>\d{2})(?P<second>\d{2})`),
+	// VID-20160513-WA0001.mp4 from WhatsApp
+	regexp.MustCompile(`^(?:VID|IMG)-(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})-WA\d+`),
+	// DJI_20160513181656_0001_D.MP4 from DJI drones
+	regexp.MustCompile(`^DJI_(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})(?P<hour>\d{2})(?P<minute>\d{2})(?P<second>\d{2})`),
+	// 2016-05-13 18.16.56.mp4 from Dropbox camera uploads
+	regexp.MustCompile(`^(?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2}) (?P<hour>\d{2})\.(?P<minute>\d{2})\.(?P<second>\d{2})`),
+}
+
+// ParseDatePattern compiles a pattern reading capture dates from file names. It must have the named groups
+// year, month and day and can have hour, minute and second, eg. ^CAM(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})
+func ParseDatePattern(expr string) (*regexp.Regexp, error) {
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range []string{"year", "month", "day"} {
+		if pattern.SubexpIndex(group) < 0 {
+			return nil, fmt.Errorf("date pattern %s has no (?P<%s>...) group", expr, group)
+		}
+	}
+	return pattern, nil
+}
+
+// Returns the date in a file name from the first pattern that matches it, then the default patterns.
+// The date is read as being in the given time zone.
+func dateFromName(patterns []*regexp.Regexp, loc *time.Location, name string) (time.Time, bool) {
+	for _, pattern := range append(append([]*regexp.Regexp(nil), patterns...), DefaultDatePatterns...) {
+		matches := pattern.FindStringSubmatch(name)
+		if matches == nil {
+			continue
+		}
+		part := func(group string) int {
+			i := pattern.SubexpIndex(group)
+			if i < 0 {
+				return 0
+			}
+			n, _ := strconv.Atoi(matches[i])
+			return n
+		}
+		if date, ok := validDate(loc, part("year"), part("month"), part("day"), part("hour"), part("minute"), part("second")); ok {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
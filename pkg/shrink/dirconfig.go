@@ -0,0 +1,68 @@
+package shrink
+
+import (
+	filepath "path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DirConfigFile is the name of the files overriding the settings of the dir they are in and the dirs below it
+const DirConfigFile = ".shrinkrc"
+
+// DirOverride is what a DirConfigFile changes for its dir and the dirs below it
+type DirOverride struct {
+	// Encoder encodes the movies below the dir
+	Encoder Encoder
+	// Skip leaves the movies below the dir alone
+	Skip bool
+}
+
+// Reads the DirConfigFile of a dir given the encoder of the dir above it, false if there is none or it can't be read
+func (p *Processor) dirOverride(dirName string, parent Encoder) (DirOverride, bool) {
+	fileName := filepath.Join(dirName, DirConfigFile)
+	if p.DirConfig == nil {
+		return DirOverride{}, false
+	}
+	if _, err := p.FS.Stat(fileName); err != nil {
+		return DirOverride{}, false
+	}
+	override, err := p.DirConfig(fileName, parent)
+	if err != nil {
+		log.Error("Ignoring dir config: ", fileName, " ", err)
+		return DirOverride{}, false
+	}
+	return override, true
+}
+
+// Returns true if the DirConfigFile of a dir skips it, for the Scanner
+func (p *Processor) skipByDirConfig(dirName string) bool {
+	override, ok := p.dirOverride(dirName, p.Encoder)
+	if ok && override.Skip {
+		log.Info("Skipping dir: ", dirName, " skipped by ", DirConfigFile)
+	}
+	return ok && override.Skip
+}
+
+// Applies the DirConfigFiles of the dirs from the input root down to the dir of a file to its encoder,
+// name is its name relative to the root. Dir rules of deeper dirs win over the files of the dirs above them,
+// which is where the encoder came from, depth dirs deep.
+func (p *Processor) dirConfigEncoder(fileName, name string, encoder Encoder, depth int) Encoder {
+	if p.DirConfig == nil {
+		return encoder
+	}
+	dirName := strings.TrimSuffix(fileName, filepath.FromSlash(name))
+	dirs := strings.Split(name, "/")
+	for level := 0; level < len(dirs); level++ {
+		if level > 0 {
+			dirName = filepath.Join(dirName, dirs[level-1])
+		}
+		if level < depth {
+			continue
+		}
+		if override, ok := p.dirOverride(dirName, encoder); ok {
+			encoder = override.Encoder
+		}
+	}
+	return encoder
+}
@@ -0,0 +1,333 @@
+package shrink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	filepath "path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encoder settings used when they aren't set
+const (
+	DefaultCodec        = "libx264"
+	DefaultPreset       = "medium"
+	DefaultCRF          = 28
+	DefaultAudioBitrate = "96k"
+)
+
+// RemuxMaxRatio is the output/input size ratio below which remuxed movies replace their originals,
+// they are about the same size and are remuxed to fix their container rather than to save space
+const RemuxMaxRatio = 1.05
+
+// copyCodec is the codec of the settings of remuxed movies, which copy their streams
+const copyCodec = "copy"
+
+// Kinds of streams that can be stripped from movies
+const (
+	// StripData drops data streams, like timecodes and the GPS tracks of action cameras
+	StripData = "data"
+	// StripAttachments drops attached files, like the fonts of mkv subtitles
+	StripAttachments = "attachments"
+	// StripCover drops cover art and thumbnail video streams
+	StripCover = "cover"
+	// StripAngles keeps only the first video stream, dropping the other angles of multi-angle recordings
+	StripAngles = "angles"
+)
+
+// StripKinds are the kinds of streams that can be stripped
+var StripKinds = []string{StripData, StripAttachments, StripCover, StripAngles}
+
+// Encoder shrinks movies with ffmpeg, zero values use the defaults
+type Encoder struct {
+	// Codec is the ffmpeg video codec, eg. libx265 or h264_nvenc
+	Codec  string
+	Preset string
+	// CRF is the constant rate factor, AutoCRF picks it for each movie with CRFMap
+	CRF int
+	// CRFMap picks the CRF of movies for AutoCRF, DefaultCRFMap if empty
+	CRFMap CRFMap
+	// AudioBitrate is the aac bitrate, eg. 128k
+	AudioBitrate string
+	// Filter is an ffmpeg video filter, eg. scale=-2:720
+	Filter string
+	// Tune is the codec tuning, eg. film or animation for libx264, none if empty
+	Tune string
+	// MaxFPS lowers the frame rate of movies recorded faster, unlimited if zero
+	MaxFPS int
+	// GOP is the most frames between keyframes, the codec's default if zero
+	GOP int
+	// Strip lists the kinds of streams to drop, eg. StripData, ffmpeg picks the streams to keep if empty
+	Strip []string
+	// AudioLanguages keeps only the audio tracks in these languages, eg. eng, und for tracks without one
+	// or AudioDefault for the default track, ffmpeg picks the tracks to keep if empty
+	AudioLanguages []string
+	// AudioCopy copies audio tracks that are already efficient, aac up to AudioCopyMaxBitrate,
+	// instead of transcoding them, the rest like pcm or ac3 are transcoded
+	AudioCopy bool
+	// DropSilentAudio leaves out the audio of movies whose tracks are all pure silence
+	DropSilentAudio bool
+	// Runner runs ffmpeg and ffprobe, os/exec if nil
+	Runner Runner
+}
+
+// EncodeSettings are the ffmpeg settings an encoder uses, with the defaults filled in
+type EncodeSettings struct {
+	Codec           string   `json:"codec"`
+	Preset          string   `json:"preset,omitempty"`
+	CRF             int      `json:"crf,omitempty"`
+	AudioBitrate    string   `json:"audioBitrate,omitempty"`
+	Filter          string   `json:"filter,omitempty"`
+	Tune            string   `json:"tune,omitempty"`
+	MaxFPS          int      `json:"maxFps,omitempty"`
+	GOP             int      `json:"gop,omitempty"`
+	Strip           []string `json:"strip,omitempty"`
+	AudioLanguages  []string `json:"audioLanguages,omitempty"`
+	AudioCopy       bool     `json:"audioCopy,omitempty"`
+	DropSilentAudio bool     `json:"dropSilentAudio,omitempty"`
+	// Quality is the quality photos are recompressed with
+	Quality int `json:"quality,omitempty"`
+}
+
+// Settings returns the ffmpeg settings of the encoder, using the defaults for those that aren't set
+func (e Encoder) Settings() EncodeSettings {
+	settings := EncodeSettings{Codec: e.Codec, Preset: e.Preset, CRF: e.CRF, AudioBitrate: e.AudioBitrate, Filter: e.Filter, Tune: e.Tune, MaxFPS: e.MaxFPS,
+		GOP: e.GOP, Strip: e.Strip, AudioLanguages: e.AudioLanguages, AudioCopy: e.AudioCopy,
+		DropSilentAudio: e.DropSilentAudio}
+	if len(settings.Codec) == 0 {
+		settings.Codec = DefaultCodec
+	}
+	if len(settings.Preset) == 0 {
+		settings.Preset = DefaultPreset
+	}
+	if settings.CRF == 0 {
+		settings.CRF = DefaultCRF
+	}
+	if len(settings.AudioBitrate) == 0 {
+		settings.AudioBitrate = DefaultAudioBitrate
+	}
+	return settings
+}
+
+// Args returns the ffmpeg arguments to encode the source into dest, keeping and transcoding all audio tracks when
+// the encoder has AudioLanguages or AudioCopy, Encode reads the languages and codecs of the tracks to pick them
+func (e Encoder) Args(sourceFile, destFile string) []string {
+	return e.args(sourceFile, destFile, nil, nil)
+}
+
+// Returns the ffmpeg arguments to encode the source into dest with the -map arguments of the audio tracks to keep,
+// nil to keep them all, and whether each audio stream is copied, nil to transcode them all
+func (e Encoder) args(sourceFile, destFile string, audioMaps []string, audioCopies []bool) []string {
+	// the projection of 360° movies is only written with unofficial boxes allowed, which the -strict experimental
+	// of the aac encoder allows as well
+	args := append([]string{"-i", sourceFile, "-strict", "unofficial"}, e.codecArgs(destFile, audioMaps, audioCopies)...)
+	// keep the metadata of the source, use_metadata_tags writes tags mp4 has no atom for, like the
+	// com.apple.quicktime.location.ISO6709 GPS location and the make and model of phones and cameras
+	return append(args, "-map_metadata", "0", "-movflags", "+faststart+use_metadata_tags", destFile)
+}
+
+// Returns the ffmpeg arguments picking the streams and encoding them, for args
+func (e Encoder) codecArgs(destFile string, audioMaps []string, audioCopies []bool) []string {
+	settings := e.Settings()
+	var args []string
+	// empty audioMaps leave out the audio
+	if len(settings.Strip) > 0 || len(settings.AudioLanguages) > 0 || audioMaps != nil {
+		args = append(args, streamMaps(destFile, settings.Strip, audioMaps)...)
+	}
+	args = append(args, e.videoArgs()...)
+	if audioMaps != nil && len(audioMaps) == 0 {
+		return append(args, "-an")
+	}
+	return append(args, audioArgs(audioCopies, settings.AudioBitrate)...)
+}
+
+// Returns the ffmpeg arguments encoding the video
+func (e Encoder) videoArgs() []string {
+	settings := e.Settings()
+	// encoders that weren't resolved for a source use the default
+	crf := settings.CRF
+	if crf == AutoCRF {
+		crf = DefaultCRF
+	}
+	args := []string{"-c:v", settings.Codec, "-preset", settings.Preset, "-crf", strconv.Itoa(crf)}
+	if len(settings.Tune) > 0 {
+		args = append(args, "-tune", settings.Tune)
+	}
+	if len(settings.Filter) > 0 {
+		args = append(args, "-vf", settings.Filter)
+	}
+	if settings.MaxFPS > 0 {
+		args = append(args, "-fpsmax", strconv.Itoa(settings.MaxFPS))
+	}
+	if settings.GOP > 0 {
+		args = append(args, "-g", strconv.Itoa(settings.GOP))
+	}
+	return args
+}
+
+// Returns the ffmpeg arguments copying or transcoding each audio stream to aac, transcoding them all if copies is nil
+func audioArgs(copies []bool, bitrate string) []string {
+	transcode := []string{"-acodec", "aac", "-strict", "experimental", "-ab", bitrate}
+	all := len(copies) > 0
+	for _, copied := range copies {
+		all = all && copied
+	}
+	switch {
+	case all:
+		return []string{"-acodec", "copy"}
+	case len(copies) == 0:
+		return transcode
+	}
+	args := []string{"-strict", "experimental"}
+	for i, copied := range copies {
+		stream := "a:" + strconv.Itoa(i)
+		if copied {
+			args = append(args, "-c:"+stream, "copy")
+		} else {
+			args = append(args, "-c:"+stream, "aac", "-b:"+stream, bitrate)
+		}
+	}
+	return args
+}
+
+// RemuxArgs returns the ffmpeg arguments to rewrap the streams of the source into dest without encoding them.
+// mkv keeps all streams, mp4 the video and audio, which are moved to the front for streaming.
+func (e Encoder) RemuxArgs(sourceFile, destFile string) []string {
+	return e.remuxArgs(sourceFile, destFile, nil)
+}
+
+// Returns the ffmpeg arguments to rewrap the source into dest with the -map arguments of the audio tracks to keep
+func (e Encoder) remuxArgs(sourceFile, destFile string, audioMaps []string) []string {
+	args := append([]string{"-i", sourceFile}, streamMaps(destFile, e.Strip, audioMaps)...)
+	args = append(args, "-c", "copy", "-map_metadata", "0")
+	if !strings.EqualFold(filepath.Ext(destFile), ".mkv") {
+		args = append(args, "-movflags", "+faststart+use_metadata_tags")
+	}
+	return append(args, destFile)
+}
+
+// Returns the -map arguments keeping the streams of a movie that dest can hold, leaving out the stripped kinds
+// and the audio tracks that aren't in audioMaps, unless it is nil. mkv holds all kinds, mp4 only video and audio.
+func streamMaps(destFile string, strip, audioMaps []string) []string {
+	stripped := map[string]bool{}
+	for _, kind := range strip {
+		stripped[kind] = true
+	}
+	// V are the video streams that aren't cover art or thumbnails
+	video := "0:v"
+	switch {
+	case stripped[StripCover] && stripped[StripAngles]:
+		video = "0:V:0"
+	case stripped[StripCover]:
+		video = "0:V"
+	case stripped[StripAngles]:
+		video = "0:v:0"
+	}
+	audio := audioMaps
+	if audio == nil {
+		audio = []string{"-map", "0:a?"}
+	}
+	if !strings.EqualFold(filepath.Ext(destFile), ".mkv") {
+		return append([]string{"-map", video}, audio...)
+	}
+	if len(strip) == 0 && audioMaps == nil {
+		return []string{"-map", "0"}
+	}
+	maps := append(append([]string{"-map", video}, audio...), "-map", "0:s?")
+	if !stripped[StripData] {
+		maps = append(maps, "-map", "0:d?")
+	}
+	if !stripped[StripAttachments] {
+		maps = append(maps, "-map", "0:t?")
+	}
+	return maps
+}
+
+// Encode runs ffmpeg on the source, if progress isn't nil it is called with the fraction encoded so far.
+// ffmpeg is killed if the context is cancelled.
+func (e Encoder) Encode(ctx context.Context, sourceFile, destFile string, progress func(float64)) error {
+	if e.CRF == AutoCRF {
+		info, _ := e.Probe(ctx, sourceFile)
+		e = e.ResolveCRF(info)
+	}
+	audioMaps, audioCopies := e.audioStreams(ctx, sourceFile)
+	return e.run(ctx, sourceFile, e.args(sourceFile, destFile, audioMaps, audioCopies), progress)
+}
+
+// Remux rewraps the streams of the source into dest without encoding them, like Encode
+func (e Encoder) Remux(ctx context.Context, sourceFile, destFile string, progress func(float64)) error {
+	return e.run(ctx, sourceFile, e.remuxArgs(sourceFile, destFile, e.audioMaps(ctx, sourceFile)), progress)
+}
+
+// Runs ffmpeg with args on the source, calling progress with the fraction done if it isn't nil
+func (e Encoder) run(ctx context.Context, sourceFile string, ffmpegArgs []string, progress func(float64)) error {
+	var args []string
+	if progress != nil {
+		args = append(args, "-progress", "pipe:1", "-nostats")
+	}
+	args = append(args, ffmpegArgs...)
+	runner := runnerOrExec(e.Runner)
+	if progress == nil {
+		return runner.Run(ctx, "ffmpeg", args, nil, nil)
+	}
+	return runWithProgress(ctx, runner, args, probeDuration(ctx, runner, sourceFile), progress)
+}
+
+// ProbeDuration gets the duration of a movie using ffprobe, zero if it can't be determined
+func ProbeDuration(ctx context.Context, fileName string) time.Duration {
+	return probeDuration(ctx, ExecRunner{}, fileName)
+}
+
+// ProbeDuration with the given runner
+func probeDuration(ctx context.Context, runner Runner, fileName string) time.Duration {
+	var out bytes.Buffer
+	if err := runner.Run(ctx, "ffprobe", []string{"-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", fileName}, &out, nil); err != nil {
+		return 0
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(out.String()), 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// Runs ffmpeg with args starting with "-progress pipe:1", calling progress with the fraction encoded so far.
+// If the duration isn't known progress is still called, with zero, so callers can tell ffmpeg is alive.
+func runWithProgress(ctx context.Context, runner Runner, args []string, duration time.Duration, progress func(float64)) error {
+	stdout, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := runner.Run(ctx, "ffmpeg", args, w, nil)
+		w.Close()
+		done <- err
+	}()
+
+	// ffmpeg writes blocks of key=value lines, out_time_us is how far into the input it is
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if !found || key != "out_time_us" {
+			continue
+		}
+		if duration <= 0 {
+			progress(0)
+			continue
+		}
+		usec, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		fraction := float64(time.Duration(usec)*time.Microsecond) / float64(duration)
+		if fraction > 1 {
+			fraction = 1
+		}
+		progress(fraction)
+	}
+	// keep draining if the scanner gave up, so ffmpeg never blocks on a full pipe
+	io.Copy(ioutil.Discard, stdout)
+	return <-done
+}
@@ -0,0 +1,133 @@
+package shrink
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncoderArgs(t *testing.T) {
+	transcode := []string{"-acodec", "aac", "-strict", "experimental", "-ab", DefaultAudioBitrate}
+	metadata := []string{"-map_metadata", "0", "-movflags", "+faststart+use_metadata_tags"}
+	tests := []struct {
+		name    string
+		encoder Encoder
+		dest    string
+		want    []string
+	}{
+		{
+			name: "defaults",
+			dest: "out.mp4",
+			want: join([]string{"-i", "in.avi", "-strict", "unofficial", "-c:v", "libx264", "-preset", "medium", "-crf", "28"}, transcode, metadata, []string{"out.mp4"}),
+		},
+		{
+			name:    "unresolved auto crf",
+			encoder: Encoder{CRF: AutoCRF},
+			dest:    "out.mp4",
+			want:    join([]string{"-i", "in.avi", "-strict", "unofficial", "-c:v", "libx264", "-preset", "medium", "-crf", "28"}, transcode, metadata, []string{"out.mp4"}),
+		},
+		{
+			name:    "all video settings",
+			encoder: Encoder{Codec: "libx265", Preset: "slow", CRF: 30, AudioBitrate: "128k", Tune: "animation", Filter: "scale=-2:720", MaxFPS: 30, GOP: 600},
+			dest:    "out.mp4",
+			want: join([]string{"-i", "in.avi", "-strict", "unofficial", "-c:v", "libx265", "-preset", "slow", "-crf", "30",
+				"-tune", "animation", "-vf", "scale=-2:720", "-fpsmax", "30", "-g", "600",
+				"-acodec", "aac", "-strict", "experimental", "-ab", "128k"}, metadata, []string{"out.mp4"}),
+		},
+		{
+			name:    "strip cover into mp4",
+			encoder: Encoder{Strip: []string{StripCover}},
+			dest:    "out.mp4",
+			want: join([]string{"-i", "in.avi", "-strict", "unofficial", "-map", "0:V", "-map", "0:a?",
+				"-c:v", "libx264", "-preset", "medium", "-crf", "28"}, transcode, metadata, []string{"out.mp4"}),
+		},
+		{
+			name:    "strip data and angles from mkv",
+			encoder: Encoder{Strip: []string{StripData, StripAngles}},
+			dest:    "out.mkv",
+			want: join([]string{"-i", "in.avi", "-strict", "unofficial", "-map", "0:v:0", "-map", "0:a?", "-map", "0:s?", "-map", "0:t?",
+				"-c:v", "libx264", "-preset", "medium", "-crf", "28"}, transcode, metadata, []string{"out.mkv"}),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.encoder.Args("in.avi", test.dest); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Args() = %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRemuxArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoder Encoder
+		dest    string
+		want    []string
+	}{
+		{"mkv keeps all streams", Encoder{}, "out.mkv", []string{"-i", "in.mts", "-map", "0", "-c", "copy", "-map_metadata", "0", "out.mkv"}},
+		{"mp4 keeps video and audio", Encoder{}, "out.mp4", []string{"-i", "in.mts", "-map", "0:v", "-map", "0:a?", "-c", "copy", "-map_metadata", "0",
+			"-movflags", "+faststart+use_metadata_tags", "out.mp4"}},
+		{"mkv without attachments", Encoder{Strip: []string{StripAttachments}}, "out.mkv", []string{"-i", "in.mts", "-map", "0:v", "-map", "0:a?", "-map", "0:s?", "-map", "0:d?",
+			"-c", "copy", "-map_metadata", "0", "out.mkv"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.encoder.RemuxArgs("in.mts", test.dest); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("RemuxArgs() = %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAudioArgs(t *testing.T) {
+	tests := []struct {
+		copies []bool
+		want   []string
+	}{
+		{nil, []string{"-acodec", "aac", "-strict", "experimental", "-ab", "96k"}},
+		{[]bool{true, true}, []string{"-acodec", "copy"}},
+		{[]bool{true, false}, []string{"-strict", "experimental", "-c:a:0", "copy", "-c:a:1", "aac", "-b:a:1", "96k"}},
+	}
+	for _, test := range tests {
+		if got := audioArgs(test.copies, "96k"); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("audioArgs(%v) = %q, want %q", test.copies, got, test.want)
+		}
+	}
+}
+
+func TestEncodeRunsFFmpeg(t *testing.T) {
+	var got []string
+	runner := fakeRunner(func(name string, args []string, stdout, stderr io.Writer) error {
+		if name == "ffmpeg" {
+			got = args
+			return nil
+		}
+		// the duration for the progress, and the codec and resolution for the auto crf
+		io.WriteString(stdout, `{"streams": [{"codec_name": "h264", "width": 1920, "height": 1080}], "format": {"duration": "60.0"}}`)
+		return nil
+	})
+	encoder := Encoder{CRF: 23, Runner: runner}
+	if err := encoder.Encode(context.Background(), "in.mov", "out.mp4", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := encoder.Args("in.mov", "out.mp4"); !containsArgs(got, want) {
+		t.Errorf("ffmpeg ran with %q, want %q", got, want)
+	}
+}
+
+// Returns the slices joined together
+func join(parts ...[]string) []string {
+	var joined []string
+	for _, part := range parts {
+		joined = append(joined, part...)
+	}
+	return joined
+}
+
+// Returns true if args has want in order, with the progress arguments runWithProgress adds around them
+func containsArgs(args, want []string) bool {
+	return strings.Contains(strings.Join(args, "\x00"), strings.Join(want, "\x00"))
+}
@@ -0,0 +1,122 @@
+package shrink
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	filepath "path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bitsPerPixel is about how many bits codecs spend per pixel of each frame at DefaultCRF and the medium preset,
+// from encoding typical phone and camera footage
+var bitsPerPixel = map[string]float64{
+	"libx264":    0.04,
+	"libx265":    0.025,
+	"libsvtav1":  0.02,
+	"libaom-av1": 0.02,
+}
+
+// defaultBitsPerPixel is used for other codecs, hardware encoders spend more bits for the same quality
+const defaultBitsPerPixel = 0.05
+
+// Estimate guesses the size of a movie once it is encoded, from its resolution, frame rate and duration.
+// Each 6 CRF below DefaultCRF about doubles the video bit rate. Movies that can't be estimated keep their size.
+func (e Encoder) Estimate(info Info) int64 {
+	if info.Duration <= 0 || info.Width == 0 || info.Height == 0 {
+		return info.Size
+	}
+	settings := e.ResolveCRF(info).Settings()
+	bpp, ok := bitsPerPixel[settings.Codec]
+	if !ok {
+		bpp = defaultBitsPerPixel
+	}
+	fps := info.FrameRate
+	if fps == 0 {
+		fps = 30
+	}
+	videoBitRate := float64(info.Width*info.Height) * fps * bpp * math.Pow(2, float64(DefaultCRF-settings.CRF)/6)
+	bits := (videoBitRate + float64(parseBitRate(settings.AudioBitrate))) * info.Duration.Seconds()
+	return int64(bits / 8)
+}
+
+// EstimateSavings guesses how many bytes shrinking a movie saves, zero if it wouldn't shrink below the ratio
+// at which originals are replaced
+func (e Encoder) EstimateSavings(info Info, maxRatio float64) int64 {
+	return Savings(info, e.Estimate(info), maxRatio)
+}
+
+// Parses a bit rate like 96k or 2M into bits per second, 0 if it is invalid
+func parseBitRate(rate string) int64 {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(rate, "k"), strings.HasSuffix(rate, "K"):
+		multiplier, rate = 1000, rate[:len(rate)-1]
+	case strings.HasSuffix(rate, "M"), strings.HasSuffix(rate, "m"):
+		multiplier, rate = 1000000, rate[:len(rate)-1]
+	}
+	value, err := strconv.ParseFloat(rate, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(value * float64(multiplier))
+}
+
+// Sample lengths and where they are taken for SampleEstimate
+var (
+	sampleLength    = 5 * time.Second
+	samplePositions = []float64{0.1, 0.5, 0.9}
+)
+
+// SampleEstimate estimates the size of a movie once it is encoded by encoding a few seconds at the start, middle and end
+// into tmpDir, which is far more accurate than Estimate but takes a while. Short movies are encoded completely.
+func (e Encoder) SampleEstimate(ctx context.Context, fileName string, info Info, tmpDir string) (int64, error) {
+	if info.Duration <= 0 {
+		return 0, fmt.Errorf("unknown duration: %s", fileName)
+	}
+	positions, length := samplePositions, sampleLength
+	if SampledDuration(info) == info.Duration {
+		positions, length = []float64{0}, info.Duration
+	}
+	runner := runnerOrExec(e.Runner)
+	var size int64
+	for i, position := range positions {
+		sampleFile := filepath.Join(tmpDir, fmt.Sprintf("sample_%d.mp4", i))
+		start := time.Duration(position * float64(info.Duration))
+		args := append([]string{"-y", "-v", "error", "-ss", fmt.Sprintf("%.3f", start.Seconds()), "-t", fmt.Sprintf("%.3f", length.Seconds())},
+			e.Args(fileName, sampleFile)...)
+		err := runner.Run(ctx, "ffmpeg", args, nil, nil)
+		if err == nil {
+			size += FileSize(sampleFile)
+		}
+		os.Remove(sampleFile)
+		if err != nil {
+			return 0, err
+		}
+	}
+	sampled := length * time.Duration(len(positions))
+	return int64(float64(size) * info.Duration.Seconds() / sampled.Seconds()), nil
+}
+
+// SampledDuration returns how much of a movie SampleEstimate encodes, all of it for short movies
+func SampledDuration(info Info) time.Duration {
+	if sampled := time.Duration(len(samplePositions)) * sampleLength; info.Duration > sampled {
+		return sampled
+	}
+	return info.Duration
+}
+
+// Savings returns how many bytes shrinking a movie to the estimated size saves, zero if it wouldn't shrink below the ratio
+// at which originals are replaced
+func Savings(info Info, estimate int64, maxRatio float64) int64 {
+	if maxRatio == 0 {
+		maxRatio = DefaultMaxRatio
+	}
+	if info.Size == 0 || float64(estimate)/float64(info.Size) >= maxRatio {
+		return 0
+	}
+	return info.Size - estimate
+}
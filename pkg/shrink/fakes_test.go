@@ -0,0 +1,116 @@
+package shrink
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRunner answers the ffmpeg and ffprobe calls of a test instead of running them
+type fakeRunner func(name string, args []string, stdout, stderr io.Writer) error
+
+// Run calls the fake, discarding output the caller doesn't want
+func (f fakeRunner) Run(ctx context.Context, name string, args []string, stdout, stderr io.Writer) error {
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	return f(name, args, stdout, stderr)
+}
+
+// probeRunner answers the Probe and probeTags calls of ffprobe with json, and ffmpeg with stderr
+func probeRunner(probeJSON, tagsJSON, ffmpegStderr string) fakeRunner {
+	return func(name string, args []string, stdout, stderr io.Writer) error {
+		if name == "ffmpeg" {
+			io.WriteString(stderr, ffmpegStderr)
+			return nil
+		}
+		if strings.Contains(strings.Join(args, " "), "format_tags:stream_tags") {
+			io.WriteString(stdout, tagsJSON)
+			return nil
+		}
+		io.WriteString(stdout, probeJSON)
+		return nil
+	}
+}
+
+// fakeFS is the real file system with failures and file sizes made up by a test
+type fakeFS struct {
+	OSFS
+	// renameErr fails renames it returns an error for
+	renameErr func(oldName, newName string) error
+	// createErr fails creating the files it returns an error for
+	createErr func(name string) error
+	// tempDirErr fails making temp dirs
+	tempDirErr error
+	// sizes are the sizes Stat reports for files, which don't need to exist
+	sizes map[string]int64
+}
+
+// Stat reports the made up size of a file
+func (f *fakeFS) Stat(name string) (os.FileInfo, error) {
+	if size, ok := f.sizes[name]; ok {
+		return fakeFileInfo{name: name, size: size}, nil
+	}
+	return f.OSFS.Stat(name)
+}
+
+// Rename fails if the test says so
+func (f *fakeFS) Rename(oldName, newName string) error {
+	if f.renameErr != nil {
+		if err := f.renameErr(oldName, newName); err != nil {
+			return err
+		}
+	}
+	return f.OSFS.Rename(oldName, newName)
+}
+
+// Create fails if the test says so
+func (f *fakeFS) Create(name string) (io.WriteCloser, error) {
+	if f.createErr != nil {
+		if err := f.createErr(name); err != nil {
+			return nil, err
+		}
+	}
+	return f.OSFS.Create(name)
+}
+
+// TempDir fails if the test says so
+func (f *fakeFS) TempDir(dir, pattern string) (string, error) {
+	if f.tempDirErr != nil {
+		return "", f.tempDirErr
+	}
+	return f.OSFS.TempDir(dir, pattern)
+}
+
+// fakeFileInfo is a file of a made up size
+type fakeFileInfo struct {
+	name string
+	size int64
+}
+
+func (i fakeFileInfo) Name() string       { return i.name }
+func (i fakeFileInfo) Size() int64        { return i.size }
+func (i fakeFileInfo) Mode() os.FileMode  { return 0644 }
+func (i fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (i fakeFileInfo) IsDir() bool        { return false }
+func (i fakeFileInfo) Sys() interface{}   { return nil }
+
+// Writes a file for a test, failing it if that doesn't work
+func writeTestFile(t *testing.T, fileName, content string) {
+	t.Helper()
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Returns the content of a file, empty if it doesn't exist
+func readTestFile(fileName string) string {
+	content, _ := os.ReadFile(fileName)
+	return string(content)
+}
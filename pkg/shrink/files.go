@@ -0,0 +1,83 @@
+package shrink
+
+import (
+	"context"
+	filepath "path/filepath"
+	"regexp"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CaptureTime gets the date a movie was shot in local time, from the creation time in its metadata,
+// else from its name if it is eg. 20160513_181656.mp4, otherwise falling back to the file modification time
+func CaptureTime(ctx context.Context, fileName string) time.Time {
+	return captureTime(ctx, ExecRunner{}, OSFS{}, time.Local, nil, fileName)
+}
+
+// CaptureTime with the given runner and file system, in the given time zone.
+// Dates in file names are read as being in that time zone, with the patterns before the DefaultDatePatterns.
+func captureTime(ctx context.Context, runner Runner, fsys FS, loc *time.Location, patterns []*regexp.Regexp, fileName string) time.Time {
+	// the file modification time is often when the movie was copied off the camera
+	var created time.Time
+	var err error
+	if IsPhoto(fileName) {
+		created, err = photoTime(ctx, runner, loc, fileName)
+	} else {
+		created, err = creationTime(ctx, runner, fileName)
+	}
+	if err == nil {
+		return created.In(loc)
+	}
+	log.Debug("No creation time in metadata: ", fileName, " ", err)
+	if date, ok := recordingDate(fsys, loc, fileName); ok {
+		return date
+	}
+	return nameOrModTime(fsys, loc, patterns, fileName).In(loc)
+}
+
+// CaptureTime with the encoder's runner, in local time
+func (e Encoder) CaptureTime(ctx context.Context, fileName string) time.Time {
+	return captureTime(ctx, runnerOrExec(e.Runner), OSFS{}, time.Local, nil, fileName)
+}
+
+// Gets the date a movie was shot from its name, otherwise falling back to the file modification time
+func nameOrModTime(fsys FS, loc *time.Location, patterns []*regexp.Regexp, fileName string) time.Time {
+	// if filename is eg. 20160513_181656.mp4 get the date from the filename instead
+	// useful if we re-encode a badly encoded camera movie, then we don't want to use the modified date
+	if date, ok := dateFromName(patterns, loc, filepath.Base(fileName)); ok {
+		return date
+	}
+
+	// else fetch the files last modification timne
+	stat, err := fsys.Stat(fileName)
+	if err != nil {
+		log.Error("Unable to get ModTime for file: ", fileName)
+		return time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return stat.ModTime()
+}
+
+// FileSize gets the size of a file in bytes
+func FileSize(fileName string) int64 {
+	return fileSize(OSFS{}, fileName)
+}
+
+// FileSize on the given file system
+func fileSize(fsys FS, fileName string) int64 {
+	stat, err := fsys.Stat(fileName)
+	if err != nil {
+		log.Error(err)
+		return 0
+	}
+	return stat.Size()
+}
+
+// Returns the name of a file relative to a root dir, using forward slashes
+func relName(rootDir, fileName string) string {
+	rel, err := filepath.Rel(rootDir, fileName)
+	if err != nil {
+		return filepath.Base(fileName)
+	}
+	return filepath.ToSlash(rel)
+}
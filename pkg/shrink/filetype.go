@@ -0,0 +1,119 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	filepath "path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// stillExt is the ContentExt of movies holding a single frame, like the MJPEG stills some cameras save as .mov
+const stillExt = ".still"
+
+// ContentExt returns an extension for what ffprobe finds in a file, whatever it is named: .jpg or .png for photos,
+// .wav or .flac for uncompressed audio, .mp4 for movies and .still for movies of a single frame.
+// Other content gets the name of its format or codec, eg. .webp or .aac. Empty if ffprobe can't read the file.
+func (e Encoder) ContentExt(ctx context.Context, fileName string) string {
+	var out bytes.Buffer
+	args := []string{"-v", "error", "-show_entries", "format=format_name:stream=codec_type,codec_name,nb_frames:stream_disposition=attached_pic",
+		"-of", "json", fileName}
+	if err := runnerOrExec(e.Runner).Run(ctx, "ffprobe", args, &out, nil); err != nil {
+		return ""
+	}
+	var probe struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType   string `json:"codec_type"`
+			CodecName   string `json:"codec_name"`
+			NbFrames    string `json:"nb_frames"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return ""
+	}
+	format := probe.Format.FormatName
+	var video, audio []string
+	still := true
+	for _, stream := range probe.Streams {
+		switch {
+		case stream.CodecType == "video" && stream.Disposition.AttachedPic == 0:
+			video = append(video, stream.CodecName)
+			still = still && stream.NbFrames == "1"
+		case stream.CodecType == "audio":
+			audio = append(audio, stream.CodecName)
+		}
+	}
+	// images are read by the image2 demuxer or one per format, eg. png_pipe
+	if format == "image2" || strings.HasSuffix(format, "_pipe") {
+		codec := strings.TrimSuffix(format, "_pipe")
+		if len(video) > 0 {
+			codec = video[0]
+		}
+		switch codec {
+		case "mjpeg":
+			return ".jpg"
+		case "png":
+			return ".png"
+		}
+		return "." + codec
+	}
+	switch {
+	case len(video) > 0 && still:
+		return stillExt
+	case len(video) > 0:
+		return ".mp4"
+	case len(audio) > 0 && strings.HasPrefix(audio[0], "pcm_"):
+		return ".wav"
+	case len(audio) > 0:
+		return "." + audio[0]
+	}
+	return ""
+}
+
+// Returns movie, photo or audio for the kind of file a name is, empty for others
+func fileKind(fileName string) string {
+	switch {
+	case IsMovie(fileName):
+		return "movie"
+	case IsPhoto(fileName):
+		return "photo"
+	case IsAudio(fileName):
+		return "audio"
+	}
+	return ""
+}
+
+// Returns the name a file is handled by, with the extension of its content if ffprobe finds it is mislabeled,
+// eg. clip.jpg for a photo named clip.mp4, and why it is skipped if its content is nothing the processor shrinks
+func (p *Processor) typeName(ctx context.Context, fileName string) (string, string) {
+	if !p.DetectTypes {
+		return fileName, ""
+	}
+	ext := p.Encoder.ContentExt(ctx, fileName)
+	kind := fileKind(fileName)
+	typeName := strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ext
+	// the single frames of heic photos are stills too, and audio files may hold any audio
+	if len(ext) == 0 || fileKind(typeName) == kind || (ext == stillExt && kind == "photo") || (kind == "audio" && len(fileKind(typeName)) == 0) {
+		return fileName, ""
+	}
+	named := map[string]string{"movie": "a movie", "photo": "a photo", "audio": "an audio file"}[kind]
+	if len(named) == 0 {
+		named = "a " + strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), ".")) + " file"
+	}
+	log.Info("Content of: ", fileName, " is ", strings.TrimPrefix(ext, "."), ", not ", named)
+	switch {
+	case ext == stillExt:
+		return "", "still image in a movie container"
+	case IsMovie(typeName), IsPhoto(typeName) && p.Photos != nil, IsAudio(typeName) && p.Audio != nil:
+		return typeName, ""
+	}
+	return "", "content is " + strings.TrimPrefix(ext, ".") + ", not " + named
+}
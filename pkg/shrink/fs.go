@@ -0,0 +1,63 @@
+package shrink
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// FS is the file system operations used to scan, size and swap files, replace it to test without real files
+type FS interface {
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+	Remove(name string) error
+	RemoveAll(name string) error
+	Rename(oldName, newName string) error
+	MkdirAll(name string, perm os.FileMode) error
+	TempDir(dir, pattern string) (string, error)
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// OSFS is the real file system
+type OSFS struct{}
+
+// Stat calls os.Stat
+func (OSFS) Stat(name string) (os.FileInfo, error) { return os.Stat(name) }
+
+// ReadDir calls ioutil.ReadDir
+func (OSFS) ReadDir(name string) ([]os.FileInfo, error) { return ioutil.ReadDir(name) }
+
+// Open calls os.Open
+func (OSFS) Open(name string) (io.ReadCloser, error) { return os.Open(name) }
+
+// Create calls os.Create
+func (OSFS) Create(name string) (io.WriteCloser, error) { return os.Create(name) }
+
+// Remove calls os.Remove
+func (OSFS) Remove(name string) error { return os.Remove(name) }
+
+// RemoveAll calls os.RemoveAll
+func (OSFS) RemoveAll(name string) error { return os.RemoveAll(name) }
+
+// Rename calls os.Rename
+func (OSFS) Rename(oldName, newName string) error { return os.Rename(oldName, newName) }
+
+// MkdirAll calls os.MkdirAll
+func (OSFS) MkdirAll(name string, perm os.FileMode) error { return os.MkdirAll(name, perm) }
+
+// TempDir calls ioutil.TempDir
+func (OSFS) TempDir(dir, pattern string) (string, error) { return ioutil.TempDir(dir, pattern) }
+
+// Chtimes calls os.Chtimes
+func (OSFS) Chtimes(name string, atime, mtime time.Time) error { return os.Chtimes(name, atime, mtime) }
+
+// Returns the file system, the real one if it is nil
+func fsOrOS(fsys FS) FS {
+	if fsys == nil {
+		return OSFS{}
+	}
+	return fsys
+}
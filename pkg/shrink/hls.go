@@ -0,0 +1,106 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	filepath "path/filepath"
+	"strconv"
+	"strings"
+)
+
+// hlsSegmentSeconds is how long HLS segments are, key frames are forced at their start so all renditions switch in step
+const hlsSegmentSeconds = 6
+
+// HLSRendition is one quality a movie is packaged in for streaming
+type HLSRendition struct {
+	// Height is the number of lines, the width keeps the aspect ratio
+	Height int
+	// Bitrate is the video bit rate, eg. 2800k
+	Bitrate string
+}
+
+// DefaultHLSRenditions are the qualities movies are packaged in, those taller than the movie are left out
+var DefaultHLSRenditions = []HLSRendition{{1080, "5M"}, {720, "2800k"}, {480, "1400k"}, {360, "800k"}}
+
+// ParseHLSRenditions parses renditions like 1080:5M,720:2800k
+func ParseHLSRenditions(text string) ([]HLSRendition, error) {
+	var renditions []HLSRendition
+	for _, part := range strings.Split(text, ",") {
+		height, bitrate, ok := strings.Cut(strings.TrimSpace(part), ":")
+		lines, err := strconv.Atoi(height)
+		if !ok || err != nil || lines <= 0 || len(bitrate) == 0 {
+			return nil, fmt.Errorf("invalid rendition %q, use height:bitrate, eg. 720:2800k", part)
+		}
+		renditions = append(renditions, HLSRendition{lines, bitrate})
+	}
+	return renditions, nil
+}
+
+// PackageHLS saves a movie as an HLS rendition set in a dir, with master.m3u8 listing a playlist per rendition
+// in the subdirs 0, 1 and so on. Renditions taller than the movie are left out, except the smallest.
+func (e Encoder) PackageHLS(ctx context.Context, sourceFile, dir string, renditions []HLSRendition) error {
+	info, err := e.Probe(ctx, sourceFile)
+	if err != nil {
+		return err
+	}
+	var used []HLSRendition
+	for _, rendition := range renditions {
+		if rendition.Height <= info.Height || info.Height == 0 {
+			used = append(used, rendition)
+		}
+	}
+	if len(used) == 0 {
+		smallest := renditions[0]
+		for _, rendition := range renditions {
+			if rendition.Height < smallest.Height {
+				smallest = rendition
+			}
+		}
+		used = []HLSRendition{smallest}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	audio := e.hasAudio(ctx, sourceFile)
+
+	settings := e.Settings()
+	split := fmt.Sprintf("[0:v]split=%d", len(used))
+	var scales, streams []string
+	args := []string{"-y", "-v", "error", "-i", sourceFile}
+	for i := range used {
+		split += fmt.Sprintf("[v%d]", i)
+		scales = append(scales, fmt.Sprintf("[v%d]scale=-2:%d[v%dout]", i, used[i].Height, i))
+	}
+	args = append(args, "-filter_complex", split+";"+strings.Join(scales, ";"))
+	for i, rendition := range used {
+		args = append(args, "-map", fmt.Sprintf("[v%dout]", i), fmt.Sprintf("-b:v:%d", i), rendition.Bitrate,
+			fmt.Sprintf("-maxrate:v:%d", i), rendition.Bitrate, fmt.Sprintf("-bufsize:v:%d", i), rendition.Bitrate)
+		stream := fmt.Sprintf("v:%d", i)
+		if audio {
+			args = append(args, "-map", "0:a:0")
+			stream += fmt.Sprintf(",a:%d", i)
+		}
+		streams = append(streams, stream)
+	}
+	args = append(args, "-c:v", "libx264", "-preset", settings.Preset, "-pix_fmt", "yuv420p",
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", hlsSegmentSeconds))
+	if audio {
+		args = append(args, "-c:a", "aac", "-b:a", settings.AudioBitrate)
+	}
+	args = append(args, "-f", "hls", "-hls_time", strconv.Itoa(hlsSegmentSeconds), "-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, "%v", "segment_%04d.ts"), "-master_pl_name", "master.m3u8",
+		"-var_stream_map", strings.Join(streams, " "), filepath.Join(dir, "%v", "index.m3u8"))
+	return runnerOrExec(e.Runner).Run(ctx, "ffmpeg", args, nil, nil)
+}
+
+// Returns true if a movie has an audio stream
+func (e Encoder) hasAudio(ctx context.Context, fileName string) bool {
+	var out bytes.Buffer
+	args := []string{"-v", "error", "-select_streams", "a", "-show_entries", "stream=index", "-of", "csv=p=0", fileName}
+	if err := runnerOrExec(e.Runner).Run(ctx, "ffprobe", args, &out, nil); err != nil {
+		return false
+	}
+	return len(strings.TrimSpace(out.String())) > 0
+}
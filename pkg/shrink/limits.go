@@ -0,0 +1,23 @@
+package shrink
+
+// Limits caps the resources of each program a runner starts, so a pathological movie can't run the host out of memory
+// or starve services running beside it. They use cgroups on Linux and job objects on Windows.
+type Limits struct {
+	// MemoryMB is the most memory a program may use, unlimited if zero
+	MemoryMB int64
+	// CPUs is how many cores worth of time a program may use, eg. 1.5, unlimited if zero
+	CPUs float64
+}
+
+// Returns true if there is a limit
+func (l Limits) enabled() bool {
+	return l.MemoryMB > 0 || l.CPUs > 0
+}
+
+// Prepare checks the limits can be applied on this system, setting up what they need, before any program is run
+func (l Limits) Prepare() error {
+	if !l.enabled() {
+		return nil
+	}
+	return prepareLimits()
+}
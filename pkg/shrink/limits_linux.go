@@ -0,0 +1,111 @@
+//go:build linux
+
+package shrink
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	filepath "path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// jobCgroups is the cgroup the job cgroups of the programs are created in, set up once
+var jobCgroups struct {
+	once sync.Once
+	dir  string
+	err  error
+	n    int64
+}
+
+// Sets up the cgroup of this process to hold a cgroup per program. A cgroup with processes can't hand its controllers
+// to children, so this process moves into a leaf first. It needs a cgroup of its own that it may write to, like a
+// systemd service with Delegate=yes or a scope started with systemd-run --user --scope -p Delegate=yes.
+func prepareLimits() error {
+	jobCgroups.once.Do(func() {
+		jobCgroups.dir, jobCgroups.err = setupJobCgroups()
+	})
+	return jobCgroups.err
+}
+
+// Returns the cgroup of this process, ready for job cgroups
+func setupJobCgroups() (string, error) {
+	in, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	dir := ""
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if path := strings.TrimPrefix(scanner.Text(), "0::"); path != scanner.Text() {
+			dir = filepath.Join(cgroupRoot, path)
+		}
+	}
+	if len(dir) == 0 {
+		return "", errors.New("limits need cgroup v2")
+	}
+	// only cgroup v2 has the controllers file, on hosts mixing it with v1 the path isn't below its mount
+	controllers, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return "", errors.New("limits need cgroup v2")
+	}
+	for _, controller := range []string{"memory", "cpu"} {
+		if !strings.Contains(" "+strings.TrimSpace(string(controllers))+" ", " "+controller+" ") {
+			return "", fmt.Errorf("the %s controller isn't delegated to the cgroup of this process: %s", controller, dir)
+		}
+	}
+	leaf := filepath.Join(dir, "shrink-movies")
+	if err := os.MkdirAll(leaf, 0755); err != nil {
+		return "", fmt.Errorf("can't create cgroup, run in a delegated cgroup: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		return "", fmt.Errorf("can't move into cgroup: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+memory +cpu"), 0644); err != nil {
+		return "", fmt.Errorf("can't enable the memory and cpu controllers, other processes share the cgroup or it isn't delegated: %v", err)
+	}
+	return dir, nil
+}
+
+// Runs a command in a cgroup of its own with the limits, removing the cgroup once it exits.
+// The kernel kills the command if it runs out of memory.
+func (l Limits) run(cmd *exec.Cmd) error {
+	if err := prepareLimits(); err != nil {
+		return err
+	}
+	dir := filepath.Join(jobCgroups.dir, fmt.Sprintf("job-%d", atomic.AddInt64(&jobCgroups.n, 1)))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	defer os.Remove(dir)
+	if l.MemoryMB > 0 {
+		if err := ioutil.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(l.MemoryMB<<20, 10)), 0644); err != nil {
+			return err
+		}
+	}
+	if l.CPUs > 0 {
+		// the quota is the time per period of 100ms
+		if err := ioutil.WriteFile(filepath.Join(dir, "cpu.max"), []byte(fmt.Sprintf("%d 100000", int64(l.CPUs*100000))), 0644); err != nil {
+			return err
+		}
+	}
+	cgroup, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer cgroup.Close()
+	// the command starts in the cgroup, so it never runs without the limits
+	cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(cgroup.Fd())}
+	return runInGroup(cmd)
+}
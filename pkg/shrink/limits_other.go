@@ -0,0 +1,21 @@
+//go:build !linux && !windows
+
+package shrink
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// errNoLimits is returned on systems without cgroups or job objects
+var errNoLimits = errors.New("limits are only supported on Linux and Windows")
+
+// Limits aren't supported here
+func prepareLimits() error {
+	return errNoLimits
+}
+
+// Limits aren't supported here
+func (l Limits) run(cmd *exec.Cmd) error {
+	return errNoLimits
+}
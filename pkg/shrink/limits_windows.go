@@ -0,0 +1,81 @@
+//go:build windows
+
+package shrink
+
+import (
+	"os/exec"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// CPU rate control of job objects, which golang.org/x/sys/windows doesn't have
+const (
+	jobObjectCPURateControlInformation = 15
+	jobObjectCPURateControlEnable      = 0x1
+	jobObjectCPURateControlHardCap     = 0x4
+)
+
+// jobCPURateControl is JOBOBJECT_CPU_RATE_CONTROL_INFORMATION with a hard cap
+type jobCPURateControl struct {
+	ControlFlags uint32
+	CPURate      uint32
+}
+
+// Job objects need no setup
+func prepareLimits() error {
+	return nil
+}
+
+// Runs a command in a job object of its own with the limits, the command is killed when the job is closed
+func (l Limits) run(cmd *exec.Cmd) error {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(job)
+
+	var info windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+	info.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	if l.MemoryMB > 0 {
+		info.BasicLimitInformation.LimitFlags |= windows.JOB_OBJECT_LIMIT_JOB_MEMORY
+		info.JobMemoryLimit = uintptr(l.MemoryMB << 20)
+	}
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		return err
+	}
+	if l.CPUs > 0 {
+		// the rate is the share of all cores in 1/100 percent
+		rate := uint32(l.CPUs / float64(runtime.NumCPU()) * 10000)
+		if rate < 1 {
+			rate = 1
+		} else if rate > 10000 {
+			rate = 10000
+		}
+		control := jobCPURateControl{ControlFlags: jobObjectCPURateControlEnable | jobObjectCPURateControlHardCap, CPURate: rate}
+		if _, err := windows.SetInformationJobObject(job, jobObjectCPURateControlInformation,
+			uintptr(unsafe.Pointer(&control)), uint32(unsafe.Sizeof(control))); err != nil {
+			return err
+		}
+	}
+
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	trackGroup(cmd.Process.Pid)
+	defer untrackGroup(cmd.Process.Pid)
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+	if err == nil {
+		err = windows.AssignProcessToJobObject(job, process)
+		windows.CloseHandle(process)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	return cmd.Wait()
+}
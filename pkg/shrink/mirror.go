@@ -0,0 +1,80 @@
+package shrink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	filepath "path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Mirror is a destination every finished file is replicated to, eg. a NAS share or a bucket next to the local library
+type Mirror struct {
+	// Name tells the destination apart in the log and report, eg. its location
+	Name string
+	Dest Uploader
+}
+
+// Verifier is implemented by destinations that can check a file arrived intact
+type Verifier interface {
+	Verify(ctx context.Context, fileName, relName string) error
+}
+
+// DestinationResult is the outcome of replicating a file to a mirror
+type DestinationResult struct {
+	Destination string `json:"destination"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Copies a finished file to every mirror and verifies it where the mirror can, a failed mirror doesn't stop the others
+func (p *Processor) replicate(ctx context.Context, fileName, name string) []DestinationResult {
+	if len(p.Mirrors) == 0 {
+		return nil
+	}
+	p.claimDestination(name)
+	capturedAt := p.copyTime(ctx, fileName)
+	results := make([]DestinationResult, len(p.Mirrors))
+	for i, mirror := range p.Mirrors {
+		results[i].Destination = mirror.Name
+		err := mirror.Dest.Upload(ctx, fileName, name, capturedAt)
+		if verifier, ok := mirror.Dest.(Verifier); ok && err == nil {
+			err = verifier.Verify(ctx, fileName, name)
+		}
+		if err != nil {
+			log.Error("Could not replicate to ", mirror.Name, ": ", fileName, " ", err)
+			results[i].Error = err.Error()
+			continue
+		}
+		log.Debug("Replicated to ", mirror.Name, ": ", name)
+	}
+	return results
+}
+
+// Verify checks the copy below the dir has the size and sha256 of the file
+func (l LocalUploader) Verify(ctx context.Context, fileName, relName string) error {
+	destFile := filepath.Join(l.Dir, filepath.FromSlash(relName))
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	destStat, err := os.Stat(destFile)
+	if err != nil {
+		return err
+	}
+	if destStat.Size() != stat.Size() {
+		return fmt.Errorf("copy has %d bytes, not %d", destStat.Size(), stat.Size())
+	}
+	sum, err := fileSHA256(OSFS{}, fileName)
+	if err != nil {
+		return err
+	}
+	destSum, err := fileSHA256(OSFS{}, destFile)
+	if err != nil {
+		return err
+	}
+	if destSum != sum {
+		return fmt.Errorf("copy has sha256 %s, not %s", destSum, sum)
+	}
+	return nil
+}
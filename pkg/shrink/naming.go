@@ -0,0 +1,206 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	filepath "path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Name templates
+const (
+	// DefaultNameTemplate names shrunk files after when they were shot, eg. 20160513_181656
+	DefaultNameTemplate = "{{.Date}}"
+	// OriginalNameTemplate keeps the name of the original, only changing its extension to .mp4
+	OriginalNameTemplate = "{{.OriginalName}}"
+	// DateDirTemplate sorts shrunk files at the destinations into dirs by year and month, eg. 2016/2016-05
+	DateDirTemplate = `{{.Time.Format "2006/2006-01"}}`
+)
+
+// cameraTags are the metadata tags naming the make and model of the camera, by who writes them
+var cameraTags = [][2]string{
+	{"com.apple.quicktime.make", "com.apple.quicktime.model"},
+	{"com.android.manufacturer", "com.android.model"},
+	{"make", "model"},
+}
+
+// NameData is what a name template is executed with
+type NameData struct {
+	// Date is when the movie was shot as 20060102_150405
+	Date string
+	// Time is when the movie was shot, eg. {{.Time.Format "2006-01-02"}}
+	Time time.Time
+	// OriginalName is the name of the original without its extension
+	OriginalName string
+	// Camera is the make and model from the metadata, eg. "Apple iPhone 12", empty if it isn't known
+	Camera string
+}
+
+// ParseNameTemplate parses a template naming shrunk files, eg. {{.Date}}_{{.OriginalName}}.
+// The .mp4 extension is added to the name.
+func ParseNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// catch fields that don't exist now instead of on the first file
+	if err := tmpl.Execute(&bytes.Buffer{}, NameData{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// Returns the name of the shrunk file without extension, from the name template or else the capture date
+func (p *Processor) outputName(ctx context.Context, sourceFile string, captured time.Time) string {
+	date := captured.Format("20060102_150405")
+	if p.NameTemplate == nil {
+		return date
+	}
+	base := filepath.Base(sourceFile)
+	data := NameData{Date: date, Time: captured, OriginalName: strings.TrimSuffix(base, filepath.Ext(base))}
+	if tags, err := probeTags(ctx, p.Encoder.Runner, sourceFile); err == nil {
+		data.Camera = camera(tags)
+	}
+	var name bytes.Buffer
+	if err := p.NameTemplate.Execute(&name, data); err != nil {
+		log.Error("Could not name file, using its date: ", sourceFile, err)
+		return date
+	}
+	// names can't leave the dir of the original, the Namer moves files elsewhere
+	cleaned := safeName(strings.NewReplacer("/", "_", "\\", "_").Replace(strings.TrimSpace(name.String())))
+	if len(cleaned) == 0 || cleaned == "." || cleaned == ".." {
+		return date
+	}
+	return cleaned
+}
+
+// Returns the name of a file at the destinations, relName is its name relative to the input root. With a dir template
+// it goes in the dir the template gives the result instead of that of the original, unless its capture date isn't known.
+func (p *Processor) destinationName(ctx context.Context, result FileResult, relName string) string {
+	if p.DirTemplate == nil || result.Captured.IsZero() {
+		return relName
+	}
+	base := path.Base(filepath.ToSlash(result.Source))
+	data := NameData{Date: result.Captured.Format("20060102_150405"), Time: result.Captured,
+		OriginalName: strings.TrimSuffix(base, path.Ext(base)), Camera: result.Camera}
+	var dir bytes.Buffer
+	if err := p.DirTemplate.Execute(&dir, data); err != nil {
+		log.Error("Could not name dir, keeping that of the original: ", result.Source, err)
+		return relName
+	}
+	// dirs stay below the root of the destination
+	var parts []string
+	for _, part := range strings.Split(path.Clean("/"+filepath.ToSlash(strings.TrimSpace(dir.String()))), "/") {
+		if part = safeName(strings.TrimSpace(part)); len(part) > 0 && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+	return path.Join(append(parts, path.Base(relName))...)
+}
+
+// Returns a name for the shrunk file no other file in the dir of the original or at the destinations has, name is that
+// of the original relative to the input root. When another movie of the same second is there, the camera that shot this
+// one is added, eg. 20160513_181656_iPhone12, so names stay the same between runs whatever order files are shrunk in.
+// Counters are only added for movies of the same camera.
+func (p *Processor) uniqueName(ctx context.Context, result FileResult, name, outName, ext string) string {
+	sourceFile := result.Source
+	taken := func(outName string) bool {
+		fileName := filepath.Join(filepath.Dir(sourceFile), outName+ext)
+		if fileName == sourceFile {
+			return false
+		}
+		if _, err := p.FS.Stat(fileName); err == nil {
+			return true
+		}
+		return p.takenAtDestination(ctx, name, p.destinationName(ctx, result, siblingName(name, outName+ext)))
+	}
+	if !taken(outName) {
+		return outName
+	}
+	if tags, err := probeTags(ctx, p.Encoder.Runner, sourceFile); err == nil {
+		if device := deviceName(tags); len(device) > 0 {
+			outName += "_" + device
+			if !taken(outName) {
+				return outName
+			}
+		}
+	}
+	for i := 1; ; i++ {
+		if name := fmt.Sprintf("%s_%04d", outName, i); !taken(name) {
+			return name
+		}
+	}
+}
+
+// Returns the model of the camera from the metadata tags, or its make, with the end of its serial number if a tag has one,
+// eg. HERO9Black-4821, as letters and digits only. Empty if they don't say.
+func deviceName(tags metadataTags) string {
+	device := ""
+	for _, names := range cameraTags {
+		if model := tags.Get(names[1]); len(model) > 0 {
+			device = model
+			break
+		}
+		if maker := tags.Get(names[0]); len(maker) > 0 && len(device) == 0 {
+			device = maker
+		}
+	}
+	serial := ""
+	for _, streamTags := range tags {
+		// sorted so the same tag wins every run
+		keys := make([]string, 0, len(streamTags))
+		for key := range streamTags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if strings.Contains(strings.ToLower(key), "serial") && len(serial) == 0 {
+				serial = lettersAndDigits(streamTags[key])
+			}
+		}
+	}
+	device = lettersAndDigits(device)
+	if len(serial) > 4 {
+		serial = serial[len(serial)-4:]
+	}
+	if len(serial) > 0 && len(device) > 0 {
+		return device + "-" + serial
+	}
+	return device + serial
+}
+
+// Returns the ascii letters and digits of a string
+func lettersAndDigits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Returns the make and model of the camera from the metadata tags, leaving out the make if the model includes it
+func camera(tags metadataTags) string {
+	for _, names := range cameraTags {
+		maker, model := tags.Get(names[0]), tags.Get(names[1])
+		switch {
+		case len(model) == 0:
+			if len(maker) > 0 {
+				return maker
+			}
+		case len(maker) == 0 || strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)):
+			return model
+		default:
+			return maker + " " + model
+		}
+	}
+	return ""
+}
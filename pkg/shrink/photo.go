@@ -0,0 +1,101 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	filepath "path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPhotoQuality is the JPEG and HEIC quality photos are recompressed with
+const DefaultPhotoQuality = 82
+
+// IsPhoto returns true if the file is a photo that can be recompressed
+func IsPhoto(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".jpg", ".jpeg", ".png", ".heic", ".heif":
+		return true
+	}
+	return false
+}
+
+// PhotoEncoder recompresses photos with ImageMagick 7, keeping their format and their EXIF, XMP and ICC metadata
+type PhotoEncoder struct {
+	// Quality is the JPEG and HEIC quality from 1 to 100, DefaultPhotoQuality if zero.
+	// PNGs are always compressed losslessly.
+	Quality int
+	// Runner runs magick, os/exec if nil
+	Runner Runner
+}
+
+// Ext returns the extension of the recompressed photo, photos keep their format
+func (e PhotoEncoder) Ext(fileName string) string {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	switch ext {
+	case ".jpeg":
+		return ".jpg"
+	case ".heif":
+		return ".heic"
+	}
+	return ext
+}
+
+// Settings returns the settings of the encoder for the audit sidecar
+func (e PhotoEncoder) Settings(fileName string) EncodeSettings {
+	settings := EncodeSettings{Codec: strings.TrimPrefix(e.Ext(fileName), ".")}
+	if settings.Codec != "png" {
+		settings.Quality = e.quality()
+	}
+	return settings
+}
+
+// Returns the quality, using the default if it isn't set
+func (e PhotoEncoder) quality() int {
+	if e.Quality == 0 {
+		return DefaultPhotoQuality
+	}
+	return e.Quality
+}
+
+// Args returns the magick arguments to recompress the source into dest
+func (e PhotoEncoder) Args(sourceFile, destFile string) []string {
+	if e.Ext(sourceFile) == ".png" {
+		// zlib level 9 with adaptive filtering, png quality isn't lossy
+		return []string{sourceFile, "-quality", "95", destFile}
+	}
+	return []string{sourceFile, "-quality", strconv.Itoa(e.quality()), destFile}
+}
+
+// Encode recompresses a photo, progress is called once it is done as photos are quick
+func (e PhotoEncoder) Encode(ctx context.Context, sourceFile, destFile string, progress func(float64)) error {
+	if err := runnerOrExec(e.Runner).Run(ctx, "magick", e.Args(sourceFile, destFile), nil, nil); err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(1)
+	}
+	return nil
+}
+
+// Reads when a photo was taken from its EXIF DateTimeOriginal, in the time zone of OffsetTimeOriginal if it has one,
+// else in the given time zone
+func photoTime(ctx context.Context, runner Runner, loc *time.Location, fileName string) (time.Time, error) {
+	var out bytes.Buffer
+	args := []string{"identify", "-format", "%[EXIF:DateTimeOriginal]|%[EXIF:OffsetTimeOriginal]", fileName + "[0]"}
+	if err := runnerOrExec(runner).Run(ctx, "magick", args, &out, nil); err != nil {
+		return time.Time{}, err
+	}
+	taken, offset, _ := strings.Cut(strings.TrimSpace(out.String()), "|")
+	if len(offset) > 0 {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", taken+offset); err == nil {
+			return t, nil
+		}
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", taken, loc)
+	if err != nil {
+		return time.Time{}, errNoCreationTime
+	}
+	return t, nil
+}
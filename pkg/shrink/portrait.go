@@ -0,0 +1,31 @@
+package shrink
+
+import (
+	"regexp"
+	"strings"
+)
+
+// scalePattern matches scale filters with a width and height, eg. scale=-2:720 or scale=1280:-1
+var scalePattern = regexp.MustCompile(`\bscale=(-?\d+):(-?\d+)`)
+
+// PortraitFilter turns a filter written for landscape movies for portrait ones: a scale limiting the height limits the width,
+// so scale=-2:720 gives 720 wide instead of 405, and it never scales up. ffmpeg rotates phone videos before the filter.
+func PortraitFilter(filter string) string {
+	return scalePattern.ReplaceAllStringFunc(filter, func(scale string) string {
+		size := scalePattern.FindStringSubmatch(scale)
+		width, height := size[1], size[2]
+		switch {
+		case strings.HasPrefix(width, "-") && !strings.HasPrefix(height, "-"):
+			return "scale='min(" + height + ",iw)':-2"
+		case strings.HasPrefix(height, "-") && !strings.HasPrefix(width, "-"):
+			return "scale=-2:'min(" + width + ",ih)'"
+		}
+		return "scale=" + height + ":" + width
+	})
+}
+
+// PortraitEncoder returns an encoder with its filter turned for portrait movies
+func PortraitEncoder(encoder Encoder) Encoder {
+	encoder.Filter = PortraitFilter(encoder.Filter)
+	return encoder
+}
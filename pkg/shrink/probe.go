@@ -0,0 +1,212 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Info describes a movie as reported by ffprobe
+type Info struct {
+	Codec    string        `json:"codec"`
+	Width    int           `json:"width"`
+	Height   int           `json:"height"`
+	Duration time.Duration `json:"duration"`
+	Size     int64         `json:"size"`
+	// BitRate is the overall bit rate in bits per second
+	BitRate int64 `json:"bitRate"`
+	// Rotation is how many degrees players turn the video, phones record portrait video as rotated landscape
+	Rotation int `json:"rotation,omitempty"`
+	// FrameRate is the average number of frames per second
+	FrameRate float64 `json:"frameRate,omitempty"`
+}
+
+// highFrameRate is the frame rate from which movies are slow motion, 120 and 240 fps clips of phones and action cameras
+const highFrameRate = 100
+
+// SlowMotion returns true for high frame rate movies, which play in slow motion when they are slowed down
+func (i Info) SlowMotion() bool {
+	return i.FrameRate >= highFrameRate
+}
+
+// Portrait returns true if the video is taller than it is wide once it is rotated
+func (i Info) Portrait() bool {
+	if i.Rotation%180 != 0 {
+		return i.Width > i.Height
+	}
+	return i.Height > i.Width
+}
+
+// Probe gets the codec, resolution, duration and bit rate of a movie using ffprobe
+func Probe(ctx context.Context, fileName string) (Info, error) {
+	return Encoder{}.Probe(ctx, fileName)
+}
+
+// Probe gets the codec, resolution, duration and bit rate of a movie with the encoder's runner
+func (e Encoder) Probe(ctx context.Context, fileName string) (Info, error) {
+	var out bytes.Buffer
+	args := []string{"-v", "error", "-select_streams", "v:0", "-show_entries", "stream=codec_name,width,height,avg_frame_rate,r_frame_rate:stream_side_data=rotation:stream_tags=rotate:format=duration,size,bit_rate", "-of", "json", fileName}
+	if err := runnerOrExec(e.Runner).Run(ctx, "ffprobe", args, &out, nil); err != nil {
+		return Info{}, err
+	}
+	var probe struct {
+		Streams []struct {
+			CodecName    string `json:"codec_name"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+			RFrameRate   string `json:"r_frame_rate"`
+			// the display matrix of ffmpeg 5 and later, older versions set the rotate tag
+			SideDataList []struct {
+				Rotation int `json:"rotation"`
+			} `json:"side_data_list"`
+			Tags struct {
+				Rotate string `json:"rotate"`
+			} `json:"tags"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+			Size     string `json:"size"`
+			BitRate  string `json:"bit_rate"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return Info{}, err
+	}
+
+	var info Info
+	if len(probe.Streams) > 0 {
+		info.Codec, info.Width, info.Height = probe.Streams[0].CodecName, probe.Streams[0].Width, probe.Streams[0].Height
+		info.Rotation, _ = strconv.Atoi(probe.Streams[0].Tags.Rotate)
+		if info.FrameRate = parseFrameRate(probe.Streams[0].AvgFrameRate); info.FrameRate == 0 {
+			info.FrameRate = parseFrameRate(probe.Streams[0].RFrameRate)
+		}
+		for _, sideData := range probe.Streams[0].SideDataList {
+			if sideData.Rotation != 0 {
+				info.Rotation = sideData.Rotation
+			}
+		}
+	}
+	seconds, _ := strconv.ParseFloat(probe.Format.Duration, 64)
+	info.Duration = time.Duration(seconds * float64(time.Second))
+	info.Size, _ = strconv.ParseInt(probe.Format.Size, 10, 64)
+	info.BitRate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	return info, nil
+}
+
+// Parses a frame rate like 30000/1001, 0 if it is unknown
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !ok {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// creationTimeTags are the metadata tags holding when a movie was shot, in order of preference.
+// The QuickTime one is written by phones and includes the time zone.
+var creationTimeTags = []string{"com.apple.quicktime.creationdate", "creation_time"}
+
+// errNoCreationTime is returned when a movie has no usable creation time in its metadata
+var errNoCreationTime = errors.New("no creation time")
+
+// CreationTime gets when a movie was shot from its metadata, using the encoder's runner
+func (e Encoder) CreationTime(ctx context.Context, fileName string) (time.Time, error) {
+	return creationTime(ctx, runnerOrExec(e.Runner), fileName)
+}
+
+// CreationTime with the given runner
+func creationTime(ctx context.Context, runner Runner, fileName string) (time.Time, error) {
+	tags, err := probeTags(ctx, runner, fileName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, tag := range creationTimeTags {
+		if created, ok := parseCreationTime(tags.Get(tag)); ok {
+			return created, nil
+		}
+	}
+	return time.Time{}, errNoCreationTime
+}
+
+// metadataTags are the metadata tags of the container followed by those of each stream
+type metadataTags []map[string]string
+
+// Get returns the first value of a tag, empty if no one has it
+func (m metadataTags) Get(tag string) string {
+	for _, tags := range m {
+		if value := strings.TrimSpace(tags[tag]); len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// Reads the metadata tags of a movie with ffprobe
+func probeTags(ctx context.Context, runner Runner, fileName string) (metadataTags, error) {
+	var out bytes.Buffer
+	args := []string{"-v", "error", "-show_entries", "format_tags:stream_tags", "-of", "json", fileName}
+	if err := runnerOrExec(runner).Run(ctx, "ffprobe", args, &out, nil); err != nil {
+		return nil, err
+	}
+	var probe struct {
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+		Streams []struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return nil, err
+	}
+	tags := metadataTags{probe.Format.Tags}
+	for _, stream := range probe.Streams {
+		tags = append(tags, stream.Tags)
+	}
+	return tags, nil
+}
+
+// Parses a creation time tag, eg. 2016-05-13T18:16:56.000000Z or 2016-05-13T18:16:56+0200
+func parseCreationTime(value string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05-0700", "2006-01-02 15:04:05"} {
+		created, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		// cameras without a clock set write the epoch of mp4 (1904) or unix (1970)
+		if created.Year() < 1980 {
+			return time.Time{}, false
+		}
+		return created, true
+	}
+	return time.Time{}, false
+}
+
+// Verify decodes the whole movie with ffmpeg and returns an error if it is damaged
+func Verify(ctx context.Context, fileName string) error {
+	return Encoder{}.Verify(ctx, fileName)
+}
+
+// Verify decodes the whole movie with the encoder's runner and returns an error if it is damaged
+func (e Encoder) Verify(ctx context.Context, fileName string) error {
+	var stderr bytes.Buffer
+	err := runnerOrExec(e.Runner).Run(ctx, "ffmpeg", []string{"-v", "error", "-i", fileName, "-f", "null", "-"}, nil, &stderr)
+	if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+		// only the first problem, damaged files can report thousands
+		return errors.New(strings.SplitN(msg, "\n", 2)[0])
+	}
+	return err
+}
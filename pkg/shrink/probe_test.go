@@ -0,0 +1,167 @@
+package shrink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want Info
+	}{
+		{
+			name: "phone movie",
+			json: `{"streams": [{"codec_name": "hevc", "width": 1920, "height": 1080, "avg_frame_rate": "30000/1001", "r_frame_rate": "30/1",
+				"side_data_list": [{"rotation": -90}]}], "format": {"duration": "12.5", "size": "31250000", "bit_rate": "20000000"}}`,
+			want: Info{Codec: "hevc", Width: 1920, Height: 1080, Duration: 12500 * time.Millisecond, Size: 31250000, BitRate: 20000000,
+				Rotation: -90, FrameRate: 30000.0 / 1001},
+		},
+		{
+			name: "rotate tag of old ffmpeg",
+			json: `{"streams": [{"codec_name": "h264", "width": 1280, "height": 720, "avg_frame_rate": "0/0", "r_frame_rate": "25/1",
+				"tags": {"rotate": "90"}}], "format": {"duration": "1.0"}}`,
+			want: Info{Codec: "h264", Width: 1280, Height: 720, Duration: time.Second, Rotation: 90, FrameRate: 25},
+		},
+		{
+			name: "no video stream",
+			json: `{"streams": [], "format": {"duration": "3.0", "size": "1000", "bit_rate": "N/A"}}`,
+			want: Info{Duration: 3 * time.Second, Size: 1000},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Encoder{Runner: probeRunner(test.json, "", "")}.Probe(context.Background(), "movie.mp4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("Probe() = %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestProbeErrors(t *testing.T) {
+	failing := fakeRunner(func(name string, args []string, stdout, stderr io.Writer) error {
+		return errors.New("exit status 1")
+	})
+	if _, err := (Encoder{Runner: failing}).Probe(context.Background(), "movie.mp4"); err == nil {
+		t.Error("Probe() didn't fail when ffprobe did")
+	}
+	if _, err := (Encoder{Runner: probeRunner("not json", "", "")}).Probe(context.Background(), "movie.mp4"); err == nil {
+		t.Error("Probe() didn't fail on output that isn't json")
+	}
+}
+
+func TestInfoOrientation(t *testing.T) {
+	tests := []struct {
+		info                 Info
+		portrait, slowMotion bool
+	}{
+		{Info{Width: 1920, Height: 1080, FrameRate: 30}, false, false},
+		{Info{Width: 1080, Height: 1920, FrameRate: 30}, true, false},
+		{Info{Width: 1920, Height: 1080, Rotation: 90}, true, false},
+		{Info{Width: 1920, Height: 1080, Rotation: -180}, false, false},
+		{Info{Width: 1280, Height: 720, FrameRate: 240}, false, true},
+	}
+	for _, test := range tests {
+		if got := test.info.Portrait(); got != test.portrait {
+			t.Errorf("%+v Portrait() = %v, want %v", test.info, got, test.portrait)
+		}
+		if got := test.info.SlowMotion(); got != test.slowMotion {
+			t.Errorf("%+v SlowMotion() = %v, want %v", test.info, got, test.slowMotion)
+		}
+	}
+}
+
+func TestParseFrameRate(t *testing.T) {
+	tests := []struct {
+		rate string
+		want float64
+	}{
+		{"30/1", 30},
+		{"30000/1001", 30000.0 / 1001},
+		{"25", 25},
+		{"0/0", 0},
+		{"30/0", 0},
+		{"", 0},
+		{"N/A", 0},
+		{"30/x", 0},
+	}
+	for _, test := range tests {
+		if got := parseFrameRate(test.rate); got != test.want {
+			t.Errorf("parseFrameRate(%q) = %v, want %v", test.rate, got, test.want)
+		}
+	}
+}
+
+func TestParseCreationTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"2016-05-13T18:16:56.000000Z", time.Date(2016, 5, 13, 18, 16, 56, 0, time.UTC), true},
+		{"2016-05-13T18:16:56+0200", time.Date(2016, 5, 13, 16, 16, 56, 0, time.UTC), true},
+		{"2016-05-13T18:16:56+02:00", time.Date(2016, 5, 13, 16, 16, 56, 0, time.UTC), true},
+		{"2016-05-13 18:16:56", time.Date(2016, 5, 13, 18, 16, 56, 0, time.UTC), true},
+		// cameras without a clock set
+		{"1904-01-01T00:00:00.000000Z", time.Time{}, false},
+		{"1970-01-01T00:00:00Z", time.Time{}, false},
+		{"", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+	}
+	for _, test := range tests {
+		got, ok := parseCreationTime(test.value)
+		if ok != test.ok || !got.Equal(test.want) {
+			t.Errorf("parseCreationTime(%q) = %v, %v, want %v, %v", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestCreationTime(t *testing.T) {
+	tests := []struct {
+		name string
+		tags string
+		want time.Time
+		err  bool
+	}{
+		{
+			name: "quicktime tag wins",
+			tags: `{"format": {"tags": {"creation_time": "2016-05-13T16:16:56.000000Z", "com.apple.quicktime.creationdate": "2016-05-13T18:16:50+0200"}}}`,
+			want: time.Date(2016, 5, 13, 16, 16, 50, 0, time.UTC),
+		},
+		{
+			name: "stream tag",
+			tags: `{"format": {}, "streams": [{"tags": {"creation_time": "2020-01-02T03:04:05.000000Z"}}]}`,
+			want: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		{
+			name: "unset clock",
+			tags: `{"format": {"tags": {"creation_time": "1970-01-01T00:00:00.000000Z"}}}`,
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Encoder{Runner: probeRunner("", test.tags, "")}.CreationTime(context.Background(), "movie.mp4")
+			if test.err {
+				if err == nil {
+					t.Errorf("CreationTime() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("CreationTime() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package shrink
+
+import (
+	"os/exec"
+	"sync"
+)
+
+// runningGroups are the process groups of the programs started by ExecRunner that haven't exited yet
+var runningGroups struct {
+	mu   sync.Mutex
+	pids map[int]bool
+}
+
+// Starts a command in a process group of its own and waits for it, the whole group is killed when its context is done,
+// so programs ffmpeg starts go with it
+func runInGroup(cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	trackGroup(cmd.Process.Pid)
+	defer untrackGroup(cmd.Process.Pid)
+	return cmd.Wait()
+}
+
+// Puts a command in a process group of its own, killing the group rather than the command when its context is done
+func setProcessGroup(cmd *exec.Cmd) {
+	startProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd.Process.Pid)
+	}
+}
+
+// Remembers a running process group
+func trackGroup(pid int) {
+	runningGroups.mu.Lock()
+	defer runningGroups.mu.Unlock()
+	if runningGroups.pids == nil {
+		runningGroups.pids = make(map[int]bool)
+	}
+	runningGroups.pids[pid] = true
+}
+
+// Forgets a process group that exited
+func untrackGroup(pid int) {
+	runningGroups.mu.Lock()
+	defer runningGroups.mu.Unlock()
+	delete(runningGroups.pids, pid)
+}
+
+// KillPrograms kills the process groups of all programs ExecRunner started that are still running,
+// for when the process exits without cancelling them, eg. on a panic
+func KillPrograms() {
+	runningGroups.mu.Lock()
+	defer runningGroups.mu.Unlock()
+	for pid := range runningGroups.pids {
+		killProcessGroup(pid)
+	}
+}
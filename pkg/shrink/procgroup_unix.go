@@ -0,0 +1,54 @@
+//go:build !windows
+
+package shrink
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Starts the command as the leader of a new process group
+func startProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// Kills every process in the group led by pid
+func killProcessGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGKILL)
+}
+
+// KillStalePrograms kills the programs whose command line names a dir, like the encoders a crashed run left behind
+// writing into its temp dir, returning how many were killed. Programs that lead a process group are killed with it.
+func KillStalePrograms(dirName string) (int, error) {
+	out, err := exec.Command("ps", "-A", "-o", "pid=,pgid=,args=").Output()
+	if err != nil {
+		return 0, err
+	}
+	killed := 0
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		fields := strings.Fields(string(line))
+		if len(fields) < 3 || !strings.Contains(strings.Join(fields[2:], " "), dirName) {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		if fields[1] == fields[0] {
+			err = killProcessGroup(pid)
+		} else {
+			err = syscall.Kill(pid, syscall.SIGKILL)
+		}
+		if err == nil {
+			killed++
+		}
+	}
+	return killed, nil
+}
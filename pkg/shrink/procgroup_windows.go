@@ -0,0 +1,52 @@
+//go:build windows
+
+package shrink
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// Starts the command in a new process group
+func startProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_NEW_PROCESS_GROUP
+}
+
+// Kills the process and every process it started, windows has no signals for a whole group
+func killProcessGroup(pid int) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid)).Run()
+}
+
+// KillStalePrograms kills the programs whose command line names a dir, like the encoders a crashed run left behind
+// writing into its temp dir, returning how many were killed. The programs they started are killed with them.
+func KillStalePrograms(dirName string) (int, error) {
+	out, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command",
+		`Get-CimInstance Win32_Process | ForEach-Object { "$($_.ProcessId) $($_.CommandLine)" }`).Output()
+	if err != nil {
+		return 0, err
+	}
+	killed := 0
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		pidField, commandLine, ok := strings.Cut(strings.TrimSpace(string(line)), " ")
+		if !ok || !strings.Contains(strings.ToLower(commandLine), strings.ToLower(dirName)) {
+			continue
+		}
+		pid, err := strconv.Atoi(pidField)
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		if killProcessGroup(pid) == nil {
+			killed++
+		}
+	}
+	return killed, nil
+}
@@ -0,0 +1,78 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Provenance is how a file was encoded, kept in its audit sidecar so a surprising result can be reproduced later
+type Provenance struct {
+	// Commands are the command lines run to encode the file, in order, with the programs ExecRunner ran
+	Commands [][]string `json:"commands"`
+	// FFmpegVersion is the first line of ffmpeg -version
+	FFmpegVersion string `json:"ffmpegVersion,omitempty"`
+	// Probe is what ffprobe reported about the original, its format and streams
+	Probe json.RawMessage `json:"probe,omitempty"`
+}
+
+// commandLog collects the command lines run for a file, ffprobe is left out as it only reads
+type commandLog struct {
+	mu       sync.Mutex
+	commands [][]string
+}
+
+type commandLogKey struct{}
+
+// Returns a context whose commands run by ExecRunner are recorded in the returned log
+func withCommandLog(ctx context.Context) (context.Context, *commandLog) {
+	commands := &commandLog{}
+	return context.WithValue(ctx, commandLogKey{}, commands), commands
+}
+
+// Records a command line in the command log of a context, if it has one
+func logCommand(ctx context.Context, program string, args []string) {
+	commands, ok := ctx.Value(commandLogKey{}).(*commandLog)
+	if !ok || strings.HasSuffix(strings.TrimSuffix(program, ".exe"), "ffprobe") {
+		return
+	}
+	commands.mu.Lock()
+	defer commands.mu.Unlock()
+	commands.commands = append(commands.commands, append([]string{program}, args...))
+}
+
+// Returns the recorded command lines
+func (c *commandLog) Commands() [][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]string(nil), c.commands...)
+}
+
+// FFmpegVersion returns the first line of ffmpeg -version, empty if ffmpeg can't be run
+func (e Encoder) FFmpegVersion(ctx context.Context) string {
+	var out bytes.Buffer
+	if err := runnerOrExec(e.Runner).Run(ctx, "ffmpeg", []string{"-version"}, &out, nil); err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(out.String(), "\n")
+	return strings.TrimSpace(line)
+}
+
+// Returns the provenance of a file encoded with the commands, reading the version of ffmpeg once
+func (p *Processor) provenance(ctx context.Context, sourceFile string, commands *commandLog) *Provenance {
+	provenance := &Provenance{Commands: commands.Commands()}
+	p.ffmpegVersionOnce.Do(func() { p.ffmpegVersion = p.Encoder.FFmpegVersion(ctx) })
+	provenance.FFmpegVersion = p.ffmpegVersion
+	var out bytes.Buffer
+	args := []string{"-v", "error", "-show_format", "-show_streams", "-of", "json", sourceFile}
+	if err := runnerOrExec(p.Encoder.Runner).Run(ctx, "ffprobe", args, &out, nil); err != nil {
+		log.Error("Could not probe original for provenance: ", sourceFile, err)
+	} else if json.Valid(out.Bytes()) {
+		provenance.Probe = out.Bytes()
+	}
+	return provenance
+}
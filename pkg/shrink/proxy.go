@@ -0,0 +1,61 @@
+package shrink
+
+import (
+	"context"
+	"os"
+	filepath "path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Proxy settings used when they aren't set
+const (
+	DefaultProxyHeight  = 540
+	DefaultProxyBitrate = "1M"
+)
+
+// Proxy saves a small copy of each movie in a parallel tree, for browsing on phones and slow links
+type Proxy struct {
+	// Dir is the root of the tree, movies keep their dir below the input root and their name
+	Dir string
+	// Height is the most lines of the copies, DefaultProxyHeight if zero
+	Height int
+	// Bitrate is the video bit rate of the copies, eg. 1M, DefaultProxyBitrate if empty
+	Bitrate string
+}
+
+// Args returns the ffmpeg arguments to save a proxy of the source as dest, movies smaller than the height aren't scaled up
+func (x Proxy) Args(sourceFile, destFile string) []string {
+	height, bitrate := x.Height, x.Bitrate
+	if height == 0 {
+		height = DefaultProxyHeight
+	}
+	if len(bitrate) == 0 {
+		bitrate = DefaultProxyBitrate
+	}
+	return []string{"-y", "-v", "error", "-i", sourceFile, "-vf", "scale=-2:'min(" + strconv.Itoa(height) + ",ih)'",
+		"-c:v", "libx264", "-preset", "veryfast", "-b:v", bitrate, "-maxrate", bitrate, "-bufsize", bitrate,
+		"-map_metadata", "0", "-movflags", "+faststart", "-c:a", "aac", "-b:a", "64k", destFile}
+}
+
+// Saves the proxy of a movie, named after it with its dir relative to the input root, unless it has one
+func (p *Processor) saveProxy(ctx context.Context, fileName, name string) {
+	base := filepath.Base(fileName)
+	proxyFile := filepath.Join(p.Proxy.Dir, filepath.Dir(name), strings.TrimSuffix(base, filepath.Ext(base))+".mp4")
+	if _, err := os.Stat(proxyFile); err == nil {
+		log.Debug("Proxy exists: ", proxyFile)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(proxyFile), 0755); err != nil {
+		log.Error("Could not save proxy: ", err)
+		return
+	}
+	if err := runnerOrExec(p.Encoder.Runner).Run(ctx, "ffmpeg", p.Proxy.Args(fileName, proxyFile), nil, nil); err != nil {
+		os.Remove(proxyFile)
+		log.Error("Could not save proxy: ", fileName, err)
+		return
+	}
+	log.Info("Saved proxy: ", proxyFile)
+}
@@ -0,0 +1,103 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Where quality is measured, as fractions of the movie, and for how long
+var (
+	qualitySampleLength    = 5 * time.Second
+	qualitySamplePositions = []float64{0.2, 0.5, 0.8}
+)
+
+// Patterns reading the scores ffmpeg logs
+var (
+	vmafScorePattern = regexp.MustCompile(`VMAF score: ([0-9.]+)`)
+	ssimScorePattern = regexp.MustCompile(`SSIM .*All:([0-9.]+)`)
+)
+
+// QualityGate keeps originals whose shrunk version scores below a minimum on sampled segments, however small it is
+type QualityGate struct {
+	// MinVMAF is the lowest VMAF from 0 to 100, not checked if zero. It needs ffmpeg built with libvmaf.
+	MinVMAF float64
+	// MinSSIM is the lowest SSIM from 0 to 1, not checked if zero
+	MinSSIM float64
+}
+
+// Quality measures how close the shrunk version of a movie is to the original with a metric, vmaf or ssim,
+// averaged over a few segments
+func (e Encoder) Quality(ctx context.Context, original, shrunk, metric string) (float64, error) {
+	var filter string
+	var pattern *regexp.Regexp
+	switch metric {
+	case "vmaf":
+		filter, pattern = "libvmaf", vmafScorePattern
+	case "ssim":
+		filter, pattern = "ssim", ssimScorePattern
+	default:
+		return 0, fmt.Errorf("unknown quality metric: %s", metric)
+	}
+	runner := runnerOrExec(e.Runner)
+	duration := probeDuration(ctx, runner, original)
+	positions, length := qualitySamplePositions, qualitySampleLength
+	if duration <= time.Duration(len(positions))*qualitySampleLength {
+		positions, length = []float64{0}, duration
+	}
+	// the shrunk version is scaled to the size of the original, with timestamps of both starting at zero
+	graph := "[0:v]settb=AVTB,setpts=PTS-STARTPTS[d];[1:v]settb=AVTB,setpts=PTS-STARTPTS[r];" +
+		"[d][r]scale2ref=flags=bicubic[ds][rs];[ds]format=yuv420p[dist];[rs]format=yuv420p[ref];[dist][ref]" + filter
+	var total float64
+	for _, position := range positions {
+		start := fmt.Sprintf("%.3f", position*duration.Seconds())
+		var stderr bytes.Buffer
+		args := []string{"-nostats", "-ss", start, "-t", fmt.Sprintf("%.3f", length.Seconds()), "-i", shrunk,
+			"-ss", start, "-t", fmt.Sprintf("%.3f", length.Seconds()), "-i", original, "-lavfi", graph, "-f", "null", "-"}
+		if err := runner.Run(ctx, "ffmpeg", args, nil, &stderr); err != nil {
+			return 0, fmt.Errorf("could not measure %s: %v", metric, err)
+		}
+		m := pattern.FindSubmatch(stderr.Bytes())
+		if m == nil {
+			return 0, fmt.Errorf("no %s score in the ffmpeg output", metric)
+		}
+		score, err := strconv.ParseFloat(string(m[1]), 64)
+		if err != nil {
+			return 0, err
+		}
+		total += score
+	}
+	return total / float64(len(positions)), nil
+}
+
+// Measures the quality of a shrunk movie into the result, returning an error if it is below the minimums of the gate
+// or can't be measured, in which case the original is kept
+func (p *Processor) checkQuality(ctx context.Context, sourceFile, destFile string, result *FileResult) error {
+	encoder := Encoder{Runner: p.Encoder.Runner}
+	if p.QualityGate.MinVMAF > 0 {
+		score, err := encoder.Quality(ctx, sourceFile, destFile, "vmaf")
+		if err != nil {
+			return err
+		}
+		log.Info("VMAF of: ", destFile, " ", score)
+		if result.VMAF = score; score < p.QualityGate.MinVMAF {
+			return fmt.Errorf("VMAF %.1f is below %.1f", score, p.QualityGate.MinVMAF)
+		}
+	}
+	if p.QualityGate.MinSSIM > 0 {
+		score, err := encoder.Quality(ctx, sourceFile, destFile, "ssim")
+		if err != nil {
+			return err
+		}
+		log.Info("SSIM of: ", destFile, " ", score)
+		if result.SSIM = score; score < p.QualityGate.MinSSIM {
+			return fmt.Errorf("SSIM %.4f is below %.4f", score, p.QualityGate.MinSSIM)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package shrink
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	filepath "path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultQuotaPoll is how often a full temp dir is checked again
+const DefaultQuotaPoll = time.Minute
+
+// TmpQuota delays encodes while they would take more temp space than allowed, counting the temp dirs of other runs too
+type TmpQuota struct {
+	// Dir holds the temp dirs, eg. the -tmp-dir shared by several runs
+	Dir string
+	// Match, when set, only counts the entries of Dir matching this pattern, eg. shrink-*
+	Match string
+	// Bytes is the most space encodes may take in Dir
+	Bytes int64
+	// Poll is how often to check again while waiting, DefaultQuotaPoll if zero
+	Poll time.Duration
+}
+
+// Used returns the bytes taken by the files in the dir
+func (q TmpQuota) Used() int64 {
+	entries, err := ioutil.ReadDir(q.Dir)
+	if err != nil {
+		return 0
+	}
+	var used int64
+	for _, entry := range entries {
+		if len(q.Match) > 0 {
+			if matched, _ := filepath.Match(q.Match, entry.Name()); !matched {
+				continue
+			}
+		}
+		filepath.Walk(filepath.Join(q.Dir, entry.Name()), func(_ string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				used += info.Size()
+			}
+			return nil
+		})
+	}
+	return used
+}
+
+// Wait blocks until need more bytes fit in the quota, or until the context is done.
+// Doesn't wait if nothing is using the dir, so a movie larger than the quota is still encoded.
+func (q TmpQuota) Wait(ctx context.Context, need int64) error {
+	poll := q.Poll
+	if poll == 0 {
+		poll = DefaultQuotaPoll
+	}
+	for waiting := false; ; waiting = true {
+		used := q.Used()
+		if used == 0 || used+need <= q.Bytes {
+			if waiting {
+				log.Info("Temp space available: ", used>>20, " MB in use")
+			}
+			return nil
+		}
+		if !waiting {
+			log.Info("Waiting for temp space: ", used>>20, " MB in use, ", need>>20, " MB needed, quota ", q.Bytes>>20, " MB")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package shrink
+
+import (
+	"bytes"
+	"io"
+	filepath "path/filepath"
+	"strings"
+	"time"
+)
+
+// Limits on how much of a movie is read looking for an embedded recording date
+const (
+	// dvMaxBlocks is about 10 PAL frames of DIF blocks
+	dvMaxBlocks = 10 * 12 * 150
+	// avchdMaxBytes is enough for the first few frames, which all carry the date
+	avchdMaxBytes = 4 << 20
+)
+
+// difBlockSize is the size of a DV DIF block
+const difBlockSize = 80
+
+// mdpmUUID starts the H.264 SEI AVCHD cameras store their Modified DV Pack Metadata in, followed by MDPM
+var mdpmUUID = []byte{0x17, 0xee, 0x8c, 0x60, 0xf8, 0x4d, 0x11, 0xd9, 0x8c, 0xd6, 0x08, 0x00, 0x20, 0x0c, 0x9a, 0x66, 'M', 'D', 'P', 'M'}
+
+// Returns the recording date DV tapes and AVCHD cameras embed in the stream itself, where ffprobe doesn't read it.
+// Captured DV tapes otherwise get the date of the capture session. The date is read as being in the given time zone.
+func recordingDate(fsys FS, loc *time.Location, fileName string) (time.Time, bool) {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if ext != ".dv" && ext != ".mts" && ext != ".m2ts" {
+		return time.Time{}, false
+	}
+	file, err := fsys.Open(fileName)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer file.Close()
+	if ext == ".dv" {
+		return dvRecordingDate(file, loc)
+	}
+	return avchdRecordingDate(file, loc)
+}
+
+// Reads the recording date from the VAUX rec date (0x62) and rec time (0x63) packs of a raw DV stream
+func dvRecordingDate(r io.Reader, loc *time.Location) (time.Time, bool) {
+	block := make([]byte, difBlockSize)
+	var date, clock []byte
+	for i := 0; i < dvMaxBlocks; i++ {
+		if _, err := io.ReadFull(r, block); err != nil {
+			break
+		}
+		// the section type is in the top 3 bits of the block id, 2 is VAUX with 15 packs of 5 bytes
+		if block[0]>>5 != 2 {
+			continue
+		}
+		for p := 3; p+5 <= 3+15*5; p += 5 {
+			switch block[p] {
+			case 0x62:
+				date = append([]byte(nil), block[p+1:p+5]...)
+			case 0x63:
+				clock = append([]byte(nil), block[p+1:p+5]...)
+			}
+		}
+		if date == nil || clock == nil {
+			continue
+		}
+		year := bcd(date[3])
+		if year < 75 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+		if t, ok := validDate(loc, year, bcd(date[2]&0x1f), bcd(date[1]&0x3f), bcd(clock[3]&0x3f), bcd(clock[2]&0x7f), bcd(clock[1]&0x7f)); ok {
+			return t, true
+		}
+		// packs of 0xff are unset, try the next frame
+		date, clock = nil, nil
+	}
+	return time.Time{}, false
+}
+
+// Reads the recording date from the 0x18 and 0x19 tags of the MDPM SEI in an AVCHD stream
+func avchdRecordingDate(r io.Reader, loc *time.Location) (time.Time, bool) {
+	data, _ := io.ReadAll(io.LimitReader(r, avchdMaxBytes))
+	for start := 0; ; {
+		i := bytes.Index(data[start:], mdpmUUID)
+		if i < 0 {
+			return time.Time{}, false
+		}
+		start += i + len(mdpmUUID)
+		end := start + 1 + 5*32
+		if end > len(data) {
+			end = len(data)
+		}
+		// the SEI has emulation prevention bytes, 00 00 03 in the stream is 00 00
+		sei := bytes.ReplaceAll(data[start:end], []byte{0, 0, 3}, []byte{0, 0})
+		if len(sei) == 0 {
+			continue
+		}
+		var date, clock []byte
+		for p := 1; p < 1+5*int(sei[0]) && p+5 <= len(sei); p += 5 {
+			switch sei[p] {
+			case 0x18:
+				date = sei[p+1 : p+5]
+			case 0x19:
+				clock = sei[p+1 : p+5]
+			}
+		}
+		if date == nil || clock == nil {
+			continue
+		}
+		if t, ok := validDate(loc, bcd(date[1])*100+bcd(date[2]), bcd(date[3]), bcd(clock[0]), bcd(clock[1]), bcd(clock[2]), bcd(clock[3])); ok {
+			return t, true
+		}
+	}
+}
+
+// Decodes a binary coded decimal byte, -1 if it isn't one
+func bcd(b byte) int {
+	if b>>4 > 9 || b&0x0f > 9 {
+		return -1
+	}
+	return int(b>>4)*10 + int(b&0x0f)
+}
+
+// Returns the time if the parts are a real date, time.Date moves eg. the 31st of April to May
+func validDate(loc *time.Location, year, month, day, hour, minute, second int) (time.Time, bool) {
+	date := time.Date(year, time.Month(month), day, hour, minute, second, 0, loc)
+	if year < 1980 || date.Year() != year || int(date.Month()) != month || date.Day() != day ||
+		date.Hour() != hour || date.Minute() != minute || date.Second() != second {
+		return time.Time{}, false
+	}
+	return date, true
+}
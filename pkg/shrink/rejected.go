@@ -0,0 +1,82 @@
+package shrink
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	filepath "path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rejectedNoteSuffix is added to the name of a rejected encode for the note next to it
+const rejectedNoteSuffix = ".json"
+
+// RejectedNote is written next to an encode kept in the rejected dir, eg. 2016/clip.mp4.json,
+// saying why it was rejected and what it was encoded from and with, so it can be reused
+type RejectedNote struct {
+	Source   string         `json:"source"`
+	InSize   int64          `json:"inSize"`
+	OutSize  int64          `json:"outSize"`
+	Ratio    float64        `json:"ratio"`
+	MaxRatio float64        `json:"maxRatio"`
+	Settings EncodeSettings `json:"settings"`
+	Rejected time.Time      `json:"rejected"`
+}
+
+// Returns where the encode of a file is kept in the rejected dir, with the dirs and name of the original
+// relative to the input root and the extension of the encode
+func (p *Processor) rejectedName(name, ext string) string {
+	return filepath.Join(p.RejectedDir, filepath.FromSlash(strings.TrimSuffix(name, path.Ext(name))+ext))
+}
+
+// Moves an encode that didn't shrink enough into the rejected dir, with a note of its ratio
+func (p *Processor) reject(destFile, name string, settings EncodeSettings, result FileResult) {
+	rejectedFile := p.rejectedName(name, filepath.Ext(destFile))
+	if err := p.FS.MkdirAll(filepath.Dir(rejectedFile), 0755); err != nil {
+		log.Error("Could not keep rejected encode: ", destFile, err)
+		return
+	}
+	if err := moveFile(p.FS, destFile, rejectedFile); err != nil {
+		log.Error("Could not keep rejected encode: ", destFile, err)
+		return
+	}
+	data, err := json.MarshalIndent(RejectedNote{Source: result.Source, InSize: result.InSize, OutSize: result.OutSize, Ratio: result.Ratio,
+		MaxRatio: p.Swapper.MaxRatio, Settings: settings, Rejected: time.Now()}, "", "  ")
+	if err == nil {
+		err = writeFile(p.FS, rejectedFile+rejectedNoteSuffix, append(data, '\n'))
+	}
+	if err != nil {
+		log.Error("Could not write rejected note: ", rejectedFile, err)
+	}
+	log.Info("Kept rejected encode: ", rejectedFile)
+}
+
+// Moves the rejected encode of a file into dest instead of encoding it again, if it was encoded from the same
+// size of input with the same settings. Returns true if it was reused.
+func (p *Processor) reuseRejected(name string, inSize int64, settings EncodeSettings, destFile string) bool {
+	rejectedFile := p.rejectedName(name, filepath.Ext(destFile))
+	in, err := p.FS.Open(rejectedFile + rejectedNoteSuffix)
+	if err != nil {
+		return false
+	}
+	data, err := ioutil.ReadAll(in)
+	in.Close()
+	var note RejectedNote
+	if err != nil || json.Unmarshal(data, &note) != nil {
+		return false
+	}
+	if note.InSize != inSize || !reflect.DeepEqual(note.Settings, settings) {
+		return false
+	}
+	if err := moveFile(p.FS, rejectedFile, destFile); err != nil {
+		log.Error("Could not reuse rejected encode: ", rejectedFile, err)
+		return false
+	}
+	p.FS.Remove(rejectedFile + rejectedNoteSuffix)
+	log.Info("Reusing rejected encode: ", rejectedFile)
+	return true
+}
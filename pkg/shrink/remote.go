@@ -0,0 +1,128 @@
+package shrink
+
+import (
+	"context"
+	"os"
+	filepath "path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Remote is a location that movies can be listed and downloaded from, and results uploaded back to.
+// Names are relative to the root of the remote and use forward slashes.
+type Remote interface {
+	Uploader
+	List(ctx context.Context) ([]string, error)
+	Download(ctx context.Context, relName, fileName string) error
+	Delete(ctx context.Context, relName string) error
+}
+
+// ProcessRemote downloads every movie from the input remote into the temp dir, shrinks it and uploads the result to out.
+// If out is nil the results replace the originals on the input remote.
+func (p *Processor) ProcessRemote(ctx context.Context, in Remote, out Uploader) error {
+	replace := out == nil
+	if replace {
+		out = in
+	}
+	p.output = out
+
+	names, err := in.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	stageDir := filepath.Join(p.TmpDir, "remote")
+	defer p.refreshChanged()
+	for _, name := range names {
+		if err := p.cancelled(ctx); err == errStopped {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if p.State.DoneRemote(p.InDir, name) {
+			log.Debug("Already processed: ", name)
+			continue
+		}
+		localFile := filepath.Join(stageDir, filepath.FromSlash(name))
+		if err := p.FS.MkdirAll(filepath.Dir(localFile), 0755); err != nil {
+			log.Error(err)
+			continue
+		}
+		if err := in.Download(ctx, name, localFile); err != nil {
+			log.Error("Could not download: ", name, err)
+			continue
+		}
+
+		result, err := p.shrink(ctx, localFile, name)
+		result.Source = name
+		if err != nil {
+			p.Report.Add(result)
+			p.countTowardsStop(result)
+			p.FS.Remove(localFile)
+			continue
+		}
+
+		resultFile := result.Result
+		resultName := p.destinationName(ctx, result, relName(stageDir, resultFile))
+		result.Destinations = p.replicate(ctx, resultFile, resultName)
+		p.Report.Add(result)
+		p.countTowardsStop(result)
+		p.upload(ctx, resultFile, resultName)
+
+		// nothing to do if the file didn't shrink and it's going back where it came from
+		if resultFile == localFile && replace {
+			p.recordRemote(name, resultFile, result)
+			p.FS.Remove(localFile)
+			continue
+		}
+
+		p.claimDestination(resultName)
+		if err := out.Upload(ctx, resultFile, resultName, p.copyTime(ctx, resultFile)); err != nil {
+			log.Error("Could not upload: ", resultName, err)
+		} else {
+			p.recordRemote(name, resultFile, result)
+			if replace && resultName != name {
+				// the result is listed next time too, it mustn't be shrunk again
+				p.recordRemote(resultName, resultFile, result)
+				// the shrunk file replaces the original, same as on local disk
+				if err := in.Delete(ctx, name); err != nil {
+					log.Error("Could not remove original: ", name, err)
+				}
+			}
+		}
+		p.FS.Remove(resultFile)
+	}
+	return nil
+}
+
+// Remembers a name on the input remote as processed, with the size of the local file it ended up as
+func (p *Processor) recordRemote(name, fileName string, result FileResult) {
+	var size int64
+	if stat, err := p.FS.Stat(fileName); err == nil {
+		size = stat.Size()
+	}
+	if err := p.State.RecordRemote(p.InDir, name, size, result.Ratio); err != nil {
+		log.Error("Could not save state: ", err)
+	}
+}
+
+// LocalUploader copies finished files below a local directory
+type LocalUploader struct {
+	Dir string
+}
+
+// Upload copies the file and sets its mod time to the capture time, if there is one
+func (l LocalUploader) Upload(ctx context.Context, fileName, relName string, captureTime time.Time) error {
+	destFile := filepath.Join(l.Dir, filepath.FromSlash(relName))
+	if err := os.MkdirAll(filepath.Dir(destFile), 0755); err != nil {
+		return err
+	}
+	if err := CopyFile(fileName, destFile); err != nil {
+		return err
+	}
+	if captureTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(destFile, captureTime, captureTime)
+}
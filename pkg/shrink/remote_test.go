@@ -0,0 +1,73 @@
+package shrink
+
+import (
+	"context"
+	"os"
+	filepath "path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeRemote is a remote of made up files that remembers what was downloaded and uploaded
+type fakeRemote struct {
+	names      []string
+	downloaded []string
+	uploaded   []string
+}
+
+func (r *fakeRemote) List(ctx context.Context) ([]string, error) {
+	return r.names, nil
+}
+
+func (r *fakeRemote) Download(ctx context.Context, relName, fileName string) error {
+	r.downloaded = append(r.downloaded, relName)
+	return os.WriteFile(fileName, []byte("movie"), 0644)
+}
+
+func (r *fakeRemote) Upload(ctx context.Context, fileName, relName string, captureTime time.Time) error {
+	r.uploaded = append(r.uploaded, relName)
+	return nil
+}
+
+func (r *fakeRemote) Delete(ctx context.Context, relName string) error {
+	return nil
+}
+
+func TestProcessRemoteState(t *testing.T) {
+	dir := t.TempDir()
+	state, err := LoadState(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.RecordRemote("s3://camera/2016", "done.mov", 5, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	in := &fakeRemote{names: []string{"done.mov", "new.mov"}}
+	out := &fakeRemote{}
+	opts := Options{
+		InDir:  "s3://camera/2016",
+		TmpDir: dir,
+		State:  state,
+		// the files are left alone, so no ffmpeg is needed
+		Before: func(ctx context.Context, fileName, name string) error { return ErrSkip },
+	}
+
+	if err := New(opts).ProcessRemote(context.Background(), in, out); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"new.mov"}; !reflect.DeepEqual(in.downloaded, want) {
+		t.Errorf("downloaded %v, want %v", in.downloaded, want)
+	}
+	if !state.DoneRemote("s3://camera/2016/", "new.mov") {
+		t.Error("new.mov isn't in the state after processing it")
+	}
+
+	in.downloaded = nil
+	if err := New(opts).ProcessRemote(context.Background(), in, out); err != nil {
+		t.Fatal(err)
+	}
+	if len(in.downloaded) > 0 {
+		t.Errorf("downloaded %v again", in.downloaded)
+	}
+}
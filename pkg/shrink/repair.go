@@ -0,0 +1,95 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	filepath "path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Repairer salvages damaged movies, like recordings cut short when the battery of a camera died
+type Repairer struct {
+	// Reference, when set, is a healthy movie from the same camera and settings,
+	// which untrunc rebuilds the index of movies that lost it from
+	Reference string
+	// QuarantineDir, when set, is where movies that can't be repaired are moved to, keeping their dir below the input root
+	QuarantineDir string
+}
+
+// Repair salvages a damaged movie into dest. ffmpeg copies the streams, ignoring errors, dropping corrupt packets
+// and making up missing timestamps. Movies that lost their index, whose recording was interrupted, are first rebuilt
+// with untrunc if there is a reference movie. Returns an error if dest still has problems.
+func (e Encoder) Repair(ctx context.Context, sourceFile, destFile, reference string) error {
+	runner := runnerOrExec(e.Runner)
+	inputFile := sourceFile
+	if problems := e.Check(ctx, sourceFile); len(reference) > 0 && len(problems) > 0 && strings.Contains(problems[0], "moov atom not found") {
+		inputFile = strings.TrimSuffix(destFile, filepath.Ext(destFile)) + "_untrunc" + filepath.Ext(sourceFile)
+		defer os.Remove(inputFile)
+		var stderr bytes.Buffer
+		if err := runner.Run(ctx, "untrunc", []string{"-dst", inputFile, reference, sourceFile}, nil, &stderr); err != nil {
+			return fmt.Errorf("untrunc failed: %v %s", err, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	args := []string{"-y", "-v", "error", "-err_detect", "ignore_err", "-fflags", "+genpts+discardcorrupt", "-i", inputFile}
+	args = append(args, streamMaps(destFile, e.Strip, e.audioMaps(ctx, inputFile))...)
+	args = append(args, "-c", "copy", "-map_metadata", "0", destFile)
+	// ffmpeg reports every damaged packet it skips, only failing to write anything is an error
+	if err := runner.Run(ctx, "ffmpeg", args, nil, nil); err != nil {
+		return err
+	}
+	if problems := e.Check(ctx, destFile); len(problems) > 0 {
+		return fmt.Errorf("still damaged: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// Quarantine moves a movie that can't be repaired into the quarantine dir, if there is one.
+// name is its name relative to the input root, using forward slashes.
+func (r Repairer) Quarantine(fileName, name string) error {
+	return r.quarantine(OSFS{}, fileName, name)
+}
+
+// Quarantine on the given file system
+func (r Repairer) quarantine(fsys FS, fileName, name string) error {
+	if len(r.QuarantineDir) == 0 {
+		return nil
+	}
+	dest := filepath.Join(r.QuarantineDir, filepath.FromSlash(name))
+	if err := fsys.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := moveFile(fsys, fileName, dest); err != nil {
+		return err
+	}
+	log.Info("Quarantined: ", fileName, " to: ", dest)
+	return nil
+}
+
+// Repairs a damaged movie into the temp dir before it is encoded, returning the repaired file, or the movie itself
+// if it isn't damaged. Movies that can't be repaired are quarantined.
+func (p *Processor) repair(ctx context.Context, sourceFile, inputFile, name string) (string, bool, error) {
+	encoder := Encoder{Strip: p.Encoder.Strip, AudioLanguages: p.Encoder.AudioLanguages, Runner: p.Encoder.Runner}
+	problems := encoder.Check(ctx, inputFile)
+	if len(problems) == 0 {
+		return inputFile, false, nil
+	}
+	log.Info("Repairing: ", sourceFile, " ", strings.Join(problems, ", "))
+	repairedFile := filepath.Join(p.TmpDir, "repaired_"+filepath.Base(inputFile))
+	if err := encoder.Repair(ctx, inputFile, repairedFile, p.Repair.Reference); err != nil {
+		p.FS.Remove(repairedFile)
+		if ctx.Err() == nil {
+			log.Error("Could not repair: ", sourceFile, " ", err)
+			if err := p.Repair.quarantine(p.FS, sourceFile, name); err != nil {
+				log.Error("Could not quarantine: ", sourceFile, err)
+			}
+		}
+		return "", false, err
+	}
+	log.Info("Repaired: ", sourceFile)
+	return repairedFile, true, nil
+}
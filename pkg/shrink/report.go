@@ -0,0 +1,189 @@
+package shrink
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FileResult is the outcome of processing a single file
+type FileResult struct {
+	Source  string  `json:"source"`
+	Result  string  `json:"result"`
+	InSize  int64   `json:"inSize"`
+	OutSize int64   `json:"outSize"`
+	Ratio   float64 `json:"ratio"`
+	Swapped bool    `json:"swapped"`
+	Error   string  `json:"error,omitempty"`
+	// Skipped is why a file was left alone without trying to encode it, eg. DRM protected
+	Skipped string `json:"skipped,omitempty"`
+	// Blank is why a movie looks blank, eg. black video and silent audio, when blank clips are looked for
+	Blank string `json:"blank,omitempty"`
+	// Camera is the make and model of the camera of a movie and Captured when it was shot, for the ratio statistics
+	Camera   string    `json:"camera,omitempty"`
+	Captured time.Time `json:"captured,omitempty"`
+	// VMAF and SSIM are the quality scores of the shrunk version, when the quality gate measured them
+	VMAF float64 `json:"vmaf,omitempty"`
+	SSIM float64 `json:"ssim,omitempty"`
+	// Destinations is how replicating the finished file to each mirror went
+	Destinations []DestinationResult `json:"destinations,omitempty"`
+	// Time is when processing of the file finished
+	Time time.Time `json:"time"`
+}
+
+// Saved returns the number of bytes saved by replacing the original
+func (f FileResult) Saved() int64 {
+	if !f.Swapped {
+		return 0
+	}
+	return f.InSize - f.OutSize
+}
+
+// Summary totals up the results of a run
+type Summary struct {
+	Processed int `json:"processed"`
+	Shrunk    int `json:"shrunk"`
+	Failed    int `json:"failed"`
+	// Skipped counts the files that were left alone because they can't be encoded
+	Skipped int `json:"skipped,omitempty"`
+	// Blank counts the movies that look blank, whether they were encoded or moved into the review dir
+	Blank int   `json:"blank,omitempty"`
+	Saved int64 `json:"saved"`
+	// Unreadable counts the dirs that were skipped because they couldn't be read
+	Unreadable int `json:"unreadable,omitempty"`
+	// MirrorFailed counts the copies to mirrors that failed, a file failing at two mirrors counts twice
+	MirrorFailed int `json:"mirrorFailed,omitempty"`
+}
+
+// UnreadableDir is a dir that was skipped because it couldn't be read
+type UnreadableDir struct {
+	Dir   string `json:"dir"`
+	Error string `json:"error"`
+}
+
+// Report collects the results of a run, it is safe to use from multiple goroutines
+type Report struct {
+	mu         sync.Mutex
+	files      []FileResult
+	unreadable []UnreadableDir
+}
+
+// Add records the result of a file, a nil report ignores it
+func (r *Report) Add(result FileResult) {
+	if r == nil {
+		return
+	}
+	if result.Time.IsZero() {
+		result.Time = time.Now()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files = append(r.files, result)
+}
+
+// AddUnreadable records a dir that couldn't be read, a nil report ignores it
+func (r *Report) AddUnreadable(dirName string, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unreadable = append(r.unreadable, UnreadableDir{Dir: dirName, Error: err.Error()})
+}
+
+// Unreadable returns a copy of the dirs that couldn't be read so far
+func (r *Report) Unreadable() []UnreadableDir {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]UnreadableDir(nil), r.unreadable...)
+}
+
+// Files returns a copy of all results so far
+func (r *Report) Files() []FileResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]FileResult(nil), r.files...)
+}
+
+// Summary totals up the results so far
+func (r *Report) Summary() Summary {
+	var summary Summary
+	for _, f := range r.Files() {
+		summary.Processed++
+		if len(f.Blank) > 0 {
+			summary.Blank++
+		}
+		for _, dest := range f.Destinations {
+			if len(dest.Error) > 0 {
+				summary.MirrorFailed++
+			}
+		}
+		if len(f.Error) > 0 {
+			summary.Failed++
+		} else if len(f.Skipped) > 0 {
+			summary.Skipped++
+		} else if f.Swapped {
+			summary.Shrunk++
+			summary.Saved += f.Saved()
+		}
+	}
+	summary.Unreadable = len(r.Unreadable())
+	return summary
+}
+
+// RatioStats describes the output/input size ratios of a group of encoded files, to tune the CRF of profiles
+type RatioStats struct {
+	Files  int     `json:"files"`
+	P10    float64 `json:"p10"`
+	Median float64 `json:"median"`
+	P90    float64 `json:"p90"`
+	// Histogram counts the ratios in steps of 0.1, the last step counts those of 1 and more
+	Histogram [11]int `json:"histogram"`
+}
+
+// RatioStats groups the ratios of the files that were encoded by "camera" or "year"
+func (r *Report) RatioStats(by string) map[string]RatioStats {
+	ratios := make(map[string][]float64)
+	for _, f := range r.Files() {
+		if len(f.Error) > 0 || f.OutSize == 0 {
+			continue
+		}
+		key := "unknown"
+		switch {
+		case by == "camera" && len(f.Camera) > 0:
+			key = f.Camera
+		case by == "year" && !f.Captured.IsZero():
+			key = strconv.Itoa(f.Captured.Year())
+		}
+		ratios[key] = append(ratios[key], f.Ratio)
+	}
+	stats := make(map[string]RatioStats)
+	for key, values := range ratios {
+		sort.Float64s(values)
+		percentile := func(p float64) float64 {
+			return values[int(p*float64(len(values)-1)+0.5)]
+		}
+		s := RatioStats{Files: len(values), P10: percentile(0.1), Median: percentile(0.5), P90: percentile(0.9)}
+		for _, ratio := range values {
+			bucket := int(ratio * 10)
+			if bucket > 10 {
+				bucket = 10
+			}
+			s.Histogram[bucket]++
+		}
+		stats[key] = s
+	}
+	return stats
+}
+
+// MarshalJSON writes the summary along with all file results, the ratio statistics and the dirs that couldn't be read
+func (r *Report) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Summary    Summary                          `json:"summary"`
+		Ratios     map[string]map[string]RatioStats `json:"ratios"`
+		Files      []FileResult                     `json:"files"`
+		Unreadable []UnreadableDir                  `json:"unreadable,omitempty"`
+	}{r.Summary(), map[string]map[string]RatioStats{"camera": r.RatioStats("camera"), "year": r.RatioStats("year")}, r.Files(), r.Unreadable()})
+}
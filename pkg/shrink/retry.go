@@ -0,0 +1,170 @@
+package shrink
+
+import (
+	"errors"
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Retry settings used when they aren't set
+const (
+	DefaultRetryAttempts = 4
+	DefaultRetryBackoff  = time.Second
+)
+
+// Errors network file systems return while a share briefly goes away, worth trying again
+var transientErrors = []error{syscall.ESTALE, syscall.EIO, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT,
+	syscall.ECONNRESET, syscall.ECONNABORTED, syscall.ENOTCONN, syscall.ENETDOWN, syscall.ENETUNREACH, syscall.EHOSTUNREACH}
+
+// The same errors on windows shares: bad network path, unexpected network error, network name deleted, semaphore timeout
+// and network unreachable
+var transientWindowsErrors = []syscall.Errno{53, 59, 64, 121, 1231}
+
+// Returns true if an error is likely to go away when the operation is tried again
+func transient(err error) bool {
+	if runtime.GOOS == "windows" {
+		var errno syscall.Errno
+		if errors.As(err, &errno) {
+			for _, transientErr := range transientWindowsErrors {
+				if errno == transientErr {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, transientErr := range transientErrors {
+		if errors.Is(err, transientErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryFS retries the operations of a file system that fail with transient errors of network shares,
+// like stale NFS handles or a NAS dropping off for a moment, waiting longer each time
+type RetryFS struct {
+	// FS is the file system to retry the operations of, the real one if nil
+	FS FS
+	// Attempts is how often an operation is tried, DefaultRetryAttempts if zero
+	Attempts int
+	// Backoff is the wait before the first retry, it doubles each time, DefaultRetryBackoff if zero
+	Backoff time.Duration
+}
+
+// Runs an operation until it succeeds, fails with an error that isn't transient or runs out of attempts
+func (r RetryFS) retry(name string, op func() error) error {
+	attempts, backoff := r.Attempts, r.Backoff
+	if attempts == 0 {
+		attempts = DefaultRetryAttempts
+	}
+	if backoff == 0 {
+		backoff = DefaultRetryBackoff
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = op(); err == nil || !transient(err) || attempt >= attempts {
+			return err
+		}
+		log.Info("Retrying ", name, " in ", backoff, ": ", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Stat retries getting the info of a file
+func (r RetryFS) Stat(name string) (info os.FileInfo, err error) {
+	err = r.retry("stat "+name, func() (err error) {
+		info, err = fsOrOS(r.FS).Stat(name)
+		return err
+	})
+	return info, err
+}
+
+// ReadDir retries reading a dir
+func (r RetryFS) ReadDir(name string) (infos []os.FileInfo, err error) {
+	err = r.retry("reading "+name, func() (err error) {
+		infos, err = fsOrOS(r.FS).ReadDir(name)
+		return err
+	})
+	return infos, err
+}
+
+// Open retries opening a file
+func (r RetryFS) Open(name string) (f io.ReadCloser, err error) {
+	err = r.retry("opening "+name, func() (err error) {
+		f, err = fsOrOS(r.FS).Open(name)
+		return err
+	})
+	return f, err
+}
+
+// Create retries creating a file
+func (r RetryFS) Create(name string) (f io.WriteCloser, err error) {
+	err = r.retry("creating "+name, func() (err error) {
+		f, err = fsOrOS(r.FS).Create(name)
+		return err
+	})
+	return f, err
+}
+
+// Remove retries removing a file, which is done once it is gone
+func (r RetryFS) Remove(name string) error {
+	first := true
+	return r.retry("removing "+name, func() error {
+		err := fsOrOS(r.FS).Remove(name)
+		if !first && os.IsNotExist(err) {
+			// an earlier attempt got through before the share dropped
+			return nil
+		}
+		first = false
+		return err
+	})
+}
+
+// RemoveAll retries removing a dir
+func (r RetryFS) RemoveAll(name string) error {
+	return r.retry("removing "+name, func() error { return fsOrOS(r.FS).RemoveAll(name) })
+}
+
+// Rename retries renaming a file, which is done once only the new name exists
+func (r RetryFS) Rename(oldName, newName string) error {
+	first := true
+	return r.retry("renaming "+oldName, func() error {
+		err := fsOrOS(r.FS).Rename(oldName, newName)
+		if err != nil && !first {
+			// an earlier attempt got through before the share dropped
+			if _, oldErr := fsOrOS(r.FS).Stat(oldName); os.IsNotExist(oldErr) {
+				if _, newErr := fsOrOS(r.FS).Stat(newName); newErr == nil {
+					return nil
+				}
+			}
+		}
+		first = false
+		return err
+	})
+}
+
+// MkdirAll retries making dirs
+func (r RetryFS) MkdirAll(name string, perm os.FileMode) error {
+	return r.retry("making "+name, func() error { return fsOrOS(r.FS).MkdirAll(name, perm) })
+}
+
+// TempDir retries making a temp dir
+func (r RetryFS) TempDir(dir, pattern string) (name string, err error) {
+	err = r.retry("making a temp dir in "+dir, func() (err error) {
+		name, err = fsOrOS(r.FS).TempDir(dir, pattern)
+		return err
+	})
+	return name, err
+}
+
+// Chtimes retries setting the times of a file
+func (r RetryFS) Chtimes(name string, atime, mtime time.Time) error {
+	return r.retry("setting the times of "+name, func() error { return fsOrOS(r.FS).Chtimes(name, atime, mtime) })
+}
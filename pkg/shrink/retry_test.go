@@ -0,0 +1,113 @@
+package shrink
+
+import (
+	"errors"
+	"os"
+	filepath "path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// flakyFS fails the first removes and renames with an error, like a share that drops off
+type flakyFS struct {
+	OSFS
+	err      error
+	failures int
+	calls    int
+	// renameFirst renames before failing, as if the reply of the share got lost
+	renameFirst bool
+}
+
+// Remove fails until the failures are used up
+func (f *flakyFS) Remove(name string) error {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.err
+	}
+	return f.OSFS.Remove(name)
+}
+
+// Rename fails until the failures are used up
+func (f *flakyFS) Rename(oldName, newName string) error {
+	f.calls++
+	if f.calls <= f.failures {
+		if f.renameFirst {
+			f.OSFS.Rename(oldName, newName)
+		}
+		return f.err
+	}
+	return f.OSFS.Rename(oldName, newName)
+}
+
+func TestRetryFS(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows shares fail with other errors")
+	}
+	stale := &os.PathError{Op: "remove", Path: "movie.mp4", Err: syscall.ESTALE}
+	tests := []struct {
+		name      string
+		fs        *flakyFS
+		attempts  int
+		wantErr   bool
+		wantCalls int
+	}{
+		{"succeeds at once", &flakyFS{}, 4, false, 1},
+		{"retries transient errors", &flakyFS{err: stale, failures: 2}, 4, false, 3},
+		{"runs out of attempts", &flakyFS{err: stale, failures: 5}, 3, true, 3},
+		{"doesn't retry other errors", &flakyFS{err: os.ErrPermission, failures: 1}, 4, true, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "movie.mp4")
+			writeTestFile(t, fileName, "movie")
+
+			err := RetryFS{FS: test.fs, Attempts: test.attempts, Backoff: time.Millisecond}.Remove(fileName)
+			if (err != nil) != test.wantErr {
+				t.Errorf("Remove() error = %v, want an error: %v", err, test.wantErr)
+			}
+			if test.fs.calls != test.wantCalls {
+				t.Errorf("tried %d times, want %d", test.fs.calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryFSRenameGotThrough(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows shares fail with other errors")
+	}
+	dir := t.TempDir()
+	oldName, newName := filepath.Join(dir, "old.mp4"), filepath.Join(dir, "new.mp4")
+	writeTestFile(t, oldName, "movie")
+
+	fsys := &flakyFS{err: syscall.ESTALE, failures: 1, renameFirst: true}
+	if err := (RetryFS{FS: fsys, Backoff: time.Millisecond}).Rename(oldName, newName); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(newName); got != "movie" {
+		t.Errorf("renamed file = %q, want movie", got)
+	}
+}
+
+func TestTransient(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows shares fail with other errors")
+	}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{syscall.ESTALE, true},
+		{&os.PathError{Op: "open", Path: "movie.mp4", Err: syscall.EIO}, true},
+		{syscall.ENOENT, false},
+		{os.ErrPermission, false},
+		{errors.New("disk full"), false},
+	}
+	for _, test := range tests {
+		if got := transient(test.err); got != test.want {
+			t.Errorf("transient(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
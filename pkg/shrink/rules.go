@@ -0,0 +1,155 @@
+package shrink
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DirRule encodes the movies below a dir with their own settings
+type DirRule struct {
+	// Dir is relative to the input root and uses forward slashes, eg. Phone/2024
+	Dir     string
+	Encoder Encoder
+}
+
+// Returns how many dirs deep the rule is, the input root itself is 0
+func (d DirRule) depth() int {
+	dir := path.Clean(strings.Trim(d.Dir, "/"))
+	if dir == "." {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// Returns true if the rule applies to a name relative to the input root
+func (d DirRule) matches(name string) bool {
+	dir := path.Clean(strings.Trim(d.Dir, "/"))
+	return dir == "." || strings.HasPrefix(path.Clean(name), dir+"/")
+}
+
+// CameraRule encodes the movies shot with some cameras with their own settings
+type CameraRule struct {
+	// Match are words that must all be in the camera make and model, encoder tag or video codec of a movie,
+	// ignoring case, eg. "DJI" or "canon mjpeg"
+	Match   string
+	Encoder Encoder
+}
+
+// Returns how many words of the rule are in the description of a camera, -1 if not all of them are
+func (c CameraRule) matches(description string) int {
+	words := strings.Fields(strings.ToLower(c.Match))
+	for _, word := range words {
+		if !strings.Contains(description, word) {
+			return -1
+		}
+	}
+	return len(words)
+}
+
+// Returns the camera make and model, encoder tag and video codec of a movie in lower case, to match camera rules against
+func cameraDescription(ctx context.Context, encoder Encoder, fileName string) string {
+	var parts []string
+	if tags, err := probeTags(ctx, encoder.Runner, fileName); err == nil {
+		parts = append(parts, camera(tags), tags.Get("encoder"))
+	}
+	if info, err := encoder.Probe(ctx, fileName); err == nil {
+		parts = append(parts, info.Codec)
+	}
+	return strings.ToLower(strings.Join(parts, " "))
+}
+
+// Returns the encoder for a file and its name relative to the input root.
+// The camera rule matching the most words wins, then the screen profile if the movie is a screen recording,
+// then the time-lapse profile if it is a time-lapse,
+// then the portrait profile if it is portrait, else the rule with the deepest dir.
+// Without a portrait profile the scale filters of the encoder are turned for portrait movies.
+// Slow motion movies use the slow motion profile if nothing else matched, and are never capped in frame rate,
+// except for screen recordings. An AutoCRF is resolved from the source.
+func (p *Processor) encoderFor(ctx context.Context, fileName, name string) Encoder {
+	encoder, depth, matched, screen := p.Encoder, -1, false, false
+	// only probe when the encoder depends on the source, its orientation or frame rate
+	var info *Info
+	probe := func() *Info {
+		if info == nil {
+			probed, err := p.Encoder.Probe(ctx, fileName)
+			if err != nil {
+				log.Debug("Could not probe file: ", fileName, " ", err)
+			}
+			info = &probed
+		}
+		return info
+	}
+	resolveCRF := func(encoder Encoder) Encoder {
+		if encoder.CRF != AutoCRF {
+			return encoder
+		}
+		encoder = encoder.ResolveCRF(*probe())
+		log.Info("Using crf ", encoder.CRF, " for: ", name)
+		return encoder
+	}
+	for _, rule := range p.DirRules {
+		if !rule.matches(name) {
+			continue
+		}
+		if d := rule.depth(); d > depth {
+			encoder, depth = rule.Encoder, d
+		}
+	}
+	encoder = p.dirConfigEncoder(fileName, name, encoder, depth)
+	if len(p.CameraRules) > 0 {
+		description, words, match := cameraDescription(ctx, p.Encoder, fileName), 0, ""
+		for _, rule := range p.CameraRules {
+			if n := rule.matches(description); n > words {
+				encoder, words, match = rule.Encoder, n, rule.Match
+			}
+		}
+		if words > 0 {
+			log.Debug("Using camera rule: ", match, " for: ", name)
+			matched = true
+		}
+	}
+	if p.ScreenRecordings && !matched {
+		var still bool
+		if screen, still = p.Encoder.ScreenRecording(ctx, fileName); screen {
+			log.Info("Using screen profile for screen recording: ", name)
+			if p.ScreenEncoder != nil {
+				encoder = *p.ScreenEncoder
+			} else {
+				encoder = ScreenEncoder(encoder, still)
+			}
+			matched = true
+		}
+	}
+	if p.TimeLapses && !matched && p.Encoder.TimeLapse(ctx, fileName, p.TimeLapseMotion) {
+		log.Info("Using time-lapse profile for time-lapse: ", name)
+		if p.TimeLapseEncoder != nil {
+			encoder = *p.TimeLapseEncoder
+		} else {
+			encoder = TimeLapseEncoder(resolveCRF(encoder))
+		}
+		matched = true
+	}
+	if (p.PortraitEncoder != nil || scalePattern.MatchString(encoder.Filter)) && probe().Portrait() {
+		if p.PortraitEncoder != nil && !matched {
+			log.Debug("Using portrait profile for: ", name)
+			encoder, matched = *p.PortraitEncoder, true
+		} else {
+			encoder = PortraitEncoder(encoder)
+		}
+	}
+	// screens refresh at 120 Hz too, their recordings aren't slow motion
+	if !screen && (p.SlowMotionEncoder != nil || capsFrameRate(encoder)) && probe().SlowMotion() {
+		if p.SlowMotionEncoder != nil && !matched {
+			log.Debug("Using slow motion profile for: ", name)
+			encoder = *p.SlowMotionEncoder
+		}
+		encoder = SlowMotionEncoder(encoder)
+	}
+	if encoder.Runner == nil {
+		encoder.Runner = p.Encoder.Runner
+	}
+	return resolveCRF(encoder)
+}
@@ -0,0 +1,100 @@
+package shrink
+
+import (
+	"context"
+	filepath "path/filepath"
+	"testing"
+)
+
+func TestDirRuleMatches(t *testing.T) {
+	tests := []struct {
+		dir, name string
+		matches   bool
+		depth     int
+	}{
+		{"", "movie.mp4", true, 0},
+		{"/", "Phone/movie.mp4", true, 0},
+		{"Phone", "Phone/movie.mp4", true, 1},
+		{"Phone/", "Phone/2024/movie.mp4", true, 1},
+		{"Phone/2024", "Phone/2024/movie.mp4", true, 2},
+		{"Phone/2024", "Phone/2023/movie.mp4", false, 2},
+		{"Phone", "Phones/movie.mp4", false, 1},
+		{"Phone", "Camera/Phone/movie.mp4", false, 1},
+	}
+	for _, test := range tests {
+		rule := DirRule{Dir: test.dir}
+		if got := rule.matches(test.name); got != test.matches {
+			t.Errorf("DirRule{%q}.matches(%q) = %v, want %v", test.dir, test.name, got, test.matches)
+		}
+		if got := rule.depth(); got != test.depth {
+			t.Errorf("DirRule{%q}.depth() = %d, want %d", test.dir, got, test.depth)
+		}
+	}
+}
+
+func TestCameraRuleMatches(t *testing.T) {
+	tests := []struct {
+		match, description string
+		want               int
+	}{
+		{"DJI", "dji fc3170 h264", 1},
+		{"canon mjpeg", "canon eos 5d mark ii mjpeg", 2},
+		{"canon mjpeg", "canon eos r5 hevc", -1},
+		{"GoPro", "apple iphone 15 pro hevc", -1},
+		{"", "apple iphone 15 pro hevc", 0},
+	}
+	for _, test := range tests {
+		if got := (CameraRule{Match: test.match}).matches(test.description); got != test.want {
+			t.Errorf("CameraRule{%q}.matches(%q) = %d, want %d", test.match, test.description, got, test.want)
+		}
+	}
+}
+
+func TestEncoderFor(t *testing.T) {
+	landscape := `{"streams": [{"codec_name": "h264", "width": 1920, "height": 1080, "avg_frame_rate": "30/1"}]}`
+	portrait := `{"streams": [{"codec_name": "hevc", "width": 1920, "height": 1080, "avg_frame_rate": "30/1", "side_data_list": [{"rotation": -90}]}]}`
+	slowMotion := `{"streams": [{"codec_name": "hevc", "width": 1920, "height": 1080, "avg_frame_rate": "240/1"}]}`
+	iphone := `{"format": {"tags": {"com.apple.quicktime.make": "Apple", "com.apple.quicktime.model": "iPhone 15 Pro"}}}`
+	dji := `{"format": {"tags": {"make": "DJI", "model": "FC3170"}}}`
+	dirRules := []DirRule{
+		{Dir: "", Encoder: Encoder{CRF: 26}},
+		{Dir: "Phone", Encoder: Encoder{CRF: 30}},
+		{Dir: "Phone/Kids", Encoder: Encoder{CRF: 24}},
+	}
+	cameraRules := []CameraRule{
+		{Match: "apple", Encoder: Encoder{CRF: 32}},
+		{Match: "apple iphone 15", Encoder: Encoder{CRF: 22}},
+		{Match: "dji", Encoder: Encoder{CRF: 20, Filter: "scale=-2:1080"}},
+	}
+	tests := []struct {
+		name        string
+		opts        Options
+		file        string
+		probe, tags string
+		want        Encoder
+	}{
+		{"no rules", Options{Encoder: Encoder{CRF: 27}}, "movie.mp4", landscape, `{}`, Encoder{CRF: 27}},
+		{"root dir rule", Options{DirRules: dirRules}, "movie.mp4", landscape, `{}`, Encoder{CRF: 26}},
+		{"deepest dir rule wins", Options{DirRules: dirRules}, "Phone/Kids/movie.mp4", landscape, `{}`, Encoder{CRF: 24}},
+		{"camera rule with the most words wins", Options{DirRules: dirRules, CameraRules: cameraRules}, "Phone/Kids/movie.mp4", landscape, iphone, Encoder{CRF: 22}},
+		{"dir rule without a camera match", Options{DirRules: dirRules, CameraRules: cameraRules}, "Phone/movie.mp4", landscape, `{}`, Encoder{CRF: 30}},
+		{"portrait turns scale filters", Options{CameraRules: cameraRules}, "movie.mp4", portrait, dji, Encoder{CRF: 20, Filter: "scale='min(1080,iw)':-2"}},
+		{"portrait profile", Options{DirRules: dirRules, PortraitEncoder: &Encoder{CRF: 31}}, "Phone/movie.mp4", portrait, `{}`, Encoder{CRF: 31}},
+		{"camera rule wins over portrait profile", Options{CameraRules: cameraRules, PortraitEncoder: &Encoder{CRF: 31}}, "movie.mp4", portrait, iphone, Encoder{CRF: 22}},
+		{"slow motion profile", Options{SlowMotionEncoder: &Encoder{CRF: 25}}, "movie.mp4", slowMotion, `{}`, Encoder{CRF: 25}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runner := probeRunner(test.probe, test.tags, "")
+			test.opts.Encoder.Runner = runner
+			p := New(test.opts)
+			fileName := filepath.Join(t.TempDir(), filepath.FromSlash(test.file))
+
+			got := p.encoderFor(context.Background(), fileName, test.file)
+			got.Runner = nil
+			if got.Settings().CRF != test.want.Settings().CRF || got.Filter != test.want.Filter {
+				t.Errorf("encoderFor() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package shrink
+
+import (
+	"context"
+	"io"
+	"os/exec"
+)
+
+// Runner runs external programs like ffmpeg and ffprobe, replace it to test without them
+type Runner interface {
+	// Run runs a program to completion, killing it if the context is cancelled.
+	// stdout and stderr may be nil to discard the output.
+	Run(ctx context.Context, name string, args []string, stdout, stderr io.Writer) error
+}
+
+// ExecRunner runs programs with os/exec
+type ExecRunner struct {
+	// FFmpeg and FFprobe are the paths to run ffmpeg and ffprobe from, they are looked up in the PATH when empty
+	FFmpeg, FFprobe string
+	// Magick is the path to run ImageMagick from, looked up in the PATH when empty
+	Magick string
+	// Untrunc is the path to run untrunc from, looked up in the PATH when empty
+	Untrunc string
+	// Limits caps the memory and cpu of each program
+	Limits Limits
+}
+
+// Run runs a program with os/exec
+func (r ExecRunner) Run(ctx context.Context, name string, args []string, stdout, stderr io.Writer) error {
+	switch {
+	case name == "ffmpeg" && len(r.FFmpeg) > 0:
+		name = r.FFmpeg
+	case name == "ffprobe" && len(r.FFprobe) > 0:
+		name = r.FFprobe
+	case name == "magick" && len(r.Magick) > 0:
+		name = r.Magick
+	case name == "untrunc" && len(r.Untrunc) > 0:
+		name = r.Untrunc
+	}
+	logCommand(ctx, name, args)
+	cmd := exec.CommandContext(ctx, name, longPathArgs(args)...)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if r.Limits.enabled() {
+		return r.Limits.run(cmd)
+	}
+	return runInGroup(cmd)
+}
+
+// Returns the runner, running programs with os/exec if it is nil
+func runnerOrExec(runner Runner) Runner {
+	if runner == nil {
+		return ExecRunner{}
+	}
+	return runner
+}
@@ -0,0 +1,174 @@
+package shrink
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	filepath "path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// IsMovie returns true is the file is a movie
+func IsMovie(fileName string) bool {
+	fileExt := strings.ToLower(filepath.Ext(fileName))
+	return fileExt == ".mpg" || fileExt == ".mpeg" || fileExt == ".avi" || fileExt == ".mp4" || fileExt == ".3gp" || fileExt == ".mov" ||
+		fileExt == ".dv" || fileExt == ".mts" || fileExt == ".m2ts" || isSphericalOriginal(fileName)
+}
+
+// Scanner finds the movies below a directory, hidden and excluded directories are skipped
+type Scanner struct {
+	// FS is the file system to scan, the real one if nil
+	FS FS
+	// Photos finds photos as well as movies
+	Photos bool
+	// Audio finds audio files as well as movies
+	Audio bool
+	// ExcludeDirs are patterns of dirs that aren't scanned, eg. @eaDir or Backups/**.
+	// Patterns without a slash match dir names anywhere, others match paths relative to the scanned dir.
+	ExcludeDirs []string
+	// Unreadable, when set, is told about the dirs below the scanned dir that can't be read, eg. for lack of permission.
+	// They are skipped either way, only the scanned dir itself failing fails the scan.
+	Unreadable func(dirName string, err error)
+	// Skip, when set, is asked about every dir below the scanned dir that isn't hidden or excluded, true leaves it out
+	Skip func(dirName string) bool
+	// MarkerFiles are names of files that leave the dir they are in and all dirs below it out, eg. DefaultMarkerFiles
+	MarkerFiles []string
+}
+
+// DefaultMarkerFiles are the .nomedia files Android apps keep their media out of galleries with,
+// and .noshrink to protect a dir from being shrunk
+var DefaultMarkerFiles = []string{".nomedia", ".noshrink"}
+
+// ValidateExcludeDirs returns an error for the first malformed exclude pattern
+func ValidateExcludeDirs(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Returns true if a dir isn't scanned, relDir is its path relative to the scanned dir using forward slashes
+func (s Scanner) skipDir(dirName, relDir string) bool {
+	name := path.Base(relDir)
+	if name[0] == '.' {
+		return true
+	}
+	for _, pattern := range s.ExcludeDirs {
+		pattern = strings.TrimSuffix(pattern, "/")
+		target := relDir
+		if !strings.Contains(pattern, "/") || strings.HasPrefix(pattern, "**/") {
+			target = name
+		}
+		pattern = strings.TrimPrefix(strings.TrimSuffix(pattern, "/**"), "**/")
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	if marker := s.marker(dirName); len(marker) > 0 {
+		log.Info("Skipping dir: ", dirName, " protected by ", marker)
+		return true
+	}
+	return s.Skip != nil && s.Skip(dirName)
+}
+
+// Returns the name of the first marker file in a dir, empty if it has none
+func (s Scanner) marker(dirName string) string {
+	for _, name := range s.MarkerFiles {
+		if _, err := fsOrOS(s.FS).Stat(filepath.Join(dirName, name)); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// Returns the first dir holding a marker file from a dir up to the root of the file system, empty if there is none
+func (s Scanner) markedDir(dirName string) string {
+	if len(s.MarkerFiles) == 0 {
+		return ""
+	}
+	dirName, _ = filepath.Abs(dirName)
+	for {
+		if len(s.marker(dirName)) > 0 {
+			return dirName
+		}
+		parent := filepath.Dir(dirName)
+		if parent == dirName {
+			return ""
+		}
+		dirName = parent
+	}
+}
+
+// Protected returns true if a marker file protects a file, being in its dir or any dir above it
+func (s Scanner) Protected(fileName string) bool {
+	return len(s.markedDir(filepath.Dir(fileName))) > 0
+}
+
+// Wanted returns true if the scanner finds the file
+func (s Scanner) Wanted(fileName string) bool {
+	return IsMovie(fileName) || s.Photos && IsPhoto(fileName) && !isPreview(fileName) || s.Audio && IsAudio(fileName)
+}
+
+// Scan returns all movies below the dir, stopping early if the context is cancelled
+func (s Scanner) Scan(ctx context.Context, dirName string) ([]string, error) {
+	if marked := s.markedDir(dirName); len(marked) > 0 {
+		log.Info("Skipping dir: ", dirName, " protected by a marker file in ", marked)
+		return nil, nil
+	}
+	var fileList []string
+	err := s.addFilesToList(ctx, dirName, "", &fileList)
+	return fileList, err
+}
+
+// Gets all files in directory, relDir is its path relative to the scanned dir
+func (s Scanner) addFilesToList(ctx context.Context, inDirName, relDir string, fileList *[]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	files, err := fsOrOS(s.FS).ReadDir(inDirName)
+	if err != nil && len(relDir) > 0 && ctx.Err() == nil {
+		log.Error("Could not read: ", inDirName, " ", err)
+		if s.Unreadable != nil {
+			s.Unreadable(inDirName, err)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() {
+			dirName := path.Join(relDir, f.Name())
+			if s.skipDir(filepath.Join(inDirName, f.Name()), dirName) {
+				continue
+			}
+			if err := s.addFilesToList(ctx, filepath.Join(inDirName, f.Name()), dirName, fileList); err != nil {
+				return err
+			}
+		} else {
+			if s.Wanted(f.Name()) {
+				fileName := filepath.Join(inDirName, f.Name())
+				*fileList = append(*fileList, fileName)
+			}
+		}
+	}
+	return nil
+}
+
+// ReadFileList reads a list of files, one per line like the output of find, skipping empty lines
+func ReadFileList(r io.Reader) ([]string, error) {
+	var fileList []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if fileName := strings.TrimSpace(scanner.Text()); len(fileName) > 0 {
+			fileList = append(fileList, fileName)
+		}
+	}
+	return fileList, scanner.Err()
+}
@@ -0,0 +1,110 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	filepath "path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// screenNamePattern matches the names screen recorders give, eg. "Screen Recording 2024-05-13 at 18.16.56.mov",
+// Screenrecorder-2024-05-13-18-16-56.mp4 on Samsungs and RPReplay_Final1715617016.mp4 on iPhones
+var screenNamePattern = regexp.MustCompile(`(?i)^(screen[ _-]?(recording|record|cast|capture)|screenrecorder|screencast|rpreplay_)`)
+
+// screenEncoderHints are words in the encoder and handler tags of screen recorders, lower case
+var screenEncoderHints = []string{"screencapturekit", "replaykit", "screen", "obs"}
+
+// filmTags are container tags of films and shows, lower case, which are never screen recordings however wide they are
+var filmTags = []string{"title", "genre", "director", "artist", "copyright", "synopsis", "imdb_id", "tmdb_id"}
+
+// stillFraction is the fraction of frames mpdecimate keeps below which a movie is near-static, camera noise
+// keeps almost every frame of a real recording different
+const stillFraction = 0.3
+
+// ScreenRecording guesses if a movie is a screen capture rather than shot with a camera, from its name, its metadata,
+// or a screen-like aspect ratio together with near-static content. Movies with camera or film tags never are.
+// still is true if most frames repeat the previous one.
+func (e Encoder) ScreenRecording(ctx context.Context, fileName string) (screen, still bool) {
+	if screenNamePattern.MatchString(filepath.Base(fileName)) {
+		return true, e.stillContent(ctx, fileName)
+	}
+	runner := runnerOrExec(e.Runner)
+	if tags, err := probeTags(ctx, runner, fileName); err == nil {
+		if len(camera(tags)) > 0 || filmTagged(tags) {
+			return false, false
+		}
+		hints := strings.ToLower(tags.Get("encoder") + " " + tags.Get("handler_name") + " " + tags.Get("com.apple.quicktime.software"))
+		for _, hint := range screenEncoderHints {
+			if strings.Contains(hints, hint) {
+				return true, e.stillContent(ctx, fileName)
+			}
+		}
+	}
+	// films are wide too, only a screen that is mostly read is taken for a recording
+	if info, err := e.Probe(ctx, fileName); err == nil && screenResolution(info.Width, info.Height) && e.stillContent(ctx, fileName) {
+		return true, true
+	}
+	return false, false
+}
+
+// Returns true if the container of a movie has any of the tags of films and shows, matroska ones are upper case
+func filmTagged(tags metadataTags) bool {
+	if len(tags) == 0 {
+		return false
+	}
+	for tag, value := range tags[0] {
+		if len(strings.TrimSpace(value)) > 0 && slices.Contains(filmTags, strings.ToLower(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true for the aspect ratios of screens cameras don't record in, 16:10 of laptops and the tall screens of phones
+func screenResolution(width, height int) bool {
+	if width == 0 || height == 0 {
+		return false
+	}
+	if width < height {
+		width, height = height, width
+	}
+	ratio := float64(width) / float64(height)
+	return (ratio > 1.59 && ratio < 1.61) || ratio > 2.0
+}
+
+// Returns true if most frames of the first 20 seconds of a movie repeat the previous one, like a screen that is read
+func (e Encoder) stillContent(ctx context.Context, fileName string) bool {
+	var stderr bytes.Buffer
+	args := []string{"-v", "debug", "-nostats", "-t", "20", "-i", fileName, "-an", "-vf", "scale=320:-2,mpdecimate", "-f", "null", "-"}
+	if err := runnerOrExec(e.Runner).Run(ctx, "ffmpeg", args, nil, &stderr); err != nil {
+		log.Debug("Could not check for still content: ", fileName, " ", err)
+		return false
+	}
+	// mpdecimate logs keep, drop or DROP for every frame at debug level
+	kept := bytes.Count(stderr.Bytes(), []byte("] keep pts:"))
+	dropped := bytes.Count(stderr.Bytes(), []byte("] drop pts:")) + bytes.Count(stderr.Bytes(), []byte("] DROP pts:"))
+	if kept+dropped == 0 {
+		return false
+	}
+	return float64(kept)/float64(kept+dropped) < stillFraction
+}
+
+// ScreenEncoder returns the built-in profile for screen recordings based on an encoder: tuned for flat, synthetic
+// content, or for slides if the recording is near-static, and at most 30 frames per second
+func ScreenEncoder(encoder Encoder, still bool) Encoder {
+	switch encoder.Settings().Codec {
+	case "libx264":
+		encoder.Tune = "animation"
+		if still {
+			encoder.Tune = "stillimage"
+		}
+	case "libx265":
+		encoder.Tune = "animation"
+	}
+	encoder.MaxFPS = 30
+	return encoder
+}
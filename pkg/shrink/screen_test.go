@@ -0,0 +1,93 @@
+package shrink
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// mpdecimate logs of the first frames of a movie whose frames mostly repeat and one whose frames all change
+var (
+	stillFrames  = strings.Repeat("[Parsed_mpdecimate_1 @ 0x1] keep pts:1\n", 1) + strings.Repeat("[Parsed_mpdecimate_1 @ 0x1] drop pts:2\n", 9)
+	movingFrames = strings.Repeat("[Parsed_mpdecimate_1 @ 0x1] keep pts:1\n", 9) + strings.Repeat("[Parsed_mpdecimate_1 @ 0x1] drop pts:2\n", 1)
+)
+
+func TestScreenRecording(t *testing.T) {
+	laptop := `{"streams": [{"codec_name": "h264", "width": 1920, "height": 1200}]}`
+	widescreen := `{"streams": [{"codec_name": "h264", "width": 1920, "height": 1080}]}`
+	cinemascope := `{"streams": [{"codec_name": "h264", "width": 1920, "height": 800}]}`
+	tests := []struct {
+		name        string
+		file        string
+		probe, tags string
+		frames      string
+		screen      bool
+		still       bool
+	}{
+		{"named by the recorder", "Screen Recording 2024-05-13 at 18.16.56.mov", widescreen, `{}`, movingFrames, true, false},
+		{"iphone recording", "RPReplay_Final1715617016.mp4", widescreen, `{}`, stillFrames, true, true},
+		{"encoder tag", "clip.mp4", widescreen, `{"streams": [{"tags": {"handler_name": "ScreenCaptureKit"}}]}`, movingFrames, true, false},
+		{"screen aspect ratio with still content", "clip.mp4", laptop, `{}`, stillFrames, true, true},
+		{"screen aspect ratio alone", "clip.mp4", laptop, `{}`, movingFrames, false, false},
+		{"still content alone", "clip.mp4", widescreen, `{}`, stillFrames, false, false},
+		{"camera tags", "clip.mp4", laptop, `{"format": {"tags": {"make": "Canon", "model": "EOS R5"}}}`, stillFrames, false, false},
+		{"film tags", "film.mkv", cinemascope, `{"format": {"tags": {"TITLE": "Lawrence of Arabia"}}}`, stillFrames, false, false},
+		{"film without tags", "film.mkv", cinemascope, `{}`, movingFrames, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoder := Encoder{Runner: probeRunner(test.probe, test.tags, test.frames)}
+			screen, still := encoder.ScreenRecording(context.Background(), test.file)
+			if screen != test.screen || still != test.still {
+				t.Errorf("ScreenRecording() = %v, %v, want %v, %v", screen, still, test.screen, test.still)
+			}
+		})
+	}
+}
+
+func TestScreenResolution(t *testing.T) {
+	tests := []struct {
+		width, height int
+		want          bool
+	}{
+		{1920, 1200, true},
+		{1200, 1920, true},
+		{1170, 2532, true},
+		{1920, 1080, false},
+		{1440, 1080, false},
+		{0, 0, false},
+	}
+	for _, test := range tests {
+		if got := screenResolution(test.width, test.height); got != test.want {
+			t.Errorf("screenResolution(%d, %d) = %v, want %v", test.width, test.height, got, test.want)
+		}
+	}
+}
+
+func TestTimeLapse(t *testing.T) {
+	widescreen := `{"streams": [{"codec_name": "h264", "width": 1920, "height": 1080, "avg_frame_rate": "30/1"}]}`
+	tests := []struct {
+		name     string
+		file     string
+		tags     string
+		frames   string
+		byMotion bool
+		want     bool
+	}{
+		{"named", "Timelapse_20240513.mp4", `{}`, movingFrames, false, true},
+		{"capture mode tag", "GX010042.MP4", `{"streams": [{"tags": {"handler_name": "GoPro TimeLapse"}}]}`, movingFrames, false, true},
+		{"android capture frame rate", "VID_20240513.mp4", `{"format": {"tags": {"com.android.capture.fps": "1.000000"}}}`, movingFrames, false, true},
+		{"android real time", "VID_20240513.mp4", `{"format": {"tags": {"com.android.capture.fps": "30.000000"}}}`, movingFrames, false, false},
+		{"static clip", "VID_20240513.mp4", `{}`, stillFrames, false, false},
+		{"low motion with byMotion", "VID_20240513.mp4", `{}`, stillFrames, true, true},
+		{"moving with byMotion", "VID_20240513.mp4", `{}`, movingFrames, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoder := Encoder{Runner: probeRunner(widescreen, test.tags, test.frames)}
+			if got := encoder.TimeLapse(context.Background(), test.file, test.byMotion); got != test.want {
+				t.Errorf("TimeLapse() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package shrink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	filepath "path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults of Segments
+const (
+	DefaultSegmentLength      = 10 * time.Minute
+	DefaultSegmentMinDuration = time.Hour
+)
+
+// Files in the work dir of a segmented encode: the journal of finished segments, the list ffmpeg writes
+// as it finishes them and the list of segments to concatenate
+const (
+	segmentJournalFile = "journal.json"
+	segmentListFile    = "segments.csv"
+	segmentConcatFile  = "concat.txt"
+)
+
+// Segments encodes long movies in chunks kept in a work dir, so an interrupted encode resumes from the last finished
+// chunk instead of starting over. The chunks are joined into the encode once they are all done.
+type Segments struct {
+	// Dir is where the chunks are kept between runs, a dir per movie, the temp dir if empty, which runs don't share
+	Dir string
+	// Length is how long a chunk is, DefaultSegmentLength if zero
+	Length time.Duration
+	// MinDuration is how long movies have to be to be encoded in chunks, DefaultSegmentMinDuration if zero
+	MinDuration time.Duration
+	// Workers, when more than one, splits movies at keyframes and encodes this many chunks at once,
+	// for machines with more cores than a single encode keeps busy
+	Workers int
+}
+
+// segmentJournal records the finished chunks of a movie and what they were encoded from and with,
+// chunks of a movie that changed or of other settings are thrown away
+type segmentJournal struct {
+	Source   string         `json:"source"`
+	Size     int64          `json:"size"`
+	ModTime  time.Time      `json:"modTime"`
+	Settings EncodeSettings `json:"settings"`
+	Length   time.Duration  `json:"length"`
+	Parallel bool           `json:"parallel,omitempty"`
+	// Pieces are the pieces a movie was split into at keyframes to encode them in parallel
+	Pieces   []journalSegment `json:"pieces,omitempty"`
+	Segments []journalSegment `json:"segments"`
+}
+
+// journalSegment is a finished chunk, its start and end are in seconds into the movie
+type journalSegment struct {
+	File  string  `json:"file"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// Returns the length of the chunks and how long movies have to be, with the defaults filled in
+func (s Segments) lengths() (time.Duration, time.Duration) {
+	length, minDuration := s.Length, s.MinDuration
+	if length <= 0 {
+		length = DefaultSegmentLength
+	}
+	if minDuration <= 0 {
+		minDuration = DefaultSegmentMinDuration
+	}
+	return length, minDuration
+}
+
+// Returns the work dir of a movie below Dir or the temp dir, named after a hash of its path
+func (s Segments) workDir(sourceFile, tmpDir string) string {
+	dir := s.Dir
+	if len(dir) == 0 {
+		dir = tmpDir
+	}
+	absName, _ := filepath.Abs(sourceFile)
+	sum := sha256.Sum256([]byte(absName))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8]))
+}
+
+// Encodes a movie with the encoder, in chunks if it is long enough. sourceFile is the original, whose size and
+// mod time tell if the chunks of an earlier run still belong to it, inputFile is what is encoded.
+func (p *Processor) encodeSegments(ctx context.Context, encoder Encoder, sourceFile, inputFile, destFile string, progress func(float64)) error {
+	length, minDuration := p.Segments.lengths()
+	duration := probeDuration(ctx, runnerOrExec(encoder.Runner), inputFile)
+	if duration < minDuration {
+		return encoder.Encode(ctx, inputFile, destFile, progress)
+	}
+	stat, err := p.FS.Stat(sourceFile)
+	if err != nil {
+		return err
+	}
+
+	workDir := p.Segments.workDir(sourceFile, p.TmpDir)
+	parallel := p.Segments.Workers > 1
+	journal := segmentJournal{Source: sourceFile, Size: stat.Size(), ModTime: stat.ModTime(), Settings: encoder.Settings(), Length: length, Parallel: parallel}
+	if earlier, ok := p.readJournal(workDir); ok && earlier.Size == journal.Size && earlier.ModTime.Equal(journal.ModTime) &&
+		earlier.Length == length && earlier.Parallel == parallel && reflect.DeepEqual(earlier.Settings, journal.Settings) {
+		journal.Pieces, journal.Segments = earlier.Pieces, earlier.Segments
+	} else if err := p.FS.RemoveAll(workDir); err != nil {
+		return err
+	}
+	if err := p.FS.MkdirAll(workDir, 0755); err != nil {
+		return err
+	}
+	if parallel {
+		err = p.encodeParallel(ctx, encoder, workDir, inputFile, destFile, &journal, duration, progress)
+	} else {
+		err = p.encodeSequential(ctx, encoder, workDir, inputFile, destFile, &journal, duration, progress)
+	}
+	if err != nil {
+		return err
+	}
+	if err := p.FS.RemoveAll(workDir); err != nil {
+		log.Error("Could not remove segments: ", workDir, err)
+	}
+	return nil
+}
+
+// Encodes a movie in one go, cutting the encode into chunks, and resumes after the last finished chunk.
+// The chunks are joined with all their streams.
+func (p *Processor) encodeSequential(ctx context.Context, encoder Encoder, workDir, inputFile, destFile string, journal *segmentJournal,
+	duration time.Duration, progress func(float64)) error {
+	// the chunks an interrupted run finished are in its list, a chunk it didn't finish is thrown away
+	p.addFinishedSegments(workDir, journal)
+	if err := p.clearUnfinishedSegments(workDir, *journal); err != nil {
+		return err
+	}
+
+	start := 0.0
+	if n := len(journal.Segments); n > 0 {
+		start = journal.Segments[n-1].End
+		log.Info("Resuming encode at ", time.Duration(start*float64(time.Second)).Round(time.Second), " of: ", journal.Source)
+	}
+	// the last chunk can end a little before the duration ffprobe reports
+	if start < duration.Seconds()-1 {
+		audioMaps, audioCopies := encoder.audioStreams(ctx, inputFile)
+		seconds := strconv.FormatFloat(journal.Length.Seconds(), 'f', -1, 64)
+		args := []string{"-y", "-v", "error", "-ss", fmt.Sprintf("%.3f", start), "-i", inputFile, "-strict", "unofficial"}
+		args = append(args, encoder.codecArgs(destFile, audioMaps, audioCopies)...)
+		// keyframes at the cuts make every chunk as long as the others, so chunks of later runs line up
+		args = append(args, "-force_key_frames", "expr:gte(t,n_forced*"+seconds+")", "-map_metadata", "0",
+			"-f", "segment", "-segment_time", seconds, "-segment_format", "mp4", "-reset_timestamps", "1",
+			"-segment_start_number", strconv.Itoa(len(journal.Segments)),
+			"-segment_list", filepath.Join(workDir, segmentListFile), "-segment_list_type", "csv",
+			filepath.Join(workDir, "segment_%05d.mp4"))
+		var chunkProgress func(float64)
+		if progress != nil {
+			done := start / duration.Seconds()
+			chunkProgress = func(fraction float64) {
+				progress(done + fraction)
+			}
+		}
+		err := encoder.run(ctx, inputFile, args, chunkProgress)
+		p.addFinishedSegments(workDir, journal)
+		if err != nil {
+			return err
+		}
+	}
+
+	concatFile, err := p.writeConcatList(workDir, journal.Segments)
+	if err != nil {
+		return err
+	}
+	args := []string{"-y", "-v", "error", "-f", "concat", "-safe", "0", "-i", concatFile, "-i", inputFile,
+		"-map", "0", "-map_metadata", "1", "-c", "copy", "-movflags", "+faststart+use_metadata_tags", "-strict", "unofficial", destFile}
+	return runnerOrExec(encoder.Runner).Run(ctx, "ffmpeg", args, nil, nil)
+}
+
+// Splits the video of a movie into pieces at keyframes and encodes them on several workers at once, resuming
+// with the pieces that aren't encoded yet. The encoded video is joined and the audio encoded from the movie in one go,
+// so there are no gaps in the sound where the pieces meet.
+func (p *Processor) encodeParallel(ctx context.Context, encoder Encoder, workDir, inputFile, destFile string, journal *segmentJournal,
+	duration time.Duration, progress func(float64)) error {
+	runner := runnerOrExec(encoder.Runner)
+	if err := p.clearUnfinishedSegments(workDir, *journal); err != nil {
+		return err
+	}
+	if len(journal.Pieces) == 0 {
+		listFile := filepath.Join(workDir, segmentListFile)
+		seconds := strconv.FormatFloat(journal.Length.Seconds(), 'f', -1, 64)
+		// copying the stream can only cut at keyframes, so the pieces are about as long as the chunk length
+		args := []string{"-y", "-v", "error", "-i", inputFile, "-map", "0:V:0", "-c", "copy", "-f", "segment", "-segment_time", seconds,
+			"-reset_timestamps", "1", "-segment_list", listFile, "-segment_list_type", "csv", filepath.Join(workDir, "piece_%05d.mkv")}
+		if err := runner.Run(ctx, "ffmpeg", args, nil, nil); err != nil {
+			return fmt.Errorf("could not split: %v", err)
+		}
+		journal.Pieces = p.readSegmentList(listFile, 0)
+		if len(journal.Pieces) == 0 {
+			return fmt.Errorf("no pieces split from: %s", inputFile)
+		}
+		p.saveJournal(workDir, *journal)
+	}
+
+	var finished float64
+	encoded := map[string]bool{}
+	for _, segment := range journal.Segments {
+		encoded[segment.File] = true
+		finished += segment.End - segment.Start
+	}
+	var pieces []journalSegment
+	for _, piece := range journal.Pieces {
+		if !encoded[chunkName(piece.File)] {
+			pieces = append(pieces, piece)
+		}
+	}
+	if len(pieces) < len(journal.Pieces) {
+		log.Info("Resuming encode with ", len(pieces), " of ", len(journal.Pieces), " pieces left: ", journal.Source)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var firstErr error
+	// how far the pieces being encoded are, in seconds
+	encoding := map[string]float64{}
+	queue := make(chan journalSegment)
+	var wg sync.WaitGroup
+	for i := 0; i < p.Segments.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for piece := range queue {
+				pieceFile := filepath.Join(workDir, piece.File)
+				args := append([]string{"-y", "-v", "error", "-i", pieceFile, "-map", "0:v:0"}, encoder.videoArgs()...)
+				args = append(args, "-an", "-strict", "unofficial", filepath.Join(workDir, chunkName(piece.File)))
+				var pieceProgress func(float64)
+				if progress != nil {
+					pieceProgress = func(fraction float64) {
+						mu.Lock()
+						encoding[piece.File] = fraction * (piece.End - piece.Start)
+						done := finished
+						for _, seconds := range encoding {
+							done += seconds
+						}
+						mu.Unlock()
+						progress(done / duration.Seconds())
+					}
+				}
+				err := encoder.run(ctx, pieceFile, args, pieceProgress)
+
+				mu.Lock()
+				delete(encoding, piece.File)
+				if err == nil {
+					journal.Segments = append(journal.Segments, journalSegment{File: chunkName(piece.File), Start: piece.Start, End: piece.End})
+					finished += piece.End - piece.Start
+					p.saveJournal(workDir, *journal)
+					p.FS.Remove(pieceFile)
+				} else if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, piece := range pieces {
+		select {
+		case queue <- piece:
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+	close(queue)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	sort.Slice(journal.Segments, func(i, j int) bool { return journal.Segments[i].Start < journal.Segments[j].Start })
+	concatFile, err := p.writeConcatList(workDir, journal.Segments)
+	if err != nil {
+		return err
+	}
+	audioMaps, audioCopies := encoder.audioStreams(ctx, inputFile)
+	args := []string{"-y", "-v", "error", "-f", "concat", "-safe", "0", "-i", concatFile, "-i", inputFile, "-map", "0:v"}
+	if audioMaps == nil {
+		args = append(args, "-map", "1:a?")
+	}
+	for _, arg := range audioMaps {
+		// the audio comes from the second input
+		if strings.HasPrefix(arg, "0:") {
+			arg = "1:" + strings.TrimPrefix(arg, "0:")
+		}
+		args = append(args, arg)
+	}
+	args = append(args, "-c:v", "copy", "-strict", "unofficial")
+	args = append(args, audioArgs(audioCopies, encoder.Settings().AudioBitrate)...)
+	args = append(args, "-map_metadata", "1", "-movflags", "+faststart+use_metadata_tags", destFile)
+	return runner.Run(ctx, "ffmpeg", args, nil, nil)
+}
+
+// Returns the name of the chunk a piece is encoded into, eg. segment_00003.mp4 for piece_00003.mkv
+func chunkName(pieceName string) string {
+	return "segment_" + strings.TrimSuffix(strings.TrimPrefix(pieceName, "piece_"), filepath.Ext(pieceName)) + ".mp4"
+}
+
+// Writes the list of chunks the concat demuxer joins, returning its name
+func (p *Processor) writeConcatList(workDir string, segments []journalSegment) (string, error) {
+	if len(segments) == 0 {
+		return "", fmt.Errorf("no segments encoded in: %s", workDir)
+	}
+	var list strings.Builder
+	for _, segment := range segments {
+		list.WriteString("file '" + strings.Replace(segment.File, "'", `'\''`, -1) + "'\n")
+	}
+	concatFile := filepath.Join(workDir, segmentConcatFile)
+	return concatFile, writeFile(p.FS, concatFile, []byte(list.String()))
+}
+
+// Reads the journal of a work dir, false if there is none
+func (p *Processor) readJournal(workDir string) (segmentJournal, bool) {
+	var journal segmentJournal
+	in, err := p.FS.Open(filepath.Join(workDir, segmentJournalFile))
+	if err != nil {
+		return journal, false
+	}
+	data, err := ioutil.ReadAll(in)
+	in.Close()
+	return journal, err == nil && json.Unmarshal(data, &journal) == nil
+}
+
+// Moves the chunks ffmpeg listed as finished into the journal and saves it. The times in the list are
+// from where ffmpeg started, which is where the journal ended.
+func (p *Processor) addFinishedSegments(workDir string, journal *segmentJournal) {
+	offset := 0.0
+	if n := len(journal.Segments); n > 0 {
+		offset = journal.Segments[n-1].End
+	}
+	journal.Segments = append(journal.Segments, p.readSegmentList(filepath.Join(workDir, segmentListFile), offset)...)
+	p.saveJournal(workDir, *journal)
+}
+
+// Reads and removes the csv list of the segments ffmpeg finished, adding offset to their times
+func (p *Processor) readSegmentList(listFile string, offset float64) []journalSegment {
+	in, err := p.FS.Open(listFile)
+	if err != nil {
+		return nil
+	}
+	records, _ := csv.NewReader(in).ReadAll()
+	in.Close()
+	p.FS.Remove(listFile)
+	var segments []journalSegment
+	for _, record := range records {
+		if len(record) < 3 {
+			continue
+		}
+		start, startErr := strconv.ParseFloat(record[1], 64)
+		end, endErr := strconv.ParseFloat(record[2], 64)
+		if startErr != nil || endErr != nil {
+			continue
+		}
+		segments = append(segments, journalSegment{File: filepath.Base(record[0]), Start: offset + start, End: offset + end})
+	}
+	return segments
+}
+
+// Saves the journal in its work dir
+func (p *Processor) saveJournal(workDir string, journal segmentJournal) {
+	data, err := json.MarshalIndent(journal, "", "  ")
+	if err == nil {
+		err = writeFile(p.FS, filepath.Join(workDir, segmentJournalFile), append(data, '\n'))
+	}
+	if err != nil {
+		log.Error("Could not save segment journal: ", workDir, err)
+	}
+}
+
+// Removes the chunks and pieces in a work dir that aren't in the journal, which ffmpeg was writing when it was interrupted
+func (p *Processor) clearUnfinishedSegments(workDir string, journal segmentJournal) error {
+	finished := map[string]bool{}
+	for _, piece := range journal.Pieces {
+		finished[piece.File] = true
+	}
+	for _, segment := range journal.Segments {
+		finished[segment.File] = true
+	}
+	entries, err := p.FS.ReadDir(workDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if (strings.HasPrefix(entry.Name(), "segment_") || strings.HasPrefix(entry.Name(), "piece_")) && !finished[entry.Name()] {
+			if err := p.FS.Remove(filepath.Join(workDir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,806 @@
+// Package shrink re-encodes movies with ffmpeg and replaces the originals when that saves enough space,
+// keeping the capture date in the file name and mod time.
+//
+// The simplest use is
+//
+//	report, err := shrink.Process(ctx, shrink.Options{InDir: "/srv/camera", TmpDir: os.TempDir()})
+package shrink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	filepath "path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Uploader pushes a finished file to a remote destination.
+// relName is the path of the file relative to the input root, using forward slashes.
+// captureTime is what to date the copy with, by Options.Times when shrunk files are uploaded, zero to leave it alone.
+type Uploader interface {
+	Upload(ctx context.Context, fileName, relName string, captureTime time.Time) error
+}
+
+// Refresher is told about directories whose files were replaced, eg. to rescan a media server library
+type Refresher interface {
+	Refresh(dirName string) error
+}
+
+// Options holds the settings for processing a set of files
+type Options struct {
+	// InDir is the root of the movies, results keep their path relative to it
+	InDir string
+	// MoreDirs are further roots processed along with InDir, sharing the report and state
+	MoreDirs []string
+	// Files, when set, are processed instead of the movies below the input dirs, which are then only used for naming results
+	Files []string
+	// TmpDir is where encodes are written before they are swapped in
+	TmpDir string
+
+	// FS is the file system the movies are on, the real one if nil
+	FS      FS
+	Scanner Scanner
+	Encoder Encoder
+	// DirRules use other encoder settings for some dirs below the input root
+	DirRules []DirRule
+	// DirConfig, when set, reads the DirConfigFile of a dir given the encoder of the dir above it,
+	// its settings win over those of the files and rules of the dirs above
+	DirConfig func(fileName string, parent Encoder) (DirOverride, error)
+	// Photos, when set, recompresses photos below the input root as well as movies
+	Photos *PhotoEncoder
+	// Audio, when set, compresses audio files below the input root as well as movies
+	Audio *AudioEncoder
+	// CameraRules use other encoder settings for movies from some cameras, they win over DirRules
+	CameraRules []CameraRule
+	// TmpQuota, when set, delays encodes while the temp dir is too full
+	TmpQuota *TmpQuota
+	// QualityGate, when set, keeps originals whose shrunk version is of too low quality
+	QualityGate *QualityGate
+	// Swapper decides when to replace originals, a zero MaxRatio uses DefaultMaxRatio
+	Swapper Swapper
+
+	// Uploaders get a copy of every finished file
+	Uploaders []Uploader
+	// Mirrors get a copy of every finished file too, with the outcome of each kept in the file's result
+	Mirrors []Mirror
+	// Report collects the results, one is created if it is nil
+	Report *Report
+	// State, when set, is used to skip files that were processed by an earlier run
+	State *State
+	// Refresher, when set, is told about dirs whose files were replaced
+	Refresher Refresher
+	// Archiver, when set, keeps a copy of every original before it is replaced
+	Archiver *Archiver
+	// Pause, when set, is called before each file and blocks while processing is paused
+	Pause func()
+	// Progress, when set, is called with the fraction of the current file encoded so far
+	Progress func(name string, fraction float64)
+	// Encoding, when set, is called when ffmpeg starts encoding a file and when it is done, so time spent waiting,
+	// uploading or checking quality can be told apart from a stalled encode
+	Encoding func(name string, encoding bool)
+	// Before, when set, is called before a file is encoded. Returning ErrSkip, wrapped or not, skips the file
+	// and any other error fails it.
+	Before func(ctx context.Context, fileName, name string) error
+	// After, when set, is called with the result of every file, including failures
+	After func(ctx context.Context, result FileResult, name string)
+	// ScreenRecordings, when set, encodes movies that look like screen captures with ScreenEncoder,
+	// or the built-in screen profile if that is nil
+	ScreenRecordings bool
+	ScreenEncoder    *Encoder
+	// TimeLapses, when set, encodes movies that look like time-lapses with TimeLapseEncoder,
+	// or the built-in time-lapse profile if that is nil. TimeLapseMotion also takes movies with very low motion
+	// for time-lapses, not only those named or tagged as one.
+	TimeLapses       bool
+	TimeLapseMotion  bool
+	TimeLapseEncoder *Encoder
+	// PortraitEncoder, when set, encodes portrait movies, like vertical phone videos
+	PortraitEncoder *Encoder
+	// SlowMotionEncoder, when set, encodes high frame rate movies, which always keep their frame rate
+	SlowMotionEncoder *Encoder
+	// Audit, when set, writes a sidecar next to each shrunk file recording the original, the encode settings
+	// and the provenance of the encode
+	Audit bool
+	// DetectTypes, when set, tells movies, photos and audio apart by what ffprobe finds in them rather than their
+	// extension, so mislabeled files are handled as what they are and those that can't be shrunk are skipped
+	DetectTypes bool
+	// Blank, when set, flags movies that are black all along in their results, and moves them into its review dir if it has one
+	Blank *BlankClips
+	// Repair, when set, salvages damaged movies before encoding them and quarantines those it can't
+	Repair *Repairer
+	// Remux, when set, rewraps movies into this container, mp4 or mkv, instead of encoding them
+	Remux string
+	// CRFRetry, when set, encodes movies that didn't shrink enough again at higher CRFs
+	CRFRetry *CRFRetry
+	// Segments, when set, encodes long movies in chunks, so an interrupted encode resumes where it stopped
+	Segments *Segments
+	// RejectedDir, when set, keeps the encodes that didn't shrink enough below this dir, with the dirs and names of their
+	// originals and a note of their ratio. Later runs reuse them instead of encoding the same file with the same settings again.
+	RejectedDir string
+	// Checksums, when set, writes the sha256 of each shrunk file, ChecksumSidecar or ChecksumManifest
+	Checksums string
+	// JoinChapters, when set, joins recordings cameras split into chapters before encoding them as one movie.
+	// Only Process joins them, not Watch.
+	JoinChapters bool
+	// Proxy, when set, saves a small copy of each movie in another tree
+	Proxy *Proxy
+	// Thumbnails, when set, saves a representative frame next to each shrunk movie as eg. 20160513_181656-thumb.jpg
+	Thumbnails bool
+	// Location is the time zone capture dates are named in, and dates in file names are read in, local time if nil
+	Location *time.Location
+	// DatePatterns read capture dates from file names before the DefaultDatePatterns, see ParseDatePattern
+	DatePatterns []*regexp.Regexp
+	// NameTemplate, when set, names shrunk files in the dir of their original, see ParseNameTemplate.
+	// They are named after their capture date otherwise.
+	NameTemplate *template.Template
+	// DirTemplate, when set, picks the dir of shrunk files at the destinations relative to their root, eg. DateDirTemplate,
+	// instead of the dir of the original. See ParseNameTemplate.
+	DirTemplate *template.Template
+	// Namer, when set, picks the name of a shrunk file relative to the input root, empty keeps its name
+	Namer func(ctx context.Context, result FileResult, name string) (string, error)
+	// StopAfter, when set, stops processing cleanly once one of its limits is reached
+	StopAfter *StopAfter
+	// Times is what shrunk files and their copies are dated with, TimesCapture if empty
+	Times string
+	// Collisions is what to do when the name of a shrunk file is taken at a destination that can be listed,
+	// like an output dir or bucket filled by earlier runs or other sources, CollisionRename if empty
+	Collisions string
+}
+
+// Processor processes files with a set of options
+type Processor struct {
+	Options
+	changed map[string]bool
+	// chapters are the later chapters of split recordings by their first chapter
+	chapters map[string][]string
+	stopping chan struct{}
+	stopOnce sync.Once
+	// mu guards the files Process has yet to start on and the way to skip the current file
+	mu    sync.Mutex
+	queue []string
+	skip  context.CancelCauseFunc
+	// decodable are the codecs ffmpeg decodes, read once
+	decodable     map[string]bool
+	decodableOnce sync.Once
+	// ffmpegVersion is the version of ffmpeg for provenance, read once
+	ffmpegVersion     string
+	ffmpegVersionOnce sync.Once
+	// output is where ProcessRemote uploads results to
+	output Uploader
+	// destinations are the names of the files at the destinations, listed once
+	destinations     map[string]bool
+	destinationsOnce sync.Once
+	destinationsMu   sync.Mutex
+	// stopCount counts towards the StopAfter limits, guarded by mu
+	stopCount stopCount
+}
+
+// New creates a processor, filling in defaults for missing options
+func New(opts Options) *Processor {
+	if opts.Swapper.MaxRatio == 0 {
+		opts.Swapper.MaxRatio = DefaultMaxRatio
+	}
+	opts.FS = fsOrOS(opts.FS)
+	if opts.Scanner.FS == nil {
+		opts.Scanner.FS = opts.FS
+	}
+	if opts.Photos != nil {
+		opts.Scanner.Photos = true
+	}
+	if opts.Audio != nil {
+		opts.Scanner.Audio = true
+	}
+	if opts.Swapper.FS == nil {
+		opts.Swapper.FS = opts.FS
+	}
+	if len(opts.Swapper.TmpDir) == 0 {
+		opts.Swapper.TmpDir = opts.TmpDir
+	}
+	if opts.Report == nil {
+		opts.Report = &Report{}
+	}
+	if opts.Scanner.Unreadable == nil {
+		opts.Scanner.Unreadable = opts.Report.AddUnreadable
+	}
+	if opts.Location == nil {
+		opts.Location = time.Local
+	}
+	if opts.Archiver != nil && opts.Archiver.Runner == nil {
+		opts.Archiver.Runner = opts.Encoder.Runner
+	}
+	if len(opts.TmpDir) == 0 {
+		opts.TmpDir = os.TempDir()
+	}
+	// drive relative paths on windows are made absolute once, they change with the current dir
+	opts.InDir = absPath(opts.InDir)
+	opts.MoreDirs = append([]string(nil), opts.MoreDirs...)
+	for i, dirName := range opts.MoreDirs {
+		opts.MoreDirs[i] = absPath(dirName)
+	}
+	opts.Files = append([]string(nil), opts.Files...)
+	for i, fileName := range opts.Files {
+		opts.Files[i] = absPath(fileName)
+	}
+	p := &Processor{Options: opts, stopping: make(chan struct{})}
+	if p.DirConfig != nil && p.Scanner.Skip == nil {
+		p.Scanner.Skip = p.skipByDirConfig
+	}
+	p.startStopTimer()
+	return p
+}
+
+// Process shrinks all movies below opts.InDir and opts.MoreDirs and returns the results
+func Process(ctx context.Context, opts Options) (*Report, error) {
+	p := New(opts)
+	err := p.Process(ctx)
+	return p.Report, err
+}
+
+// Stop makes Process, ProcessRemote and Watch return once the current file is done,
+// unlike cancelling their context which also kills the encode in progress
+func (p *Processor) Stop() {
+	p.stopOnce.Do(func() { close(p.stopping) })
+}
+
+// Skip cancels the file being processed, keeping its original, and moves on to the next one
+func (p *Processor) Skip() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.skip != nil {
+		p.skip(ErrSkipped)
+	}
+}
+
+// ErrSkipped is returned by ProcessFile for a file that was skipped with Skip
+var ErrSkipped = errors.New("skipped")
+
+// ErrSkip is returned by a Before hook to leave a file alone, it is reported as skipped and not failed
+var ErrSkip = errors.New("skipped")
+
+// Queue returns the files Process has yet to start on
+func (p *Processor) Queue() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.queue...)
+}
+
+// Stopping is closed once Stop has been called
+func (p *Processor) Stopping() <-chan struct{} {
+	return p.stopping
+}
+
+// Returns an error once the context is done, waits first if processing is paused
+func (p *Processor) cancelled(ctx context.Context) error {
+	if p.Pause != nil {
+		p.Pause()
+	}
+	select {
+	case <-p.stopping:
+		return errStopped
+	default:
+	}
+	return ctx.Err()
+}
+
+// errStopped ends a loop after Stop, it is never returned to callers
+var errStopped = errors.New("stopped")
+
+// Returns the input roots, InDir first
+func (p *Processor) inDirs() []string {
+	return append([]string{p.InDir}, p.MoreDirs...)
+}
+
+// Returns the name of a file relative to the input root it is in, the deepest one if roots are nested.
+// Listed files outside of the roots are named by their base name.
+func (p *Processor) relName(fileName string) string {
+	root := ""
+	for _, dirName := range p.inDirs() {
+		dirName = filepath.Clean(dirName)
+		if strings.HasPrefix(fileName, dirName+string(filepath.Separator)) && len(dirName) > len(root) {
+			root = dirName
+		}
+	}
+	if len(root) == 0 {
+		root = p.InDir
+	}
+	name := relName(root, fileName)
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return filepath.Base(fileName)
+	}
+	return name
+}
+
+// Process loops through all files in the input dirs and processes them all, stopping early if the context is done
+func (p *Processor) Process(ctx context.Context) error {
+	// Get all files in the directories, one queue for all of them, unless given a list of files
+	var fileList []string
+	if len(p.Files) > 0 {
+		for _, fileName := range p.Files {
+			if !p.Scanner.Wanted(fileName) {
+				log.Info("Skipping file: ", fileName, " not a movie")
+				continue
+			}
+			if p.Scanner.Protected(fileName) {
+				log.Info("Skipping file: ", fileName, " protected by a marker file")
+				continue
+			}
+			fileList = append(fileList, fileName)
+		}
+	} else {
+		for _, dirName := range p.inDirs() {
+			files, err := p.Scanner.Scan(ctx, dirName)
+			if err != nil {
+				return err
+			}
+			fileList = append(fileList, files...)
+		}
+	}
+
+	// Later chapters of split recordings are processed with their first one
+	joined := make(map[string]bool)
+	if p.JoinChapters {
+		p.chapters = p.findChapters(ctx, fileList)
+		for _, chapters := range p.chapters {
+			for _, chapter := range chapters {
+				joined[chapter] = true
+			}
+		}
+	}
+
+	var queue []string
+	for _, fileName := range fileList {
+		if !joined[fileName] {
+			queue = append(queue, fileName)
+		}
+	}
+
+	// Process each file in directory
+	defer p.refreshChanged()
+	defer p.setQueue(nil)
+	for i, fileName := range queue {
+		p.setQueue(queue[i+1:])
+		if err := p.cancelled(ctx); err == errStopped {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		p.ProcessFile(ctx, fileName)
+	}
+	return nil
+}
+
+// Sets the files Process has yet to start on
+func (p *Processor) setQueue(queue []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queue = queue
+}
+
+// Returns the progress callback for a file, nil if progress isn't tracked
+func (p *Processor) progressFor(name string) func(float64) {
+	if p.Progress == nil {
+		return nil
+	}
+	return func(fraction float64) {
+		p.Progress(name, fraction)
+	}
+}
+
+// Reports that a file started encoding, returning the function reporting it is done
+func (p *Processor) encoding(name string) func() {
+	if p.Encoding == nil {
+		return func() {}
+	}
+	p.Encoding(name, true)
+	return func() { p.Encoding(name, false) }
+}
+
+// ProcessFile processes a file below the input dir and uploads the result
+func (p *Processor) ProcessFile(ctx context.Context, fileName string) (FileResult, error) {
+	if p.State.Done(fileName) {
+		log.Debug("Already processed: ", fileName)
+		return FileResult{Source: fileName, Result: fileName}, nil
+	}
+	fileCtx, skip := context.WithCancelCause(ctx)
+	defer skip(nil)
+	p.mu.Lock()
+	p.skip = skip
+	p.mu.Unlock()
+	result, err := p.shrink(fileCtx, fileName, p.relName(fileName))
+	p.mu.Lock()
+	p.skip = nil
+	p.mu.Unlock()
+	if err != nil && ctx.Err() == nil && context.Cause(fileCtx) == ErrSkipped {
+		// skipped files aren't failures, a later run tries them again
+		log.Info("Skipped: ", fileName)
+		return result, ErrSkipped
+	}
+	destName := p.destinationName(ctx, result, p.relName(result.Result))
+	if err == nil {
+		result.Destinations = p.replicate(ctx, result.Result, destName)
+	}
+	p.Report.Add(result)
+	p.countTowardsStop(result)
+	if err != nil {
+		return result, err
+	}
+	if err := p.State.Record(result); err != nil {
+		log.Error("Could not save state: ", err)
+	}
+	if result.Swapped {
+		p.markChanged(filepath.Dir(result.Result))
+		if len(p.Checksums) > 0 {
+			p.writeChecksum(result.Result)
+		}
+	}
+	p.upload(ctx, result.Result, destName)
+	return result, nil
+}
+
+// Encodes a single file and swaps it in if it shrunk enough, calling the Before and After hooks around it.
+// The result names the resulting file, which is the original file if it didn't shrink enough.
+// name is the name of the file relative to the input root, used for progress and archiving.
+func (p *Processor) shrink(ctx context.Context, sourceFile, name string) (FileResult, error) {
+	result := FileResult{Source: sourceFile, Result: sourceFile}
+	var err error
+	if p.Before != nil {
+		err = p.Before(ctx, sourceFile, name)
+	}
+	switch {
+	case errors.Is(err, ErrSkip):
+		log.Info("Skipping file: ", sourceFile, " ", err)
+		result.Skipped, err = err.Error(), nil
+	case err != nil:
+		log.Error("Could not start on file: ", sourceFile, " ", err)
+		result.Error = err.Error()
+	default:
+		result, err = p.encodeAndSwap(ctx, sourceFile, name)
+		if err == nil && result.Swapped && p.Namer != nil {
+			if newName, err := p.Namer(ctx, result, name); err != nil {
+				log.Error("Could not name file, keeping its name: ", result.Result, err)
+			} else if len(newName) > 0 {
+				result.Result = p.rename(result.Result, sourceFile, name, newName)
+			}
+		}
+	}
+	if p.After != nil {
+		// the hook still runs for files that were cancelled
+		p.After(context.WithoutCancel(ctx), result, name)
+	}
+	return result, err
+}
+
+// Moves a shrunk file to a new name relative to the root the source file's name is relative to,
+// returning where it ended up
+func (p *Processor) rename(fileName, sourceFile, name, newName string) string {
+	root := strings.TrimSuffix(sourceFile, filepath.FromSlash(name))
+	parts := strings.Split(path.Clean("/"+newName), "/")
+	for i, part := range parts {
+		parts[i] = safeName(part)
+	}
+	newFile := filepath.Join(root, filepath.FromSlash(strings.Join(parts, "/")))
+	if newFile == fileName {
+		return fileName
+	}
+	if _, err := p.FS.Stat(newFile); err == nil {
+		log.Error("Not renaming, file exists: ", newFile)
+		return fileName
+	}
+	if err := p.FS.MkdirAll(filepath.Dir(newFile), 0755); err != nil {
+		log.Error(err)
+		return fileName
+	}
+	sidecarFiles := sidecars(p.FS, fileName)
+	if err := p.FS.Rename(fileName, newFile); err != nil {
+		log.Error(err)
+		return fileName
+	}
+	log.Info("Renamed: ", fileName, " to: ", newFile)
+	moveSidecars(p.FS, sidecarFiles, fileName, newFile)
+	return newFile
+}
+
+// Encodes a single file and swaps it in if it shrunk enough
+func (p *Processor) encodeAndSwap(ctx context.Context, sourceFile, name string) (FileResult, error) {
+	result := FileResult{Source: sourceFile, Result: sourceFile}
+	modTime := p.captureTime(ctx, sourceFile)
+	result.Captured = modTime
+	spherical := false
+	// files are handled by their content with DetectTypes, not only their extension
+	typeName, reason := p.typeName(ctx, sourceFile)
+	if len(reason) > 0 {
+		log.Info("Skipping file: ", sourceFile, " ", reason)
+		result.Skipped = reason
+		return result, nil
+	}
+	if IsMovie(typeName) {
+		if tags, err := probeTags(ctx, p.Encoder.Runner, sourceFile); err == nil {
+			result.Camera = camera(tags)
+		}
+		// protected and undecodable movies are left alone, they aren't failures
+		if reason := p.unsupported(ctx, sourceFile); len(reason) > 0 {
+			log.Info("Skipping file: ", sourceFile, " ", reason)
+			result.Skipped = reason
+			return result, nil
+		}
+		if p.Blank != nil && p.reviewBlank(ctx, sourceFile, name, &result) {
+			return result, nil
+		}
+		spherical = isSpherical(ctx, p.Encoder.Runner, sourceFile)
+	}
+
+	// Get an output file name, make all movies mp4  and make sure we can support multiple files in the same dir
+	encode, ext, settings := p.encodingFor(ctx, sourceFile, typeName, name)
+	outName := p.outputName(ctx, sourceFile, modTime)
+	if destName := p.destinationName(ctx, result, siblingName(name, outName+ext)); p.Collisions == CollisionSkip && p.takenAtDestination(ctx, name, destName) {
+		log.Info("Skipping file: ", sourceFile, " its name is taken at the destination: ", destName)
+		result.Skipped = "name taken at the destination: " + destName
+		return result, nil
+	}
+	outName = p.uniqueName(ctx, result, name, outName, ext)
+	destFile := filepath.Join(p.TmpDir, outName+ext)
+	for i := 1; ; i++ {
+		if _, err := p.FS.Stat(destFile); os.IsNotExist(err) {
+			break
+		}
+		destFile = filepath.Join(p.TmpDir, fmt.Sprintf("%s_%04d%s", outName, i, ext))
+	}
+
+	// record the commands making the encode for its audit sidecar
+	var commands *commandLog
+	if p.Audit {
+		ctx, commands = withCommandLog(ctx)
+	}
+
+	// Join the chapters of a split recording first, they are encoded as one movie named after the first chapter
+	inputFile, chapters := sourceFile, p.chapters[sourceFile]
+	var err error
+	if p.TmpQuota != nil {
+		// the encode takes at most about the size of the original, joined chapters take that again
+		need := fileSize(p.FS, sourceFile)
+		for _, chapter := range chapters {
+			need += fileSize(p.FS, chapter)
+		}
+		if len(chapters) > 0 {
+			need *= 2
+		}
+		if err := p.TmpQuota.Wait(ctx, need); err != nil {
+			result.Error = err.Error()
+			return result, err
+		}
+	}
+	if len(chapters) > 0 {
+		inputFile = strings.TrimSuffix(destFile, ext) + "_joined" + filepath.Ext(sourceFile)
+		defer p.FS.Remove(inputFile)
+		err = p.joinChapters(ctx, append([]string{sourceFile}, chapters...), inputFile)
+	}
+
+	// Salvage damaged movies first, they replace their original even if they don't shrink
+	repaired := false
+	if err == nil && p.Repair != nil && IsMovie(typeName) {
+		repairedFile := ""
+		if repairedFile, repaired, err = p.repair(ctx, sourceFile, inputFile, name); err != nil {
+			result.Error = err.Error()
+			return result, err
+		}
+		if repaired {
+			defer p.FS.Remove(repairedFile)
+			inputFile = repairedFile
+		}
+	}
+
+	// Run ffmpeg on the input file and save to the temp dir, unless an earlier run kept the same encode when it was rejected
+	reused := false
+	if err == nil && len(p.RejectedDir) > 0 && !repaired {
+		inSize := fileSize(p.FS, sourceFile)
+		for _, chapter := range chapters {
+			inSize += fileSize(p.FS, chapter)
+		}
+		reused = p.reuseRejected(name, inSize, settings, destFile)
+	}
+	if err == nil && !reused {
+		done := p.encoding(name)
+		err = encode(ctx, inputFile, destFile, p.progressFor(name))
+		done()
+	}
+	if err != nil {
+		p.FS.Remove(destFile)
+		if ctx.Err() != nil {
+			log.Info("Cancelled: ", sourceFile)
+			result.Error = ctx.Err().Error()
+			return result, ctx.Err()
+		}
+		log.Error("Could not run ffmpeg on file: ", sourceFile, err, destFile)
+		result.Error = err.Error()
+		return result, err
+	}
+	// 360° players show movies without their projection as a flat, distorted frame
+	if spherical && !isSpherical(ctx, p.Encoder.Runner, destFile) {
+		p.FS.Remove(destFile)
+		log.Info("Skipping file: ", sourceFile, " its encode lost the 360° projection")
+		result.Skipped = "encode lost the 360° projection"
+		return result, nil
+	}
+
+	// Check what the ratio input/output is
+	result.InSize = fileSize(p.FS, sourceFile)
+	for _, chapter := range chapters {
+		result.InSize += fileSize(p.FS, chapter)
+	}
+	result.OutSize = fileSize(p.FS, destFile)
+	result.Ratio = float64(result.OutSize) / float64(result.InSize)
+	swap := p.Swapper.ShouldSwap(result.Ratio)
+	if settings.Codec == copyCodec {
+		swap = result.Ratio < RemuxMaxRatio
+	}
+	if repaired {
+		swap = true
+	}
+	if !swap && p.CRFRetry != nil && settings.Codec != copyCodec && IsMovie(typeName) {
+		swap = p.retryCRF(ctx, sourceFile, inputFile, destFile, name, &settings, &result)
+		if ctx.Err() != nil {
+			p.FS.Remove(destFile)
+			log.Info("Cancelled: ", sourceFile)
+			result.Error = ctx.Err().Error()
+			return result, ctx.Err()
+		}
+	}
+	rejected := !swap
+	if swap && p.QualityGate != nil && IsMovie(typeName) {
+		if err := p.checkQuality(ctx, inputFile, destFile, &result); err != nil {
+			log.Info("Keeping original, the quality check failed: ", sourceFile, " ", err)
+			swap = false
+		}
+	}
+	if swap {
+		// keep a copy of the original somewhere safe before it's removed
+		if p.Archiver != nil {
+			for _, original := range append([]string{sourceFile}, chapters...) {
+				if err := p.Archiver.Archive(ctx, original, p.relName(original)); err != nil {
+					log.Error("Could not archive original, keeping it: ", original, err)
+					p.FS.Remove(destFile)
+					result.Error = err.Error()
+					return result, err
+				}
+			}
+		}
+		var audit AuditRecord
+		if p.Audit {
+			audit = p.auditRecord(ctx, settings, sourceFile, name, modTime, result)
+			audit.Provenance = p.provenance(ctx, sourceFile, commands)
+		}
+		// mp4 can't hold some subtitles, they are kept as sidecars
+		subtitleFiles := p.extractSubtitles(ctx, sourceFile, inputFile, destFile)
+		sidecarFiles := sidecars(p.FS, sourceFile)
+		swapped, err := p.Swapper.Swap(sourceFile, destFile)
+		if err != nil {
+			log.Error("Could not replace original, keeping it: ", sourceFile, err)
+			p.FS.Remove(destFile)
+			for _, subtitleFile := range subtitleFiles {
+				p.FS.Remove(subtitleFile)
+			}
+			result.Error = err.Error()
+			return result, err
+		}
+		result.Result = swapped
+		result.Swapped = true
+		moveSidecars(p.FS, sidecarFiles, sourceFile, result.Result)
+		p.placeSubtitles(subtitleFiles, destFile, result.Result)
+		for _, chapter := range chapters {
+			if err := p.FS.Remove(chapter); err != nil {
+				log.Error("Could not remove joined chapter: ", chapter, err)
+			}
+		}
+		if p.Audit {
+			p.writeAudit(result.Result, audit)
+		}
+		// Date the new file with when the original was shot, unless the options say otherwise
+		if err := p.dateResult(result.Result, modTime); err != nil {
+			log.Error(err)
+		}
+		if p.Thumbnails && IsMovie(result.Result) {
+			thumbFile := ThumbnailName(result.Result)
+			if err := (Encoder{Runner: p.Encoder.Runner}).Thumbnail(ctx, result.Result, thumbFile); err != nil {
+				log.Error("Could not save thumbnail: ", result.Result, err)
+			} else {
+				log.Info("Saved thumbnail: ", thumbFile)
+			}
+		}
+	} else if rejected && len(p.RejectedDir) > 0 {
+		p.reject(destFile, name, settings, result)
+	} else {
+		p.FS.Remove(destFile)
+	}
+	if p.Proxy != nil && IsMovie(result.Result) {
+		p.saveProxy(ctx, result.Result, name)
+	}
+
+	log.Info("Processed File: ", sourceFile, " ratio: ", result.Ratio)
+	return result, nil
+}
+
+// Returns how to encode a file, the extension of the result and the settings used, by the kind of file typeName is.
+// Movies become mp4 with the encoder for them, or are remuxed, photos keep their format and audio becomes m4a or opus.
+func (p *Processor) encodingFor(ctx context.Context, fileName, typeName, name string) (func(context.Context, string, string, func(float64)) error, string, EncodeSettings) {
+	if p.Photos != nil && IsPhoto(typeName) {
+		photos := *p.Photos
+		if photos.Runner == nil {
+			photos.Runner = p.Encoder.Runner
+		}
+		return photos.Encode, photos.Ext(typeName), photos.Settings(typeName)
+	}
+	if p.Audio != nil && IsAudio(typeName) {
+		audio := *p.Audio
+		if audio.Runner == nil {
+			audio.Runner = p.Encoder.Runner
+		}
+		return audio.Encode, audio.Ext(), audio.Settings()
+	}
+	if len(p.Remux) > 0 && IsMovie(typeName) {
+		remuxer := Encoder{Strip: p.Encoder.Strip, AudioLanguages: p.Encoder.AudioLanguages, Runner: p.Encoder.Runner}
+		return remuxer.Remux, "." + p.Remux, EncodeSettings{Codec: copyCodec, Strip: remuxer.Strip, AudioLanguages: remuxer.AudioLanguages}
+	}
+	encoder := p.encoderFor(ctx, fileName, name)
+	if p.Segments != nil {
+		encode := func(ctx context.Context, inputFile, destFile string, progress func(float64)) error {
+			return p.encodeSegments(ctx, encoder, fileName, inputFile, destFile, progress)
+		}
+		return encode, ".mp4", encoder.Settings()
+	}
+	return encoder.Encode, ".mp4", encoder.Settings()
+}
+
+// Returns when a movie was shot in the time zone of the options, reading its metadata with the encoder's runner
+func (p *Processor) captureTime(ctx context.Context, fileName string) time.Time {
+	return captureTime(ctx, runnerOrExec(p.Encoder.Runner), p.FS, p.Location, p.DatePatterns, fileName)
+}
+
+// Sends a finished file to all uploaders
+func (p *Processor) upload(ctx context.Context, fileName, name string) {
+	if len(p.Uploaders) == 0 {
+		return
+	}
+	p.claimDestination(name)
+	capturedAt := p.copyTime(ctx, fileName)
+	for _, uploader := range p.Uploaders {
+		if err := uploader.Upload(ctx, fileName, name, capturedAt); err != nil {
+			log.Error("Could not upload file: ", fileName, err)
+		}
+	}
+}
+
+// Remembers a dir whose files were replaced, so it is refreshed later
+func (p *Processor) markChanged(dirName string) {
+	if p.Refresher == nil {
+		return
+	}
+	if p.changed == nil {
+		p.changed = make(map[string]bool)
+	}
+	p.changed[dirName] = true
+}
+
+// Refreshes all changed dirs
+func (p *Processor) refreshChanged() {
+	var dirNames []string
+	for dirName := range p.changed {
+		dirNames = append(dirNames, dirName)
+	}
+	sort.Strings(dirNames)
+	for _, dirName := range dirNames {
+		if err := p.Refresher.Refresh(dirName); err != nil {
+			log.Error("Could not refresh media server: ", err)
+		} else {
+			log.Info("Refreshed media server: ", dirName)
+		}
+	}
+	p.changed = nil
+}
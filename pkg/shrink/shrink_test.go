@@ -0,0 +1,47 @@
+package shrink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBeforeHook(t *testing.T) {
+	tests := []struct {
+		name      string
+		beforeErr error
+		skipped   bool
+		wantErr   bool
+	}{
+		{"skip", ErrSkip, true, false},
+		{"wrapped skip", fmt.Errorf("%w by the pre hook, exit status 1", ErrSkip), true, false},
+		{"failure", errors.New("pre hook: sh not found"), false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var after *FileResult
+			p := New(Options{
+				Before: func(ctx context.Context, fileName, name string) error { return test.beforeErr },
+				After:  func(ctx context.Context, result FileResult, name string) { after = &result },
+			})
+
+			result, err := p.shrink(context.Background(), "movie.avi", "movie.avi")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("shrink() error = %v, want an error: %v", err, test.wantErr)
+			}
+			if got := len(result.Skipped) > 0; got != test.skipped {
+				t.Errorf("shrink() skipped = %q, want skipped: %v", result.Skipped, test.skipped)
+			}
+			if got := len(result.Error) > 0; got != test.wantErr {
+				t.Errorf("shrink() error in result = %q, want one: %v", result.Error, test.wantErr)
+			}
+			if after == nil {
+				t.Fatal("After wasn't called")
+			}
+			if after.Skipped != result.Skipped || after.Error != result.Error {
+				t.Errorf("After called with %+v, want %+v", *after, result)
+			}
+		})
+	}
+}
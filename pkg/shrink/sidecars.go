@@ -0,0 +1,83 @@
+package shrink
+
+import (
+	filepath "path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sidecarExts are the extensions of files that belong to the movie they are named after,
+// like subtitles, metadata, thumbnails, GPS tracks and checksums
+var sidecarExts = []string{".srt", ".ass", ".sup", ".xmp", ".thm", ".gpx", ".json", checksumSuffix}
+
+// Returns the sidecars of a movie, the files in its dir named after it with or without its extension,
+// eg. clip.srt, clip.THM or clip.mp4.json as Google Takeout writes them, subtitles in a language like clip.eng.srt,
+// its audit sidecar and its previews
+func sidecars(fsys FS, fileName string) []string {
+	entries, err := fsys.ReadDir(filepath.Dir(fileName))
+	if err != nil {
+		return nil
+	}
+	base := filepath.Base(fileName)
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	var found []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == base {
+			continue
+		}
+		if suffix, ok := previewSuffix(name); ok && strings.EqualFold(name, stem+suffix) {
+			found = append(found, filepath.Join(filepath.Dir(fileName), name))
+			continue
+		}
+		if !isSidecarExt(filepath.Ext(name)) {
+			continue
+		}
+		rest := strings.TrimSuffix(name, filepath.Ext(name))
+		if rest == stem || rest == base || name == base+auditSuffix || (isSubtitleExt(filepath.Ext(name)) && strings.HasPrefix(rest, stem+".")) {
+			found = append(found, filepath.Join(filepath.Dir(fileName), name))
+		}
+	}
+	return found
+}
+
+// Returns true for the extensions of sidecars, whatever their case
+func isSidecarExt(ext string) bool {
+	for _, sidecarExt := range sidecarExts {
+		if strings.EqualFold(ext, sidecarExt) {
+			return true
+		}
+	}
+	return false
+}
+
+// Moves the sidecars of a movie that was renamed from oldName to newName, so they keep belonging to it.
+// A sidecar named after the whole movie name, eg. clip.mov.json, is named after the whole new name.
+func moveSidecars(fsys FS, sidecarFiles []string, oldName, newName string) {
+	oldBase, newBase := filepath.Base(oldName), filepath.Base(newName)
+	oldStem := strings.TrimSuffix(oldBase, filepath.Ext(oldBase))
+	newStem := strings.TrimSuffix(newBase, filepath.Ext(newBase))
+	for _, sidecar := range sidecarFiles {
+		name := filepath.Base(sidecar)
+		var newSidecar string
+		if strings.HasPrefix(name, oldBase+".") {
+			newSidecar = newBase + strings.TrimPrefix(name, oldBase)
+		} else {
+			newSidecar = newStem + strings.TrimPrefix(name, oldStem)
+		}
+		newSidecar = filepath.Join(filepath.Dir(newName), newSidecar)
+		if newSidecar == sidecar {
+			continue
+		}
+		if _, err := fsys.Stat(newSidecar); err == nil {
+			log.Error("Not moving sidecar, file exists: ", newSidecar)
+			continue
+		}
+		if err := fsys.Rename(sidecar, newSidecar); err != nil {
+			log.Error("Could not move sidecar: ", sidecar, err)
+			continue
+		}
+		log.Info("Moved sidecar: ", sidecar, " to: ", newSidecar)
+	}
+}
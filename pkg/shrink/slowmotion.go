@@ -0,0 +1,22 @@
+package shrink
+
+import (
+	"regexp"
+	"strings"
+)
+
+// fpsFilterPattern matches frame rate filters in a filter chain, eg. fps=30
+var fpsFilterPattern = regexp.MustCompile(`(^|,)(fps|framerate)=[^,]*`)
+
+// SlowMotionEncoder returns an encoder keeping every frame of slow motion movies: without a frame rate cap or
+// frame rate filters, which would drop the frames they are slowed down with
+func SlowMotionEncoder(encoder Encoder) Encoder {
+	encoder.MaxFPS = 0
+	encoder.Filter = strings.TrimPrefix(fpsFilterPattern.ReplaceAllString(encoder.Filter, ""), ",")
+	return encoder
+}
+
+// Returns true if an encoder lowers the frame rate of movies
+func capsFrameRate(encoder Encoder) bool {
+	return encoder.MaxFPS > 0 || fpsFilterPattern.MatchString(encoder.Filter)
+}
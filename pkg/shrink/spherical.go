@@ -0,0 +1,52 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	filepath "path/filepath"
+	"strings"
+)
+
+// sphericalOriginalExts are the extensions of unstitched recordings of 360° cameras, Insta360 .insv and GoPro MAX .360.
+// Their lenses are in separate streams or halves of the frame, with the stitching data in boxes ffmpeg doesn't keep,
+// so encoding them breaks them for the camera's software.
+var sphericalOriginalExts = []string{".insv", ".360"}
+
+// Returns true for the unstitched recordings of 360° cameras
+func isSphericalOriginal(fileName string) bool {
+	ext := filepath.Ext(fileName)
+	for _, sphericalExt := range sphericalOriginalExts {
+		if strings.EqualFold(ext, sphericalExt) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the video of a movie has spherical metadata, its projection for 360° players
+func isSpherical(ctx context.Context, runner Runner, fileName string) bool {
+	var out bytes.Buffer
+	args := []string{"-v", "error", "-select_streams", "v:0", "-show_entries", "stream_side_data=side_data_type", "-of", "json", fileName}
+	if err := runnerOrExec(runner).Run(ctx, "ffprobe", args, &out, nil); err != nil {
+		return false
+	}
+	var probe struct {
+		Streams []struct {
+			SideData []struct {
+				Type string `json:"side_data_type"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return false
+	}
+	for _, stream := range probe.Streams {
+		for _, sideData := range stream.SideData {
+			if sideData.Type == "Spherical Mapping" {
+				return true
+			}
+		}
+	}
+	return false
+}
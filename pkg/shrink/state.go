@@ -0,0 +1,121 @@
+package shrink
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	filepath "path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateEntry remembers a file that has already been processed
+type StateEntry struct {
+	Size      int64
+	ModTime   time.Time
+	Ratio     float64
+	Processed time.Time
+}
+
+// State is a small json database of processed files, so repeated runs over the same dir skip them.
+// A file is only skipped while its size and mod time are unchanged.
+type State struct {
+	fileName string
+	mu       sync.Mutex
+	Files    map[string]StateEntry
+}
+
+// LoadState reads the state file, a missing file gives an empty state
+func LoadState(fileName string) (*State, error) {
+	state := &State{fileName: fileName, Files: make(map[string]StateEntry)}
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Done returns true if the file was processed before and hasn't changed since, a nil state knows nothing
+func (s *State) Done(fileName string) bool {
+	if s == nil {
+		return false
+	}
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return false
+	}
+	absName, _ := filepath.Abs(fileName)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.Files[absName]
+	return ok && entry.Size == stat.Size() && entry.ModTime.Equal(stat.ModTime())
+}
+
+// Record remembers the resulting file of a successful run and saves the state, a nil state does nothing
+func (s *State) Record(result FileResult) error {
+	if s == nil {
+		return nil
+	}
+	stat, err := os.Stat(result.Result)
+	if err != nil {
+		return err
+	}
+	absName, _ := filepath.Abs(result.Result)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Files[absName] = StateEntry{Size: stat.Size(), ModTime: stat.ModTime(), Ratio: result.Ratio, Processed: time.Now()}
+	return s.save()
+}
+
+// DoneRemote returns true if a file on a remote was processed before. Remotes are only listed,
+// so unlike Done it can't tell if the file changed since.
+func (s *State) DoneRemote(location, name string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.Files[remoteKey(location, name)]
+	return ok
+}
+
+// RecordRemote remembers a file on a remote as processed and saves the state, a nil state does nothing
+func (s *State) RecordRemote(location, name string, size int64, ratio float64) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Files[remoteKey(location, name)] = StateEntry{Size: size, Ratio: ratio, Processed: time.Now()}
+	return s.save()
+}
+
+// Returns the key of a file on a remote, its name below the location, eg. s3://bucket/prefix/clip.mp4
+func remoteKey(location, name string) string {
+	return strings.TrimSuffix(location, "/") + "/" + name
+}
+
+// Writes the state to a temp file and renames it, so a crash never leaves a half written state behind
+func (s *State) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.fileName), 0755); err != nil {
+		return err
+	}
+	tmpName := s.fileName + ".tmp"
+	if err := ioutil.WriteFile(tmpName, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, s.fileName)
+}
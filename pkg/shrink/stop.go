@@ -0,0 +1,54 @@
+package shrink
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// StopAfter ends a run cleanly once one of its limits is reached, finishing the current file first, eg. to spread
+// shrinking a large library over several nights. Zero is no limit.
+type StopAfter struct {
+	// Files is how many files to process, files that are done already aren't counted
+	Files int
+	// SavedBytes is how many bytes to reclaim
+	SavedBytes int64
+	// Runtime is how long to run for
+	Runtime time.Duration
+}
+
+// stopCount is what counts towards the StopAfter limits so far
+type stopCount struct {
+	files int
+	saved int64
+}
+
+// Starts the clock of the runtime limit, stopping the processor once it runs out
+func (p *Processor) startStopTimer() {
+	if p.StopAfter == nil || p.StopAfter.Runtime <= 0 {
+		return
+	}
+	time.AfterFunc(p.StopAfter.Runtime, func() {
+		log.Info("Stopping after the current file, ran for: ", p.StopAfter.Runtime)
+		p.Stop()
+	})
+}
+
+// Counts a processed file towards the StopAfter limits, stopping the processor once one is reached
+func (p *Processor) countTowardsStop(result FileResult) {
+	if p.StopAfter == nil {
+		return
+	}
+	p.mu.Lock()
+	p.stopCount.files++
+	p.stopCount.saved += result.Saved()
+	count := p.stopCount
+	p.mu.Unlock()
+	if p.StopAfter.Files > 0 && count.files >= p.StopAfter.Files {
+		log.Info("Stopping, processed files: ", count.files)
+		p.Stop()
+	} else if p.StopAfter.SavedBytes > 0 && count.saved >= p.StopAfter.SavedBytes {
+		log.Info("Stopping, saved MB: ", count.saved>>20)
+		p.Stop()
+	}
+}
@@ -0,0 +1,146 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	filepath "path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// subtitleExts are the extensions of subtitle sidecars, which may have a language between the movie name and
+// the extension, eg. clip.eng.srt as Jellyfin, Emby and Kodi name them
+var subtitleExts = []string{".srt", ".ass", ".sup"}
+
+// subtitleTrack is a subtitle stream of a movie
+type subtitleTrack struct {
+	Index     int    `json:"index"`
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Tags      struct {
+		Language string `json:"language"`
+	} `json:"tags"`
+}
+
+// Returns the extension and ffmpeg codec of the sidecar a subtitle track is extracted to, false if it can't be.
+// Styled subtitles keep their styling in .ass, other text subtitles become .srt and PGS bitmaps are copied into .sup.
+func (t subtitleTrack) sidecar() (string, string, bool) {
+	switch t.CodecName {
+	case "ass", "ssa":
+		return ".ass", "copy", true
+	case "subrip", "srt", "text", "webvtt":
+		return ".srt", "srt", true
+	case "hdmv_pgs_subtitle":
+		return ".sup", "copy", true
+	}
+	return "", "", false
+}
+
+// Reads the subtitle streams of a movie with ffprobe
+func probeSubtitleTracks(ctx context.Context, runner Runner, fileName string) ([]subtitleTrack, error) {
+	var out bytes.Buffer
+	args := []string{"-v", "error", "-select_streams", "s", "-show_entries", "stream=index,codec_type,codec_name:stream_tags=language",
+		"-of", "json", fileName}
+	if err := runnerOrExec(runner).Run(ctx, "ffprobe", args, &out, nil); err != nil {
+		return nil, err
+	}
+	var probe struct {
+		Streams []subtitleTrack `json:"streams"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return nil, err
+	}
+	return probe.Streams, nil
+}
+
+// ExtractSubtitles saves the subtitle tracks of a movie that mp4 can't hold as sidecars named after destFile,
+// eg. clip.eng.srt, and returns their names. mov_text tracks are left alone, mp4 keeps them.
+func (e Encoder) ExtractSubtitles(ctx context.Context, movieFile, destFile string) ([]string, error) {
+	runner := runnerOrExec(e.Runner)
+	tracks, err := probeSubtitleTracks(ctx, runner, movieFile)
+	if err != nil {
+		return nil, err
+	}
+	stem := strings.TrimSuffix(destFile, filepath.Ext(destFile))
+	args := []string{"-y", "-v", "error", "-i", movieFile}
+	var files []string
+	taken := map[string]bool{}
+	for _, track := range tracks {
+		if track.CodecType != "subtitle" || track.CodecName == "mov_text" {
+			continue
+		}
+		ext, codec, ok := track.sidecar()
+		if !ok {
+			log.Info("Can't extract ", track.CodecName, " subtitles, dropping track ", track.Index, " of: ", movieFile)
+			continue
+		}
+		name := stem
+		if language := strings.ToLower(track.Tags.Language); len(language) > 0 && language != "und" {
+			name += "." + language
+		}
+		// several tracks in a language are numbered, eg. clip.eng.srt and clip.eng.2.srt
+		subtitleFile := name + ext
+		for i := 2; taken[subtitleFile]; i++ {
+			subtitleFile = name + "." + strconv.Itoa(i) + ext
+		}
+		taken[subtitleFile] = true
+		args = append(args, "-map", "0:"+strconv.Itoa(track.Index), "-c:s", codec, subtitleFile)
+		files = append(files, subtitleFile)
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	if err := runner.Run(ctx, "ffmpeg", args, nil, nil); err != nil {
+		for _, subtitleFile := range files {
+			os.Remove(subtitleFile)
+		}
+		return nil, err
+	}
+	return files, nil
+}
+
+// Returns true for the extensions of subtitle sidecars, whatever their case
+func isSubtitleExt(ext string) bool {
+	for _, subtitleExt := range subtitleExts {
+		if strings.EqualFold(ext, subtitleExt) {
+			return true
+		}
+	}
+	return false
+}
+
+// Extracts the subtitles of a movie encoded into mp4 that it can't hold into the temp dir, next to the encode
+func (p *Processor) extractSubtitles(ctx context.Context, sourceFile, inputFile, destFile string) []string {
+	if !IsMovie(sourceFile) || !strings.EqualFold(filepath.Ext(destFile), ".mp4") {
+		return nil
+	}
+	files, err := (Encoder{Runner: p.Encoder.Runner}).ExtractSubtitles(ctx, inputFile, destFile)
+	if err != nil {
+		log.Error("Could not extract subtitles: ", sourceFile, err)
+	}
+	return files
+}
+
+// Moves subtitles extracted next to the encode in the temp dir next to the movie it became
+func (p *Processor) placeSubtitles(subtitleFiles []string, destFile, resultFile string) {
+	destStem := strings.TrimSuffix(filepath.Base(destFile), filepath.Ext(destFile))
+	resultStem := strings.TrimSuffix(resultFile, filepath.Ext(resultFile))
+	for _, subtitleFile := range subtitleFiles {
+		newFile := resultStem + strings.TrimPrefix(filepath.Base(subtitleFile), destStem)
+		if _, err := p.FS.Stat(newFile); err == nil {
+			log.Error("Not saving subtitles, file exists: ", newFile)
+			p.FS.Remove(subtitleFile)
+			continue
+		}
+		if err := moveFile(p.FS, subtitleFile, newFile); err != nil {
+			log.Error("Could not save subtitles: ", newFile, err)
+			p.FS.Remove(subtitleFile)
+			continue
+		}
+		log.Info("Saved subtitles: ", newFile)
+	}
+}
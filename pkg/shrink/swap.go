@@ -0,0 +1,103 @@
+package shrink
+
+import (
+	"fmt"
+	"io"
+	filepath "path/filepath"
+)
+
+// DefaultMaxRatio is the output/input size ratio below which originals are replaced
+const DefaultMaxRatio = 0.93
+
+// Swapper decides whether a shrunk file replaces its original and swaps them around
+type Swapper struct {
+	// MaxRatio is the output/input size ratio below which the original is replaced
+	MaxRatio float64
+	// FS is the file system the files are on, the real one if nil
+	FS FS
+	// TmpDir is where originals are moved to while swapping, the OS temp dir if empty.
+	// On the file system of the originals files are renamed rather than copied.
+	TmpDir string
+}
+
+// ShouldSwap returns true if the output is small enough to replace the original
+func (s Swapper) ShouldSwap(ratio float64) bool {
+	return ratio < s.MaxRatio
+}
+
+// Swap replaces the original with the shrunk file, which keeps its own name.
+// Returns the new name of the shrunk file. If that fails the original is moved back, and if even that fails
+// it is kept in the swap dir the error names.
+func (s Swapper) Swap(inFile, outFile string) (string, error) {
+	// create new temp dir
+	fsys := fsOrOS(s.FS)
+	swapDir, err := fsys.TempDir(s.TmpDir, "swap")
+	if err != nil {
+		return "", fmt.Errorf("unable to create swap dir: %v", err)
+	}
+
+	// swap files around, first move source to temp, then move dest to source
+	swapFile := filepath.Join(swapDir, filepath.Base(inFile))
+	if err := moveFile(fsys, inFile, swapFile); err != nil {
+		fsys.RemoveAll(swapDir)
+		return "", fmt.Errorf("unable to move original aside: %v", err)
+	}
+
+	destFileName := filepath.Join(filepath.Dir(inFile), filepath.Base(outFile))
+	if err := moveFile(fsys, outFile, destFileName); err != nil {
+		if restoreErr := moveFile(fsys, swapFile, inFile); restoreErr != nil {
+			// the swap dir holds the only copy of the original now
+			return "", fmt.Errorf("unable to move shrunk file into place: %v, original kept at %s: %v", err, swapFile, restoreErr)
+		}
+		fsys.RemoveAll(swapDir)
+		return "", fmt.Errorf("unable to move shrunk file into place: %v", err)
+	}
+
+	fsys.RemoveAll(swapDir) // clean up
+	return destFileName, nil
+}
+
+// Renames a file, copying it when it is on another file system
+func moveFile(fsys FS, src, dst string) error {
+	if err := fsys.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(fsys, src, dst); err != nil {
+		fsys.Remove(dst)
+		return err
+	}
+	return fsys.Remove(src)
+}
+
+// CopyFile Helper function to copy a file
+func CopyFile(src, dst string) error {
+	return copyFile(OSFS{}, src, dst)
+}
+
+// CopyFile on the given file system, a copy that fails part way on a retrying file system is started over
+func copyFile(fsys FS, src, dst string) error {
+	if r, ok := fsys.(RetryFS); ok {
+		return r.retry("copying "+src, func() error { return copyFile(fsOrOS(r.FS), src, dst) })
+	}
+	// open input file
+	in, err := fsys.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// create dest file
+	out, err := fsys.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// copy contents from source to destination
+	_, err = io.Copy(out, in)
+	cerr := out.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
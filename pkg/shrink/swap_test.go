@@ -0,0 +1,198 @@
+package shrink
+
+import (
+	"errors"
+	"os"
+	filepath "path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShouldSwap(t *testing.T) {
+	tests := []struct {
+		maxRatio, ratio float64
+		want            bool
+	}{
+		{DefaultMaxRatio, 0.5, true},
+		{DefaultMaxRatio, 0.92, true},
+		{DefaultMaxRatio, DefaultMaxRatio, false},
+		{DefaultMaxRatio, 1.2, false},
+		{RemuxMaxRatio, 1.01, true},
+	}
+	for _, test := range tests {
+		if got := (Swapper{MaxRatio: test.maxRatio}).ShouldSwap(test.ratio); got != test.want {
+			t.Errorf("ShouldSwap(%v) with MaxRatio %v = %v, want %v", test.ratio, test.maxRatio, got, test.want)
+		}
+	}
+}
+
+func TestSwap(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name string
+		fs   func(inFile, outFile string) *fakeFS
+		// wantErr is part of the error, empty if the swap works
+		wantErr string
+		// want are the contents of the files in the movie dir afterwards
+		want map[string]string
+		// kept is true if the original is left in the swap dir
+		kept bool
+	}{
+		{
+			name: "swaps",
+			fs:   func(inFile, outFile string) *fakeFS { return &fakeFS{} },
+			want: map[string]string{"movie.mp4": "shrunk"},
+		},
+		{
+			name:    "no swap dir",
+			fs:      func(inFile, outFile string) *fakeFS { return &fakeFS{tempDirErr: errFailed} },
+			wantErr: "unable to create swap dir",
+			want:    map[string]string{"movie.avi": "original"},
+		},
+		{
+			name: "original can't be moved aside",
+			fs: func(inFile, outFile string) *fakeFS {
+				return &fakeFS{
+					renameErr: func(oldName, newName string) error {
+						if oldName == inFile {
+							return errFailed
+						}
+						return nil
+					},
+					createErr: func(name string) error { return errFailed },
+				}
+			},
+			wantErr: "unable to move original aside",
+			want:    map[string]string{"movie.avi": "original"},
+		},
+		{
+			name: "shrunk file can't be moved into place",
+			fs: func(inFile, outFile string) *fakeFS {
+				return &fakeFS{
+					renameErr: func(oldName, newName string) error {
+						if oldName == outFile {
+							return errFailed
+						}
+						return nil
+					},
+					createErr: func(name string) error {
+						if filepath.Dir(name) == filepath.Dir(inFile) {
+							return errFailed
+						}
+						return nil
+					},
+				}
+			},
+			wantErr: "unable to move shrunk file into place",
+			want:    map[string]string{"movie.avi": "original"},
+		},
+		{
+			name: "original can't be moved back",
+			fs: func(inFile, outFile string) *fakeFS {
+				return &fakeFS{
+					renameErr: func(oldName, newName string) error {
+						if oldName == outFile || newName == inFile {
+							return errFailed
+						}
+						return nil
+					},
+					createErr: func(name string) error {
+						if filepath.Dir(name) == filepath.Dir(inFile) {
+							return errFailed
+						}
+						return nil
+					},
+				}
+			},
+			wantErr: "original kept at",
+			want:    map[string]string{},
+			kept:    true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			movieDir, encodeDir, swapDir := t.TempDir(), t.TempDir(), t.TempDir()
+			inFile, outFile := filepath.Join(movieDir, "movie.avi"), filepath.Join(encodeDir, "movie.mp4")
+			writeTestFile(t, inFile, "original")
+			writeTestFile(t, outFile, "shrunk")
+
+			swapper := Swapper{FS: test.fs(inFile, outFile), TmpDir: swapDir}
+			result, err := swapper.Swap(inFile, outFile)
+			if len(test.wantErr) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := filepath.Join(movieDir, "movie.mp4"); result != want {
+					t.Errorf("Swap() = %s, want %s", result, want)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("Swap() error = %v, want %s", err, test.wantErr)
+			}
+
+			entries, _ := os.ReadDir(movieDir)
+			if len(entries) != len(test.want) {
+				t.Errorf("movie dir has %d files, want %v", len(entries), test.want)
+			}
+			for name, content := range test.want {
+				if got := readTestFile(filepath.Join(movieDir, name)); got != content {
+					t.Errorf("%s = %q, want %q", name, got, content)
+				}
+			}
+			kept, _ := filepath.Glob(filepath.Join(swapDir, "swap*", "movie.avi"))
+			if test.kept != (len(kept) == 1) {
+				t.Errorf("original kept in swap dir: %v, want %v", kept, test.kept)
+			}
+			if !test.kept {
+				if left, _ := os.ReadDir(swapDir); len(left) > 0 {
+					t.Errorf("swap dir not cleaned up: %v", left)
+				}
+			}
+		})
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	errCrossDevice := errors.New("invalid cross-device link")
+	tests := []struct {
+		name    string
+		fs      *fakeFS
+		wantErr bool
+	}{
+		{"renames", &fakeFS{}, false},
+		{"copies across file systems", &fakeFS{renameErr: func(string, string) error { return errCrossDevice }}, false},
+		{"fails to copy", &fakeFS{
+			renameErr: func(string, string) error { return errCrossDevice },
+			createErr: func(string) error { return errCrossDevice },
+		}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src, dst := filepath.Join(dir, "src.mp4"), filepath.Join(dir, "dst.mp4")
+			writeTestFile(t, src, "movie")
+
+			err := moveFile(test.fs, src, dst)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("moveFile() didn't fail")
+				}
+				if got := readTestFile(src); got != "movie" {
+					t.Errorf("source = %q after a failed move", got)
+				}
+				if _, err := os.Stat(dst); !os.IsNotExist(err) {
+					t.Errorf("destination left after a failed move: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readTestFile(dst); got != "movie" {
+				t.Errorf("destination = %q, want movie", got)
+			}
+			if _, err := os.Stat(src); !os.IsNotExist(err) {
+				t.Errorf("source left after the move: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package shrink
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	filepath "path/filepath"
+	"sync"
+	"time"
+)
+
+// tarTrailer is the size of the two zero blocks ending a tar file
+const tarTrailer = 2 * 512
+
+// TarEntry is a line in the index next to a tar archive, enough to find a file without reading the archive
+type TarEntry struct {
+	Name string `json:"name"`
+	// Offset is where the contents of the file start in the archive, eg. for dd
+	Offset      int64     `json:"offset"`
+	Size        int64     `json:"size"`
+	CaptureTime time.Time `json:"captureTime"`
+	Archived    time.Time `json:"archived"`
+}
+
+// TarUploader appends files to a tar archive of the day in a local directory, eg. originals-2016-05-13.tar,
+// listing them in an index next to it, eg. originals-2016-05-13.tar.jsonl. The archives can be moved to offline storage.
+type TarUploader struct {
+	Dir string
+	mu  *sync.Mutex
+}
+
+// NewTarUploader returns an uploader appending to dated tar archives in dir
+func NewTarUploader(dir string) TarUploader {
+	return TarUploader{Dir: dir, mu: &sync.Mutex{}}
+}
+
+// Upload appends the file to the archive of today under its relative name, with the capture time as its mod time
+func (t TarUploader) Upload(ctx context.Context, fileName, relName string, captureTime time.Time) error {
+	if t.mu != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+	}
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tarName := filepath.Join(t.Dir, "originals-"+time.Now().Format("2006-01-02")+".tar")
+	archive, err := os.OpenFile(tarName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	start, err := tarEnd(archive)
+	if err != nil {
+		return err
+	}
+	if _, err := archive.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	writer := tar.NewWriter(archive)
+	header := &tar.Header{Name: relName, Mode: 0644, Size: stat.Size(), ModTime: captureTime, Format: tar.FormatPAX}
+	if captureTime.IsZero() {
+		header.ModTime = stat.ModTime()
+	}
+	if err := writer.WriteHeader(header); err != nil {
+		return truncateTar(archive, start, err)
+	}
+	// the header takes whole blocks, so the contents start where the writer is now
+	offset, err := archive.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return truncateTar(archive, start, err)
+	}
+	if _, err := io.Copy(writer, in); err != nil {
+		return truncateTar(archive, start, err)
+	}
+	if err := writer.Close(); err != nil {
+		return truncateTar(archive, start, err)
+	}
+	if err := archive.Sync(); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(TarEntry{Name: relName, Offset: offset, Size: stat.Size(), CaptureTime: captureTime, Archived: time.Now()})
+	if err != nil {
+		return err
+	}
+	index, err := os.OpenFile(tarName+".jsonl", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := index.Write(append(line, '\n')); err != nil {
+		index.Close()
+		return err
+	}
+	return index.Close()
+}
+
+// Cuts off a file that failed to be appended, writing the trailer again so the archive stays readable
+func truncateTar(archive *os.File, start int64, err error) error {
+	if truncErr := archive.Truncate(start); truncErr == nil {
+		archive.WriteAt(make([]byte, tarTrailer), start)
+	}
+	return err
+}
+
+// Returns where the next file goes in a tar archive, over its trailer of zero blocks, zero for a new archive
+func tarEnd(archive *os.File) (int64, error) {
+	stat, err := archive.Stat()
+	if err != nil || stat.Size() < tarTrailer {
+		return 0, err
+	}
+	trailer := make([]byte, tarTrailer)
+	if _, err := archive.ReadAt(trailer, stat.Size()-tarTrailer); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(trailer, make([]byte, tarTrailer)) {
+		return stat.Size(), nil
+	}
+	return stat.Size() - tarTrailer, nil
+}
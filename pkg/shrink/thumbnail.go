@@ -0,0 +1,84 @@
+package shrink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	filepath "path/filepath"
+	"strings"
+)
+
+// Previews are named after their movie with these suffixes, eg. clip-thumb.jpg, which Jellyfin, Emby and Kodi pick up
+const (
+	thumbSuffix  = "-thumb.jpg"
+	sheetSuffix  = "-sheet.jpg"
+	spriteSuffix = "-sprite.jpg"
+)
+
+// previewSuffixes are the suffixes of all previews, they are moved along with their movie
+var previewSuffixes = []string{thumbSuffix, sheetSuffix, spriteSuffix}
+
+// thumbnailFilter drops frames that are mostly black or blurred, then picks the most representative of the rest
+const thumbnailFilter = "blackframe=amount=0,metadata=select:key=lavfi.blackframe.pblack:value=90:function=less," +
+	"blurdetect,metadata=select:key=lavfi.blur:value=8:function=less,thumbnail=120"
+
+// ThumbnailName returns the name of the thumbnail of a movie, next to it
+func ThumbnailName(fileName string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + thumbSuffix
+}
+
+// ContactSheetName returns the name of the contact sheet of a movie next to it, or of its sprite
+func ContactSheetName(fileName string, sprite bool) string {
+	if sprite {
+		return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + spriteSuffix
+	}
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + sheetSuffix
+}
+
+// Returns the suffix if the file is a preview written for a movie, eg. a thumbnail
+func previewSuffix(fileName string) (string, bool) {
+	for _, suffix := range previewSuffixes {
+		if strings.HasSuffix(strings.ToLower(fileName), suffix) {
+			return suffix, true
+		}
+	}
+	return "", false
+}
+
+// Thumbnail saves a representative frame of a movie as a JPEG, skipping black and blurred frames.
+// If every frame looks black or blurred the most representative one is taken anyway.
+func (e Encoder) Thumbnail(ctx context.Context, movieFile, thumbFile string) error {
+	runner := runnerOrExec(e.Runner)
+	// start a bit into the movie, past fades and the camera being raised
+	start := probeDuration(ctx, runner, movieFile) / 10
+	args := func(filter string) []string {
+		return []string{"-y", "-v", "error", "-ss", fmt.Sprintf("%.3f", start.Seconds()), "-i", movieFile,
+			"-vf", filter, "-frames:v", "1", "-q:v", "3", thumbFile}
+	}
+	if err := runner.Run(ctx, "ffmpeg", args(thumbnailFilter), nil, nil); err == nil {
+		// ffmpeg leaves an empty file when the filter let no frame through
+		if info, err := os.Stat(thumbFile); err == nil && info.Size() > 0 {
+			return nil
+		}
+	}
+	return runner.Run(ctx, "ffmpeg", args("thumbnail=120"), nil, nil)
+}
+
+// ContactSheet saves frames spread evenly across a movie as one JPEG, tiled in rows of columns frames
+// that are width pixels wide. A single row is a sprite for hover previews.
+func (e Encoder) ContactSheet(ctx context.Context, movieFile, sheetFile string, frames, columns, width int) error {
+	runner := runnerOrExec(e.Runner)
+	duration := probeDuration(ctx, runner, movieFile)
+	if duration <= 0 {
+		return fmt.Errorf("unknown duration: %s", movieFile)
+	}
+	rows := (frames + columns - 1) / columns
+	filter := fmt.Sprintf("fps=%.6f,scale=%d:-2,tile=%dx%d", float64(frames)/duration.Seconds(), width, columns, rows)
+	return runner.Run(ctx, "ffmpeg", []string{"-y", "-v", "error", "-i", movieFile, "-vf", filter, "-frames:v", "1", "-q:v", "3", sheetFile}, nil, nil)
+}
+
+// Returns true if the file is a preview written for a movie
+func isPreview(fileName string) bool {
+	_, ok := previewSuffix(fileName)
+	return ok
+}
@@ -0,0 +1,59 @@
+package shrink
+
+import (
+	"context"
+	filepath "path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// timeLapseNamePattern matches the names cameras and apps give time-lapses, eg. Timelapse_20240513.mp4 or hyperlapse-1.mp4
+var timeLapseNamePattern = regexp.MustCompile(`(?i)(time[ _-]?lapse|hyper[ _-]?lapse)`)
+
+// timeLapseHints are words in the tags of time-lapses, lower case, like the capture mode of phones and action cameras
+var timeLapseHints = []string{"timelapse", "time-lapse", "time lapse", "hyperlapse"}
+
+// captureFPSTag is the frame rate Android phones record at, below the frame rate of the movie for time-lapses
+const captureFPSTag = "com.android.capture.fps"
+
+// Time-lapse settings: a keyframe every 600 frames, as their frames change little, and a higher CRF,
+// as they are watched fast and their frames are sharp stills
+const (
+	timeLapseGOP      = 600
+	timeLapseCRFDelta = 2
+)
+
+// TimeLapse guesses if a movie is a time-lapse, from its name or the capture mode or frame rate in its metadata.
+// With byMotion very low motion, most frames repeating the previous one, counts too, which static clips also have.
+func (e Encoder) TimeLapse(ctx context.Context, fileName string, byMotion bool) bool {
+	if timeLapseNamePattern.MatchString(filepath.Base(fileName)) {
+		return true
+	}
+	runner := runnerOrExec(e.Runner)
+	if tags, err := probeTags(ctx, runner, fileName); err == nil {
+		for _, streamTags := range tags {
+			for _, value := range streamTags {
+				for _, hint := range timeLapseHints {
+					if strings.Contains(strings.ToLower(value), hint) {
+						return true
+					}
+				}
+			}
+		}
+		if captureFPS, err := strconv.ParseFloat(tags.Get(captureFPSTag), 64); err == nil && captureFPS > 0 {
+			if info, err := e.Probe(ctx, fileName); err == nil && captureFPS < info.FrameRate/2 {
+				return true
+			}
+		}
+	}
+	return byMotion && e.stillContent(ctx, fileName)
+}
+
+// TimeLapseEncoder returns the built-in profile for time-lapses based on an encoder: long groups of pictures
+// and a higher CRF
+func TimeLapseEncoder(encoder Encoder) Encoder {
+	encoder.GOP = timeLapseGOP
+	encoder.CRF = encoder.Settings().CRF + timeLapseCRFDelta
+	return encoder
+}
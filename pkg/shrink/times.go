@@ -0,0 +1,51 @@
+package shrink
+
+import (
+	"context"
+	"time"
+)
+
+// What shrunk files and their copies at the destinations are dated with, see Options.Times
+const (
+	// TimesCapture dates them with when the movie was shot, so libraries sort them by it
+	TimesCapture = "capture"
+	// TimesEncode dates them with when they were encoded, for backup tools that pick up files by their mod time
+	TimesEncode = "encode"
+	// TimesNone leaves them dated by writing them, copies get the time they were made
+	TimesNone = "none"
+)
+
+// ResultTime returns the time a finished file is dated with under a policy of Times, false if it is left alone
+func ResultTime(times string, captured time.Time) (time.Time, bool) {
+	switch times {
+	case TimesNone:
+		return time.Time{}, false
+	case TimesEncode:
+		return time.Now(), true
+	}
+	return captured, true
+}
+
+// Dates a shrunk file with the times of the options
+func (p *Processor) dateResult(fileName string, captured time.Time) error {
+	modTime, ok := ResultTime(p.Times, captured)
+	if !ok {
+		return nil
+	}
+	return p.FS.Chtimes(fileName, modTime, modTime)
+}
+
+// Returns the time copies of a finished file are dated with, the date of the file itself when it was encoded,
+// zero for destinations to leave them alone
+func (p *Processor) copyTime(ctx context.Context, fileName string) time.Time {
+	switch p.Times {
+	case TimesNone:
+		return time.Time{}
+	case TimesEncode:
+		if stat, err := p.FS.Stat(fileName); err == nil {
+			return stat.ModTime()
+		}
+		return time.Now()
+	}
+	return p.captureTime(ctx, fileName)
+}
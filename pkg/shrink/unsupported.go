@@ -0,0 +1,77 @@
+package shrink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Codec tags of streams encrypted with DRM, FairPlay of the iTunes store and common encryption
+var drmTags = map[string]bool{"drmi": true, "drms": true, "drac": true, "encv": true, "enca": true}
+
+// Unsupported returns why a movie can't be encoded, empty if it can: streams protected with DRM or in codecs ffmpeg
+// can't decode. decodable are the codecs ffmpeg decodes, nil doesn't check them.
+func (e Encoder) Unsupported(ctx context.Context, fileName string, decodable map[string]bool) string {
+	var out bytes.Buffer
+	args := []string{"-v", "error", "-show_entries", "stream=codec_type,codec_name,codec_tag_string", "-of", "json", fileName}
+	if err := runnerOrExec(e.Runner).Run(ctx, "ffprobe", args, &out, nil); err != nil {
+		// unreadable movies fail the encode with ffmpeg's own error
+		return ""
+	}
+	var probe struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+			CodecTag  string `json:"codec_tag_string"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return ""
+	}
+	for _, stream := range probe.Streams {
+		if stream.CodecType != "video" && stream.CodecType != "audio" {
+			continue
+		}
+		if drmTags[strings.ToLower(stream.CodecTag)] {
+			return "DRM protected " + stream.CodecType
+		}
+		if len(stream.CodecName) == 0 || stream.CodecName == "none" {
+			return "unknown " + stream.CodecType + " codec " + stream.CodecTag
+		}
+		if decodable != nil && !decodable[stream.CodecName] {
+			return "ffmpeg can't decode " + stream.CodecType + " codec " + stream.CodecName
+		}
+	}
+	return ""
+}
+
+// DecodableCodecs returns the codecs ffmpeg can decode, nil if it can't tell
+func (e Encoder) DecodableCodecs(ctx context.Context) map[string]bool {
+	var out bytes.Buffer
+	if err := runnerOrExec(e.Runner).Run(ctx, "ffmpeg", []string{"-hide_banner", "-codecs"}, &out, nil); err != nil {
+		return nil
+	}
+	var codecs map[string]bool
+	for _, line := range strings.Split(out.String(), "\n") {
+		// lines look like " DEV.LS h264                 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10", D for decoding
+		fields := strings.Fields(line)
+		if len(fields) < 3 || len(fields[0]) != 6 || strings.Trim(fields[0], "DEVASTIL.") != "" || fields[1] == "=" {
+			continue
+		}
+		if codecs == nil {
+			codecs = make(map[string]bool)
+		}
+		codecs[fields[1]] = fields[0][0] == 'D'
+	}
+	return codecs
+}
+
+// Returns why a movie can't be encoded, empty if it can, asking ffmpeg what it decodes once
+func (p *Processor) unsupported(ctx context.Context, fileName string) string {
+	if isSphericalOriginal(fileName) {
+		return "unstitched 360° recording, stitch it with the camera's software first"
+	}
+	p.decodableOnce.Do(func() { p.decodable = p.Encoder.DecodableCodecs(ctx) })
+	return p.Encoder.Unsupported(ctx, fileName, p.decodable)
+}
@@ -0,0 +1,142 @@
+package shrink
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	filepath "path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// Adds a watch for a dir and all dirs below it, skipping hidden and excluded dirs same as the Scanner.
+// Files the scanner wants already in newly added dirs are passed to found, eg. when a whole folder is moved in.
+// relDir is the path of the dir relative to the input root.
+func addWatches(watcher *fsnotify.Watcher, scanner Scanner, dirName, relDir string, found func(string)) {
+	if err := watcher.Add(dirName); err != nil {
+		log.Error("Unable to watch dir: ", dirName, err)
+		return
+	}
+
+	files, err := ioutil.ReadDir(dirName)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for _, f := range files {
+		name := filepath.Join(dirName, f.Name())
+		if f.IsDir() {
+			if subDir := path.Join(relDir, f.Name()); !scanner.skipDir(name, subDir) {
+				addWatches(watcher, scanner, name, subDir, found)
+			}
+		} else if found != nil && scanner.Wanted(f.Name()) {
+			found(name)
+		}
+	}
+}
+
+// Watch processes movies added below the input dir once they have been unchanged for the settle delay,
+// so files that are still being copied or synced in aren't picked up half written.
+// Runs until Stop is called, then waits for the file currently being processed to finish,
+// or until the context is cancelled which also cancels that file.
+func (p *Processor) Watch(ctx context.Context, settle time.Duration) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// files we wrote ourselves must not be processed again
+	var mu sync.Mutex
+	produced := make(map[string]bool)
+
+	// encode on a separate goroutine so events keep being read during long encodes
+	queue := make(chan string, 1024)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	defer wg.Wait()
+	defer close(queue)
+	go func() {
+		defer wg.Done()
+		for fileName := range queue {
+			// don't start on anything else once we've been asked to stop
+			if err := p.cancelled(ctx); err != nil {
+				return
+			}
+			mu.Lock()
+			skip := produced[fileName]
+			mu.Unlock()
+			if skip {
+				continue
+			}
+			if _, err := os.Stat(fileName); err != nil {
+				continue
+			}
+			result, err := p.ProcessFile(ctx, fileName)
+			if err == nil {
+				mu.Lock()
+				produced[result.Result] = true
+				mu.Unlock()
+			}
+			p.refreshChanged()
+		}
+	}()
+
+	// last time each pending file changed
+	pending := make(map[string]time.Time)
+	for _, dirName := range p.inDirs() {
+		addWatches(watcher, p.Scanner, dirName, "", nil)
+		log.Info("Watching for new movies in: ", dirName)
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-p.stopping:
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+			stat, err := os.Stat(event.Name)
+			if err != nil {
+				continue
+			}
+			if stat.IsDir() {
+				if relDir := p.relName(event.Name); event.Op&fsnotify.Create != 0 && !p.Scanner.skipDir(event.Name, relDir) {
+					addWatches(watcher, p.Scanner, event.Name, relDir, func(name string) { pending[name] = time.Now() })
+				}
+				continue
+			}
+			// a marker file may have been added to a dir that is watched already
+			if p.Scanner.Wanted(event.Name) && !p.Scanner.Protected(event.Name) {
+				pending[event.Name] = time.Now()
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Error("Watch error: ", err)
+
+		case now := <-ticker.C:
+			for fileName, changed := range pending {
+				if now.Sub(changed) >= settle {
+					delete(pending, fileName)
+					queue <- fileName
+				}
+			}
+		}
+	}
+}
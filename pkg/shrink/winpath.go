@@ -0,0 +1,78 @@
+package shrink
+
+import (
+	filepath "path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Windows paths this long need the \\?\ prefix, dirs are limited to 248 characters and files to 260
+const maxPath = 248
+
+// Names windows reserves for devices, with any extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// Returns a path windows accepts beyond MAX_PATH, absolute with the \\?\ prefix.
+// Other paths, and all paths elsewhere, are returned as is.
+func longPath(fileName string) string {
+	if runtime.GOOS != "windows" || len(fileName) < maxPath || strings.HasPrefix(fileName, `\\?\`) {
+		return fileName
+	}
+	abs, err := filepath.Abs(fileName)
+	if err != nil {
+		return fileName
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
+
+// Returns the args of an external program with long paths prefixed, other programs don't handle them like Go does
+func longPathArgs(args []string) []string {
+	if runtime.GOOS != "windows" {
+		return args
+	}
+	fixed := make([]string, len(args))
+	for i, arg := range args {
+		fixed[i] = arg
+		if filepath.IsAbs(arg) {
+			fixed[i] = longPath(arg)
+		}
+	}
+	return fixed
+}
+
+// Returns an absolute path on windows, where drive relative paths like C:movies depend on the current dir of that drive
+func absPath(fileName string) string {
+	if runtime.GOOS != "windows" || len(fileName) == 0 {
+		return fileName
+	}
+	if abs, err := filepath.Abs(fileName); err == nil {
+		return abs
+	}
+	return fileName
+}
+
+// Returns a file name windows can create, replacing characters it doesn't allow and suffixing reserved device names.
+// Names are returned as is elsewhere.
+func safeName(name string) string {
+	if runtime.GOOS != "windows" {
+		return name
+	}
+	name = strings.NewReplacer("<", "_", ">", "_", ":", "_", "\"", "_", "|", "_", "?", "_", "*", "_").Replace(name)
+	// trailing dots and spaces are dropped by windows
+	name = strings.TrimRight(name, ". ")
+	base := name
+	if i := strings.Index(base, "."); i >= 0 {
+		base = base[:i]
+	}
+	if reservedNames[strings.ToUpper(strings.TrimSpace(base))] {
+		name = base + "_" + name[len(base):]
+	}
+	return name
+}
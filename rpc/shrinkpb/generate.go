@@ -0,0 +1,4 @@
+// Package shrinkpb holds the gRPC service definition and generated client and server code for the job API
+package shrinkpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative shrink.proto
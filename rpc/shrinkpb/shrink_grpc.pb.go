@@ -0,0 +1,331 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: shrink.proto
+
+package shrinkpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Shrink_SubmitJob_FullMethodName = "/shrink.v1.Shrink/SubmitJob"
+	Shrink_GetJob_FullMethodName    = "/shrink.v1.Shrink/GetJob"
+	Shrink_ListJobs_FullMethodName  = "/shrink.v1.Shrink/ListJobs"
+	Shrink_CancelJob_FullMethodName = "/shrink.v1.Shrink/CancelJob"
+	Shrink_GetReport_FullMethodName = "/shrink.v1.Shrink/GetReport"
+	Shrink_WatchJob_FullMethodName  = "/shrink.v1.Shrink/WatchJob"
+)
+
+// ShrinkClient is the client API for Shrink service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Shrink submits and tracks jobs on a server started with `shrink-movies serve -grpc-addr`
+type ShrinkClient interface {
+	// SubmitJob queues a directory, file or remote input for processing
+	SubmitJob(ctx context.Context, in *SubmitJobRequest, opts ...grpc.CallOption) (*Job, error)
+	// GetJob returns the status of a job
+	GetJob(ctx context.Context, in *JobRequest, opts ...grpc.CallOption) (*Job, error)
+	// ListJobs returns all queued, running and finished jobs
+	ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsResponse, error)
+	// CancelJob cancels a queued job, or a running job before its next file
+	CancelJob(ctx context.Context, in *JobRequest, opts ...grpc.CallOption) (*Job, error)
+	// GetReport returns the per file results of a job
+	GetReport(ctx context.Context, in *JobRequest, opts ...grpc.CallOption) (*Report, error)
+	// WatchJob streams progress of a job until it has finished
+	WatchJob(ctx context.Context, in *JobRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[JobProgress], error)
+}
+
+type shrinkClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewShrinkClient(cc grpc.ClientConnInterface) ShrinkClient {
+	return &shrinkClient{cc}
+}
+
+func (c *shrinkClient) SubmitJob(ctx context.Context, in *SubmitJobRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, Shrink_SubmitJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shrinkClient) GetJob(ctx context.Context, in *JobRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, Shrink_GetJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shrinkClient) ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListJobsResponse)
+	err := c.cc.Invoke(ctx, Shrink_ListJobs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shrinkClient) CancelJob(ctx context.Context, in *JobRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, Shrink_CancelJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shrinkClient) GetReport(ctx context.Context, in *JobRequest, opts ...grpc.CallOption) (*Report, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Report)
+	err := c.cc.Invoke(ctx, Shrink_GetReport_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shrinkClient) WatchJob(ctx context.Context, in *JobRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[JobProgress], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Shrink_ServiceDesc.Streams[0], Shrink_WatchJob_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[JobRequest, JobProgress]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Shrink_WatchJobClient = grpc.ServerStreamingClient[JobProgress]
+
+// ShrinkServer is the server API for Shrink service.
+// All implementations must embed UnimplementedShrinkServer
+// for forward compatibility.
+//
+// Shrink submits and tracks jobs on a server started with `shrink-movies serve -grpc-addr`
+type ShrinkServer interface {
+	// SubmitJob queues a directory, file or remote input for processing
+	SubmitJob(context.Context, *SubmitJobRequest) (*Job, error)
+	// GetJob returns the status of a job
+	GetJob(context.Context, *JobRequest) (*Job, error)
+	// ListJobs returns all queued, running and finished jobs
+	ListJobs(context.Context, *ListJobsRequest) (*ListJobsResponse, error)
+	// CancelJob cancels a queued job, or a running job before its next file
+	CancelJob(context.Context, *JobRequest) (*Job, error)
+	// GetReport returns the per file results of a job
+	GetReport(context.Context, *JobRequest) (*Report, error)
+	// WatchJob streams progress of a job until it has finished
+	WatchJob(*JobRequest, grpc.ServerStreamingServer[JobProgress]) error
+	mustEmbedUnimplementedShrinkServer()
+}
+
+// UnimplementedShrinkServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedShrinkServer struct{}
+
+func (UnimplementedShrinkServer) SubmitJob(context.Context, *SubmitJobRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitJob not implemented")
+}
+func (UnimplementedShrinkServer) GetJob(context.Context, *JobRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJob not implemented")
+}
+func (UnimplementedShrinkServer) ListJobs(context.Context, *ListJobsRequest) (*ListJobsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListJobs not implemented")
+}
+func (UnimplementedShrinkServer) CancelJob(context.Context, *JobRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelJob not implemented")
+}
+func (UnimplementedShrinkServer) GetReport(context.Context, *JobRequest) (*Report, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetReport not implemented")
+}
+func (UnimplementedShrinkServer) WatchJob(*JobRequest, grpc.ServerStreamingServer[JobProgress]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchJob not implemented")
+}
+func (UnimplementedShrinkServer) mustEmbedUnimplementedShrinkServer() {}
+func (UnimplementedShrinkServer) testEmbeddedByValue()                {}
+
+// UnsafeShrinkServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ShrinkServer will
+// result in compilation errors.
+type UnsafeShrinkServer interface {
+	mustEmbedUnimplementedShrinkServer()
+}
+
+func RegisterShrinkServer(s grpc.ServiceRegistrar, srv ShrinkServer) {
+	// If the following call pancis, it indicates UnimplementedShrinkServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Shrink_ServiceDesc, srv)
+}
+
+func _Shrink_SubmitJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShrinkServer).SubmitJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shrink_SubmitJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShrinkServer).SubmitJob(ctx, req.(*SubmitJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shrink_GetJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(JobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShrinkServer).GetJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shrink_GetJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShrinkServer).GetJob(ctx, req.(*JobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shrink_ListJobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListJobsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShrinkServer).ListJobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shrink_ListJobs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShrinkServer).ListJobs(ctx, req.(*ListJobsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shrink_CancelJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(JobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShrinkServer).CancelJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shrink_CancelJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShrinkServer).CancelJob(ctx, req.(*JobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shrink_GetReport_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(JobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShrinkServer).GetReport(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shrink_GetReport_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShrinkServer).GetReport(ctx, req.(*JobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shrink_WatchJob_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(JobRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ShrinkServer).WatchJob(m, &grpc.GenericServerStream[JobRequest, JobProgress]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Shrink_WatchJobServer = grpc.ServerStreamingServer[JobProgress]
+
+// Shrink_ServiceDesc is the grpc.ServiceDesc for Shrink service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Shrink_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "shrink.v1.Shrink",
+	HandlerType: (*ShrinkServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitJob",
+			Handler:    _Shrink_SubmitJob_Handler,
+		},
+		{
+			MethodName: "GetJob",
+			Handler:    _Shrink_GetJob_Handler,
+		},
+		{
+			MethodName: "ListJobs",
+			Handler:    _Shrink_ListJobs_Handler,
+		},
+		{
+			MethodName: "CancelJob",
+			Handler:    _Shrink_CancelJob_Handler,
+		},
+		{
+			MethodName: "GetReport",
+			Handler:    _Shrink_GetReport_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchJob",
+			Handler:       _Shrink_WatchJob_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "shrink.proto",
+}
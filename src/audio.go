@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+)
+
+// audioFlags are the flags compressing audio files
+type audioFlags struct {
+	audio *bool
+	codec *string
+}
+
+// Adds the flags compressing audio files, they are applied with setupAudio once they are parsed
+func addAudioFlags(flags *flag.FlagSet) *audioFlags {
+	return &audioFlags{
+		audio: flags.Bool("audio", false, "compress wav, aiff and flac audio files with -audio-bitrate as well as shrinking movies"),
+		codec: flags.String("audio-codec", shrink.DefaultAudioFileCodec, "codec audio files are compressed with, aac saved as .m4a or opus saved as .opus"),
+	}
+}
+
+// Sets the audio encoder if -audio is set, failing if the codec is unknown
+func setupAudio(opts *shrink.Options, audio *audioFlags) error {
+	if !*audio.audio {
+		return nil
+	}
+	if *audio.codec != "aac" && *audio.codec != "opus" {
+		return fmt.Errorf("unknown audio codec, use aac or opus: %s", *audio.codec)
+	}
+	opts.Audio = &shrink.AudioEncoder{Codec: *audio.codec, Bitrate: opts.Encoder.AudioBitrate, Runner: opts.Encoder.Runner}
+	return nil
+}
@@ -0,0 +1,927 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	filepath "path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	log "github.com/sirupsen/logrus"
+)
+
+// command is a subcommand of the cli, eg. shrink-movies scan -i ~/Videos
+type command struct {
+	Name    string
+	Summary string
+	// Setup defines the flags of the command and returns what runs it once they are parsed
+	Setup func(flags *flag.FlagSet) func(config *Config)
+}
+
+// commands are listed in this order by help
+var commands = []command{
+	{"run", "shrink every movie below the input and replace the originals that got smaller", process},
+	{"watch", "keep running and shrink movies as they are added", watchCommand},
+	{"serve", "run the job server with its REST, gRPC and web interface", serve},
+	{"service", "install or uninstall daemon mode as a Windows service or launchd agent", serviceCommand},
+	{"scan", "list the movies a run would process", scanCommand},
+	{"encode", "encode movies into another dir, leaving the originals alone", encodeCommand},
+	{"analyze", "show the codec, resolution and bit rate of movies", analyzeCommand},
+	{"verify", "decode movies completely and list the damaged ones", verifyCommand},
+	{"audit", "quickly check the library for truncated, empty and unplayable movies", auditCommand},
+	{"repair", "salvage damaged and interrupted recordings, quarantining those that can't be", repairCommand},
+	{"sheet", "save a contact sheet or preview sprite of each movie", sheetCommand},
+	{"compare", "save frames of an original and its shrunk version side by side", compareCommand},
+	{"hls", "package movies as HLS rendition sets to stream from a web server", hlsCommand},
+	{"report", "summarize the files recorded in a state file", reportCommand},
+	{"version", "print the version, build info and ffmpeg version", versionCommand},
+}
+
+// Returns the flags of a command, including -config, and what runs it
+func commandFlags(cmd command) (*flag.FlagSet, func(*Config)) {
+	flags := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	run := cmd.Setup(flags)
+	flags.String("config", "", "yaml or toml file with settings, keyed by flag name")
+	return flags, run
+}
+
+// Runs a command by name
+func runCommand(name string, args []string) {
+	if name == "help" {
+		if len(args) > 0 {
+			runCommand(args[0], []string{"-h"})
+			return
+		}
+		printCommands()
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			flags, run := commandFlags(cmd)
+			run(parseFlags(flags, args))
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", name)
+	printCommands()
+	os.Exit(exitConfig)
+}
+
+// Prints all commands with their summary
+func printCommands() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.Name, cmd.Summary)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "\nUse \"%s help <command>\" for the flags of a command. Without a command flags are passed to run.\n", filepath.Base(os.Args[0]))
+}
+
+// Same as run with -watch
+func watchCommand(flags *flag.FlagSet) func(*Config) {
+	run := process(flags)
+	watch := flags.Lookup("watch")
+	watch.Value.Set("true")
+	watch.DefValue = "true"
+	return run
+}
+
+// Returns a context that is cancelled on Ctrl-C or SIGTERM, so ffmpeg and uploads are stopped instead of left running
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// Adds the flags every command that reads local movies has, returning the input and log format
+func addInputFlags(flags *flag.FlagSet) (*string, *string) {
+	inPtr := flags.String("i", "", "input file or directory")
+	logFormatPtr := flags.String("log-format", "text", "log format, text or json")
+	return inPtr, logFormatPtr
+}
+
+// Returns the movie itself if the input is a file, or all movies below it if it is a dir
+func findMovies(ctx context.Context, input string) ([]string, error) {
+	return scanMovies(ctx, shrink.Scanner{}, input)
+}
+
+// Returns the movie itself if the input is a file, or all movies the scanner finds below it if it is a dir
+func scanMovies(ctx context.Context, scanner shrink.Scanner, input string) ([]string, error) {
+	if len(input) == 0 {
+		return nil, fmt.Errorf("need to define an input with -i")
+	}
+	stat, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+	if !stat.IsDir() {
+		return []string{input}, nil
+	}
+	return scanner.Scan(ctx, input)
+}
+
+// Adds the repeatable -exclude-dir flag
+func addExcludeFlag(flags *flag.FlagSet) *listFlag {
+	var patterns listFlag
+	flags.Var(&patterns, "exclude-dir", "skip dirs matching this pattern, eg. @eaDir or Backups/**, patterns with a slash match paths relative to -i, can be repeated")
+	return &patterns
+}
+
+// Adds the -marker-files flag
+func addMarkerFlag(flags *flag.FlagSet) *string {
+	return flags.String("marker-files", strings.Join(shrink.DefaultMarkerFiles, ","), "comma separated names of files that protect the dir they are in and the dirs below it from processing, empty for none")
+}
+
+// Returns the names of the marker files
+func setupMarkers(names string) []string {
+	var markers []string
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			markers = append(markers, name)
+		}
+	}
+	return markers
+}
+
+// Returns the exclude patterns, failing if one is malformed
+func setupExclude(patterns *listFlag) ([]string, error) {
+	if err := shrink.ValidateExcludeDirs(*patterns); err != nil {
+		return nil, err
+	}
+	return *patterns, nil
+}
+
+// Lists the movies a run would process, skipping the ones the state says are done
+func scanCommand(flags *flag.FlagSet) func(*Config) {
+	inPtr := flags.String("i", "", "input directory, s3://bucket/prefix or rclone:remote:path")
+	statePtr := flags.String("state", "", "json file remembering processed files, those are left out")
+	logFormatPtr := flags.String("log-format", "text", "log format, text or json")
+	exclude := addExcludeFlag(flags)
+	markersPtr := addMarkerFlag(flags)
+	return func(*Config) {
+		setupLogging(*logFormatPtr)
+		excludeDirs, err := setupExclude(exclude)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		scanner := shrink.Scanner{ExcludeDirs: excludeDirs, MarkerFiles: setupMarkers(*markersPtr)}
+		ctx, cancel := signalContext()
+		defer cancel()
+
+		var names []string
+		if IsRemoteInput(*inPtr) {
+			var remote shrink.Remote
+			if remote, err = NewRemote(*inPtr); err == nil {
+				names, err = remote.List(ctx)
+			}
+		} else {
+			names, err = scanMovies(ctx, scanner, *inPtr)
+		}
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+
+		var state *shrink.State
+		if len(*statePtr) > 0 {
+			if state, err = shrink.LoadState(*statePtr); err != nil {
+				fatal(exitConfig, "Unable to load state: ", err)
+			}
+		}
+		found := 0
+		for _, name := range names {
+			done := state.Done(name)
+			if IsRemoteInput(*inPtr) {
+				done = state.DoneRemote(*inPtr, name)
+			}
+			if !done {
+				fmt.Println(name)
+				found++
+			}
+		}
+		if found == 0 {
+			exitCode = exitNothingToDo
+		}
+	}
+}
+
+// Encodes movies into the output dir without touching the originals
+func encodeCommand(flags *flag.FlagSet) func(*Config) {
+	inPtr, logFormatPtr := addInputFlags(flags)
+	outPtr := flags.String("o", "", "directory to write the encoded movies to")
+	timesPtr := addTimesFlag(flags)
+	encoder := addEncoderFlags(flags)
+	tools := addFFmpegFlags(flags)
+	return func(*Config) {
+		setupLogging(*logFormatPtr)
+		if len(*outPtr) == 0 {
+			fatal(exitConfig, "Error, need to define an output directory.")
+		}
+		times, err := setupTimes(*timesPtr)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		ctx, cancel := signalContext()
+		defer cancel()
+		encoder.Runner = tools.Runner()
+
+		fileNames, err := findMovies(ctx, *inPtr)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		if len(fileNames) == 0 {
+			log.Info("No movies found: ", *inPtr)
+			exitCode = exitNothingToDo
+			return
+		}
+		failed := false
+		for _, fileName := range fileNames {
+			name := filepath.Base(fileName)
+			if rel, err := filepath.Rel(*inPtr, fileName); err == nil && rel != "." {
+				name = rel
+			}
+			destFile := filepath.Join(*outPtr, strings.TrimSuffix(name, filepath.Ext(name))+".mp4")
+			if err := os.MkdirAll(filepath.Dir(destFile), 0755); err != nil {
+				log.Fatal(err)
+			}
+			if err := encoder.Encode(ctx, fileName, destFile, nil); err != nil {
+				os.Remove(destFile)
+				if ctx.Err() != nil {
+					log.Info("Cancelled: ", fileName)
+					exitCode = exitInterrupted
+					return
+				}
+				log.Error("Could not run ffmpeg on file: ", fileName, err)
+				failed = true
+				continue
+			}
+			if modTime, ok := shrink.ResultTime(times, encoder.CaptureTime(ctx, fileName)); ok {
+				os.Chtimes(destFile, modTime, modTime)
+			}
+			log.Info("Encoded File: ", fileName, " ratio: ", float64(shrink.FileSize(destFile))/float64(shrink.FileSize(fileName)))
+		}
+		if failed {
+			exitCode = exitFailures
+		}
+	}
+}
+
+// estimateFlag is -estimate of analyze, on its own it guesses the savings from bit rates,
+// -estimate=0.1 encodes samples of that fraction of the movies
+type estimateFlag struct {
+	on       bool
+	fraction float64
+}
+
+// String returns the fraction sampled, or whether estimating is on
+func (e *estimateFlag) String() string {
+	if e == nil || e.fraction == 0 {
+		return strconv.FormatBool(e != nil && e.on)
+	}
+	return strconv.FormatFloat(e.fraction, 'g', -1, 64)
+}
+
+// Set takes true or false, or the fraction of the movies to sample from 0 to 1
+func (e *estimateFlag) Set(value string) error {
+	switch value {
+	case "true":
+		e.on, e.fraction = true, 0
+		return nil
+	case "false":
+		e.on, e.fraction = false, 0
+		return nil
+	}
+	fraction, err := strconv.ParseFloat(value, 64)
+	if err != nil || fraction < 0 || fraction > 1 {
+		return fmt.Errorf("not true, false or a fraction from 0 to 1: %s", value)
+	}
+	e.on, e.fraction = fraction > 0, fraction
+	return nil
+}
+
+// IsBoolFlag lets -estimate be given without a value
+func (e *estimateFlag) IsBoolFlag() bool {
+	return true
+}
+
+// sampleFlag is the -sample alias of -estimate, which needs a fraction
+type sampleFlag struct {
+	*estimateFlag
+}
+
+// IsBoolFlag makes -sample take the next argument as its fraction
+func (s sampleFlag) IsBoolFlag() bool {
+	return false
+}
+
+// Prints what ffprobe knows about each movie, and with -estimate how much shrinking them would save per dir
+func analyzeCommand(flags *flag.FlagSet) func(*Config) {
+	inPtr, logFormatPtr := addInputFlags(flags)
+	jsonPtr := flags.Bool("json", false, "print json lines instead of a table")
+	estimate := &estimateFlag{}
+	flags.Var(estimate, "estimate", "estimate the savings per dir with the encoder settings, -estimate=0.1 by encoding a few seconds of that fraction of the movies and scaling the estimates of the others by them, =1 samples all")
+	flags.Var(sampleFlag{estimate}, "sample", "same as -estimate=fraction")
+	topPtr := flags.Int("top", 0, "also list the n largest movies and the n with the largest estimated savings")
+	inventoryPtr := flags.Bool("inventory", false, "also summarize the movies by codec, container, resolution and year")
+	wattsPtr := flags.Float64("watts", 0, "with -estimate=fraction, the power the machine draws while encoding in watts, to estimate the energy of the run")
+	pricePtr := flags.Float64("kwh-price", 0, "with -watts, the price of a kWh to estimate the cost of the run")
+	encoder := addEncoderFlags(flags)
+	tools := addFFmpegFlags(flags)
+	return func(*Config) {
+		setupLogging(*logFormatPtr)
+		ctx, cancel := signalContext()
+		defer cancel()
+		encoder.Runner = tools.Runner()
+		prober := *encoder
+		if *wattsPtr < 0 || *pricePtr < 0 {
+			fatal(exitConfig, "Error, -watts and -kwh-price can't be negative.")
+		}
+		sampler := &sampler{Encoder: *encoder, Fraction: estimate.fraction}
+		if sampler.Fraction > 0 {
+			tmpDir, _ := ioutil.TempDir("", "shrink-sample")
+			defer os.RemoveAll(tmpDir)
+			sampler.TmpDir = tmpDir
+		}
+
+		fileNames, err := findMovies(ctx, *inPtr)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		if len(fileNames) == 0 {
+			exitCode = exitNothingToDo
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		if !*jsonPtr {
+			fmt.Fprintln(w, "FILE\tCODEC\tRESOLUTION\tDURATION\tSIZE MB\tMBIT/S")
+		}
+		dirs := make(map[string]*dirEstimate)
+		var files []fileEstimate
+		inventory := make(map[string]map[string]*inventoryEntry)
+		for _, fileName := range fileNames {
+			info, err := prober.Probe(ctx, fileName)
+			if err != nil {
+				if ctx.Err() != nil {
+					exitCode = exitInterrupted
+					break
+				}
+				log.Error("Could not probe file: ", fileName, err)
+				exitCode = exitFailures
+				continue
+			}
+			saved := shrink.Savings(info, sampler.Estimate(ctx, fileName, info), shrink.DefaultMaxRatio)
+			dir := filepath.Dir(fileName)
+			if dirs[dir] == nil {
+				dirs[dir] = &dirEstimate{}
+			}
+			dirs[dir].Files++
+			dirs[dir].Size += info.Size
+			dirs[dir].Saved += saved
+			dirs[dir].Pixels += moviePixels(info)
+			files = append(files, fileEstimate{fileName, info.Size, saved})
+			if *inventoryPtr {
+				year := strconv.Itoa(encoder.CaptureTime(ctx, fileName).Year())
+				container := strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))
+				for _, group := range [][2]string{{"codec", info.Codec}, {"container", container}, {"resolution", resolutionClass(info)}, {"year", year}} {
+					if inventory[group[0]] == nil {
+						inventory[group[0]] = make(map[string]*inventoryEntry)
+					}
+					if inventory[group[0]][group[1]] == nil {
+						inventory[group[0]][group[1]] = &inventoryEntry{}
+					}
+					inventory[group[0]][group[1]].Files++
+					inventory[group[0]][group[1]].Size += info.Size
+				}
+			}
+			if *jsonPtr {
+				var estimated *int64
+				if estimate.on {
+					estimated = &saved
+				}
+				var seconds *float64
+				if encodeTime := sampler.EncodeTime(moviePixels(info)); encodeTime > 0 {
+					s := encodeTime.Seconds()
+					seconds = &s
+				}
+				json.NewEncoder(os.Stdout).Encode(struct {
+					File string `json:"file"`
+					shrink.Info
+					Saved   *int64   `json:"estimatedSavings,omitempty"`
+					Seconds *float64 `json:"estimatedEncodeSeconds,omitempty"`
+				}{fileName, info, estimated, seconds})
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%dx%d\t%s\t%.1f\t%.1f\n", fileName, info.Codec, info.Width, info.Height,
+				info.Duration.Round(time.Second), float64(info.Size)/(1<<20), float64(info.BitRate)/1e6)
+		}
+		w.Flush()
+		if (estimate.on) && !*jsonPtr {
+			printEstimates(dirs, encoder.Settings())
+			printEncodeCost(dirs, sampler, *wattsPtr, *pricePtr)
+		}
+		if *topPtr > 0 && !*jsonPtr {
+			printTop(files, *topPtr)
+		}
+		if *inventoryPtr && !*jsonPtr {
+			printInventory(inventory)
+		}
+	}
+}
+
+// dirEstimate adds up the movies of a dir and the estimated savings on them
+type dirEstimate struct {
+	Files       int
+	Size, Saved int64
+	// Pixels are the pixels of all frames of the movies, to estimate the time to encode them
+	Pixels float64
+}
+
+// Prints the estimated savings per dir and in total
+func printEstimates(dirs map[string]*dirEstimate, settings shrink.EncodeSettings) {
+	var dirNames []string
+	var total dirEstimate
+	for dir, estimate := range dirs {
+		dirNames = append(dirNames, dir)
+		total.Files += estimate.Files
+		total.Size += estimate.Size
+		total.Saved += estimate.Saved
+	}
+	sort.Strings(dirNames)
+	crf := strconv.Itoa(settings.CRF)
+	if settings.CRF == shrink.AutoCRF {
+		crf = "auto"
+	}
+	fmt.Printf("\nEstimated savings with %s crf %s:\n", settings.Codec, crf)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DIR\tFILES\tSIZE GB\tSAVED GB\tSAVED %")
+	for _, dir := range dirNames {
+		dirs[dir].print(w, dir)
+	}
+	total.print(w, "total")
+	w.Flush()
+}
+
+// sampler estimates the size of movies by encoding samples of some of them, the others are estimated from their bit rate
+// and scaled by how far off that was for the sampled ones
+type sampler struct {
+	Encoder shrink.Encoder
+	// Fraction of the movies to sample, none if zero
+	Fraction float64
+	TmpDir   string
+
+	movies, sampled   int
+	measured, guessed int64
+	// pixels encoded by the samples and the time it took, to measure the encode speed
+	sampledPixels float64
+	sampledTime   time.Duration
+}
+
+// Estimate returns the estimated size of a movie once it is encoded
+func (s *sampler) Estimate(ctx context.Context, fileName string, info shrink.Info) int64 {
+	guess := s.Encoder.Estimate(info)
+	s.movies++
+	// sample evenly spread movies, starting with the first
+	if s.Fraction > 0 && float64(s.sampled) < s.Fraction*float64(s.movies) {
+		start := time.Now()
+		estimate, err := s.Encoder.SampleEstimate(ctx, fileName, info, s.TmpDir)
+		if err == nil {
+			s.sampled++
+			if info.Duration > 0 {
+				s.sampledPixels += moviePixels(info) * shrink.SampledDuration(info).Seconds() / info.Duration.Seconds()
+				s.sampledTime += time.Since(start)
+			}
+			s.measured += estimate
+			s.guessed += guess
+			log.Debug("Sampled: ", fileName, " estimate: ", estimate, " from bit rate: ", guess)
+			return estimate
+		}
+		log.Error("Could not sample file: ", fileName, err)
+	}
+	if s.guessed > 0 {
+		return int64(float64(guess) * float64(s.measured) / float64(s.guessed))
+	}
+	return guess
+}
+
+// EncodeTime returns how long encoding pixels takes at the speed measured on the samples, zero if none were encoded
+func (s *sampler) EncodeTime(pixels float64) time.Duration {
+	if s.sampledPixels <= 0 {
+		return 0
+	}
+	return time.Duration(pixels / s.sampledPixels * float64(s.sampledTime))
+}
+
+// Returns the pixels of all frames of a movie, at 30 frames per second if its frame rate isn't known
+func moviePixels(info shrink.Info) float64 {
+	fps := info.FrameRate
+	if fps == 0 {
+		fps = 30
+	}
+	return float64(info.Width*info.Height) * fps * info.Duration.Seconds()
+}
+
+// Prints how long encoding all movies takes at the speed of the samples, and with watts the energy it uses and its cost
+func printEncodeCost(dirs map[string]*dirEstimate, s *sampler, watts, price float64) {
+	var pixels float64
+	for _, estimate := range dirs {
+		pixels += estimate.Pixels
+	}
+	encodeTime := s.EncodeTime(pixels)
+	if encodeTime <= 0 {
+		fmt.Println("\nSample movies with -estimate=0.1 to estimate the encode time.")
+		return
+	}
+	speed := s.sampledPixels / s.sampledTime.Seconds() / 1e6
+	fmt.Printf("\nEstimated encode time: %s, at %.1f megapixels/s measured on %d samples\n", encodeTime.Round(time.Minute), speed, s.sampled)
+	if watts > 0 {
+		kwh := watts * encodeTime.Hours() / 1000
+		fmt.Printf("Estimated energy: %.2f kWh at %.0f W", kwh, watts)
+		if price > 0 {
+			fmt.Printf(", costing %.2f", kwh*price)
+		}
+		fmt.Println()
+	}
+}
+
+// inventoryEntry counts the movies with a codec, container, resolution or year
+type inventoryEntry struct {
+	Files int
+	Size  int64
+}
+
+// Returns the resolution class of a movie by its short side, eg. 1080p
+func resolutionClass(info shrink.Info) string {
+	lines := info.Height
+	if info.Width < lines {
+		lines = info.Width
+	}
+	for _, class := range []int{2160, 1440, 1080, 720, 480} {
+		if lines >= class {
+			return strconv.Itoa(class) + "p"
+		}
+	}
+	if lines == 0 {
+		return "unknown"
+	}
+	return "SD"
+}
+
+// Prints the number and size of the movies by codec, container, resolution and year, largest first
+func printInventory(inventory map[string]map[string]*inventoryEntry) {
+	for _, group := range []string{"codec", "container", "resolution", "year"} {
+		entries := inventory[group]
+		var keys []string
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if entries[keys[i]].Size != entries[keys[j]].Size {
+				return entries[keys[i]].Size > entries[keys[j]].Size
+			}
+			return keys[i] < keys[j]
+		})
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\tFILES\tSIZE GB\n", strings.ToUpper(group))
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%d\t%.1f\n", key, entries[key].Files, float64(entries[key].Size)/(1<<30))
+		}
+		w.Flush()
+	}
+}
+
+// fileEstimate is the size of a movie and the estimated savings on it
+type fileEstimate struct {
+	File        string
+	Size, Saved int64
+}
+
+// Prints the n largest movies and the n with the largest estimated savings, to pick what to shrink first
+func printTop(files []fileEstimate, n int) {
+	list := func(title string, less func(a, b fileEstimate) bool) {
+		sorted := append([]fileEstimate(nil), files...)
+		sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+		if len(sorted) > n {
+			sorted = sorted[:n]
+		}
+		fmt.Printf("\n%s:\n", title)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE\tSIZE MB\tSAVED MB")
+		for _, file := range sorted {
+			fmt.Fprintf(w, "%s\t%.1f\t%.1f\n", file.File, float64(file.Size)/(1<<20), float64(file.Saved)/(1<<20))
+		}
+		w.Flush()
+	}
+	list("Largest movies", func(a, b fileEstimate) bool { return a.Size > b.Size })
+	list("Largest estimated savings", func(a, b fileEstimate) bool { return a.Saved > b.Saved })
+}
+
+// Prints a row of the estimates table
+func (d *dirEstimate) print(w io.Writer, name string) {
+	percent := 0.0
+	if d.Size > 0 {
+		percent = 100 * float64(d.Saved) / float64(d.Size)
+	}
+	fmt.Fprintf(w, "%s\t%d\t%.1f\t%.1f\t%.0f\n", name, d.Files, float64(d.Size)/(1<<30), float64(d.Saved)/(1<<30), percent)
+}
+
+// Decodes each movie and lists the damaged ones, exiting with exitFailures if there are any
+func verifyCommand(flags *flag.FlagSet) func(*Config) {
+	inPtr, logFormatPtr := addInputFlags(flags)
+	tools := addFFmpegFlags(flags)
+	return func(*Config) {
+		setupLogging(*logFormatPtr)
+		ctx, cancel := signalContext()
+		defer cancel()
+		verifier := shrink.Encoder{Runner: tools.Runner()}
+
+		fileNames, err := findMovies(ctx, *inPtr)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		if len(fileNames) == 0 {
+			exitCode = exitNothingToDo
+			return
+		}
+		damaged := 0
+		for _, fileName := range fileNames {
+			if err := verifier.Verify(ctx, fileName); err != nil {
+				if ctx.Err() != nil {
+					log.Info("Cancelled")
+					exitCode = exitInterrupted
+					return
+				}
+				fmt.Printf("%s: %v\n", fileName, err)
+				damaged++
+			} else {
+				log.Debug("OK: ", fileName)
+			}
+		}
+		log.Info("Verified ", len(fileNames), " files, damaged: ", damaged)
+		if damaged > 0 {
+			exitCode = exitFailures
+		}
+	}
+}
+
+// Checks each movie with ffprobe, and with -decode by decoding samples, listing the damaged ones.
+// It exits with exitFailures if there are any.
+func auditCommand(flags *flag.FlagSet) func(*Config) {
+	inPtr, logFormatPtr := addInputFlags(flags)
+	decodePtr := flags.Bool("decode", false, "also decode a few seconds at the start, middle and end of each movie")
+	jsonPtr := flags.Bool("json", false, "print json lines instead of a table")
+	tools := addFFmpegFlags(flags)
+	return func(*Config) {
+		setupLogging(*logFormatPtr)
+		ctx, cancel := signalContext()
+		defer cancel()
+		checker := shrink.Encoder{Runner: tools.Runner()}
+
+		fileNames, err := findMovies(ctx, *inPtr)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		if len(fileNames) == 0 {
+			exitCode = exitNothingToDo
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		if !*jsonPtr {
+			fmt.Fprintln(w, "FILE\tPROBLEM")
+		}
+		damaged := 0
+		for _, fileName := range fileNames {
+			problems := checker.Check(ctx, fileName)
+			if len(problems) == 0 && *decodePtr {
+				if info, err := checker.Probe(ctx, fileName); err == nil {
+					if err := checker.VerifySamples(ctx, fileName, info.Duration); err != nil {
+						problems = append(problems, "undecodable "+err.Error())
+					}
+				}
+			}
+			if ctx.Err() != nil {
+				log.Info("Cancelled")
+				exitCode = exitInterrupted
+				break
+			}
+			if len(problems) == 0 {
+				log.Debug("OK: ", fileName)
+				continue
+			}
+			damaged++
+			if *jsonPtr {
+				json.NewEncoder(os.Stdout).Encode(struct {
+					File     string   `json:"file"`
+					Problems []string `json:"problems"`
+				}{fileName, problems})
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\n", fileName, strings.Join(problems, ", "))
+		}
+		w.Flush()
+		log.Info("Audited ", len(fileNames), " files, damaged: ", damaged)
+		if damaged > 0 && exitCode == exitOK {
+			exitCode = exitFailures
+		}
+	}
+}
+
+// Saves frames or clips of an original and its shrunk version side by side, to judge the quality of the settings
+func compareCommand(flags *flag.FlagSet) func(*Config) {
+	originalPtr := flags.String("a", "", "the original movie")
+	shrunkPtr := flags.String("b", "", "the shrunk movie")
+	outPtr := flags.String("o", ".", "directory to save the comparisons to")
+	framesPtr := flags.Int("frames", 5, "number of comparisons spread across the movie")
+	heightPtr := flags.Int("height", 720, "lines both movies are scaled to")
+	clipPtr := flags.Duration("clip", 0, "save clips of this length instead of stills, eg. 5s")
+	logFormatPtr := flags.String("log-format", "text", "log format, text or json")
+	tools := addFFmpegFlags(flags)
+	return func(*Config) {
+		setupLogging(*logFormatPtr)
+		if len(*originalPtr) == 0 || len(*shrunkPtr) == 0 {
+			fatal(exitConfig, "Error, need to define the original with -a and the shrunk movie with -b.")
+		}
+		if *framesPtr < 1 || *heightPtr < 1 {
+			fatal(exitConfig, "Error, -frames and -height must be positive.")
+		}
+		ctx, cancel := signalContext()
+		defer cancel()
+		encoder := shrink.Encoder{Runner: tools.Runner()}
+		if err := os.MkdirAll(*outPtr, 0755); err != nil {
+			fatal(exitConfig, err)
+		}
+
+		saved, err := encoder.Compare(ctx, *originalPtr, *shrunkPtr, *outPtr, *framesPtr, *heightPtr, *clipPtr)
+		for _, fileName := range saved {
+			fmt.Println(fileName)
+		}
+		if ctx.Err() != nil {
+			exitCode = exitInterrupted
+		} else if err != nil {
+			log.Error("Could not compare: ", err)
+			exitCode = exitFailures
+		}
+	}
+}
+
+// Saves a contact sheet of each movie next to it or below the output dir, skipping those that have one
+func sheetCommand(flags *flag.FlagSet) func(*Config) {
+	inPtr, logFormatPtr := addInputFlags(flags)
+	outPtr := flags.String("o", "", "directory to save the sheets to, next to the movies if empty")
+	framesPtr := flags.Int("frames", 16, "number of frames spread across each movie")
+	columnsPtr := flags.Int("columns", 4, "frames per row of the sheet")
+	widthPtr := flags.Int("width", 320, "width of each frame in pixels")
+	spritePtr := flags.Bool("sprite", false, "save all frames in one row as <name>-sprite.jpg for hover previews, instead of <name>-sheet.jpg")
+	tools := addFFmpegFlags(flags)
+	return func(*Config) {
+		setupLogging(*logFormatPtr)
+		if *framesPtr < 1 || *columnsPtr < 1 || *widthPtr < 1 {
+			fatal(exitConfig, "Error, -frames, -columns and -width must be positive.")
+		}
+		columns := *columnsPtr
+		if *spritePtr {
+			columns = *framesPtr
+		}
+		ctx, cancel := signalContext()
+		defer cancel()
+		encoder := shrink.Encoder{Runner: tools.Runner()}
+
+		fileNames, err := findMovies(ctx, *inPtr)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		saved := 0
+		for _, fileName := range fileNames {
+			sheetFile := shrink.ContactSheetName(fileName, *spritePtr)
+			if len(*outPtr) > 0 {
+				name := filepath.Base(sheetFile)
+				if rel, err := filepath.Rel(*inPtr, sheetFile); err == nil && rel != "." && rel != name {
+					name = rel
+				}
+				sheetFile = filepath.Join(*outPtr, name)
+			}
+			if _, err := os.Stat(sheetFile); err == nil {
+				log.Debug("Sheet exists: ", sheetFile)
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(sheetFile), 0755); err != nil {
+				log.Fatal(err)
+			}
+			if err := encoder.ContactSheet(ctx, fileName, sheetFile, *framesPtr, columns, *widthPtr); err != nil {
+				os.Remove(sheetFile)
+				if ctx.Err() != nil {
+					log.Info("Cancelled: ", fileName)
+					exitCode = exitInterrupted
+					return
+				}
+				log.Error("Could not save sheet: ", fileName, err)
+				exitCode = exitFailures
+				continue
+			}
+			log.Info("Saved sheet: ", sheetFile)
+			saved++
+		}
+		if saved == 0 && exitCode == exitOK {
+			exitCode = exitNothingToDo
+		}
+	}
+}
+
+// Packages each movie as an HLS rendition set in a dir below the output named after it, skipping those that have one
+func hlsCommand(flags *flag.FlagSet) func(*Config) {
+	inPtr, logFormatPtr := addInputFlags(flags)
+	outPtr := flags.String("o", "", "directory to save the rendition sets to, with the dirs of the movies below the input")
+	renditionsPtr := flags.String("renditions", "1080:5M,720:2800k,480:1400k,360:800k", "height:bitrate of each rendition, those taller than a movie are left out")
+	tools := addFFmpegFlags(flags)
+	return func(*Config) {
+		setupLogging(*logFormatPtr)
+		if len(*outPtr) == 0 {
+			fatal(exitConfig, "Error, need to define an output directory.")
+		}
+		renditions, err := shrink.ParseHLSRenditions(*renditionsPtr)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		ctx, cancel := signalContext()
+		defer cancel()
+		encoder := shrink.Encoder{Runner: tools.Runner()}
+
+		fileNames, err := findMovies(ctx, *inPtr)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		packaged := 0
+		for _, fileName := range fileNames {
+			name := filepath.Base(fileName)
+			if rel, err := filepath.Rel(*inPtr, fileName); err == nil && rel != "." {
+				name = rel
+			}
+			dir := filepath.Join(*outPtr, strings.TrimSuffix(name, filepath.Ext(name)))
+			if _, err := os.Stat(filepath.Join(dir, "master.m3u8")); err == nil {
+				log.Debug("Already packaged: ", fileName)
+				continue
+			}
+			if err := encoder.PackageHLS(ctx, fileName, dir, renditions); err != nil {
+				if ctx.Err() != nil {
+					log.Info("Cancelled: ", fileName)
+					exitCode = exitInterrupted
+					return
+				}
+				log.Error("Could not package file: ", fileName, err)
+				exitCode = exitFailures
+				continue
+			}
+			log.Info("Packaged File: ", fileName, " in: ", dir)
+			packaged++
+		}
+		if packaged == 0 && exitCode == exitOK {
+			exitCode = exitNothingToDo
+		}
+	}
+}
+
+// Prints the files recorded in a state file and the space saved on them
+func reportCommand(flags *flag.FlagSet) func(*Config) {
+	statePtr := flags.String("state", "", "json file remembering processed files")
+	logFormatPtr := flags.String("log-format", "text", "log format, text or json")
+	return func(*Config) {
+		setupLogging(*logFormatPtr)
+		if len(*statePtr) == 0 {
+			fatal(exitConfig, "Error, need to define a state file.")
+		}
+
+		state, err := shrink.LoadState(*statePtr)
+		if err != nil {
+			fatal(exitConfig, "Unable to load state: ", err)
+		}
+		var fileNames []string
+		for fileName := range state.Files {
+			fileNames = append(fileNames, fileName)
+		}
+		sort.Strings(fileNames)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE\tPROCESSED\tSIZE MB\tRATIO")
+		var size, saved int64
+		for _, fileName := range fileNames {
+			entry := state.Files[fileName]
+			fmt.Fprintf(w, "%s\t%s\t%.1f\t%.2f\n", fileName, entry.Processed.Format("2006-01-02 15:04"), float64(entry.Size)/(1<<20), entry.Ratio)
+			size += entry.Size
+			// only files that were replaced saved anything
+			if entry.Ratio > 0 && entry.Ratio < shrink.DefaultMaxRatio {
+				saved += int64(float64(entry.Size)/entry.Ratio) - entry.Size
+			}
+		}
+		w.Flush()
+		fmt.Printf("\n%d files, %.1f GB, saved about %.1f GB\n", len(fileNames), float64(size)/(1<<30), float64(saved)/(1<<30))
+	}
+}
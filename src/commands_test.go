@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+func TestEstimateFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		on       bool
+		fraction float64
+		wantErr  bool
+	}{
+		{nil, false, 0, false},
+		{[]string{"-estimate"}, true, 0, false},
+		{[]string{"-estimate=0.1"}, true, 0.1, false},
+		{[]string{"-estimate=1"}, true, 1, false},
+		{[]string{"-estimate=0"}, false, 0, false},
+		{[]string{"-estimate=false"}, false, 0, false},
+		{[]string{"-sample", "0.25"}, true, 0.25, false},
+		{[]string{"-estimate=2"}, false, 0, true},
+		{[]string{"-estimate=some"}, false, 0, true},
+	}
+	for _, test := range tests {
+		flags := flag.NewFlagSet("analyze", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		estimate := &estimateFlag{}
+		flags.Var(estimate, "estimate", "")
+		flags.Var(sampleFlag{estimate}, "sample", "")
+		err := flags.Parse(test.args)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parsing %q: error = %v, want an error: %v", test.args, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && (estimate.on != test.on || estimate.fraction != test.fraction) {
+			t.Errorf("parsing %q = %v, %v, want %v, %v", test.args, estimate.on, estimate.fraction, test.on, test.fraction)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	filepath "path/filepath"
+	"strings"
+)
+
+func init() {
+	// added here as the completion refers back to the list of commands
+	commands = append(commands, command{"completion", "print a bash, zsh, fish or powershell completion script", completionCommand})
+}
+
+// completion is what a completion script offers, taken from the commands and the config file
+type completion struct {
+	// Program is the name the script completes
+	Program  string
+	Commands []command
+	// Flags of each command, including -config
+	Flags    map[string][]*flag.Flag
+	Profiles []string
+}
+
+// Returns the flags of a command in the order they print in
+func flagsOf(cmd command) []*flag.Flag {
+	flags, _ := commandFlags(cmd)
+	var list []*flag.Flag
+	flags.VisitAll(func(f *flag.Flag) {
+		list = append(list, f)
+	})
+	return list
+}
+
+// Returns the flag names of a command with their dash, separated by spaces
+func (c completion) flagWords(name string) string {
+	var words []string
+	for _, f := range c.Flags[name] {
+		words = append(words, "-"+f.Name)
+	}
+	return strings.Join(words, " ")
+}
+
+// Returns the command names, separated by spaces
+func (c completion) commandWords() string {
+	var words []string
+	for _, cmd := range c.Commands {
+		words = append(words, cmd.Name)
+	}
+	return strings.Join(append(words, "help"), " ")
+}
+
+// Writes a bash completion script
+func (c completion) bash(w io.Writer) {
+	fn := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(c.Program)
+	fmt.Fprintf(w, "# bash completion for %s, eg. source <(%s completion bash)\n", c.Program, c.Program)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" flags`)
+	fmt.Fprintf(w, "\tif [ \"$prev\" = -profile ] || [ \"$prev\" = --profile ]; then\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn\n\tfi\n", strings.Join(c.Profiles, " "))
+	fmt.Fprintf(w, "\tif [ \"$COMP_CWORD\" -eq 1 ] && [[ \"$cur\" != -* ]]; then\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn\n\tfi\n", c.commandWords())
+	fmt.Fprintln(w, `	case "${COMP_WORDS[1]}" in`)
+	for _, cmd := range c.Commands {
+		fmt.Fprintf(w, "\t%s) flags=%q ;;\n", cmd.Name, c.flagWords(cmd.Name))
+	}
+	fmt.Fprintf(w, "\t*) flags=%q ;;\n", c.flagWords("run"))
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	if [[ "$cur" == -* ]]; then`)
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -W "$flags" -- "$cur"))`)
+	fmt.Fprintln(w, "\telse")
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -f -- "$cur"))`)
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintf(w, "complete -o filenames -F %s %s\n", fn, c.Program)
+}
+
+// Writes a zsh completion script, zsh runs the bash one through bashcompinit
+func (c completion) zsh(w io.Writer) {
+	fmt.Fprintf(w, "# zsh completion for %s, eg. source <(%s completion zsh)\n", c.Program, c.Program)
+	fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit")
+	c.bash(w)
+}
+
+// Writes a fish completion script
+func (c completion) fish(w io.Writer) {
+	fmt.Fprintf(w, "# fish completion for %s, eg. %s completion fish | source\n", c.Program, c.Program)
+	fmt.Fprintf(w, "complete -c %s -f -n __fish_use_subcommand -a help -d 'show the flags of a command'\n", c.Program)
+	for _, cmd := range c.Commands {
+		fmt.Fprintf(w, "complete -c %s -f -n __fish_use_subcommand -a %s -d %s\n", c.Program, cmd.Name, fishQuote(cmd.Summary))
+	}
+	for _, cmd := range c.Commands {
+		condition := "'__fish_seen_subcommand_from " + cmd.Name + "'"
+		if cmd.Name == "run" {
+			// flags without a command are passed to run
+			condition = "'__fish_seen_subcommand_from run; or __fish_use_subcommand'"
+		}
+		for _, f := range c.Flags[cmd.Name] {
+			args := ""
+			if f.Name == "profile" {
+				args = " -x -a " + fishQuote(strings.Join(c.Profiles, " "))
+			}
+			fmt.Fprintf(w, "complete -c %s -n %s -o %s -d %s%s\n", c.Program, condition, f.Name, fishQuote(f.Usage), args)
+		}
+	}
+}
+
+// Quotes a string for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// Writes a powershell completion script
+func (c completion) powershell(w io.Writer) {
+	fmt.Fprintf(w, "# powershell completion for %s, eg. %s completion powershell | Out-String | Invoke-Expression\n", c.Program, c.Program)
+	fmt.Fprintf(w, "Register-ArgumentCompleter -Native -CommandName '%s', '%s.exe' -ScriptBlock {\n", c.Program, c.Program)
+	fmt.Fprintln(w, "    param($wordToComplete, $commandAst, $cursorPosition)")
+	fmt.Fprintln(w, "    $flags = @{")
+	for _, cmd := range c.Commands {
+		fmt.Fprintf(w, "        '%s' = '%s' -split ' '\n", cmd.Name, c.flagWords(cmd.Name))
+	}
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintf(w, "    $commands = '%s' -split ' '\n", c.commandWords())
+	fmt.Fprintf(w, "    $profiles = '%s' -split ' '\n", strings.Join(c.Profiles, " "))
+	fmt.Fprintln(w, "    $words = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })")
+	fmt.Fprintln(w, "    $prev = if ($wordToComplete) { $words[-2] } else { $words[-1] }")
+	fmt.Fprintln(w, "    $cmd = if ($words.Count -gt 1 -and $flags.ContainsKey($words[1])) { $words[1] } else { 'run' }")
+	fmt.Fprintln(w, "    $candidates = if ($prev -eq '-profile' -or $prev -eq '--profile') { $profiles }")
+	fmt.Fprintln(w, "        elseif ($words.Count -le 2 -and -not $wordToComplete.StartsWith('-')) { $commands }")
+	fmt.Fprintln(w, "        else { $flags[$cmd] }")
+	fmt.Fprintln(w, "    $candidates | Where-Object { $_ -and $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	fmt.Fprintln(w, "        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)")
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "}")
+}
+
+// Prints a completion script for a shell, profile names come from the -config file
+func completionCommand(flags *flag.FlagSet) func(*Config) {
+	programPtr := flags.String("program", strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe"), "name of the program to complete")
+	return func(config *Config) {
+		shell := flags.Arg(0)
+		c := completion{Program: *programPtr, Commands: commands, Flags: make(map[string][]*flag.Flag), Profiles: config.Profiles}
+		for _, cmd := range commands {
+			c.Flags[cmd.Name] = flagsOf(cmd)
+		}
+		switch shell {
+		case "bash":
+			c.bash(os.Stdout)
+		case "zsh":
+			c.zsh(os.Stdout)
+		case "fish":
+			c.fish(os.Stdout)
+		case "powershell":
+			c.powershell(os.Stdout)
+		default:
+			fatal(exitConfig, "Usage: completion [-config file] bash|zsh|fish|powershell")
+		}
+	}
+}
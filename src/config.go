@@ -0,0 +1,360 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	filepath "path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// configAliases gives the single letter flags a readable name in config files
+var configAliases = map[string]string{
+	"input":  "i",
+	"output": "o",
+}
+
+// Config fills in flags from a yaml or toml file, using the flag names as keys, eg.
+//
+//	input: /srv/camera
+//	schedule: "0 2 * * *"
+//	profile: archive
+//	profiles:
+//	  archive: {codec: libx265, crf: 24, preset: slow}
+//	  mobile: {crf: 30, vf: "scale=-2:720"}
+//	dir-profiles:
+//	  Phone: mobile
+//	camera-profiles:
+//	  DJI: archive
+//	screen-profile: mobile
+//
+// Flags given on the command line or in the environment always win over the file.
+// The selected profile wins over encoder settings at the top of the file.
+type Config struct {
+	FileName string
+	// DirRules are the dir-profiles of the file
+	DirRules []shrink.DirRule
+	// CameraRules are the camera-profiles of the file
+	CameraRules []shrink.CameraRule
+	// Profiles are the names of the profiles in the file
+	Profiles []string
+	// profiles are the settings of the profiles by name
+	profiles map[string]map[string]interface{}
+
+	flags *flag.FlagSet
+	// given are the flags set on the command line or in the environment
+	given map[string]bool
+	// loaded are the flags set by the last load, so settings removed from the file go back to their default
+	loaded map[string]bool
+}
+
+// Reads a config file into a map of flag names to values
+func readConfig(fileName string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("unknown config format %s, use .yaml, .yml or .toml", fileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", fileName, err)
+	}
+	return values, nil
+}
+
+// Returns a config value as a flag value, lists are joined with commas
+func configValue(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, part := range v {
+			parts[i] = configValue(part)
+		}
+		return strings.Join(parts, ",")
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// listFlag is a flag that can be given more than once, collecting all values.
+// In the config file it is a list.
+type listFlag []string
+
+// String returns the values separated by spaces
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, " ")
+}
+
+// Set adds a value
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Sets a flag to a value from the config file, a list flag is set to each item of a list instead of them joined by commas
+func setFlag(flags *flag.FlagSet, name string, value interface{}) error {
+	list, ok := flags.Lookup(name).Value.(*listFlag)
+	if !ok {
+		return flags.Set(name, configValue(value))
+	}
+	// the file replaces the list, so a reload doesn't add to it
+	*list = nil
+	items, ok := value.([]interface{})
+	if !ok {
+		items = []interface{}{value}
+	}
+	for _, item := range items {
+		if s := configValue(item); len(s) > 0 {
+			list.Set(s)
+		}
+	}
+	return nil
+}
+
+// Returns a nested section of the config file, eg. the profiles
+func configSection(values map[string]interface{}, key string) (map[string]interface{}, error) {
+	section, ok := values[key]
+	delete(values, key)
+	if !ok {
+		return nil, nil
+	}
+	settings, ok := section.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a map", key)
+	}
+	return settings, nil
+}
+
+// Load reads the config file and sets every flag that wasn't given on the command line or in the environment.
+// It can be called again to pick up changes to the file.
+func (c *Config) Load() error {
+	if c == nil {
+		return nil
+	}
+	values := make(map[string]interface{})
+	if len(c.FileName) > 0 {
+		var err error
+		if values, err = readConfig(c.FileName); err != nil {
+			return err
+		}
+	}
+
+	// check everything before changing anything, so a bad reload keeps the old settings
+	profileSettings, err := configSection(values, "profiles")
+	if err != nil {
+		return fmt.Errorf("%s: %v", c.FileName, err)
+	}
+	profiles := make(map[string]map[string]interface{})
+	for name, settings := range profileSettings {
+		settings, ok := settings.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: profile %s must be a map", c.FileName, name)
+		}
+		profiles[name] = settings
+	}
+	dirProfiles, err := configSection(values, "dir-profiles")
+	if err != nil {
+		return fmt.Errorf("%s: %v", c.FileName, err)
+	}
+	var rules []shrink.DirRule
+	for dir, name := range dirProfiles {
+		settings, ok := profiles[configValue(name)]
+		if !ok {
+			return fmt.Errorf("%s: unknown profile %v for %s", c.FileName, name, dir)
+		}
+		encoder, err := profileEncoder(configValue(name), settings)
+		if err != nil {
+			return fmt.Errorf("%s: %v", c.FileName, err)
+		}
+		rules = append(rules, shrink.DirRule{Dir: dir, Encoder: encoder})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Dir < rules[j].Dir })
+	cameraProfiles, err := configSection(values, "camera-profiles")
+	if err != nil {
+		return fmt.Errorf("%s: %v", c.FileName, err)
+	}
+	var cameraRules []shrink.CameraRule
+	for match, name := range cameraProfiles {
+		settings, ok := profiles[configValue(name)]
+		if !ok {
+			return fmt.Errorf("%s: unknown profile %v for camera %s", c.FileName, name, match)
+		}
+		encoder, err := profileEncoder(configValue(name), settings)
+		if err != nil {
+			return fmt.Errorf("%s: %v", c.FileName, err)
+		}
+		cameraRules = append(cameraRules, shrink.CameraRule{Match: match, Encoder: encoder})
+	}
+	sort.Slice(cameraRules, func(i, j int) bool { return cameraRules[i].Match < cameraRules[j].Match })
+
+	names := make(map[string]string)
+	var keys []string
+	for key := range values {
+		name := key
+		if alias, ok := configAliases[key]; ok {
+			name = alias
+		}
+		if c.flags.Lookup(name) == nil {
+			// the file is shared by all commands, so it can hold settings this one doesn't have
+			log.Debug("Ignoring setting for other commands: ", key)
+			continue
+		}
+		names[key] = name
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	loaded := make(map[string]bool)
+	for _, key := range keys {
+		name := names[key]
+		if c.given[name] {
+			continue
+		}
+		if err := setFlag(c.flags, name, values[key]); err != nil {
+			return fmt.Errorf("%s: invalid value for %s: %v", c.FileName, key, err)
+		}
+		loaded[name] = true
+	}
+
+	// the selected profile fills in the encoder settings that weren't given
+	if f := c.flags.Lookup("profile"); f != nil && len(f.Value.String()) > 0 {
+		name := f.Value.String()
+		settings, ok := profiles[name]
+		if !ok {
+			return fmt.Errorf("unknown profile: %s", name)
+		}
+		if _, err := profileEncoder(name, settings); err != nil {
+			return err
+		}
+		for key, value := range settings {
+			if c.given[key] {
+				continue
+			}
+			c.flags.Set(key, configValue(value))
+			loaded[key] = true
+		}
+	}
+	for name := range c.loaded {
+		if !loaded[name] {
+			setFlag(c.flags, name, c.flags.Lookup(name).DefValue)
+		}
+	}
+	c.loaded = loaded
+	c.DirRules = rules
+	c.CameraRules = cameraRules
+	c.profiles = profiles
+	c.Profiles = nil
+	for name := range profiles {
+		c.Profiles = append(c.Profiles, name)
+	}
+	sort.Strings(c.Profiles)
+	return nil
+}
+
+// Reload loads the file again and applies its settings with apply.
+// If either fails the flags and profiles go back to what they were, so a bad reload keeps the old settings.
+func (c *Config) Reload(apply func() error) error {
+	if c == nil {
+		return apply()
+	}
+	saved := *c
+	values := make(map[string]string)
+	lists := make(map[string]listFlag)
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if list, ok := f.Value.(*listFlag); ok {
+			lists[f.Name] = append(listFlag(nil), *list...)
+			return
+		}
+		values[f.Name] = f.Value.String()
+	})
+
+	err := c.Load()
+	if err == nil {
+		err = apply()
+	}
+	if err != nil {
+		for name, value := range values {
+			c.flags.Set(name, value)
+		}
+		for name, list := range lists {
+			*c.flags.Lookup(name).Value.(*listFlag) = list
+		}
+		*c = saved
+	}
+	return err
+}
+
+// ProfileEncoder returns the encoder of a profile in the file, settings it leaves out use the defaults
+func (c *Config) ProfileEncoder(name string) (shrink.Encoder, error) {
+	settings, ok := c.profiles[name]
+	if !ok {
+		return shrink.Encoder{}, fmt.Errorf("unknown profile: %s", name)
+	}
+	return profileEncoder(name, settings)
+}
+
+// DirConfig reads a .shrinkrc, a yaml file of encoder settings keyed by flag name like the config file, eg.
+//
+//	profile: archive
+//	vf: ""
+//	skip: false
+//
+// A profile of the config file replaces the settings of the dir above, the other settings change them
+// and skip leaves the dir alone.
+func (c *Config) DirConfig(fileName string, parent shrink.Encoder) (shrink.DirOverride, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return shrink.DirOverride{}, err
+	}
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return shrink.DirOverride{}, err
+	}
+
+	override := shrink.DirOverride{Encoder: parent}
+	if skip, ok := values["skip"]; ok {
+		override.Skip = configValue(skip) == "true"
+		delete(values, "skip")
+	}
+	if name, ok := values["profile"]; ok {
+		if override.Encoder, err = c.ProfileEncoder(configValue(name)); err != nil {
+			return shrink.DirOverride{}, err
+		}
+		override.Encoder.Runner = parent.Runner
+		delete(values, "profile")
+	}
+	flags := flag.NewFlagSet(fileName, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	bindEncoderFlags(flags, &override.Encoder)
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if flags.Lookup(key) == nil {
+			return shrink.DirOverride{}, fmt.Errorf("unknown setting %s", key)
+		}
+		if err := flags.Set(key, configValue(values[key])); err != nil {
+			return shrink.DirOverride{}, fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+	}
+	return override, nil
+}
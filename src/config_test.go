@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	filepath "path/filepath"
+	"testing"
+)
+
+func TestConfigReload(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		applyErr error
+		// crf and exclude are the flags after the reload
+		crf, exclude string
+		wantErr      bool
+	}{
+		{"applies the file", "crf: 30\nexclude-dir: [Trash]\n", nil, "30", "Trash", false},
+		{"invalid value", "crf: 30\nexclude-dir: [Trash]\nstrip: bogus\n", nil, "28", "Backup", true},
+		{"settings rejected", "crf: 30\nexclude-dir: [Trash]\n", errors.New("-crf-step must be positive"), "28", "Backup", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "shrink.yaml")
+			if err := os.WriteFile(fileName, []byte("exclude-dir: [Backup]\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			flags := flag.NewFlagSet("run", flag.ContinueOnError)
+			flags.SetOutput(io.Discard)
+			addEncoderFlags(flags)
+			addExcludeFlag(flags)
+			config := &Config{FileName: fileName, flags: flags, given: make(map[string]bool)}
+			if err := config.Load(); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(fileName, []byte(test.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := config.Reload(func() error { return test.applyErr })
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Reload() error = %v, want an error: %v", err, test.wantErr)
+			}
+			if got := flags.Lookup("crf").Value.String(); got != test.crf {
+				t.Errorf("crf = %s, want %s", got, test.crf)
+			}
+			if got := flags.Lookup("exclude-dir").Value.String(); got != test.exclude {
+				t.Errorf("exclude = %s, want %s", got, test.exclude)
+			}
+		})
+	}
+}
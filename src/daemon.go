@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Daemon runs watch mode as a long running service, or full passes over the input dir if a schedule is given
+type Daemon struct {
+	Options  shrink.Options
+	Settle   time.Duration
+	Schedule cron.Schedule
+	// Reload re-reads the config file and builds the options anew, it is called on every SIGHUP.
+	// The settle time, schedule and the input and temp dirs of the options stay as they were started.
+	Reload func() (shrink.Options, error)
+	// Started is called with every processor the daemon starts, if set
+	Started func(*shrink.Processor)
+
+	running atomic.Bool
+}
+
+// Alive returns an error if the daemon isn't watching or waiting for its schedule
+func (d *Daemon) Alive() error {
+	if !d.running.Load() {
+		return errNotRunning
+	}
+	return nil
+}
+
+// Serve runs until SIGINT/SIGTERM. Readiness, reloads and shutdown are reported to systemd,
+// SIGHUP reloads the config file, reconnects all destinations and rescans the input dir, SIGINT/SIGTERM stop after the current file and a second one cancels it.
+func (d *Daemon) Serve() error {
+	schedule, settle := d.Schedule, d.Settle
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	// the service manager of windows asks to stop rather than signal, it's told once the daemon stopped
+	defer runAsService(signals)()
+
+	// keep the systemd watchdog happy for as long as we're running
+	if interval := sdWatchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		go func() {
+			for range ticker.C {
+				sdNotify("WATCHDOG=1")
+			}
+		}()
+	}
+
+	opts := d.Options
+	for {
+		processor := shrink.New(opts)
+		if d.Started != nil {
+			d.Started(processor)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		d.running.Store(true)
+		if schedule != nil {
+			go func() {
+				done <- scheduled(ctx, processor, schedule)
+				d.running.Store(false)
+			}()
+			sdNotify("READY=1")
+		} else {
+			go func() {
+				done <- processor.Watch(ctx, settle)
+				d.running.Store(false)
+			}()
+			sdNotify("READY=1\nSTATUS=Watching " + processor.InDir)
+		}
+
+		select {
+		case err := <-done:
+			cancel()
+			sdNotify("STOPPING=1")
+			return err
+		case sig := <-signals:
+			processor.Stop()
+			if sig != syscall.SIGHUP {
+				log.Info("Shutting down, waiting for current file to finish, signal again to cancel it")
+				sdNotify("STOPPING=1")
+				return d.drain(done, signals, cancel)
+			}
+			log.Info("Reloading")
+			sdNotify("RELOADING=1")
+			if err := d.drain(done, signals, cancel); err != nil {
+				return err
+			}
+			// a config file that can't be read keeps the daemon running with the settings it had
+			reloaded, err := d.Reload()
+			if err != nil {
+				log.Error("Could not reload, keeping the settings: ", err)
+				continue
+			}
+			opts = reloaded
+			log.Info("Reloaded settings")
+		}
+	}
+}
+
+// Waits for the current file to finish, a SIGINT or SIGTERM meanwhile cancels it
+func (d *Daemon) drain(done <-chan error, signals <-chan os.Signal, cancel context.CancelFunc) error {
+	defer cancel()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				log.Info("Cancelling current file")
+				cancel()
+			}
+		}
+	}
+}
+
+// Processes the whole input dir each time the schedule comes around, until the processor is stopped
+func scheduled(ctx context.Context, p *shrink.Processor, schedule cron.Schedule) error {
+	for {
+		next := schedule.Next(time.Now())
+		log.Info("Next run at: ", next)
+		sdNotify("STATUS=Next run at " + next.Format(time.RFC1123))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-p.Stopping():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		sdNotify("STATUS=Processing " + p.InDir)
+		if err := p.Process(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Error(err)
+		}
+		log.Info("Done processing: ", p.InDir)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// Returns the bytes available to us on the filesystem holding the dir
+func diskFree(dirName string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dirName, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// Returns true if both paths are on the same filesystem, so files can be renamed between them
+func sameFilesystem(a, b string) bool {
+	var statA, statB syscall.Stat_t
+	if syscall.Stat(a, &statA) != nil || syscall.Stat(b, &statB) != nil {
+		return false
+	}
+	return statA.Dev == statB.Dev
+}
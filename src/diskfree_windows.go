@@ -0,0 +1,30 @@
+//go:build windows
+
+package main
+
+import (
+	filepath "path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// Returns the bytes available to us on the volume holding the dir
+func diskFree(dirName string) (uint64, error) {
+	dir, err := windows.UTF16PtrFromString(dirName)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(dir, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
+
+// Returns true if both paths are on the same volume, so files can be renamed between them
+func sameFilesystem(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && strings.EqualFold(filepath.VolumeName(absA), filepath.VolumeName(absB))
+}
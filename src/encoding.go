@@ -0,0 +1,304 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+)
+
+// Adds the ffmpeg settings to a flag set, the returned encoder is filled in when the flags are parsed
+func addEncoderFlags(flags *flag.FlagSet) *shrink.Encoder {
+	encoder := &shrink.Encoder{Codec: shrink.DefaultCodec, Preset: shrink.DefaultPreset, CRF: shrink.DefaultCRF, AudioBitrate: shrink.DefaultAudioBitrate}
+	bindEncoderFlags(flags, encoder)
+	flags.String("profile", "", "named encoding profile from the -config file")
+	return encoder
+}
+
+// Adds the ffmpeg settings to a flag set, defaulting to the settings of the encoder, which the flags set
+func bindEncoderFlags(flags *flag.FlagSet, encoder *shrink.Encoder) {
+	flags.StringVar(&encoder.Codec, "codec", encoder.Codec, "ffmpeg video codec, eg. libx265 or h264_nvenc")
+	flags.StringVar(&encoder.Preset, "preset", encoder.Preset, "ffmpeg preset, slower presets give smaller files")
+	flags.Var(&crfFlag{crf: &encoder.CRF}, "crf", "constant rate factor, higher is smaller and lower quality, auto picks it for each movie with -crf-map")
+	flags.Var(&crfMapFlag{crfMap: &encoder.CRFMap}, "crf-map",
+		"bits per pixel of sources and the crf auto gives them, the last crf for the rest, eg. 0.05:24,0.1:26,28 (default "+shrink.DefaultCRFMap.String()+")")
+	flags.StringVar(&encoder.AudioBitrate, "audio-bitrate", encoder.AudioBitrate, "aac audio bitrate")
+	flags.StringVar(&encoder.Filter, "vf", encoder.Filter, "ffmpeg video filter, eg. scale=-2:720 to downscale to 720p")
+	flags.StringVar(&encoder.Tune, "tune", encoder.Tune, "codec tuning, eg. film, animation or stillimage for libx264")
+	flags.IntVar(&encoder.MaxFPS, "max-fps", encoder.MaxFPS, "lower the frame rate of movies recorded faster than this, 0 keeps it")
+	flags.IntVar(&encoder.GOP, "gop", encoder.GOP, "most frames between keyframes, 0 keeps the codec's default")
+	flags.Var(&commaFlag{values: &encoder.Strip, known: shrink.StripKinds}, "strip", "comma separated kinds of streams to drop: data, attachments, cover and angles")
+	flags.Var(&commaFlag{values: &encoder.AudioLanguages}, "audio-lang",
+		"comma separated languages of the audio tracks to keep, eg. eng,und, und for tracks without one or default for the default track")
+	flags.BoolVar(&encoder.AudioCopy, "audio-copy", encoder.AudioCopy, "copy audio that is already aac of at most 192k instead of transcoding it, transcode the rest")
+	flags.BoolVar(&encoder.DropSilentAudio, "drop-silent-audio", encoder.DropSilentAudio, "leave out the audio of movies whose tracks are all pure silence, like screen recordings and muted cameras")
+}
+
+// crfFlag is a constant rate factor or auto
+type crfFlag struct {
+	crf *int
+}
+
+// String returns the crf, auto for shrink.AutoCRF
+func (c *crfFlag) String() string {
+	if c == nil || c.crf == nil {
+		return ""
+	}
+	if *c.crf == shrink.AutoCRF {
+		return "auto"
+	}
+	return strconv.Itoa(*c.crf)
+}
+
+// Set sets the crf, failing if it isn't a number or auto
+func (c *crfFlag) Set(value string) error {
+	if strings.EqualFold(value, "auto") {
+		*c.crf = shrink.AutoCRF
+		return nil
+	}
+	crf, err := strconv.Atoi(value)
+	if err != nil || crf < 0 {
+		return fmt.Errorf("crf must be a number or auto")
+	}
+	*c.crf = crf
+	return nil
+}
+
+// crfMapFlag is the mapping -crf auto uses
+type crfMapFlag struct {
+	crfMap *shrink.CRFMap
+}
+
+// String returns the steps of the mapping
+func (c *crfMapFlag) String() string {
+	if c == nil || c.crfMap == nil {
+		return ""
+	}
+	return c.crfMap.String()
+}
+
+// Set replaces the mapping, empty goes back to the default
+func (c *crfMapFlag) Set(value string) error {
+	if len(strings.TrimSpace(value)) == 0 {
+		*c.crfMap = nil
+		return nil
+	}
+	crfMap, err := shrink.ParseCRFMap(value)
+	if err != nil {
+		return err
+	}
+	*c.crfMap = crfMap
+	return nil
+}
+
+// commaFlag is a comma separated list of lower case values, limited to the known ones if there are any
+type commaFlag struct {
+	values *[]string
+	known  []string
+}
+
+// String returns the values separated by commas
+func (c *commaFlag) String() string {
+	if c == nil || c.values == nil {
+		return ""
+	}
+	return strings.Join(*c.values, ",")
+}
+
+// Set replaces the values, failing if one is unknown
+func (c *commaFlag) Set(value string) error {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if len(v) == 0 {
+			continue
+		}
+		known := len(c.known) == 0
+		for _, knownValue := range c.known {
+			known = known || v == knownValue
+		}
+		if !known {
+			return fmt.Errorf("unknown value, use %s: %s", strings.Join(c.known, ", "), v)
+		}
+		values = append(values, v)
+	}
+	*c.values = values
+	return nil
+}
+
+// Adds the flags saving proxies, the returned proxy is filled in when the flags are parsed and is only used if it has a dir
+func addProxyFlags(flags *flag.FlagSet) *shrink.Proxy {
+	proxy := &shrink.Proxy{}
+	flags.StringVar(&proxy.Dir, "proxy-dir", "", "also save a small copy of each movie below this dir, with the same dirs and names")
+	flags.IntVar(&proxy.Height, "proxy-height", shrink.DefaultProxyHeight, "most lines of the proxy copies")
+	flags.StringVar(&proxy.Bitrate, "proxy-bitrate", shrink.DefaultProxyBitrate, "video bit rate of the proxy copies")
+	return proxy
+}
+
+// Adds the flags encoding long movies in chunks that interrupted encodes resume from
+func addSegmentFlags(flags *flag.FlagSet) *shrink.Segments {
+	segments := &shrink.Segments{}
+	flags.StringVar(&segments.Dir, "segment-dir", "", "encode long movies in chunks kept below this dir, so an interrupted encode resumes from the last finished chunk")
+	flags.DurationVar(&segments.Length, "segment-length", shrink.DefaultSegmentLength, "length of the chunks long movies are encoded in")
+	flags.DurationVar(&segments.MinDuration, "segment-min", shrink.DefaultSegmentMinDuration, "movies at least this long are encoded in chunks")
+	flags.IntVar(&segments.Workers, "segment-workers", 1, "split long movies at keyframes and encode this many chunks at once, for many-core machines")
+	return segments
+}
+
+// Adds the flags rewrapping movies instead of encoding them
+func addRemuxFlags(flags *flag.FlagSet) (*bool, *string) {
+	remuxPtr := flags.Bool("remux-only", false, "don't encode movies, rewrap their streams to fix their container and move the index to the front for streaming")
+	containerPtr := flags.String("remux-container", "mp4", "container to rewrap movies into with -remux-only, mp4 or mkv")
+	return remuxPtr, containerPtr
+}
+
+// Returns the container to remux into, empty when not remuxing, failing if it is unknown
+func setupRemux(remux bool, container string) (string, error) {
+	if !remux {
+		return "", nil
+	}
+	if container != "mp4" && container != "mkv" {
+		return "", fmt.Errorf("unknown container, use mp4 or mkv: %s", container)
+	}
+	return container, nil
+}
+
+// Returns the CRF retries, nil without a step, failing if the step is negative
+func setupCRFRetry(step, maxCRF int) (*shrink.CRFRetry, error) {
+	if step < 0 {
+		return nil, fmt.Errorf("-crf-step must be positive: %d", step)
+	}
+	if step == 0 {
+		return nil, nil
+	}
+	return &shrink.CRFRetry{Step: step, MaxCRF: maxCRF}, nil
+}
+
+// Returns the way of writing checksums, failing if it is unknown
+func setupChecksums(checksums string) (string, error) {
+	if len(checksums) > 0 && checksums != shrink.ChecksumSidecar && checksums != shrink.ChecksumManifest {
+		return "", fmt.Errorf("unknown checksums, use sidecar or manifest: %s", checksums)
+	}
+	return checksums, nil
+}
+
+// Adds the flags of the quality gate, the returned gate is filled in when the flags are parsed and only used if it has a minimum
+func addQualityFlags(flags *flag.FlagSet) *shrink.QualityGate {
+	gate := &shrink.QualityGate{}
+	flags.Float64Var(&gate.MinVMAF, "min-vmaf", 0, "keep originals whose shrunk version has a lower VMAF on sampled segments, eg. 93, needs ffmpeg with libvmaf")
+	flags.Float64Var(&gate.MinSSIM, "min-ssim", 0, "keep originals whose shrunk version has a lower SSIM on sampled segments, eg. 0.97")
+	return gate
+}
+
+// Returns the encoder for a profile from the config file, settings it leaves out use the defaults
+func profileEncoder(name string, settings map[string]interface{}) (shrink.Encoder, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	encoder := addEncoderFlags(flags)
+
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "profile" || flags.Lookup(key) == nil {
+			return shrink.Encoder{}, fmt.Errorf("profile %s: unknown setting %s", name, key)
+		}
+		if err := flags.Set(key, configValue(settings[key])); err != nil {
+			return shrink.Encoder{}, fmt.Errorf("profile %s: invalid value for %s: %v", name, key, err)
+		}
+	}
+	return *encoder, nil
+}
+
+// Adds the flags encoding screen recordings with their own settings, they are applied with setupScreen
+func addScreenFlags(flags *flag.FlagSet) (*bool, *string) {
+	screenPtr := flags.Bool("screen-recordings", false, "detect screen recordings and encode them tuned for screen content at up to 30 fps")
+	profilePtr := flags.String("screen-profile", "", "profile from the -config file for screen recordings, instead of the built-in one")
+	return screenPtr, profilePtr
+}
+
+// Adds the flags encoding time-lapses with their own settings, they are applied with setupTimeLapse
+func addTimeLapseFlags(flags *flag.FlagSet) (*bool, *bool, *string) {
+	timeLapsePtr := flags.Bool("time-lapses", false, "detect time-lapses by their name and tags and encode them with long groups of pictures and a higher CRF")
+	motionPtr := flags.Bool("time-lapse-motion", false, "also take movies with very low motion for time-lapses, static camera clips look the same")
+	profilePtr := flags.String("time-lapse-profile", "", "profile from the -config file for time-lapses, instead of the built-in one")
+	return timeLapsePtr, motionPtr, profilePtr
+}
+
+// Adds the flags picking profiles for portrait and slow motion movies, they are applied with setupPortrait and setupSlowMotion
+func addPortraitFlags(flags *flag.FlagSet) (*string, *string) {
+	portraitPtr := flags.String("portrait-profile", "", "profile from the -config file for portrait movies, by default their scale filters are turned")
+	slowMotionPtr := flags.String("slow-motion-profile", "", "profile from the -config file for slow motion movies of 100 fps and more")
+	return portraitPtr, slowMotionPtr
+}
+
+// Enables detecting screen recordings, failing if the screen profile is unknown
+func setupScreen(opts *shrink.Options, config *Config, screen bool, profile string) error {
+	if !screen {
+		if len(profile) > 0 {
+			return fmt.Errorf("-screen-profile needs -screen-recordings")
+		}
+		return nil
+	}
+	opts.ScreenRecordings = true
+	if len(profile) > 0 {
+		encoder, err := config.ProfileEncoder(profile)
+		if err != nil {
+			return fmt.Errorf("invalid screen profile: %v", err)
+		}
+		opts.ScreenEncoder = &encoder
+	}
+	return nil
+}
+
+// Enables detecting time-lapses, failing if the time-lapse profile is unknown
+func setupTimeLapse(opts *shrink.Options, config *Config, timeLapse, motion bool, profile string) error {
+	if !timeLapse {
+		if len(profile) > 0 {
+			return fmt.Errorf("-time-lapse-profile needs -time-lapses")
+		}
+		if motion {
+			return fmt.Errorf("-time-lapse-motion needs -time-lapses")
+		}
+		return nil
+	}
+	opts.TimeLapses, opts.TimeLapseMotion = true, motion
+	if len(profile) > 0 {
+		encoder, err := config.ProfileEncoder(profile)
+		if err != nil {
+			return fmt.Errorf("invalid time-lapse profile: %v", err)
+		}
+		opts.TimeLapseEncoder = &encoder
+	}
+	return nil
+}
+
+// Uses a profile from the config file for portrait movies, failing if it is unknown
+func setupPortrait(opts *shrink.Options, config *Config, profile string) error {
+	if len(profile) == 0 {
+		return nil
+	}
+	encoder, err := config.ProfileEncoder(profile)
+	if err != nil {
+		return fmt.Errorf("invalid portrait profile: %v", err)
+	}
+	opts.PortraitEncoder = &encoder
+	return nil
+}
+
+// Uses a profile from the config file for slow motion movies, failing if it is unknown
+func setupSlowMotion(opts *shrink.Options, config *Config, profile string) error {
+	if len(profile) == 0 {
+		return nil
+	}
+	encoder, err := config.ProfileEncoder(profile)
+	if err != nil {
+		return fmt.Errorf("invalid slow motion profile: %v", err)
+	}
+	opts.SlowMotionEncoder = &encoder
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// envAliases gives the single letter flags a readable environment variable name
+var envAliases = map[string]string{
+	"i": "SHRINK_INPUT",
+	"o": "SHRINK_OUTPUT",
+}
+
+// Returns the environment variable that configures a flag, eg. gdrive-folder is SHRINK_GDRIVE_FOLDER
+func envName(flagName string) string {
+	if alias, ok := envAliases[flagName]; ok {
+		return alias
+	}
+	return "SHRINK_" + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// Sets every flag that wasn't given on the command line from its SHRINK_* environment variable,
+// so flags always win over the environment
+func applyEnv(flags *flag.FlagSet) error {
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || err != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if serr := flags.Set(f.Name, value); serr != nil {
+				err = fmt.Errorf("invalid value for %s: %v", envName(f.Name), serr)
+			}
+		}
+	})
+	return err
+}
+
+// Parses the command line, then fills in the rest from the environment and the -config file
+func parseFlags(flags *flag.FlagSet, args []string) *Config {
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of %s:\n", flags.Name())
+		flags.PrintDefaults()
+		fmt.Fprintln(flags.Output(), "\nEvery flag can also be set with a SHRINK_* environment variable, eg. SHRINK_INPUT or SHRINK_GDRIVE_FOLDER,")
+		fmt.Fprintln(flags.Output(), "or in the -config file. Flags win over the environment, which wins over the file.")
+	}
+	flags.Parse(args)
+	if err := applyEnv(flags); err != nil {
+		fatal(exitConfig, err)
+	}
+
+	config := &Config{FileName: flags.Lookup("config").Value.String(), flags: flags, given: make(map[string]bool)}
+	flags.Visit(func(f *flag.Flag) {
+		config.given[f.Name] = true
+	})
+	if err := config.Load(); err != nil {
+		fatal(exitConfig, err)
+	}
+	return config
+}
+
+// Logs to stdout, as text or as json for log collectors
+func setupLogging(format string) {
+	log.SetOutput(os.Stdout)
+	switch format {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	case "text", "":
+	default:
+		fatal(exitConfig, "Unknown log format: ", format)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Exit codes, so wrapper scripts and schedulers can branch on the outcome. They are listed in the README.
+const (
+	exitOK          = 0   // everything that needed doing was done
+	exitError       = 1   // an unexpected error stopped the run
+	exitConfig      = 2   // bad flags, config file or settings, the flag package uses 2 as well
+	exitFailures    = 3   // the run completed but some files failed
+	exitNothingToDo = 4   // there were no movies to process
+	exitNoFFmpeg    = 5   // ffmpeg or ffprobe, or ImageMagick for -photos, could not be found
+	exitInterrupted = 130 // stopped by Ctrl-C or SIGTERM, 128 + SIGINT like shells report it
+)
+
+// exitCode is what main exits with once the command returns, so deferred clean up still runs
+var exitCode = exitOK
+
+// missingError is a program a run needs that can't be found, its setup fails with exitNoFFmpeg
+type missingError struct {
+	err error
+}
+
+func (e missingError) Error() string {
+	return e.err.Error()
+}
+
+// Returns the code to exit with when setting up a run fails, exitNoFFmpeg for missing programs and exitConfig otherwise
+func setupExitCode(err error) int {
+	var missing missingError
+	if errors.As(err, &missing) {
+		return exitNoFFmpeg
+	}
+	return exitConfig
+}
+
+// Logs the error and exits straight away with the code, killing the programs still running
+func fatal(code int, args ...interface{}) {
+	log.Error(args...)
+	shrink.KillPrograms()
+	os.Exit(code)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+)
+
+// ffmpegFlags locate ffmpeg and ffprobe
+type ffmpegFlags struct {
+	ffmpeg, ffprobe *string
+	memory          *int64
+	cpus            *float64
+}
+
+// Adds the flags locating ffmpeg and ffprobe, they are resolved with Runner once the flags are parsed
+func addFFmpegFlags(flags *flag.FlagSet) *ffmpegFlags {
+	return &ffmpegFlags{
+		ffmpeg:  flags.String("ffmpeg-path", "ffmpeg", "ffmpeg executable, looked up in the PATH unless it is a path"),
+		ffprobe: flags.String("ffprobe-path", "ffprobe", "ffprobe executable, looked up in the PATH unless it is a path"),
+		memory:  flags.Int64("job-memory", 0, "most MB of memory each ffmpeg may use, it is killed if it needs more, with cgroups on Linux and job objects on Windows"),
+		cpus:    flags.Float64("job-cpus", 0, "most cores each ffmpeg may keep busy, eg. 2.5, to leave room for a media server"),
+	}
+}
+
+// Runner returns the runner for the ffmpeg and ffprobe to use.
+// It exits with exitNoFFmpeg if they can't be found.
+func (f *ffmpegFlags) Runner() shrink.ExecRunner {
+	runner, err := f.runner()
+	if err != nil {
+		fatal(setupExitCode(err), err)
+	}
+	return runner
+}
+
+// Returns the runner for the ffmpeg and ffprobe to use, with a missingError if they can't be found
+func (f *ffmpegFlags) runner() (shrink.ExecRunner, error) {
+	ffmpeg, err := exec.LookPath(*f.ffmpeg)
+	if err == nil {
+		var ffprobe string
+		if ffprobe, err = exec.LookPath(*f.ffprobe); err == nil {
+			runner := shrink.ExecRunner{FFmpeg: ffmpeg, FFprobe: ffprobe, Limits: shrink.Limits{MemoryMB: *f.memory, CPUs: *f.cpus}}
+			if err := runner.Limits.Prepare(); err != nil {
+				return runner, fmt.Errorf("unable to limit ffmpeg: %v", err)
+			}
+			return runner, nil
+		}
+	}
+	return shrink.ExecRunner{}, missingError{fmt.Errorf("unable to find ffmpeg, install it or set -ffmpeg-path and -ffprobe-path: %v", err)}
+}
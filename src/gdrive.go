@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	filepath "path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// The Drive API, variables so tests can point them at a fake server
+var (
+	driveUploadURL = "https://www.googleapis.com/upload/drive/v3/files?uploadType=resumable"
+	driveFilesURL  = "https://www.googleapis.com/drive/v3/files"
+)
+
+// driveFolderType is the mime type of Drive folders
+const driveFolderType = "application/vnd.google-apps.folder"
+
+// DriveUploader uploads finished files into a Google Drive folder, keeping the dirs of their relative names as folders
+type DriveUploader struct {
+	FolderID string
+	client   *http.Client
+	// folders are the ids of the folders below FolderID by relative dir, guarded by mu
+	folders map[string]string
+	mu      sync.Mutex
+}
+
+// NewDriveUploader creates an uploader from a service account or authorized user credentials file,
+// falling back to the application default credentials if no file is given
+func NewDriveUploader(folderID, credentialsFile string) (*DriveUploader, error) {
+	ctx := context.Background()
+	// drive.file would only reach files the tool created, not a -gdrive-folder made in the Drive web app
+	scope := "https://www.googleapis.com/auth/drive"
+
+	var creds *google.Credentials
+	var err error
+	if len(credentialsFile) > 0 {
+		data, rerr := ioutil.ReadFile(credentialsFile)
+		if rerr != nil {
+			return nil, rerr
+		}
+		creds, err = google.CredentialsFromJSON(ctx, data, scope)
+	} else {
+		creds, err = google.FindDefaultCredentials(ctx, scope)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &DriveUploader{FolderID: folderID, client: oauth2.NewClient(ctx, creds.TokenSource), folders: make(map[string]string)}, nil
+}
+
+// Returns the id of the folder of a relative dir below FolderID, finding or creating each folder on the way.
+// Folders are made one at a time, so two uploads into a new dir don't both create it.
+func (d *DriveUploader) folder(ctx context.Context, relDir string) (string, error) {
+	parent := d.FolderID
+	relDir = path.Clean(relDir)
+	if relDir == "." || relDir == "/" {
+		return parent, nil
+	}
+	if len(parent) == 0 {
+		parent = "root"
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dir := ""
+	for _, name := range strings.Split(strings.Trim(relDir, "/"), "/") {
+		dir = path.Join(dir, name)
+		if id, ok := d.folders[dir]; ok {
+			parent = id
+			continue
+		}
+		id, err := d.findFolder(ctx, parent, name)
+		if err != nil {
+			return "", err
+		}
+		if len(id) == 0 {
+			if id, err = d.createFolder(ctx, parent, name); err != nil {
+				return "", err
+			}
+		}
+		d.folders[dir] = id
+		parent = id
+	}
+	return parent, nil
+}
+
+// Returns the id of the folder with a name in the parent folder, empty if there is none
+func (d *DriveUploader) findFolder(ctx context.Context, parent, name string) (string, error) {
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	query := url.Values{
+		"q":      {fmt.Sprintf("name = '%s' and '%s' in parents and mimeType = '%s' and trashed = false", quote.Replace(name), quote.Replace(parent), driveFolderType)},
+		"fields": {"files(id)"},
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", driveFilesURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	var list struct {
+		Files []struct {
+			ID string `json:"id"`
+		} `json:"files"`
+	}
+	if err := d.call(req, &list); err != nil {
+		return "", fmt.Errorf("drive: could not look up folder %s: %v", name, err)
+	}
+	if len(list.Files) == 0 {
+		return "", nil
+	}
+	return list.Files[0].ID, nil
+}
+
+// Creates a folder in the parent folder, returning its id
+func (d *DriveUploader) createFolder(ctx context.Context, parent, name string) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{"name": name, "mimeType": driveFolderType, "parents": []string{parent}})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", driveFilesURL+"?fields=id", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	var folder struct {
+		ID string `json:"id"`
+	}
+	if err := d.call(req, &folder); err != nil {
+		return "", fmt.Errorf("drive: could not create folder %s: %v", name, err)
+	}
+	return folder.ID, nil
+}
+
+// Sends a request to the Drive API and reads its json response
+func (d *DriveUploader) call(req *http.Request, response interface{}) error {
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// Upload sends the file using a resumable upload session so large movies are streamed from disk,
+// created and modified times are set to the capture time so Drive sorts them correctly
+func (d *DriveUploader) Upload(ctx context.Context, fileName, relName string, captureTime time.Time) error {
+	folderID, err := d.folder(ctx, path.Dir(relName))
+	if err != nil {
+		return err
+	}
+	metadata := map[string]interface{}{
+		"name": path.Base(relName),
+	}
+	if !captureTime.IsZero() {
+		metadata["createdTime"] = captureTime.UTC().Format(time.RFC3339)
+		metadata["modifiedTime"] = captureTime.UTC().Format(time.RFC3339)
+	}
+	if len(folderID) > 0 {
+		metadata["parents"] = []string{folderID}
+	}
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(fileName))
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+
+	// start the upload session
+	req, err := http.NewRequestWithContext(ctx, "POST", driveUploadURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", contentType)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("drive: could not start upload of %s: %s", fileName, resp.Status)
+	}
+	sessionURL := resp.Header.Get("Location")
+
+	// send the contents
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, "PUT", sessionURL, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = fileInfo.Size()
+	req.Header.Set("Content-Type", contentType)
+	resp, err = d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("drive: upload of %s failed: %s", fileName, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	filepath "path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDriveUploadFolders(t *testing.T) {
+	// folders by parent and name, the input folder already has 2016
+	folders := map[string]string{"in/2016": "f2016"}
+	var created []string
+	var uploadParents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/files":
+			var parent, name string
+			fmt.Sscanf(strings.NewReplacer("'", " ").Replace(r.URL.Query().Get("q")), "name = %s and %s in parents", &name, &parent)
+			if id, ok := folders[parent+"/"+name]; ok {
+				fmt.Fprintf(w, `{"files": [{"id": %q}]}`, id)
+				return
+			}
+			fmt.Fprint(w, `{"files": []}`)
+		case r.Method == "POST" && r.URL.Path == "/files":
+			var folder struct {
+				Name    string
+				Parents []string
+			}
+			json.NewDecoder(r.Body).Decode(&folder)
+			id := "f" + folder.Name
+			folders[folder.Parents[0]+"/"+folder.Name] = id
+			created = append(created, folder.Parents[0]+"/"+folder.Name)
+			fmt.Fprintf(w, `{"id": %q}`, id)
+		case r.Method == "POST" && r.URL.Path == "/upload":
+			var metadata struct {
+				Name    string
+				Parents []string
+			}
+			json.NewDecoder(r.Body).Decode(&metadata)
+			if metadata.Name != "clip.mp4" {
+				t.Errorf("uploaded as %s, want clip.mp4", metadata.Name)
+			}
+			uploadParents = metadata.Parents
+			w.Header().Set("Location", "http://"+r.Host+"/session")
+		case r.Method == "PUT" && r.URL.Path == "/session":
+			w.WriteHeader(http.StatusCreated)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	driveFilesURL, driveUploadURL = server.URL+"/files", server.URL+"/upload"
+	defer func(files, upload string) { driveFilesURL, driveUploadURL = files, upload }(driveFilesURL, driveUploadURL)
+
+	fileName := filepath.Join(t.TempDir(), "clip.mp4")
+	if err := os.WriteFile(fileName, []byte("movie"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	drive := &DriveUploader{FolderID: "in", client: server.Client(), folders: make(map[string]string)}
+	for i := 0; i < 2; i++ {
+		if err := drive.Upload(context.Background(), fileName, "2016/05/clip.mp4", time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"f2016/05"}; !reflect.DeepEqual(created, want) {
+		t.Errorf("created folders %v, want %v", created, want)
+	}
+	if want := []string{"f05"}; !reflect.DeepEqual(uploadParents, want) {
+		t.Errorf("uploaded into %v, want %v", uploadParents, want)
+	}
+}
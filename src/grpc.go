@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	"github.com/dylanclement/shrink-movies/rpc/shrinkpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer exposes the jobs of a Server over gRPC
+type grpcServer struct {
+	shrinkpb.UnimplementedShrinkServer
+	server *Server
+}
+
+// Converts a time to a timestamp, nil stays nil
+func toTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// Converts a summary to its protobuf message
+func summaryToProto(summary shrink.Summary) *shrinkpb.Summary {
+	return &shrinkpb.Summary{
+		Processed: int64(summary.Processed),
+		Shrunk:    int64(summary.Shrunk),
+		Failed:    int64(summary.Failed),
+		Saved:     summary.Saved,
+	}
+}
+
+// Converts a job snapshot to its protobuf message
+func jobToProto(job Job) *shrinkpb.Job {
+	return &shrinkpb.Job{
+		Id:       int64(job.ID),
+		Path:     job.Path,
+		Output:   job.Output,
+		Status:   job.Status,
+		Error:    job.Error,
+		Created:  timestamppb.New(job.Created),
+		Started:  toTimestamp(job.Started),
+		Finished: toTimestamp(job.Finished),
+		Summary:  summaryToProto(job.Summary),
+	}
+}
+
+// Looks up a job, returning a NotFound error if it doesn't exist
+func (g *grpcServer) job(id int64) (*Job, error) {
+	g.server.mu.Lock()
+	job := g.server.find(int(id))
+	g.server.mu.Unlock()
+	if job == nil {
+		return nil, status.Errorf(codes.NotFound, "job %d not found", id)
+	}
+	return job, nil
+}
+
+func (g *grpcServer) SubmitJob(ctx context.Context, req *shrinkpb.SubmitJobRequest) (*shrinkpb.Job, error) {
+	job, err := g.server.Submit(req.GetPath(), req.GetOutput())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return jobToProto(g.server.snapshot(job)), nil
+}
+
+func (g *grpcServer) GetJob(ctx context.Context, req *shrinkpb.JobRequest) (*shrinkpb.Job, error) {
+	job, err := g.job(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return jobToProto(g.server.snapshot(job)), nil
+}
+
+func (g *grpcServer) ListJobs(ctx context.Context, req *shrinkpb.ListJobsRequest) (*shrinkpb.ListJobsResponse, error) {
+	g.server.mu.Lock()
+	jobs := append([]*Job(nil), g.server.jobs...)
+	g.server.mu.Unlock()
+
+	resp := &shrinkpb.ListJobsResponse{}
+	for _, job := range jobs {
+		resp.Jobs = append(resp.Jobs, jobToProto(g.server.snapshot(job)))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) CancelJob(ctx context.Context, req *shrinkpb.JobRequest) (*shrinkpb.Job, error) {
+	job, err := g.job(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	g.server.Cancel(job.ID)
+	return jobToProto(g.server.snapshot(job)), nil
+}
+
+func (g *grpcServer) GetReport(ctx context.Context, req *shrinkpb.JobRequest) (*shrinkpb.Report, error) {
+	job, err := g.job(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	report := &shrinkpb.Report{Summary: summaryToProto(job.report.Summary())}
+	for _, f := range job.report.Files() {
+		report.Files = append(report.Files, &shrinkpb.FileResult{
+			Source:  f.Source,
+			Result:  f.Result,
+			InSize:  f.InSize,
+			OutSize: f.OutSize,
+			Ratio:   f.Ratio,
+			Swapped: f.Swapped,
+			Error:   f.Error,
+			Time:    timestamppb.New(f.Time),
+		})
+	}
+	return report, nil
+}
+
+// WatchJob polls the job and sends an update whenever its state or progress changes
+func (g *grpcServer) WatchJob(req *shrinkpb.JobRequest, stream shrinkpb.Shrink_WatchJobServer) error {
+	job, err := g.job(req.GetId())
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	var last *shrinkpb.JobProgress
+	for {
+		snap := g.server.snapshot(job)
+		progress := &shrinkpb.JobProgress{Job: jobToProto(snap)}
+		if current := g.server.Status().Current; current.JobID == job.ID {
+			progress.File = current.File
+			progress.Fraction = current.Fraction
+		}
+		if last == nil || !proto.Equal(last, progress) {
+			if err := stream.Send(progress); err != nil {
+				return err
+			}
+			last = progress
+		}
+		if snap.Finished != nil {
+			return nil
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refuses calls changing jobs without the server's token as bearer token in their authorization metadata
+func (g *grpcServer) authorize(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !strings.HasSuffix(info.FullMethod, "/SubmitJob") && !strings.HasSuffix(info.FullMethod, "/CancelJob") {
+		return handler(ctx, req)
+	}
+	if len(g.server.Token) == 0 {
+		return nil, status.Error(codes.PermissionDenied, "changing jobs over the API needs -api-token")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if given, ok := strings.CutPrefix(value, "Bearer "); ok && tokenMatches(given, g.server.Token) {
+			return handler(ctx, req)
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "invalid token")
+}
+
+// Serves the gRPC job API until the listener fails
+func serveGRPC(addr string, server *Server) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	g := &grpcServer{server: server}
+	grpcSrv := grpc.NewServer(grpc.UnaryInterceptor(g.authorize))
+	shrinkpb.RegisterShrinkServer(grpcSrv, g)
+	return grpcSrv.Serve(listener)
+}
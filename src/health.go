@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+)
+
+// Health reports whether the service is alive and ready to take on work
+type Health struct {
+	TmpDir string
+	// FFmpeg is the path of ffmpeg, looked up in the PATH when empty
+	FFmpeg string
+	// MinTempSpace is the free space in bytes the temp dir needs to be ready
+	MinTempSpace uint64
+	// Alive, when set, returns an error if processing has stalled
+	Alive func() error
+}
+
+// Runs the checks and writes the result, 503 if any of them failed
+func writeChecks(w http.ResponseWriter, checks map[string]error) {
+	status := http.StatusOK
+	results := make(map[string]string)
+	for name, err := range checks {
+		if err != nil {
+			status = http.StatusServiceUnavailable
+			results[name] = err.Error()
+		} else {
+			results[name] = "ok"
+		}
+	}
+	writeJSON(w, status, results)
+}
+
+// Checks that ffmpeg can be found
+func (h *Health) checkFFmpeg() error {
+	ffmpeg := h.FFmpeg
+	if len(ffmpeg) == 0 {
+		ffmpeg = "ffmpeg"
+	}
+	_, err := exec.LookPath(ffmpeg)
+	return err
+}
+
+// Checks that the temp dir has enough space for an encode
+func (h *Health) checkTempSpace() error {
+	free, err := diskFree(h.TmpDir)
+	if err != nil {
+		return err
+	}
+	if free < h.MinTempSpace {
+		return fmt.Errorf("only %d MB free in %s", free>>20, h.TmpDir)
+	}
+	return nil
+}
+
+// Checks that processing hasn't stalled
+func (h *Health) checkAlive() error {
+	if h.Alive == nil {
+		return nil
+	}
+	return h.Alive()
+}
+
+// Register adds /healthz for liveness and /readyz for readiness to a mux
+func (h *Health) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeChecks(w, map[string]error{"queue": h.checkAlive()})
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeChecks(w, map[string]error{
+			"queue":     h.checkAlive(),
+			"ffmpeg":    h.checkFFmpeg(),
+			"tempSpace": h.checkTempSpace(),
+		})
+	})
+}
+
+// errNotRunning is returned by liveness checks when processing has stopped
+var errNotRunning = errors.New("not running")
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Adds the hook flags to a flag set, returning the pre and post hook commands
+func addHookFlags(flags *flag.FlagSet) (*string, *string) {
+	prePtr := flags.String("pre-hook", "", "shell command run before each file, a non-zero exit skips the file")
+	postPtr := flags.String("post-hook", "", "shell command run after each file with the outcome in SHRINK_OUTCOME")
+	return prePtr, postPtr
+}
+
+// Runs a command with the shell of the platform
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// Runs a hook command with the file described in SHRINK_* environment variables, its output goes to ours
+func runHook(ctx context.Context, command string, env map[string]string) error {
+	cmd := shellCommand(ctx, command)
+	cmd.Env = os.Environ()
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// Returns the outcome of a file for the post hook: shrunk, kept, skipped, failed or cancelled
+func outcome(result shrink.FileResult) string {
+	switch {
+	case len(result.Skipped) > 0:
+		return "skipped"
+	case result.Error == context.Canceled.Error():
+		return "cancelled"
+	case len(result.Error) > 0:
+		return "failed"
+	case result.Swapped:
+		return "shrunk"
+	}
+	return "kept"
+}
+
+// Sets up the pre and post hooks of a run, empty commands are left out
+func setupHooks(opts *shrink.Options, pre, post string) {
+	if len(pre) > 0 {
+		opts.Before = func(ctx context.Context, fileName, name string) error {
+			err := runHook(ctx, pre, map[string]string{
+				"SHRINK_HOOK":    "pre",
+				"SHRINK_SOURCE":  fileName,
+				"SHRINK_NAME":    name,
+				"SHRINK_IN_SIZE": strconv.FormatInt(shrink.FileSize(fileName), 10),
+			})
+			if _, ok := err.(*exec.ExitError); ok && ctx.Err() == nil {
+				return fmt.Errorf("%w by the pre hook, %v", shrink.ErrSkip, err)
+			}
+			if err != nil {
+				return fmt.Errorf("pre hook: %v", err)
+			}
+			return nil
+		}
+	}
+	if len(post) > 0 {
+		opts.After = func(ctx context.Context, result shrink.FileResult, name string) {
+			err := runHook(ctx, post, map[string]string{
+				"SHRINK_HOOK":     "post",
+				"SHRINK_SOURCE":   result.Source,
+				"SHRINK_RESULT":   result.Result,
+				"SHRINK_NAME":     name,
+				"SHRINK_IN_SIZE":  strconv.FormatInt(result.InSize, 10),
+				"SHRINK_OUT_SIZE": strconv.FormatInt(result.OutSize, 10),
+				"SHRINK_RATIO":    strconv.FormatFloat(result.Ratio, 'f', 3, 64),
+				"SHRINK_OUTCOME":  outcome(result),
+				"SHRINK_ERROR":    result.Error,
+			})
+			if err != nil {
+				log.Error("Post hook failed for: ", result.Source, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	filepath "path/filepath"
+	"strings"
+	"time"
+)
+
+// MediaServer refreshes the library of a Plex, Jellyfin or Emby server
+type MediaServer struct {
+	Kind  string
+	URL   string
+	Token string
+	// PathFrom is replaced with PathTo to get the path the media server sees, eg. when it runs in a container
+	PathFrom string
+	PathTo   string
+	client   *http.Client
+}
+
+// NewMediaServer creates a refresher for a plex, jellyfin or emby server,
+// pathMap is an optional local=server path prefix mapping
+func NewMediaServer(kind, serverURL, token, pathMap string) (*MediaServer, error) {
+	switch kind {
+	case "plex", "jellyfin", "emby":
+	default:
+		return nil, fmt.Errorf("unknown media server: %s", kind)
+	}
+	m := &MediaServer{Kind: kind, URL: strings.TrimSuffix(serverURL, "/"), Token: token, client: &http.Client{Timeout: 30 * time.Second}}
+	if len(pathMap) > 0 {
+		parts := strings.SplitN(pathMap, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("path map must be local=server: %s", pathMap)
+		}
+		m.PathFrom, m.PathTo = parts[0], parts[1]
+	}
+	return m, nil
+}
+
+// Returns the path of a local dir as the media server sees it
+func (m *MediaServer) serverPath(dirName string) string {
+	dirName, _ = filepath.Abs(dirName)
+	if len(m.PathFrom) > 0 && strings.HasPrefix(dirName, m.PathFrom) {
+		return path.Join(m.PathTo, filepath.ToSlash(strings.TrimPrefix(dirName, m.PathFrom)))
+	}
+	return filepath.ToSlash(dirName)
+}
+
+// Refresh scans just the changed dir rather than the whole library
+func (m *MediaServer) Refresh(dirName string) error {
+	dirName = m.serverPath(dirName)
+	if m.Kind == "plex" {
+		return m.refreshPlex(dirName)
+	}
+	return m.refreshJellyfin(dirName)
+}
+
+// Sends a request and checks for a 2xx status
+func (m *MediaServer) do(req *http.Request) (*http.Response, error) {
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s %s: %s", m.Kind, req.Method, req.URL.Path, resp.Status)
+	}
+	return resp, nil
+}
+
+// Plex needs the section that contains the dir, then does a partial scan of just that path
+func (m *MediaServer) refreshPlex(dirName string) error {
+	req, err := http.NewRequest("GET", m.URL+"/library/sections?X-Plex-Token="+url.QueryEscape(m.Token), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := m.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var sections struct {
+		Directories []struct {
+			Key       string `xml:"key,attr"`
+			Locations []struct {
+				Path string `xml:"path,attr"`
+			} `xml:"Location"`
+		} `xml:"Directory"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&sections); err != nil {
+		return err
+	}
+
+	for _, section := range sections.Directories {
+		for _, location := range section.Locations {
+			if dirName == location.Path || strings.HasPrefix(dirName, strings.TrimSuffix(location.Path, "/")+"/") {
+				query := url.Values{"path": {dirName}, "X-Plex-Token": {m.Token}}
+				req, err := http.NewRequest("GET", m.URL+"/library/sections/"+section.Key+"/refresh?"+query.Encode(), nil)
+				if err != nil {
+					return err
+				}
+				resp, err := m.do(req)
+				if err != nil {
+					return err
+				}
+				resp.Body.Close()
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("plex: no library contains %s", dirName)
+}
+
+// Jellyfin and Emby share the same api for reporting changed paths
+func (m *MediaServer) refreshJellyfin(dirName string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"Updates": []map[string]string{{"Path": dirName, "UpdateType": "Modified"}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", m.URL+"/Library/Media/Updated", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Emby-Token", m.Token)
+	resp, err := m.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
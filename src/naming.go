@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+	// so -timezone works on machines without a time zone database, like Windows
+	_ "time/tzdata"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+)
+
+// namingFlags are the flags naming shrunk files
+type namingFlags struct {
+	template, timezone *string
+	keepName           *bool
+	dirTemplate        *string
+	dateDirs           *bool
+	datePatterns       listFlag
+	collisions, times  *string
+}
+
+// Adds the flags naming shrunk files, they are applied with setupNaming once they are parsed
+func addNamingFlags(flags *flag.FlagSet) *namingFlags {
+	templatePtr := flags.String("name-template", shrink.DefaultNameTemplate,
+		"name of shrunk files without .mp4, with the fields .Date, .Time, .OriginalName and .Camera, eg. {{.Date}}_{{.OriginalName}}_{{.Camera}}")
+	timezonePtr := flags.String("timezone", "Local", "time zone capture dates are named in, and dates in file names are read in, eg. UTC or Europe/Amsterdam")
+	keepNamePtr := flags.Bool("keep-name", false, "keep the name of the original, only changing its extension to .mp4, same as -name-template "+shrink.OriginalNameTemplate)
+	collisionsPtr := flags.String("collisions", shrink.CollisionRename, "when the name of a shrunk file is taken in the output, -o or a -mirror, rename it after its camera or with a counter, or skip the file")
+	dirTemplatePtr := flags.String("dir-template", "", "dir of shrunk files in the output and mirrors instead of that of the original, with the fields of -name-template, eg. {{.Time.Format \"2006/01\"}}/{{.Camera}}")
+	dateDirsPtr := flags.Bool("date-dirs", false, "sort shrunk files in the output and mirrors into dirs by when they were shot, eg. 2016/2016-05, same as -dir-template '"+shrink.DateDirTemplate+"'")
+	naming := &namingFlags{template: templatePtr, timezone: timezonePtr, keepName: keepNamePtr, dirTemplate: dirTemplatePtr, dateDirs: dateDirsPtr,
+		collisions: collisionsPtr, times: addTimesFlag(flags)}
+	flags.Var(&naming.datePatterns, "date-pattern", "regexp reading capture dates from file names with the groups (?P<year>), (?P<month>), (?P<day>) and optionally (?P<hour>), (?P<minute>), (?P<second>), can be repeated")
+	return naming
+}
+
+// Adds the -times flag
+func addTimesFlag(flags *flag.FlagSet) *string {
+	return flags.String("times", shrink.TimesCapture, "what shrunk files and their copies in the output are dated with: capture, when they were shot, encode, when they were encoded, or none to leave them dated by writing them")
+}
+
+// Returns the -times policy, failing if it is unknown
+func setupTimes(times string) (string, error) {
+	switch times {
+	case shrink.TimesCapture, shrink.TimesEncode, shrink.TimesNone:
+		return times, nil
+	}
+	return "", fmt.Errorf("invalid -times, need capture, encode or none: %s", times)
+}
+
+// Sets the template and time zone naming shrunk files, failing if they are invalid
+func setupNaming(opts *shrink.Options, naming *namingFlags) error {
+	loc, err := time.LoadLocation(*naming.timezone)
+	if err != nil {
+		return fmt.Errorf("invalid time zone: %v", err)
+	}
+	opts.Location = loc
+	if opts.Times, err = setupTimes(*naming.times); err != nil {
+		return err
+	}
+	switch *naming.collisions {
+	case shrink.CollisionRename, shrink.CollisionSkip:
+		opts.Collisions = *naming.collisions
+	default:
+		return fmt.Errorf("invalid -collisions, need rename or skip: %s", *naming.collisions)
+	}
+	for _, expr := range naming.datePatterns {
+		pattern, err := shrink.ParseDatePattern(expr)
+		if err != nil {
+			return fmt.Errorf("invalid date pattern: %v", err)
+		}
+		opts.DatePatterns = append(opts.DatePatterns, pattern)
+	}
+	dirText := *naming.dirTemplate
+	if *naming.dateDirs {
+		if len(dirText) > 0 && dirText != shrink.DateDirTemplate {
+			return fmt.Errorf("-date-dirs and -dir-template can't be used together")
+		}
+		dirText = shrink.DateDirTemplate
+	}
+	if len(dirText) > 0 {
+		tmpl, err := shrink.ParseNameTemplate(dirText)
+		if err != nil {
+			return fmt.Errorf("invalid dir template: %v", err)
+		}
+		opts.DirTemplate = tmpl
+	}
+	text := *naming.template
+	if *naming.keepName {
+		if len(text) > 0 && text != shrink.DefaultNameTemplate {
+			return fmt.Errorf("-keep-name and -name-template can't be used together")
+		}
+		text = shrink.OriginalNameTemplate
+	}
+	if len(text) == 0 || text == shrink.DefaultNameTemplate {
+		return nil
+	}
+	tmpl, err := shrink.ParseNameTemplate(text)
+	if err != nil {
+		return fmt.Errorf("invalid name template: %v", err)
+	}
+	opts.NameTemplate = tmpl
+	return nil
+}
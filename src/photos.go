@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+)
+
+// photoFlags are the flags recompressing photos
+type photoFlags struct {
+	photos  *bool
+	quality *int
+	magick  *string
+}
+
+// Adds the flags recompressing photos, they are applied with setupPhotos once they are parsed
+func addPhotoFlags(flags *flag.FlagSet) *photoFlags {
+	return &photoFlags{
+		photos:  flags.Bool("photos", false, "recompress jpeg, png and heic photos with ImageMagick as well as shrinking movies"),
+		quality: flags.Int("photo-quality", shrink.DefaultPhotoQuality, "jpeg and heic quality from 1 to 100, pngs are compressed losslessly"),
+		magick:  flags.String("magick-path", "magick", "ImageMagick 7 executable, looked up in the PATH unless it is a path"),
+	}
+}
+
+// Sets the photo encoder if -photos is set, failing if the quality is invalid
+// and with a missingError if ImageMagick can't be found
+func setupPhotos(opts *shrink.Options, photos *photoFlags, runner shrink.ExecRunner) error {
+	if !*photos.photos {
+		return nil
+	}
+	if *photos.quality < 1 || *photos.quality > 100 {
+		return fmt.Errorf("invalid photo quality, it must be from 1 to 100: %d", *photos.quality)
+	}
+	magick, err := exec.LookPath(*photos.magick)
+	if err != nil {
+		return missingError{fmt.Errorf("unable to find ImageMagick, install it or set -magick-path: %v", err)}
+	}
+	runner.Magick = magick
+	opts.Encoder.Runner = runner
+	opts.Photos = &shrink.PhotoEncoder{Quality: *photos.quality, Runner: runner}
+	return nil
+}
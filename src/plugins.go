@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	filepath "path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Plugin kinds
+const (
+	PluginFilter      = "filter"
+	PluginName        = "name"
+	PluginDestination = "destination"
+)
+
+// Plugin is an executable in the plugins dir. Every call runs it with a single json request on stdin
+// and reads a single json response from stdout, eg.
+//
+//	{"type": "filter", "file": "/srv/camera/a.mov", "name": "a.mov"}  ->  {"accept": false, "reason": "too short"}
+type Plugin struct {
+	Path  string
+	Name  string
+	Kinds []string
+}
+
+// pluginRequest is written to a plugin's stdin
+type pluginRequest struct {
+	// Type is describe, filter, name or upload
+	Type        string             `json:"type"`
+	File        string             `json:"file,omitempty"`
+	Name        string             `json:"name,omitempty"`
+	Result      *shrink.FileResult `json:"result,omitempty"`
+	CaptureTime *time.Time         `json:"captureTime,omitempty"`
+}
+
+// pluginResponse is read from a plugin's stdout, a non-empty error fails the call
+type pluginResponse struct {
+	// Name is the plugin name for describe, or the new name of the file for name
+	Name  string   `json:"name"`
+	Kinds []string `json:"kinds"`
+	// Accept and Reason answer filter, a missing accept accepts the file
+	Accept *bool  `json:"accept"`
+	Reason string `json:"reason"`
+	Error  string `json:"error"`
+}
+
+// Runs the plugin with a request, its stderr goes to ours
+func (p *Plugin) call(ctx context.Context, req pluginRequest) (pluginResponse, error) {
+	var resp pluginResponse
+	in, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(in), &out, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return resp, fmt.Errorf("plugin %s: %v", p.Name, err)
+	}
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		return resp, fmt.Errorf("plugin %s: invalid response: %v", p.Name, err)
+	}
+	if len(resp.Error) > 0 {
+		return resp, fmt.Errorf("plugin %s: %s", p.Name, resp.Error)
+	}
+	return resp, nil
+}
+
+// Returns true if the plugin handles a kind of call
+func (p *Plugin) Has(kind string) bool {
+	for _, k := range p.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Upload hands a finished file to a destination plugin
+func (p *Plugin) Upload(ctx context.Context, fileName, relName string, captureTime time.Time) error {
+	request := pluginRequest{Type: "upload", File: fileName, Name: relName}
+	if !captureTime.IsZero() {
+		request.CaptureTime = &captureTime
+	}
+	_, err := p.call(ctx, request)
+	return err
+}
+
+// Returns true if a file in the plugins dir can be run
+func isExecutable(info os.FileInfo) bool {
+	if info.IsDir() || info.Name()[0] == '.' {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		switch strings.ToLower(filepath.Ext(info.Name())) {
+		case ".exe", ".bat", ".cmd":
+			return true
+		}
+		return false
+	}
+	return info.Mode()&0111 != 0
+}
+
+// LoadPlugins asks every executable in the dir what it does, plugins that can't describe themselves are skipped
+func LoadPlugins(ctx context.Context, dirName string) ([]*Plugin, error) {
+	files, err := ioutil.ReadDir(dirName)
+	if err != nil {
+		return nil, err
+	}
+	var plugins []*Plugin
+	for _, f := range files {
+		if !isExecutable(f) {
+			continue
+		}
+		plugin := &Plugin{Path: filepath.Join(dirName, f.Name()), Name: f.Name()}
+		resp, err := plugin.call(ctx, pluginRequest{Type: "describe"})
+		if err != nil {
+			log.Error("Skipping plugin: ", err)
+			continue
+		}
+		if len(resp.Name) > 0 {
+			plugin.Name = resp.Name
+		}
+		plugin.Kinds = resp.Kinds
+		log.Info("Loaded plugin: ", plugin.Name, " ", strings.Join(plugin.Kinds, ","))
+		plugins = append(plugins, plugin)
+	}
+	return plugins, nil
+}
+
+// Chains the filter and name plugins into the options, after any hooks already set.
+// Returns the destination plugins, which are uploaders.
+func setupPlugins(opts *shrink.Options, plugins []*Plugin) []shrink.Uploader {
+	var filters, namers []*Plugin
+	var destinations []shrink.Uploader
+	for _, plugin := range plugins {
+		if plugin.Has(PluginFilter) {
+			filters = append(filters, plugin)
+		}
+		if plugin.Has(PluginName) {
+			namers = append(namers, plugin)
+		}
+		if plugin.Has(PluginDestination) {
+			destinations = append(destinations, plugin)
+		}
+	}
+
+	if len(filters) > 0 {
+		before := opts.Before
+		opts.Before = func(ctx context.Context, fileName, name string) error {
+			if before != nil {
+				if err := before(ctx, fileName, name); err != nil {
+					return err
+				}
+			}
+			for _, filter := range filters {
+				resp, err := filter.call(ctx, pluginRequest{Type: "filter", File: fileName, Name: name})
+				if err != nil {
+					return err
+				}
+				if resp.Accept != nil && !*resp.Accept {
+					return fmt.Errorf("%w by %s: %s", shrink.ErrSkip, filter.Name, resp.Reason)
+				}
+			}
+			return nil
+		}
+	}
+
+	if len(namers) > 0 {
+		opts.Namer = func(ctx context.Context, result shrink.FileResult, name string) (string, error) {
+			// each plugin sees the name the one before it picked
+			newName := ""
+			for _, namer := range namers {
+				resp, err := namer.call(ctx, pluginRequest{Type: "name", File: result.Result, Name: name, Result: &result})
+				if err != nil {
+					return newName, err
+				}
+				if len(resp.Name) > 0 {
+					newName, name = resp.Name, resp.Name
+				}
+			}
+			return newName, nil
+		}
+	}
+	return destinations
+}
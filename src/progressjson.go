@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	log "github.com/sirupsen/logrus"
+)
+
+// ProgressEvent is a line of the -progress-json stream
+type ProgressEvent struct {
+	// Event is started, progress, completed or skipped
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	File  string    `json:"file"`
+	Name  string    `json:"name"`
+	// Percent is how much of the file is encoded, for progress events
+	Percent int `json:"percent,omitempty"`
+	// Outcome is shrunk, kept, failed or cancelled, for completed events
+	Outcome string  `json:"outcome,omitempty"`
+	Result  string  `json:"result,omitempty"`
+	InSize  int64   `json:"inSize,omitempty"`
+	OutSize int64   `json:"outSize,omitempty"`
+	Ratio   float64 `json:"ratio,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	// Reason is why a file was skipped, for skipped events
+	Reason string `json:"reason,omitempty"`
+}
+
+// progressStream writes progress events as json lines for wrappers and GUIs
+type progressStream struct {
+	mu      sync.Mutex
+	out     io.WriteCloser
+	file    string
+	percent int
+	failed  bool
+}
+
+// Opens the progress stream, - for stdout or the name of a file or named pipe, which blocks until it has a reader
+func openProgressStream(fileName string) (*progressStream, error) {
+	if fileName == "-" {
+		return &progressStream{out: os.Stdout}, nil
+	}
+	out, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &progressStream{out: out}, nil
+}
+
+// Sends progress events to the stream, chaining the before, after and progress callbacks of the options
+func (s *progressStream) Attach(opts *shrink.Options) {
+	before, after, progress := opts.Before, opts.After, opts.Progress
+	opts.Before = func(ctx context.Context, fileName, name string) error {
+		// a file the hooks or filters reject isn't started, After sends the skipped event with the reason
+		if before != nil {
+			if err := before(ctx, fileName, name); err != nil {
+				return err
+			}
+		}
+		s.mu.Lock()
+		s.file, s.percent = fileName, 0
+		s.mu.Unlock()
+		s.write(ProgressEvent{Event: "started", File: fileName, Name: name})
+		return nil
+	}
+	opts.Progress = func(name string, fraction float64) {
+		s.progress(name, fraction)
+		if progress != nil {
+			progress(name, fraction)
+		}
+	}
+	opts.After = func(ctx context.Context, result shrink.FileResult, name string) {
+		if after != nil {
+			after(ctx, result, name)
+		}
+		event := ProgressEvent{Event: "completed", File: result.Source, Name: name, Outcome: outcome(result), Result: result.Result,
+			InSize: result.InSize, OutSize: result.OutSize, Ratio: result.Ratio, Error: result.Error}
+		if len(result.Skipped) > 0 {
+			event = ProgressEvent{Event: "skipped", File: result.Source, Name: name, Reason: result.Skipped}
+		}
+		s.write(event)
+	}
+}
+
+// Writes a progress event when the encoded percentage of the current file changes, started events stand for 0
+func (s *progressStream) progress(name string, fraction float64) {
+	percent := int(fraction * 100)
+	if percent > 100 {
+		percent = 100
+	}
+	s.mu.Lock()
+	changed, fileName := percent != s.percent, s.file
+	s.percent = percent
+	s.mu.Unlock()
+	if changed {
+		s.write(ProgressEvent{Event: "progress", File: fileName, Name: name, Percent: percent})
+	}
+}
+
+// Writes an event as a line, a stream whose reader went away is given up on
+func (s *progressStream) write(event ProgressEvent) {
+	event.Time = time.Now()
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed {
+		return
+	}
+	if _, err := s.out.Write(append(line, '\n')); err != nil {
+		log.Error("Could not write progress, stopping the progress stream: ", err)
+		s.failed = true
+	}
+}
+
+// Close closes the stream, unless it is stdout
+func (s *progressStream) Close() error {
+	if s.out == os.Stdout {
+		return nil
+	}
+	return s.out.Close()
+}
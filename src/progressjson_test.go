@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+)
+
+// nopWriteCloser is a buffer the progress stream can close
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestProgressStreamBefore(t *testing.T) {
+	tests := []struct {
+		name      string
+		beforeErr error
+		want      []string
+	}{
+		{"started", nil, []string{"started", "completed"}},
+		{"rejected", fmt.Errorf("%w by min-length: shorter than 5s", shrink.ErrSkip), []string{"skipped"}},
+		{"hook failed", errors.New("pre hook: sh not found"), []string{"completed"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			stream := &progressStream{out: nopWriteCloser{&out}}
+			opts := shrink.Options{Before: func(ctx context.Context, fileName, name string) error { return test.beforeErr }}
+			stream.Attach(&opts)
+
+			// the processor calls After with the outcome of Before
+			result := shrink.FileResult{Source: "movie.avi", Result: "movie.avi"}
+			if err := opts.Before(context.Background(), "movie.avi", "movie.avi"); errors.Is(err, shrink.ErrSkip) {
+				result.Skipped = err.Error()
+			} else if err != nil {
+				result.Error = err.Error()
+			}
+			opts.After(context.Background(), result, "movie.avi")
+
+			var events []string
+			decoder := json.NewDecoder(&out)
+			for decoder.More() {
+				var event ProgressEvent
+				if err := decoder.Decode(&event); err != nil {
+					t.Fatal(err)
+				}
+				events = append(events, event.Event)
+				if event.Event == "skipped" && len(event.Reason) == 0 {
+					t.Error("skipped event without a reason")
+				}
+			}
+			if !reflect.DeepEqual(events, test.want) {
+				t.Errorf("events = %v, want %v", events, test.want)
+			}
+		})
+	}
+}
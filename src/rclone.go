@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+)
+
+// RcloneRemote reads and writes files on any rclone remote, eg. rclone:gdrive:Videos
+type RcloneRemote struct {
+	// Root is the rclone remote and path, eg. gdrive:Videos
+	Root string
+}
+
+// IsRcloneURI returns true if the location is an rclone remote
+func IsRcloneURI(location string) bool {
+	return strings.HasPrefix(location, "rclone:")
+}
+
+// NewRcloneRemote creates a remote from an rclone:remote:path location
+func NewRcloneRemote(location string) RcloneRemote {
+	return RcloneRemote{Root: strings.TrimSuffix(strings.TrimPrefix(location, "rclone:"), "/")}
+}
+
+// Returns the rclone path of a name relative to the root
+func (r RcloneRemote) path(relName string) string {
+	if strings.HasSuffix(r.Root, ":") {
+		return r.Root + relName
+	}
+	return r.Root + "/" + relName
+}
+
+// Runs rclone, remotes and credentials come from the users rclone config
+func runRclone(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "rclone", args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
+
+// List returns the names of all movies below the root
+func (r RcloneRemote) List(ctx context.Context) ([]string, error) {
+	out, err := runRclone(ctx, "lsjson", "--recursive", "--files-only", "--no-mimetype", r.Root)
+	if err != nil {
+		return nil, err
+	}
+	var listing []struct {
+		Path string
+	}
+	if err := json.Unmarshal(out, &listing); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range listing {
+		if shrink.IsMovie(entry.Path) {
+			names = append(names, path.Clean(entry.Path))
+		}
+	}
+	return names, nil
+}
+
+// Download copies a file into the temp dir, rclone preserves the mod time
+func (r RcloneRemote) Download(ctx context.Context, relName, fileName string) error {
+	_, err := runRclone(ctx, "copyto", r.path(relName), fileName)
+	return err
+}
+
+// Upload copies a finished file to the remote, rclone preserves the mod time
+func (r RcloneRemote) Upload(ctx context.Context, fileName, relName string, captureTime time.Time) error {
+	_, err := runRclone(ctx, "copyto", fileName, r.path(relName))
+	return err
+}
+
+// Verify checks the file on the remote has the size of the local file
+func (r RcloneRemote) Verify(ctx context.Context, fileName, relName string) error {
+	out, err := runRclone(ctx, "lsjson", "--files-only", "--no-mimetype", r.path(relName))
+	if err != nil {
+		return err
+	}
+	var listing []struct {
+		Size int64
+	}
+	if err := json.Unmarshal(out, &listing); err != nil {
+		return err
+	}
+	if len(listing) != 1 {
+		return fmt.Errorf("not found on remote: %s", relName)
+	}
+	return verifySize(fileName, listing[0].Size)
+}
+
+// Delete removes a file from the remote
+func (r RcloneRemote) Delete(ctx context.Context, relName string) error {
+	_, err := runRclone(ctx, "deletefile", r.path(relName))
+	return err
+}
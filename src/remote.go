@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+)
+
+// IsRemoteInput returns true if the input location is a remote rather than a local directory
+func IsRemoteInput(location string) bool {
+	return IsS3URI(location) || IsRcloneURI(location)
+}
+
+// NewRemote creates a remote for an s3:// or rclone: location
+func NewRemote(location string) (shrink.Remote, error) {
+	switch {
+	case IsS3URI(location):
+		return NewS3Remote(location)
+	case IsRcloneURI(location):
+		return NewRcloneRemote(location), nil
+	}
+	return nil, fmt.Errorf("unsupported remote: %s", location)
+}
+
+// Processes a remote input, uploading the results to out or replacing the originals if out is empty or the input itself
+func processRemote(ctx context.Context, p *shrink.Processor, out string) error {
+	in, err := NewRemote(p.InDir)
+	if err != nil {
+		return err
+	}
+	var dest shrink.Uploader
+	if len(out) > 0 && out != p.InDir {
+		if dest, err = NewUploader(out); err != nil {
+			return err
+		}
+	}
+	if err := p.ProcessRemote(ctx, in, dest); err != nil {
+		return fmt.Errorf("unable to process %s: %v", p.InDir, err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	filepath "path/filepath"
+	"strings"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	log "github.com/sirupsen/logrus"
+)
+
+// repairFlags are the flags salvaging damaged movies
+type repairFlags struct {
+	repair     *bool
+	reference  *string
+	quarantine *string
+	untrunc    *string
+}
+
+// Adds the flags salvaging damaged movies, they are applied with setupRepair once they are parsed
+func addRepairFlags(flags *flag.FlagSet) *repairFlags {
+	return &repairFlags{
+		repair:     flags.Bool("repair", false, "salvage damaged movies before shrinking them, replacing the original even if it doesn't shrink"),
+		reference:  flags.String("repair-reference", "", "healthy movie from the same camera, to rebuild the index of interrupted recordings with untrunc"),
+		quarantine: flags.String("quarantine", "", "move movies that can't be repaired into this dir, keeping their dir below the input"),
+		untrunc:    flags.String("untrunc-path", "untrunc", "untrunc executable, looked up in the PATH unless it is a path"),
+	}
+}
+
+// Sets the repairer if -repair is set, with a missingError if untrunc is needed and can't be found
+func setupRepair(opts *shrink.Options, repair *repairFlags) error {
+	if !*repair.repair {
+		return nil
+	}
+	repairer, err := newRepairer(opts, repair)
+	if err != nil {
+		return err
+	}
+	opts.Repair = repairer
+	return nil
+}
+
+// Adds the flags looking for blank clips, they are applied with setupBlank
+func addBlankFlags(flags *flag.FlagSet) (*bool, *string) {
+	blankPtr := flags.Bool("blank-clips", false, "flag movies that are black all along, like recordings with the lens cap on, in the report")
+	reviewPtr := flags.String("blank-dir", "", "move blank clips into this dir instead of encoding them, keeping their dir below the input")
+	return blankPtr, reviewPtr
+}
+
+// Returns the blank clip check of the flags, nil if -blank-clips isn't set, failing if only -blank-dir is
+func setupBlank(blank bool, reviewDir string) (*shrink.BlankClips, error) {
+	if !blank {
+		if len(reviewDir) > 0 {
+			return nil, fmt.Errorf("-blank-dir needs -blank-clips")
+		}
+		return nil, nil
+	}
+	return &shrink.BlankClips{ReviewDir: reviewDir}, nil
+}
+
+// Returns the repairer of the flags, finding untrunc if there is a reference movie
+func newRepairer(opts *shrink.Options, repair *repairFlags) (*shrink.Repairer, error) {
+	if len(*repair.reference) > 0 {
+		untrunc, err := exec.LookPath(*repair.untrunc)
+		if err != nil {
+			return nil, missingError{fmt.Errorf("unable to find untrunc, install it or set -untrunc-path: %v", err)}
+		}
+		runner, _ := opts.Encoder.Runner.(shrink.ExecRunner)
+		runner.Untrunc = untrunc
+		opts.Encoder.Runner = runner
+	}
+	return &shrink.Repairer{Reference: *repair.reference, QuarantineDir: *repair.quarantine}, nil
+}
+
+// Salvages the damaged movies below the input instead of shrinking them, replacing them or saving them elsewhere
+func repairCommand(flags *flag.FlagSet) func(*Config) {
+	inPtr, logFormatPtr := addInputFlags(flags)
+	outPtr := flags.String("o", "", "directory to save the repaired movies to, replacing the damaged ones if empty")
+	repair := addRepairFlags(flags)
+	tools := addFFmpegFlags(flags)
+	return func(*Config) {
+		setupLogging(*logFormatPtr)
+		ctx, cancel := signalContext()
+		defer cancel()
+		opts := shrink.Options{Encoder: shrink.Encoder{Runner: tools.Runner()}}
+		repairer, err := newRepairer(&opts, repair)
+		if err != nil {
+			fatal(setupExitCode(err), err)
+		}
+		encoder := opts.Encoder
+
+		fileNames, err := findMovies(ctx, *inPtr)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		tmpDir, _ := ioutil.TempDir("", "shrink-repair")
+		defer os.RemoveAll(tmpDir)
+
+		damaged, failed := 0, 0
+		for _, fileName := range fileNames {
+			problems := encoder.Check(ctx, fileName)
+			if ctx.Err() != nil {
+				log.Info("Cancelled")
+				exitCode = exitInterrupted
+				return
+			}
+			if len(problems) == 0 {
+				continue
+			}
+			damaged++
+			name := filepath.Base(fileName)
+			if rel, err := filepath.Rel(*inPtr, fileName); err == nil && rel != "." {
+				name = filepath.ToSlash(rel)
+			}
+			log.Info("Repairing: ", fileName, " ", strings.Join(problems, ", "))
+			repairedFile := filepath.Join(tmpDir, filepath.Base(fileName))
+			if err := encoder.Repair(ctx, fileName, repairedFile, repairer.Reference); err != nil {
+				os.Remove(repairedFile)
+				if ctx.Err() != nil {
+					log.Info("Cancelled")
+					exitCode = exitInterrupted
+					return
+				}
+				log.Error("Could not repair: ", fileName, " ", err)
+				if err := repairer.Quarantine(fileName, name); err != nil {
+					log.Error("Could not quarantine: ", fileName, err)
+				}
+				failed++
+				continue
+			}
+			if len(*outPtr) == 0 {
+				if _, err := (shrink.Swapper{}).Swap(fileName, repairedFile); err != nil {
+					log.Error("Could not replace damaged movie: ", fileName, " ", err)
+					os.Remove(repairedFile)
+					failed++
+					continue
+				}
+				log.Info("Repaired: ", fileName)
+				continue
+			}
+			outFile := filepath.Join(*outPtr, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(outFile), 0755); err != nil {
+				fatal(exitConfig, err)
+			}
+			if err := shrink.CopyFile(repairedFile, outFile); err != nil {
+				log.Error("Could not save repaired movie: ", outFile, err)
+				failed++
+			} else {
+				log.Info("Repaired: ", fileName, " to: ", outFile)
+			}
+			os.Remove(repairedFile)
+		}
+		log.Info("Checked ", len(fileNames), " files, damaged: ", damaged, " not repaired: ", failed)
+		if failed > 0 {
+			exitCode = exitFailures
+		} else if damaged == 0 {
+			exitCode = exitNothingToDo
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+)
+
+// s3Location is a bucket and key prefix parsed from an s3://bucket/prefix URI
+type s3Location struct {
+	Bucket string
+	Prefix string
+}
+
+// IsS3URI returns true if the location points to an S3 bucket
+func IsS3URI(location string) bool {
+	return strings.HasPrefix(location, "s3://")
+}
+
+// Parses an s3://bucket/prefix URI, the prefix may be empty
+func parseS3URI(uri string) (s3Location, error) {
+	trimmed := strings.TrimPrefix(uri, "s3://")
+	parts := strings.SplitN(trimmed, "/", 2)
+	if len(parts[0]) == 0 {
+		return s3Location{}, fmt.Errorf("invalid S3 URI, missing bucket: %s", uri)
+	}
+	loc := s3Location{Bucket: parts[0]}
+	if len(parts) > 1 {
+		loc.Prefix = strings.TrimSuffix(parts[1], "/")
+	}
+	return loc, nil
+}
+
+// Returns the full s3:// URI for a key in the bucket
+func (l s3Location) uri(key string) string {
+	return "s3://" + l.Bucket + "/" + key
+}
+
+// Returns the key for a path relative to the prefix
+func (l s3Location) key(rel string) string {
+	if len(l.Prefix) == 0 {
+		return rel
+	}
+	return path.Join(l.Prefix, rel)
+}
+
+// Runs the aws cli, all S3 access goes through it so the usual credential chain and profiles work
+func runAWS(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "aws", args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
+
+// S3Remote reads and writes files below a bucket prefix.
+// S3 compatible services like Backblaze B2 work by setting AWS_ENDPOINT_URL or endpoint_url in the aws profile.
+type S3Remote struct {
+	Location s3Location
+	// StorageClass for uploads, eg. GLACIER or DEEP_ARCHIVE, empty for the bucket default
+	StorageClass string
+}
+
+// NewS3Remote creates a remote from an s3://bucket/prefix URI
+func NewS3Remote(uri string) (S3Remote, error) {
+	loc, err := parseS3URI(uri)
+	return S3Remote{Location: loc}, err
+}
+
+// List returns the names of all movie objects relative to the prefix
+func (s S3Remote) List(ctx context.Context) ([]string, error) {
+	args := []string{"s3api", "list-objects-v2", "--bucket", s.Location.Bucket, "--output", "json"}
+	if len(s.Location.Prefix) > 0 {
+		args = append(args, "--prefix", s.Location.Prefix+"/")
+	}
+	out, err := runAWS(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// aws cli prints nothing at all if the prefix is empty
+	var listing struct {
+		Contents []struct {
+			Key string
+		}
+	}
+	if len(out) > 0 {
+		if err := json.Unmarshal(out, &listing); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	for _, obj := range listing.Contents {
+		if shrink.IsMovie(obj.Key) {
+			names = append(names, strings.TrimPrefix(strings.TrimPrefix(obj.Key, s.Location.Prefix), "/"))
+		}
+	}
+	return names, nil
+}
+
+// Download copies an object to a local file, aws cli sets the mod time to the objects last modified time
+func (s S3Remote) Download(ctx context.Context, relName, fileName string) error {
+	_, err := runAWS(ctx, "s3", "cp", "--only-show-errors", s.Location.uri(s.Location.key(relName)), fileName)
+	return err
+}
+
+// Upload copies the file to the key matching its relative name
+func (s S3Remote) Upload(ctx context.Context, fileName, relName string, captureTime time.Time) error {
+	args := []string{"s3", "cp", "--only-show-errors", fileName, s.Location.uri(s.Location.key(relName))}
+	if len(s.StorageClass) > 0 {
+		args = append(args, "--storage-class", s.StorageClass)
+	}
+	_, err := runAWS(ctx, args...)
+	return err
+}
+
+// Verify checks the object of a relative name has the size of the file
+func (s S3Remote) Verify(ctx context.Context, fileName, relName string) error {
+	out, err := runAWS(ctx, "s3api", "head-object", "--bucket", s.Location.Bucket, "--key", s.Location.key(relName), "--output", "json")
+	if err != nil {
+		return err
+	}
+	var head struct {
+		ContentLength int64
+	}
+	if err := json.Unmarshal(out, &head); err != nil {
+		return err
+	}
+	return verifySize(fileName, head.ContentLength)
+}
+
+// Delete removes an object
+func (s S3Remote) Delete(ctx context.Context, relName string) error {
+	_, err := runAWS(ctx, "s3", "rm", "--only-show-errors", s.Location.uri(s.Location.key(relName)))
+	return err
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	filepath "path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	log "github.com/sirupsen/logrus"
+)
+
+// Job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a path submitted to the server for processing
+type Job struct {
+	ID       int            `json:"id"`
+	Path     string         `json:"path"`
+	Output   string         `json:"output,omitempty"`
+	Status   string         `json:"status"`
+	Error    string         `json:"error,omitempty"`
+	Created  time.Time      `json:"created"`
+	Started  *time.Time     `json:"started,omitempty"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Summary  shrink.Summary `json:"summary"`
+
+	report *shrink.Report
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Progress is what the server is working on right now
+type Progress struct {
+	JobID    int     `json:"jobId,omitempty"`
+	File     string  `json:"file,omitempty"`
+	Fraction float64 `json:"fraction"`
+}
+
+// Status is the overall state of the server
+type Status struct {
+	Paused  bool     `json:"paused"`
+	Queued  int      `json:"queued"`
+	Current Progress `json:"current"`
+}
+
+// Server runs submitted jobs one at a time and exposes them over a REST API
+type Server struct {
+	TmpDir string
+	// Options are the encoder settings and hooks every job starts from
+	Options shrink.Options
+	// Token must be sent as a bearer token to submit, cancel or reorder jobs and to pause, which is refused without one
+	Token string
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    []*Job
+	nextID  int
+	queue   []*Job
+	paused  bool
+	current Progress
+	// encoding is true while ffmpeg encodes a file of the current job, lastActivity is when it last made progress
+	encoding     bool
+	lastActivity time.Time
+}
+
+// stallTimeout is how long an encode can go without progress before the server counts as stalled
+const stallTimeout = 15 * time.Minute
+
+// Alive returns an error if the current encode has stopped making progress. Time spent paused, waiting for
+// temp space, uploading or checking quality doesn't count.
+func (s *Server) Alive() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.encoding && time.Since(s.lastActivity) > stallTimeout {
+		return fmt.Errorf("job %d made no progress since %s", s.current.JobID, s.lastActivity.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// NewServer creates a server and starts its worker
+func NewServer(tmpDir string) *Server {
+	s := &Server{TmpDir: tmpDir, nextID: 1}
+	s.cond = sync.NewCond(&s.mu)
+	go s.worker()
+	return s
+}
+
+// Submit validates and queues a new job
+func (s *Server) Submit(path, output string) (*Job, error) {
+	if len(path) == 0 {
+		return nil, errors.New("path is required")
+	}
+	if !IsRemoteInput(path) {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := &Job{
+		ID:      s.nextID,
+		Path:    path,
+		Output:  output,
+		Status:  JobQueued,
+		Created: time.Now(),
+		report:  &shrink.Report{},
+	}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	s.nextID++
+	s.jobs = append(s.jobs, job)
+	s.queue = append(s.queue, job)
+	s.cond.Broadcast()
+	return job, nil
+}
+
+// Cancel stops a queued job, or a running job along with its current encode
+func (s *Server) Cancel(id int) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.find(id)
+	if job == nil {
+		return nil, false
+	}
+	if (job.Status == JobQueued || job.Status == JobRunning) && job.ctx.Err() == nil {
+		job.cancel()
+		if job.Status == JobQueued {
+			s.dequeue(job)
+			s.finish(job, JobCancelled, nil)
+		}
+		// wake up a paused job so it notices
+		s.cond.Broadcast()
+	}
+	return job, true
+}
+
+// MoveToFront makes a queued job the next one to run
+func (s *Server) MoveToFront(id int) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.find(id)
+	if job == nil || job.Status != JobQueued {
+		return job, false
+	}
+	s.dequeue(job)
+	s.queue = append([]*Job{job}, s.queue...)
+	return job, true
+}
+
+// SetPaused pauses or resumes processing, a paused server finishes the current file and then waits
+func (s *Server) SetPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+	s.cond.Broadcast()
+}
+
+// Status returns the overall state of the server
+func (s *Server) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Status{Paused: s.paused, Queued: len(s.queue), Current: s.current}
+}
+
+// Returns the state to publish over MQTT, with the savings of all jobs so far
+func (s *Server) mqttState() MQTTState {
+	s.mu.Lock()
+	jobs := append([]*Job(nil), s.jobs...)
+	s.mu.Unlock()
+
+	var total shrink.Summary
+	for _, job := range jobs {
+		summary := job.report.Summary()
+		total.Processed += summary.Processed
+		total.Shrunk += summary.Shrunk
+		total.Failed += summary.Failed
+		total.Saved += summary.Saved
+	}
+	status := s.Status()
+	return mqttState(total, status.Current, status.Queued)
+}
+
+// Blocks while the server is paused, unless the job gets cancelled
+func (s *Server) waitWhilePaused(job *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.paused && job.ctx.Err() == nil {
+		s.cond.Wait()
+	}
+}
+
+// Removes a job from the queue, needs the lock held
+func (s *Server) dequeue(job *Job) {
+	for i, queued := range s.queue {
+		if queued == job {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// Returns a job by id, needs the lock held
+func (s *Server) find(id int) *Job {
+	for _, job := range s.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// Marks a job as finished, needs the lock held
+func (s *Server) finish(job *Job, status string, err error) {
+	now := time.Now()
+	job.Status = status
+	job.Finished = &now
+	if err != nil {
+		job.Error = err.Error()
+	}
+}
+
+// Returns a snapshot of a job that is safe to serialize
+func (s *Server) snapshot(job *Job) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := *job
+	snap.Summary = job.report.Summary()
+	return snap
+}
+
+// Processes queued jobs in order
+func (s *Server) worker() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 || s.paused {
+			s.cond.Wait()
+		}
+		job := s.queue[0]
+		s.queue = s.queue[1:]
+		now := time.Now()
+		job.Status = JobRunning
+		job.Started = &now
+		s.current = Progress{JobID: job.ID}
+		s.mu.Unlock()
+
+		err := s.runJob(job)
+
+		s.mu.Lock()
+		s.current = Progress{}
+		switch {
+		case job.ctx.Err() != nil:
+			s.finish(job, JobCancelled, nil)
+		case err != nil:
+			s.finish(job, JobFailed, err)
+		default:
+			s.finish(job, JobDone, nil)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Processes a single job, the path can be a directory, a single file or a remote
+func (s *Server) runJob(job *Job) error {
+	// each job gets its own temp dir, so nothing a cancelled job leaves behind is mixed up with the next one
+	jobDir := filepath.Join(s.TmpDir, "job-"+strconv.Itoa(job.ID))
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(jobDir)
+
+	opts := s.Options
+	opts.InDir = job.Path
+	opts.TmpDir = jobDir
+	opts.Report = job.report
+	opts.Pause = func() { s.waitWhilePaused(job) }
+	opts.Progress = func(fileName string, fraction float64) {
+		s.mu.Lock()
+		s.current = Progress{JobID: job.ID, File: fileName, Fraction: fraction}
+		s.lastActivity = time.Now()
+		s.mu.Unlock()
+	}
+	opts.Encoding = func(fileName string, encoding bool) {
+		s.mu.Lock()
+		s.encoding, s.lastActivity = encoding, time.Now()
+		s.mu.Unlock()
+	}
+	if IsRemoteInput(job.Path) {
+		return processRemote(job.ctx, shrink.New(opts), job.Output)
+	}
+	if IsRemoteURI(job.Output) {
+		uploader, err := NewUploader(job.Output)
+		if err != nil {
+			return err
+		}
+		opts.Uploaders = append(append([]shrink.Uploader(nil), opts.Uploaders...), uploader)
+	}
+
+	stat, err := os.Stat(job.Path)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		opts.InDir = filepath.Dir(job.Path)
+		_, err := shrink.New(opts).ProcessFile(job.ctx, job.Path)
+		return err
+	}
+	return shrink.New(opts).Process(job.ctx)
+}
+
+// Writes a value as json
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Writes an error as json
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// Returns true if a token given by a client is the configured one, which must be set
+func tokenMatches(given, token string) bool {
+	return len(token) > 0 && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// Wraps a handler changing jobs, which needs the token and a json body, so web pages open in the user's browser
+// can't change them with a cross-site form or text/plain request
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.Token) == 0 {
+			writeError(w, http.StatusForbidden, errors.New("changing jobs over the API needs -api-token"))
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !tokenMatches(given, s.Token) {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+		if r.Method == http.MethodPost {
+			if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// Looks up the job named in the request path
+func (s *Server) jobFromRequest(w http.ResponseWriter, r *http.Request) *Job {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil
+	}
+	s.mu.Lock()
+	job := s.find(id)
+	s.mu.Unlock()
+	if job == nil {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+	}
+	return job
+}
+
+// Handler returns the REST API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /jobs", s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Path   string `json:"path"`
+			Output string `json:"output"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		job, err := s.Submit(req.Path, req.Output)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, s.snapshot(job))
+	}))
+
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		jobs := append([]*Job(nil), s.jobs...)
+		s.mu.Unlock()
+		snapshots := make([]Job, 0, len(jobs))
+		for _, job := range jobs {
+			snapshots = append(snapshots, s.snapshot(job))
+		}
+		writeJSON(w, http.StatusOK, snapshots)
+	})
+
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if job := s.jobFromRequest(w, r); job != nil {
+			writeJSON(w, http.StatusOK, s.snapshot(job))
+		}
+	})
+
+	mux.HandleFunc("GET /jobs/{id}/report", func(w http.ResponseWriter, r *http.Request) {
+		if job := s.jobFromRequest(w, r); job != nil {
+			writeJSON(w, http.StatusOK, job.report)
+		}
+	})
+
+	mux.HandleFunc("DELETE /jobs/{id}", s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		if job := s.jobFromRequest(w, r); job != nil {
+			s.Cancel(job.ID)
+			writeJSON(w, http.StatusOK, s.snapshot(job))
+		}
+	}))
+
+	mux.HandleFunc("POST /jobs/{id}/top", s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		if job := s.jobFromRequest(w, r); job != nil {
+			if _, ok := s.MoveToFront(job.ID); !ok {
+				writeError(w, http.StatusConflict, errors.New("job is not queued"))
+				return
+			}
+			writeJSON(w, http.StatusOK, s.snapshot(job))
+		}
+	}))
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Status())
+	})
+
+	mux.HandleFunc("POST /pause", s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		s.SetPaused(true)
+		writeJSON(w, http.StatusOK, s.Status())
+	}))
+
+	mux.HandleFunc("POST /resume", s.authorized(func(w http.ResponseWriter, r *http.Request) {
+		s.SetPaused(false)
+		writeJSON(w, http.StatusOK, s.Status())
+	}))
+
+	mux.Handle("GET /", webUI())
+
+	return mux
+}
+
+// Runs the serve subcommand
+func serve(flags *flag.FlagSet) func(*Config) {
+	addrPtr := flags.String("addr", "127.0.0.1:8080", "address to listen on, only this machine can connect by default")
+	apiTokenPtr := flags.String("api-token", "", "bearer token clients must send to submit, cancel and reorder jobs or pause, which is refused without one")
+	grpcAddrPtr := flags.String("grpc-addr", "", "address to serve the gRPC job API on, disabled if empty")
+	webhookTokenPtr := flags.String("webhook-token", "", "token that webhooks must pass as ?token=, POST /webhook is only served with one")
+	webhookPathMapPtr := flags.String("webhook-path-map", "", "sender=local path prefix mapping for files named in webhooks")
+	run := addRunFlags(flags)
+	return func(config *Config) {
+		setupLogging(*run.logFormat)
+
+		// Create temp dir and remember to clean up
+		tmpDir := makeTmpDir(*run.tmpDir, nil)
+		defer os.RemoveAll(tmpDir) // clean up
+
+		server := NewServer(tmpDir)
+		server.Token = *apiTokenPtr
+		opts, runner, err := run.options(config)
+		if err != nil {
+			fatal(setupExitCode(err), err)
+		}
+		server.Options = opts
+		if len(*grpcAddrPtr) > 0 {
+			go func() {
+				log.Info("gRPC listening on: ", *grpcAddrPtr)
+				if err := serveGRPC(*grpcAddrPtr, server); err != nil {
+					log.Fatal(err)
+				}
+			}()
+		}
+		if len(*run.mqtt) > 0 {
+			publisher, err := NewMQTTPublisher(*run.mqtt, *run.mqttTopic, server.mqttState)
+			if err != nil {
+				log.Fatal("Unable to connect to MQTT: ", err)
+			}
+			go publisher.Run(nil)
+			defer publisher.Close()
+		}
+
+		webhooks := &Webhooks{Server: server, Token: *webhookTokenPtr}
+		if len(*webhookPathMapPtr) > 0 {
+			parts := strings.SplitN(*webhookPathMapPtr, "=", 2)
+			if len(parts) != 2 {
+				fatal(exitConfig, "Webhook path map must be sender=local: ", *webhookPathMapPtr)
+			}
+			webhooks.PathFrom, webhooks.PathTo = parts[0], parts[1]
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/", server.Handler())
+		if len(webhooks.Token) > 0 {
+			mux.Handle("POST /webhook", webhooks)
+		} else if len(webhooks.PathFrom) > 0 {
+			fatal(exitConfig, "Error, -webhook-path-map needs -webhook-token, the webhook isn't served without one.")
+		}
+		health := &Health{TmpDir: tmpDir, FFmpeg: runner.FFmpeg, MinTempSpace: *run.minTempSpace << 20, Alive: server.Alive}
+		health.Register(mux)
+
+		log.Info("Listening on: ", *addrPtr)
+		if err := http.ListenAndServe(*addrPtr, mux); err != nil {
+			log.Error(err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorized(t *testing.T) {
+	tests := []struct {
+		name                      string
+		token                     string
+		method, auth, contentType string
+		want                      int
+	}{
+		{"no token configured", "", http.MethodPost, "Bearer ", "application/json", http.StatusForbidden},
+		{"no authorization", "secret", http.MethodPost, "", "application/json", http.StatusUnauthorized},
+		{"wrong token", "secret", http.MethodPost, "Bearer guess", "application/json", http.StatusUnauthorized},
+		{"not a bearer token", "secret", http.MethodPost, "secret", "application/json", http.StatusUnauthorized},
+		{"form post", "secret", http.MethodPost, "Bearer secret", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"text post", "secret", http.MethodPost, "Bearer secret", "text/plain", http.StatusUnsupportedMediaType},
+		{"json post", "secret", http.MethodPost, "Bearer secret", "application/json; charset=utf-8", http.StatusOK},
+		{"delete without a body", "secret", http.MethodDelete, "Bearer secret", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Server{Token: test.token}
+			handler := s.authorized(func(w http.ResponseWriter, r *http.Request) {})
+			r := httptest.NewRequest(test.method, "/jobs", nil)
+			if len(test.auth) > 0 {
+				r.Header.Set("Authorization", test.auth)
+			}
+			if len(test.contentType) > 0 {
+				r.Header.Set("Content-Type", test.contentType)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != test.want {
+				t.Errorf("status = %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestTokenMatches(t *testing.T) {
+	tests := []struct {
+		given, token string
+		want         bool
+	}{
+		{"secret", "secret", true},
+		{"Secret", "secret", false},
+		{"secre", "secret", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		if got := tokenMatches(test.given, test.token); got != test.want {
+			t.Errorf("tokenMatches(%q, %q) = %v, want %v", test.given, test.token, got, test.want)
+		}
+	}
+}
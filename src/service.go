@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// serviceDescription is what the Windows service manager shows for the service
+const serviceDescription = "Shrinks the movies added to a folder with ffmpeg, replacing the originals that got smaller"
+
+// Installs daemon mode as a Windows service or launchd agent with the flags of the run command, or uninstalls it
+func serviceCommand(flags *flag.FlagSet) func(*Config) {
+	namePtr := flags.String("name", "shrink-movies", "name of the Windows service or launchd agent")
+	return func(*Config) {
+		setupLogging("text")
+		args := flags.Args()
+		if len(args) == 0 {
+			fatal(exitConfig, "Error, need install or uninstall, eg. shrink-movies service install -i D:\\Videos")
+		}
+		switch args[0] {
+		case "install":
+			// check the flags now rather than when the service fails to start
+			runFlags := flag.NewFlagSet("run", flag.ContinueOnError)
+			runFlags.SetOutput(ioutil.Discard)
+			process(runFlags)
+			runFlags.String("config", "", "")
+			if err := runFlags.Parse(args[1:]); err != nil {
+				fatal(exitConfig, "Invalid run flags: ", err)
+			}
+			exe, err := os.Executable()
+			if err != nil {
+				fatal(exitError, "Unable to find this executable: ", err)
+			}
+			if err := installService(*namePtr, exe, append([]string{"run", "-daemon"}, args[1:]...)); err != nil {
+				fatal(exitError, "Unable to install service: ", err)
+			}
+			log.Info("Installed and started service: ", *namePtr)
+		case "uninstall":
+			if err := uninstallService(*namePtr); err != nil {
+				fatal(exitError, "Unable to uninstall service: ", err)
+			}
+			log.Info("Uninstalled service: ", *namePtr)
+		default:
+			fatal(exitConfig, "Unknown service action: ", args[0], ", need install or uninstall")
+		}
+	}
+}
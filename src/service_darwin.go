@@ -0,0 +1,98 @@
+//go:build darwin
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	filepath "path/filepath"
+)
+
+// Returns the plist file of a launchd agent of the user
+func launchAgentFile(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", name+".plist"), nil
+}
+
+// Writes a string as a plist string element
+func plistString(buf *bytes.Buffer, s string) {
+	buf.WriteString("<string>")
+	xml.EscapeText(buf, []byte(s))
+	buf.WriteString("</string>")
+}
+
+// Installs a launchd agent running the command line at login, restarted when it fails, and loads it.
+// Its log goes to ~/Library/Logs, and it gets the PATH of the shell installing it so Homebrew's ffmpeg is found.
+func installService(name, exe string, args []string) error {
+	plist, err := launchAgentFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(plist); err == nil {
+		return fmt.Errorf("launchd agent %s is installed already: %s", name, plist)
+	}
+	home, _ := os.UserHomeDir()
+	logFile := filepath.Join(home, "Library", "Logs", name+".log")
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	buf.WriteString("<plist version=\"1.0\">\n<dict>\n\t<key>Label</key>")
+	plistString(&buf, name)
+	buf.WriteString("\n\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range append([]string{exe}, args...) {
+		buf.WriteString("\t\t")
+		plistString(&buf, arg)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\t</array>\n\t<key>EnvironmentVariables</key>\n\t<dict>\n\t\t<key>PATH</key>")
+	plistString(&buf, os.Getenv("PATH"))
+	buf.WriteString("\n\t</dict>\n\t<key>RunAtLoad</key>\n\t<true/>\n")
+	// restart unless it stopped cleanly, eg. after SIGTERM
+	buf.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	buf.WriteString("\t<key>StandardOutPath</key>")
+	plistString(&buf, logFile)
+	buf.WriteString("\n\t<key>StandardErrorPath</key>")
+	plistString(&buf, logFile)
+	buf.WriteString("\n</dict>\n</plist>\n")
+
+	if err := os.MkdirAll(filepath.Dir(plist), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(plist, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if out, err := exec.Command("launchctl", "load", "-w", plist).CombinedOutput(); err != nil {
+		os.Remove(plist)
+		return fmt.Errorf("launchctl load: %v %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// Unloads the launchd agent, which stops it after the current file, and removes its plist
+func uninstallService(name string) error {
+	plist, err := launchAgentFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(plist); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("launchd agent %s isn't installed", name)
+	}
+	if out, err := exec.Command("launchctl", "unload", "-w", plist).CombinedOutput(); err != nil {
+		return fmt.Errorf("launchctl unload: %v %s", err, bytes.TrimSpace(out))
+	}
+	return os.Remove(plist)
+}
+
+// launchd stops agents with SIGTERM, the daemon handles that already
+func runAsService(signals chan<- os.Signal) func() {
+	return func() {}
+}
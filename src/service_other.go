@@ -0,0 +1,26 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errNoServiceManager is returned where daemon mode runs under systemd instead
+var errNoServiceManager = errors.New("services are installed on Windows and macOS, use contrib/shrink-movies.service with systemd")
+
+// Services aren't installed here
+func installService(name, exe string, args []string) error {
+	return errNoServiceManager
+}
+
+// Services aren't installed here
+func uninstallService(name string) error {
+	return errNoServiceManager
+}
+
+// systemd stops services with SIGTERM, the daemon handles that already
+func runAsService(signals chan<- os.Signal) func() {
+	return func() {}
+}
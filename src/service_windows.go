@@ -0,0 +1,152 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Installs a service starting with Windows and running the command line, restarted a minute after it fails,
+// registers its name as a source of the event log and starts it
+func installService(name, exe string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is installed already", name)
+	}
+	s, err := m.CreateService(name, exe, mgr.Config{DisplayName: name, Description: serviceDescription, StartType: mgr.StartAutomatic}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: time.Minute}}, 24*60*60); err != nil {
+		log.Error("Could not set the service to restart when it fails: ", err)
+	}
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return err
+	}
+	return s.Start()
+}
+
+// Stops the service, it finishes the current file first, and removes it and its event log source
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s isn't installed", name)
+	}
+	defer s.Close()
+	if status, err := s.Query(); err == nil && status.State != svc.Stopped {
+		if _, err := s.Control(svc.Stop); err != nil {
+			log.Error("Could not stop service: ", err)
+		}
+	}
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	return eventlog.Remove(name)
+}
+
+// windowsService tells the service manager the daemon is running and passes its stop requests on as SIGTERM
+type windowsService struct {
+	signals chan<- os.Signal
+	done    chan struct{}
+}
+
+// Execute runs until the daemon stops, the first argument is the name of the service
+func (w *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	if len(args) > 0 {
+		logToEventLog(args[0])
+	}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-w.done:
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				select {
+				case w.signals <- syscall.SIGTERM:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// Reports to the service manager if it started the daemon, returning what to call once the daemon stopped
+func runAsService(signals chan<- os.Signal) func() {
+	if isService, err := svc.IsWindowsService(); err != nil || !isService {
+		return func() {}
+	}
+	service := &windowsService{signals: signals, done: make(chan struct{})}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := svc.Run("", service); err != nil {
+			log.Error("Could not run as service: ", err)
+		}
+	}()
+	return func() {
+		close(service.done)
+		<-stopped
+	}
+}
+
+// eventLogHook writes log entries to the Windows event log
+type eventLogHook struct {
+	events *eventlog.Log
+}
+
+// Levels returns all levels, the logger leaves out those below its level
+func (h eventLogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire writes an entry as an error, warning or information event
+func (h eventLogHook) Fire(entry *log.Entry) error {
+	msg, err := entry.String()
+	if err != nil {
+		return err
+	}
+	switch entry.Level {
+	case log.PanicLevel, log.FatalLevel, log.ErrorLevel:
+		return h.events.Error(1, msg)
+	case log.WarnLevel:
+		return h.events.Warning(1, msg)
+	}
+	return h.events.Info(1, msg)
+}
+
+// Sends the log to the event log of the service instead of the console, which services don't have
+func logToEventLog(name string) {
+	events, err := eventlog.Open(name)
+	if err != nil {
+		log.Error("Could not open event log: ", err)
+		return
+	}
+	log.AddHook(eventLogHook{events: events})
+	log.SetOutput(ioutil.Discard)
+}
@@ -3,190 +3,352 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
+	"net/http"
 	"os"
-	"os/exec"
 	filepath "path/filepath"
-	"regexp"
+	"sort"
 	"strings"
 	"time"
 
-	log "github.com/Sirupsen/logrus"
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
 )
 
-// GetFileModTime Helper to get file modification time, useful as a fallback if file is not a jpg.
-func getFileModTime(fileName string) time.Time {
-	var containsDateRegExp = regexp.MustCompile(`^(\d{8})_.*`)
-	matches := containsDateRegExp.FindStringSubmatch(fileName)
-	// if filename is eg. 20160513_181656.mp4 get the date from the filename instead
-	if len(matches) > 0 {
-		// useful if we re-encode a badly encoded camera movie, then we don't want to use the modified date
-		dateStr := matches[1]
-		date, _ := time.Parse("20060102", dateStr)
-		return date
-	}
-
-	// else fetch the files last modification timne
-	stat, err := os.Stat(fileName)
-	if err != nil {
-		log.Error("Unable to get ModTime for file: ", fileName)
-		return time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
-	}
-	return stat.ModTime()
-}
-
-// Gets the size of a file in bytes
-func getFileSize(fileName string) int64 {
-	file, err := os.Open(fileName)
-	if err != nil {
-		log.Error(err)
-	}
-	defer file.Close()
-
-	fileInfo, _ := file.Stat()
-	return fileInfo.Size()
-}
-
-// CopyFile Helper function to copy a file
-func CopyFile(src, dst string) error {
-	// open input file
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	// create dest file
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	// copy contents from source to destination
-	_, err = io.Copy(out, in)
-	cerr := out.Close()
-	if err != nil {
-		return err
+func main() {
+	defer func() {
+		// encoders run in process groups of their own, so they don't die with this process
+		if r := recover(); r != nil {
+			shrink.KillPrograms()
+			panic(r)
+		}
+	}()
+	if len(os.Args) > 1 && (os.Args[1] == "--version" || os.Args[1] == "-version") {
+		runCommand("version", os.Args[2:])
+	} else if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		runCommand(os.Args[1], os.Args[2:])
+	} else {
+		// flags without a command shrink everything, as before there were commands
+		runCommand("run", os.Args[1:])
 	}
-	return cerr
+	os.Exit(exitCode)
 }
 
-// Swaps 2 files
-func swapFiles(inFile, outFile string) string {
-	// create new temp dir
-	swapDir, err := ioutil.TempDir("", "swap")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer os.RemoveAll(swapDir) // clean up
-
-	// swap files around, first move source to temp, then move dest to source
-	if err := CopyFile(inFile, filepath.Join(swapDir, filepath.Base(inFile))); err != nil {
-		log.Error(err)
-	}
-	os.Remove(inFile)
+// Runs the run and watch commands, shrinking every movie below the input and replacing the originals that got smaller
+func process(flags *flag.FlagSet) func(*Config) {
+	var inDirs listFlag
+	flags.Var(&inDirs, "i", "input directory, s3://bucket/prefix or rclone:remote:path, can be repeated to process several local directories in one run")
+	filesFromPtr := flags.String("files-from", "", "process the files listed one per line in this file, or - for stdin, instead of every movie below -i")
+	outDirNamePtr := flags.String("o", "", "output directory, s3://bucket/prefix, rclone:remote:path, sftp://user@host/path or webdav[s]://user:password@host/path")
+	driveFolderPtr := flags.String("gdrive-folder", "", "upload finished files to this Google Drive folder id")
+	driveCredentialsPtr := flags.String("gdrive-credentials", "", "Google credentials json, defaults to the application default credentials")
+	watchPtr := flags.Bool("watch", false, "keep running and process movies as they are added to the input directory")
+	settlePtr := flags.Duration("settle", 30*time.Second, "in watch mode, how long a new file must be unchanged before it is processed")
+	daemonPtr := flags.Bool("daemon", false, "run watch mode as a service, notifying systemd and reloading on SIGHUP")
+	schedulePtr := flags.String("schedule", "", "in daemon mode, process the whole input dir on a cron schedule instead of watching, eg. \"0 2 * * *\"")
+	statePtr := flags.String("state", "", "json file remembering processed files so later runs skip them")
+	archivePtr := flags.String("archive", "", "upload originals here before they are replaced, eg. s3://bucket/originals or rclone:b2:originals")
+	archiveClassPtr := flags.String("archive-storage-class", "GLACIER", "S3 storage class for archived originals")
+	archiveManifestPtr := flags.String("archive-manifest", "", "json lines manifest of archived originals, defaults to .shrink-archive.jsonl in the input dir")
+	healthAddrPtr := flags.String("health-addr", "", "in daemon mode, address to serve /healthz and /readyz on")
+	mediaServerPtr := flags.String("media-server", "", "refresh changed dirs on a plex, jellyfin or emby server")
+	mediaServerURLPtr := flags.String("media-server-url", "", "media server url, eg. http://localhost:32400")
+	mediaServerTokenPtr := flags.String("media-server-token", "", "plex token or jellyfin/emby api key")
+	mediaServerPathMapPtr := flags.String("media-server-path-map", "", "local=server path prefix mapping if the media server sees the files elsewhere")
+	maxFilesPtr := flags.Int("max-files", 0, "stop after processing this many files, finishing the current one, 0 for no limit")
+	maxSavedPtr := flags.Int64("max-saved", 0, "stop once this many MB were saved, finishing the current file, 0 for no limit")
+	maxRuntimePtr := flags.Duration("max-runtime", 0, "stop after running this long, eg. 4h, finishing the current file, 0 for no limit")
+	run := addRunFlags(flags)
+	tuiPtr := flags.Bool("tui", false, "show a full-screen dashboard of the queue, progress and recent results, with keys to pause, skip and quit")
+	progressJSONPtr := flags.String("progress-json", "", "write json lines of progress events to - for stdout, a file or a named pipe, for wrappers and GUIs")
 
-	destFileName := filepath.Join(filepath.Dir(inFile), filepath.Base(outFile))
-	if err := CopyFile(outFile, destFileName); err != nil {
-		log.Error(err)
-	}
-	os.Remove(outFile)
+	return func(config *Config) {
+		setupLogging(*run.logFormat)
+		var files []string
+		if len(*filesFromPtr) > 0 {
+			var err error
+			if files, err = readFileList(*filesFromPtr); err != nil {
+				fatal(exitConfig, "Unable to read file list: ", err)
+			}
+			if *watchPtr || *daemonPtr {
+				fatal(exitConfig, "Error, -files-from can't be used with watch or daemon mode.")
+			}
+			if len(files) == 0 {
+				log.Info("No files listed in: ", *filesFromPtr)
+				exitCode = exitNothingToDo
+				return
+			}
+			if len(inDirs) == 0 {
+				// files are then named by their base name when uploading and archiving
+				inDirs = listFlag{""}
+			}
+		}
+		if len(inDirs) == 0 {
+			fatal(exitConfig, "Error, need to define an input directory.")
+		}
+		if *tuiPtr && (*daemonPtr || *filesFromPtr == "-" || !dashboardSupported()) {
+			fatal(exitConfig, "Error, -tui needs a terminal and can't be used with daemon mode or a file list from stdin.")
+		}
+		if *tuiPtr && *progressJSONPtr == "-" {
+			fatal(exitConfig, "Error, -tui and -progress-json - both need stdout.")
+		}
+		inDirName, inputName := inDirs[0], inDirs.String()
+		if len(files) > 0 {
+			if IsRemoteInput(inDirName) {
+				fatal(exitConfig, "Error, -files-from needs a local input directory.")
+			}
+			inputName = *filesFromPtr
+		}
+		if len(inDirs) > 1 {
+			for _, dirName := range inDirs {
+				if IsRemoteInput(dirName) {
+					fatal(exitConfig, "Error, a remote input can't be combined with other inputs: ", dirName)
+				}
+			}
+		}
 
-	return destFileName
-}
+		// Create temp dir and remember to clean up
+		tmpDir := makeTmpDir(*run.tmpDir, inDirs)
+		defer os.RemoveAll(tmpDir) // clean up
 
-// Processes a single photo file, copying it to the output dir and creating thumbnails etc. in S3
-func processFile(sourceFile, outDir, tmpDir string) error {
-	modTime := getFileModTime(sourceFile)
+		if (*watchPtr || *daemonPtr) && IsRemoteInput(inDirName) {
+			fatal(exitConfig, "Error, watch mode needs a local input directory.")
+		}
+		// the report, state and status outputs last the whole run, reloads of the daemon included
+		report := &shrink.Report{}
+		var state *shrink.State
+		if len(*statePtr) > 0 {
+			var err error
+			if state, err = shrink.LoadState(*statePtr); err != nil {
+				fatal(exitConfig, "Unable to load state: ", err)
+			}
+		}
+		var tracker *progressTracker
+		if len(*run.mqtt) > 0 {
+			tracker = &progressTracker{}
+			publisher, err := NewMQTTPublisher(*run.mqtt, *run.mqttTopic, func() MQTTState {
+				return mqttState(report.Summary(), tracker.Current(), tracker.Queued())
+			})
+			if err != nil {
+				log.Fatal("Unable to connect to MQTT: ", err)
+			}
+			stop := make(chan struct{})
+			go publisher.Run(stop)
+			defer publisher.Close()
+			defer close(stop)
+		}
+		var stream *progressStream
+		if len(*progressJSONPtr) > 0 {
+			if *progressJSONPtr == "-" {
+				// keep stdout for the events
+				log.SetOutput(os.Stderr)
+			}
+			var err error
+			if stream, err = openProgressStream(*progressJSONPtr); err != nil {
+				fatal(exitConfig, "Unable to open progress stream: ", err)
+			}
+			defer stream.Close()
+		}
 
-	// Get an output file name, make all files mp4  and make sure we can support multiple files in the same dir
-	destFile := filepath.Join(tmpDir, modTime.Format("20060102_150405")+".mp4")
-	for i := 1; ; i++ {
-		if _, err := os.Stat(destFile); os.IsNotExist(err) {
-			break
+		// Builds the options from the flags and config file and connects to all destinations, called again when the daemon reloads
+		// Nothing outside the options is changed before they are all valid, so a bad reload keeps the old ones.
+		var runner shrink.ExecRunner
+		setup := func() (shrink.Options, error) {
+			opts, newRunner, err := run.options(config)
+			if err != nil {
+				return opts, err
+			}
+			opts.InDir, opts.MoreDirs, opts.Files, opts.TmpDir = inDirName, inDirs[1:], files, tmpDir
+			opts.Report, opts.State = report, state
+			if opts.StopAfter, err = setupStopAfter(*maxFilesPtr, *maxSavedPtr, *maxRuntimePtr); err != nil {
+				return opts, err
+			}
+			if len(*archivePtr) > 0 {
+				dest, err := NewUploader(*archivePtr)
+				if err != nil {
+					return opts, fmt.Errorf("unable to use archive: %v", err)
+				}
+				if s3, ok := dest.(S3Remote); ok {
+					s3.StorageClass = *archiveClassPtr
+					dest = s3
+				}
+				manifest := *archiveManifestPtr
+				if len(manifest) == 0 {
+					manifest = ".shrink-archive.jsonl"
+					if !IsRemoteInput(inDirName) {
+						manifest = filepath.Join(inDirName, manifest)
+					}
+				}
+				opts.Archiver = &shrink.Archiver{Dest: dest, Location: *archivePtr, ManifestFile: manifest}
+			}
+			if len(*mediaServerPtr) > 0 {
+				mediaServer, err := NewMediaServer(*mediaServerPtr, *mediaServerURLPtr, *mediaServerTokenPtr, *mediaServerPathMapPtr)
+				if err != nil {
+					return opts, err
+				}
+				opts.Refresher = mediaServer
+			}
+			if tracker != nil {
+				opts.Progress = tracker.Update
+			}
+			if stream != nil {
+				stream.Attach(&opts)
+			}
+			// the destination plugins come first
+			if !IsRemoteInput(inDirName) && IsRemoteURI(*outDirNamePtr) {
+				uploader, err := NewUploader(*outDirNamePtr)
+				if err != nil {
+					return opts, fmt.Errorf("unable to use output: %v", err)
+				}
+				opts.Uploaders = append(opts.Uploaders, uploader)
+			}
+			if len(*driveFolderPtr) > 0 || len(*driveCredentialsPtr) > 0 {
+				drive, err := NewDriveUploader(*driveFolderPtr, *driveCredentialsPtr)
+				if err != nil {
+					return opts, fmt.Errorf("unable to connect to Google Drive: %v", err)
+				}
+				opts.Uploaders = append(opts.Uploaders, drive)
+			}
+			runner = newRunner
+			return opts, nil
+		}
+		opts, err := setup()
+		if err != nil {
+			fatal(setupExitCode(err), err)
 		}
-		destFile = filepath.Join(tmpDir, fmt.Sprintf(modTime.Format("20060102_150405")+"_%04d.mp4", i))
-	}
 
-	// Run ffmpeg on the input file and save to output dir
-	cmd := exec.Command("ffmpeg", "-i", sourceFile, "-c:v", "libx264", "-preset", "medium", "-crf", "28", "-movflags", "+faststart", "-acodec", "aac", "-strict", "experimental", "-ab", "96k", destFile)
-	if err := cmd.Run(); err != nil {
-		log.Error("Could not run ffmpeg on file: ", sourceFile, err, destFile)
-		return err
-	}
+		if *daemonPtr {
+			var schedule cron.Schedule
+			if len(*schedulePtr) > 0 {
+				var err error
+				if schedule, err = cron.ParseStandard(*schedulePtr); err != nil {
+					fatal(exitConfig, "Invalid schedule: ", err)
+				}
+			}
+			// re-read the config file on every reload, so settings and destinations can be changed without a restart,
+			// a file with invalid settings leaves the flags as they were
+			reload := func() (opts shrink.Options, err error) {
+				err = config.Reload(func() error {
+					opts, err = setup()
+					return err
+				})
+				return opts, err
+			}
+			daemon := &Daemon{Options: opts, Settle: *settlePtr, Schedule: schedule, Reload: reload}
+			if tracker != nil {
+				daemon.Started = tracker.Track
+			}
+			if len(*healthAddrPtr) > 0 {
+				health := &Health{TmpDir: tmpDir, FFmpeg: runner.FFmpeg, MinTempSpace: *run.minTempSpace << 20, Alive: daemon.Alive}
+				mux := http.NewServeMux()
+				health.Register(mux)
+				go func() {
+					log.Info("Health checks listening on: ", *healthAddrPtr)
+					if err := http.ListenAndServe(*healthAddrPtr, mux); err != nil {
+						log.Error(err)
+					}
+				}()
+			}
+			if err := daemon.Serve(); err != nil {
+				log.Error(err)
+				exitCode = exitError
+			}
+			return
+		}
 
-	// Check what the ratio input/output is
-	inSize := getFileSize(sourceFile)
-	outSize := getFileSize(destFile)
-	ratio := float64(outSize) / float64(inSize)
-	if ratio < 0.93 {
-		newDestFile := swapFiles(sourceFile, destFile)
-		// Make sure new file has the same mod time as original file
-		if err := os.Chtimes(newDestFile, modTime, modTime); err != nil {
+		var dash *dashboard
+		if *tuiPtr {
+			dash = newDashboard(&opts)
+		}
+		processor := shrink.New(opts)
+		if tracker != nil {
+			tracker.Track(processor)
+		}
+		ctx, cancel := signalContext()
+		defer cancel()
+		if dash != nil {
+			if err := dash.Start(processor, cancel); err != nil {
+				fatal(exitConfig, "Unable to start the dashboard: ", err)
+			}
+		}
+		if *watchPtr {
+			err = processor.Watch(ctx, *settlePtr)
+		} else if IsRemoteInput(inDirName) {
+			err = processRemote(ctx, processor, *outDirNamePtr)
+		} else {
+			err = processor.Process(ctx)
+		}
+		if dash != nil {
+			dash.Stop()
+		}
+		if ctx.Err() != nil {
+			// return normally so the temp dir is cleaned up
+			log.Info("Cancelled processing: ", inputName)
+			exitCode = exitInterrupted
+			return
+		}
+		if err != nil {
 			log.Error(err)
+			exitCode = exitError
+			return
+		}
+		summary := report.Summary()
+		log.Info("Done processing: ", inputName, " processed: ", summary.Processed, " shrunk: ", summary.Shrunk, " failed: ", summary.Failed)
+		if summary.Skipped > 0 {
+			log.Info("Skipped protected, unsupported, blank, name colliding or filtered files: ", summary.Skipped)
+		}
+		if summary.Blank > 0 {
+			log.Info("Blank clips: ", summary.Blank)
+		}
+		for _, dir := range opts.Report.Unreadable() {
+			log.Error("Skipped unreadable dir: ", dir.Dir, " ", dir.Error)
+		}
+		logMirrorFailures(opts.Report)
+		logRatioStats(opts.Report)
+		if summary.Failed > 0 || summary.Unreadable > 0 || summary.MirrorFailed > 0 {
+			exitCode = exitFailures
+		} else if summary.Processed == 0 && !*watchPtr {
+			exitCode = exitNothingToDo
 		}
 	}
-
-	log.Info("Processed File: ", sourceFile, " ratio: ", ratio)
-	return nil
 }
 
-// IsMovie returns true is the file is a movie
-func IsMovie(fileName string) bool {
-	fileExt := strings.ToLower(filepath.Ext(fileName))
-	return fileExt == ".mpg" || fileExt == ".mpeg" || fileExt == ".avi" || fileExt == ".mp4" || fileExt == ".3gp" || fileExt == ".mov"
-}
-
-// Gets all files in directory
-func addFilesToList(inDirName string, fileList *[]string) {
-	files, err := ioutil.ReadDir(inDirName)
-	if err != nil {
-		log.Fatal(err.Error())
+// Returns the limits a run stops after, nil without any, failing if one is negative
+func setupStopAfter(files int, savedMB int64, runtime time.Duration) (*shrink.StopAfter, error) {
+	if files < 0 || savedMB < 0 || runtime < 0 {
+		return nil, fmt.Errorf("-max-files, -max-saved and -max-runtime can't be negative")
 	}
-
-	for _, f := range files {
-		if f.IsDir() {
-			dirName := f.Name()
-			if dirName[0] == '.' {
-				continue
-			}
-			addFilesToList(filepath.Join(inDirName, dirName), fileList)
-		} else {
-			if IsMovie(f.Name()) {
-				fileName := filepath.Join(inDirName, f.Name())
-				*fileList = append(*fileList, fileName)
-			}
-		}
+	if files == 0 && savedMB == 0 && runtime == 0 {
+		return nil, nil
 	}
+	return &shrink.StopAfter{Files: files, SavedBytes: savedMB << 20, Runtime: runtime}, nil
 }
 
-// Loops through all files in a dir and processes them all
-func process(inDirName, outDirName, tmpDir string) {
-	// Get all files in directory
-	var fileList []string
-	addFilesToList(inDirName, &fileList)
-
-	// Process each file in directory
-	for _, fileName := range fileList {
-		processFile(fileName, outDirName, tmpDir)
+// Reads the files to process from a list, - reads it from stdin
+func readFileList(fileName string) ([]string, error) {
+	if fileName == "-" {
+		return shrink.ReadFileList(os.Stdin)
 	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return shrink.ReadFileList(f)
 }
 
-func main() {
-	inDirNamePtr := flag.String("i", "", "input directory")
-	outDirNamePtr := flag.String("o", "", "output directory")
-
-	flag.Parse()
-	if len(*inDirNamePtr) == 0 {
-		log.Fatal("Error, need to define an input directory.")
+// Logs the distribution of the compression ratios per camera and per year, to help tune the CRF of profiles
+func logRatioStats(report *shrink.Report) {
+	for _, by := range []string{"camera", "year"} {
+		stats := report.RatioStats(by)
+		keys := make([]string, 0, len(stats))
+		for key := range stats {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := stats[key]
+			log.Info(fmt.Sprintf("Compression ratios for %s %s: files: %d p10: %.2f median: %.2f p90: %.2f histogram: %v", by, key, s.Files, s.P10, s.Median, s.P90, s.Histogram))
+		}
 	}
-
-	// Create temp dir and remember to clean up
-	tmpDir, _ := ioutil.TempDir("", "shrink-file")
-	defer os.RemoveAll(tmpDir) // clean up
-
-	process(*inDirNamePtr, *outDirNamePtr, tmpDir)
-	log.Info("Done processing: ", *inDirNamePtr)
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Sends a state update to systemd, does nothing when not started by systemd with Type=notify
+func sdNotify(state string) error {
+	socketName := os.Getenv("NOTIFY_SOCKET")
+	if len(socketName) == 0 {
+		return nil
+	}
+	// a leading @ means an abstract socket
+	if socketName[0] == '@' {
+		socketName = "\x00" + socketName[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketName, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Returns the interval systemd expects watchdog pings at, zero if the watchdog isn't enabled
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// the watchdog is meant for us, not for a child process
+	if pid := os.Getenv("WATCHDOG_PID"); len(pid) > 0 && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
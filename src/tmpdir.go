@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	filepath "path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	log "github.com/sirupsen/logrus"
+)
+
+// pidFile names the process owning a temp dir, so the dirs of killed runs can be told apart from those of running ones
+const pidFile = "shrink.pid"
+
+// Creates the temp dir for encodes below parent, logging which input dirs shrunk files have to be copied into.
+// Temp dirs left below parent by killed runs are removed first.
+func makeTmpDir(parent string, inDirs []string) string {
+	removeOrphanedTmpDirs(parent)
+	tmpDir, err := ioutil.TempDir(parent, "shrink-file")
+	if err != nil {
+		fatal(exitConfig, "Unable to create temp dir: ", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, pidFile), []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		log.Error("Could not write pid file: ", err)
+	}
+	for _, dirName := range inDirs {
+		if len(dirName) > 0 && !IsRemoteInput(dirName) && !sameFilesystem(tmpDir, dirName) {
+			log.Info("Temp dir is on another filesystem than: ", dirName, ", shrunk files are copied into place, use -tmp-dir to rename them")
+		}
+	}
+	return tmpDir
+}
+
+// Removes the temp dirs below parent whose process is gone, dirs without a pid file are left alone.
+// Encoders the killed run left writing into them are killed first.
+func removeOrphanedTmpDirs(parent string) {
+	if len(parent) == 0 {
+		parent = os.TempDir()
+	}
+	dirNames, _ := filepath.Glob(filepath.Join(parent, "shrink-file*"))
+	for _, dirName := range dirNames {
+		data, err := ioutil.ReadFile(filepath.Join(dirName, pidFile))
+		if err != nil {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil || pid == os.Getpid() || processAlive(pid) {
+			continue
+		}
+		if killed, err := shrink.KillStalePrograms(dirName); err != nil {
+			log.Error("Could not look for programs left running by a killed run: ", err)
+		} else if killed > 0 {
+			log.Info("Killed programs left running by a killed run: ", killed)
+		}
+		log.Info("Removing temp dir of a killed run: ", dirName)
+		if err := os.RemoveAll(dirName); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// Returns true if a process is running
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// finding a process on windows opens it, which fails once it is gone
+		proc.Release()
+		return true
+	}
+	err = proc.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, os.ErrPermission)
+}
+
+// Returns the quota for the temp dirs of all runs below parent, nil without a quota
+func tmpQuota(parent string, megabytes int64) *shrink.TmpQuota {
+	if megabytes <= 0 {
+		return nil
+	}
+	if len(parent) == 0 {
+		parent = os.TempDir()
+	}
+	return &shrink.TmpQuota{Dir: parent, Match: "shrink-*", Bytes: megabytes << 20}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	filepath "path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+// How often the dashboard is redrawn and how many log lines it keeps
+const (
+	dashboardRefresh = 500 * time.Millisecond
+	dashboardLogs    = 100
+)
+
+// dashboard is the full-screen terminal view of a run with -tui: the queue, the file being encoded,
+// recent results and the log, with keys to pause, skip and quit
+type dashboard struct {
+	report  *shrink.Report
+	tracker progressTracker
+	started time.Time
+
+	mu        sync.Mutex
+	processor *shrink.Processor
+	cancel    context.CancelFunc
+	paused    bool
+	resumed   chan struct{}
+	logs      []string
+	restore   func()
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// Returns true if the dashboard can take over the terminal, which needs stdin for its keys
+func dashboardSupported() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// Creates a dashboard tracking the progress of a run, chaining the progress and pause callbacks of the options
+func newDashboard(opts *shrink.Options) *dashboard {
+	if opts.Report == nil {
+		opts.Report = &shrink.Report{}
+	}
+	d := &dashboard{report: opts.Report, started: time.Now()}
+	progress, pause := opts.Progress, opts.Pause
+	opts.Progress = func(name string, fraction float64) {
+		d.tracker.Update(name, fraction)
+		if progress != nil {
+			progress(name, fraction)
+		}
+	}
+	opts.Pause = func() {
+		// called before each file, the last one is done
+		d.tracker.Update("", 0)
+		d.waitWhilePaused()
+		if pause != nil {
+			pause()
+		}
+	}
+	return d
+}
+
+// Start takes over the terminal until Stop, cancel is called when the user quits
+func (d *dashboard) Start(processor *shrink.Processor, cancel context.CancelFunc) error {
+	enableEscapes(os.Stdout)
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return err
+	}
+	d.processor, d.cancel, d.done = processor, cancel, make(chan struct{})
+	d.restore = func() {
+		// leave the alternate screen and show the cursor again
+		fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+		term.Restore(int(os.Stdin.Fd()), state)
+	}
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	log.SetOutput(d)
+
+	go d.readKeys()
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(dashboardRefresh)
+		defer ticker.Stop()
+		for {
+			d.draw()
+			select {
+			case <-d.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop gives the terminal back, the log goes to stderr again
+func (d *dashboard) Stop() {
+	close(d.done)
+	d.wg.Wait()
+	d.restore()
+	log.SetOutput(os.Stderr)
+}
+
+// Write keeps the last lines of the log to show them, it is the output of the log while the dashboard runs
+func (d *dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		d.logs = append(d.logs, line)
+	}
+	if len(d.logs) > dashboardLogs {
+		d.logs = d.logs[len(d.logs)-dashboardLogs:]
+	}
+	return len(p), nil
+}
+
+// Reads keys until stdin is closed, the read blocks so this goroutine is left behind when the run ends
+func (d *dashboard) readKeys() {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range buf[:n] {
+			switch key {
+			case 'p', ' ':
+				d.togglePause()
+			case 's':
+				log.Info("Skipping the current file")
+				d.processor.Skip()
+			case 'q', 3: // 3 is Ctrl-C, which raw mode doesn't turn into a signal
+				log.Info("Quitting")
+				d.setPaused(false)
+				d.cancel()
+			}
+		}
+	}
+}
+
+// Pauses or resumes processing, a paused run finishes the current file and then waits
+func (d *dashboard) togglePause() {
+	d.mu.Lock()
+	paused := !d.paused
+	d.mu.Unlock()
+	d.setPaused(paused)
+	if paused {
+		log.Info("Pausing after the current file")
+	} else {
+		log.Info("Resuming")
+	}
+}
+
+// Sets whether processing is paused, waking up a waiting run when resumed
+func (d *dashboard) setPaused(paused bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if paused && !d.paused {
+		d.resumed = make(chan struct{})
+	} else if !paused && d.paused {
+		close(d.resumed)
+	}
+	d.paused = paused
+}
+
+// Blocks while processing is paused
+func (d *dashboard) waitWhilePaused() {
+	d.mu.Lock()
+	paused, resumed := d.paused, d.resumed
+	d.mu.Unlock()
+	if paused {
+		<-resumed
+	}
+}
+
+// Draws the dashboard to fit the terminal
+func (d *dashboard) draw() {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width < 20 || height < 10 {
+		width, height = 80, 24
+	}
+	d.mu.Lock()
+	paused := d.paused
+	logs := append([]string(nil), d.logs...)
+	d.mu.Unlock()
+
+	summary := d.report.Summary()
+	status := "running"
+	if paused {
+		status = "PAUSED"
+	}
+	lines := []string{
+		fmt.Sprintf("shrink-movies  %s  %s  processed: %d shrunk: %d failed: %d saved: %d MB",
+			status, time.Since(d.started).Round(time.Second), summary.Processed, summary.Shrunk, summary.Failed, summary.Saved>>20),
+		"",
+	}
+
+	current := d.tracker.Current()
+	if len(current.File) > 0 {
+		lines = append(lines, "Encoding: "+current.File, progressBar(current.Fraction, width))
+	} else if paused {
+		lines = append(lines, "Waiting, paused", "")
+	} else {
+		lines = append(lines, "Starting the next file", "")
+	}
+
+	// the rest of the screen is shared by the queue, the recent results and the log
+	rows := (height - len(lines) - 5) / 3
+	queue := d.processor.Queue()
+	lines = append(lines, "", fmt.Sprintf("Queue (%d)", len(queue)))
+	for i, fileName := range queue {
+		if i == rows {
+			break
+		}
+		lines = append(lines, "  "+fileName)
+	}
+
+	lines = append(lines, "", "Recent")
+	files := d.report.Files()
+	for i := len(files) - 1; i >= 0 && i >= len(files)-rows; i-- {
+		f := files[i]
+		switch {
+		case len(f.Error) > 0:
+			lines = append(lines, fmt.Sprintf("  failed  %s: %s", f.Source, f.Error))
+		case f.Swapped:
+			lines = append(lines, fmt.Sprintf("  %.2f    %s -> %s", f.Ratio, f.Source, filepath.Base(f.Result)))
+		default:
+			lines = append(lines, fmt.Sprintf("  %.2f    %s kept", f.Ratio, f.Source))
+		}
+	}
+
+	lines = append(lines, "", "Log")
+	if rows := height - len(lines) - 1; rows > 0 && len(logs) > rows {
+		logs = logs[len(logs)-rows:]
+	}
+	for _, line := range logs {
+		lines = append(lines, "  "+line)
+	}
+	if len(lines) > height-1 {
+		lines = lines[:height-1]
+	}
+
+	var screen strings.Builder
+	// home and clear, raw mode needs carriage returns
+	screen.WriteString("\x1b[H\x1b[2J")
+	for _, line := range lines {
+		screen.WriteString(truncate(line, width) + "\r\n")
+	}
+	screen.WriteString(fmt.Sprintf("\x1b[%d;1H\x1b[7m%s\x1b[0m", height, truncate(" p pause/resume  s skip  q quit", width)))
+	os.Stdout.WriteString(screen.String())
+}
+
+// Returns a progress bar as wide as the terminal, eg. [#####-----]  50%
+func progressBar(fraction float64, width int) string {
+	if fraction > 1 {
+		fraction = 1
+	}
+	size := width - 8
+	done := int(fraction * float64(size))
+	return "[" + strings.Repeat("#", done) + strings.Repeat("-", size-done) + fmt.Sprintf("] %3d%%", int(fraction*100))
+}
+
+// Cuts a line to the width of the terminal
+func truncate(line string, width int) string {
+	runes := []rune(line)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return line
+}
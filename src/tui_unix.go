@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// Terminals understand escape sequences already
+func enableEscapes(f *os.File) {}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/dylanclement/shrink-movies/pkg/shrink"
+	log "github.com/sirupsen/logrus"
+)
+
+// IsRemoteURI returns true if the location is a remote destination rather than a local directory
+func IsRemoteURI(location string) bool {
+	u, err := url.Parse(location)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "s3", "rclone", "sftp", "webdav", "webdavs":
+		return true
+	}
+	return false
+}
+
+// NewUploader creates an uploader for an s3://, rclone:, sftp://, webdav:// or webdavs:// location,
+// or tar: followed by a local directory of dated tar archives, anything else is treated as a local directory
+func NewUploader(location string) (shrink.Uploader, error) {
+	if strings.HasPrefix(location, "tar:") {
+		return shrink.NewTarUploader(strings.TrimPrefix(location, "tar:")), nil
+	}
+	if !IsRemoteURI(location) {
+		return shrink.LocalUploader{Dir: location}, nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "s3", "rclone":
+		return NewRemote(location)
+	case "sftp":
+		return NewSFTPUploader(u)
+	case "webdav", "webdavs":
+		return NewWebDAVUploader(u), nil
+	}
+	return nil, fmt.Errorf("unsupported destination: %s", location)
+}
+
+// Returns an error if a copy of a file doesn't have its size
+func verifySize(fileName string, size int64) error {
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	if size != stat.Size() {
+		return fmt.Errorf("copy has %d bytes, not %d", size, stat.Size())
+	}
+	return nil
+}
+
+// Adds the repeatable -mirror flag
+func addMirrorFlag(flags *flag.FlagSet) *listFlag {
+	var locations listFlag
+	flags.Var(&locations, "mirror", "also copy every finished file to this local dir, s3://, rclone:, sftp:// or webdav:// location and verify the copy, can be repeated")
+	return &locations
+}
+
+// Lists the files that didn't make it to a mirror, grouped by mirror
+func logMirrorFailures(report *shrink.Report) {
+	failed := make(map[string][]string)
+	var mirrors []string
+	for _, f := range report.Files() {
+		for _, dest := range f.Destinations {
+			if len(dest.Error) == 0 {
+				continue
+			}
+			if _, ok := failed[dest.Destination]; !ok {
+				mirrors = append(mirrors, dest.Destination)
+			}
+			failed[dest.Destination] = append(failed[dest.Destination], f.Result)
+		}
+	}
+	for _, mirror := range mirrors {
+		log.Error("Failed copies to mirror ", mirror, ": ", len(failed[mirror]))
+		for _, fileName := range failed[mirror] {
+			log.Error("Not on mirror ", mirror, ": ", fileName)
+		}
+	}
+}
+
+// Returns a mirror for each location, failing if one can't be used
+func setupMirrors(locations []string) ([]shrink.Mirror, error) {
+	var mirrors []shrink.Mirror
+	for _, location := range locations {
+		dest, err := NewUploader(location)
+		if err != nil {
+			return nil, fmt.Errorf("unable to use mirror %s: %v", location, err)
+		}
+		if _, ok := dest.(shrink.Verifier); !ok {
+			log.Info("Copies to mirror can't be verified: ", location)
+		}
+		mirrors = append(mirrors, shrink.Mirror{Name: location, Dest: dest})
+	}
+	return mirrors, nil
+}
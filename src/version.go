@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Set at build time, eg. go build -ldflags "-X main.version=1.2.0 -X main.commit=abc123 -X main.buildDate=2024-05-01"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// hardwareEncoders are the parts of ffmpeg encoder names that mean they run on a GPU or media engine
+var hardwareEncoders = []string{"nvenc", "qsv", "vaapi", "videotoolbox", "amf", "v4l2m2m", "mf"}
+
+// Returns the commit and build date, from the build flags or else from the vcs info go embeds
+func buildInfo() (string, string) {
+	rev, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && len(rev) == 0:
+				rev = setting.Value
+			case setting.Key == "vcs.time" && len(date) == 0:
+				date = setting.Value
+			}
+		}
+	}
+	return rev, date
+}
+
+// Returns the first line of "<tool> -version", or why it couldn't be run
+func toolVersion(tool string) string {
+	out, err := exec.Command(tool, "-version").Output()
+	if err != nil {
+		return "not found (" + err.Error() + ")"
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSpace(line)
+}
+
+// Returns the video encoders of ffmpeg that use hardware acceleration
+func detectHardwareEncoders(ffmpeg string) []string {
+	out, err := exec.Command(ffmpeg, "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return nil
+	}
+	var found []string
+	for _, line := range strings.Split(string(out), "\n") {
+		// lines look like " V....D h264_nvenc           NVIDIA NVENC H.264 encoder"
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[0], "V") {
+			continue
+		}
+		for _, hw := range hardwareEncoders {
+			if strings.HasSuffix(fields[1], "_"+hw) {
+				found = append(found, fields[1])
+				break
+			}
+		}
+	}
+	return found
+}
+
+// Returns the version of the program and the ffmpeg and ffprobe it uses, for bug reports
+func versionInfo(ffmpeg, ffprobe string) string {
+	rev, date := buildInfo()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "shrink-movies %s\n", version)
+	if len(rev) > 0 {
+		fmt.Fprintf(&b, "commit:   %s\n", rev)
+	}
+	if len(date) > 0 {
+		fmt.Fprintf(&b, "built:    %s\n", date)
+	}
+	fmt.Fprintf(&b, "go:       %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "ffmpeg:   %s\n", toolVersion(ffmpeg))
+	fmt.Fprintf(&b, "ffprobe:  %s\n", toolVersion(ffprobe))
+	encoders := detectHardwareEncoders(ffmpeg)
+	if len(encoders) == 0 {
+		encoders = []string{"none"}
+	}
+	fmt.Fprintf(&b, "hardware: %s\n", strings.Join(encoders, " "))
+	return b.String()
+}
+
+// Prints the version, -short prints just the version number for scripts
+func versionCommand(flags *flag.FlagSet) func(*Config) {
+	shortPtr := flags.Bool("short", false, "print just the version number")
+	tools := addFFmpegFlags(flags)
+	return func(*Config) {
+		if *shortPtr {
+			fmt.Println(version)
+			return
+		}
+		fmt.Print(versionInfo(*tools.ffmpeg, *tools.ffprobe))
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	filepath "path/filepath"
+	"testing"
+)
+
+func TestWebDAVVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		length  string
+		wantErr bool
+	}{
+		{"same size", http.StatusMultiStatus, "5", false},
+		{"other size", http.StatusMultiStatus, "3", true},
+		{"no size", http.StatusMultiStatus, "", true},
+		{"missing", http.StatusNotFound, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != "PROPFIND" || r.URL.Path != "/dav/2016/clip.mp4" || r.Header.Get("Depth") != "0" {
+					t.Errorf("got %s %s with depth %s", r.Method, r.URL.Path, r.Header.Get("Depth"))
+				}
+				w.WriteHeader(test.status)
+				fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>%s</d:href>`+
+					`<d:propstat><d:prop><d:getcontentlength>%s</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>`+
+					`</d:response></d:multistatus>`, r.URL.Path, test.length)
+			}))
+			defer server.Close()
+			uri, _ := url.Parse(server.URL + "/dav/")
+			uploader := NewWebDAVUploader(uri)
+
+			fileName := filepath.Join(t.TempDir(), "clip.mp4")
+			if err := os.WriteFile(fileName, []byte("movie"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := uploader.Verify(context.Background(), fileName, "2016/clip.mp4")
+			if (err != nil) != test.wantErr {
+				t.Errorf("Verify() error = %v, want an error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// webhookEvent covers the fields we need from Radarr and Sonarr webhooks, plus a plain path for anything else
+type webhookEvent struct {
+	EventType string `json:"eventType"`
+	Movie     *struct {
+		FolderPath string `json:"folderPath"`
+	} `json:"movie"`
+	MovieFile *struct {
+		RelativePath string `json:"relativePath"`
+	} `json:"movieFile"`
+	Series *struct {
+		Path string `json:"path"`
+	} `json:"series"`
+	EpisodeFile *struct {
+		RelativePath string `json:"relativePath"`
+	} `json:"episodeFile"`
+	Path string `json:"path"`
+}
+
+// Returns the imported file, empty if the event isn't an import
+func (e webhookEvent) importedFile() string {
+	switch {
+	case e.Movie != nil && e.MovieFile != nil && e.EventType == "Download":
+		return path.Join(e.Movie.FolderPath, e.MovieFile.RelativePath)
+	case e.Series != nil && e.EpisodeFile != nil && e.EventType == "Download":
+		return path.Join(e.Series.Path, e.EpisodeFile.RelativePath)
+	case len(e.EventType) == 0:
+		return e.Path
+	}
+	return ""
+}
+
+// Webhooks queues files imported by Radarr or Sonarr, or any tool that posts {"path": ...}
+type Webhooks struct {
+	Server *Server
+	// Token must be passed as ?token= on the webhook url, the webhook isn't served without one
+	Token string
+	// PathFrom is replaced with PathTo, for when the sender sees the files under another path
+	PathFrom string
+	PathTo   string
+}
+
+// Maps a path from the senders view to ours
+func (h *Webhooks) localPath(fileName string) string {
+	if len(h.PathFrom) > 0 && strings.HasPrefix(fileName, h.PathFrom) {
+		return h.PathTo + strings.TrimPrefix(fileName, h.PathFrom)
+	}
+	return fileName
+}
+
+// ServeHTTP handles a webhook, test and other events are acknowledged and ignored
+func (h *Webhooks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !tokenMatches(r.URL.Query().Get("token"), h.Token) {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+		return
+	}
+
+	var event webhookEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	fileName := event.importedFile()
+	if len(fileName) == 0 {
+		writeJSON(w, http.StatusOK, map[string]string{"ignored": event.EventType})
+		return
+	}
+
+	job, err := h.Server.Submit(h.localPath(fileName), "")
+	if err != nil {
+		log.Error("Could not queue webhook file: ", fileName, err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	log.Info("Queued from webhook: ", job.Path)
+	writeJSON(w, http.StatusCreated, h.Server.snapshot(job))
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// Serves the dashboard, it only talks to the REST API
+func webUI() http.Handler {
+	root, _ := fs.Sub(webFiles, "web")
+	return http.FileServer(http.FS(root))
+}